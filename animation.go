@@ -0,0 +1,461 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"time"
+)
+
+// GenerateGIF generates a thumbnail of every frame of an animated GIF and
+// returns the result ready for gif.EncodeAll. Frame delays, the loop count
+// and each frame's disposal method are kept. Frames are composited as a
+// viewer would show them before scaling, so that partial frames scale
+// cleanly, and each output frame stores only the part of the thumbnail its
+// source frame changed. Frames past opts.MaxDuration are cut. With
+// opts.MaxFrames set, frames are dropped evenly and their delays added to
+// the frame shown in their place; a kept frame is then disposed of to the
+// background when that is needed to clear what dropped frames would have.
+//
+// Frames keep their source palettes, with one entry given up for
+// transparency where needed. Colors are matched without dithering so that
+// still areas of the animation do not flicker.
+func GenerateGIF(g *gif.GIF, opts Options) (*gif.GIF, error) {
+	release, err := acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if g == nil || len(g.Image) == 0 {
+		return nil, ErrNilImage
+	}
+	c := newGIFCanvas(g)
+	l, opts, err := prepare(c.screen.Dx(), c.screen.Dy(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	keep := keptFrames(len(g.Image), func(i int) time.Duration { return gifDelay(g, i) }, opts)
+	out := &gif.GIF{
+		LoopCount:       g.LoopCount,
+		BackgroundIndex: g.BackgroundIndex,
+		Config: image.Config{
+			ColorModel: g.Config.ColorModel,
+			Width:      l.size.X,
+			Height:     l.size.Y,
+		},
+	}
+	thumb := image.NewRGBA(image.Rectangle{Max: l.size})
+	// shown is what a viewer of the output shows before its next frame,
+	// and before what it showed before the last one. last holds the
+	// thumbnail and palette the last output frame was made from.
+	shown := image.NewRGBA(thumb.Rect)
+	before := image.NewRGBA(thumb.Rect)
+	last := image.NewRGBA(thumb.Rect)
+	var lastPal color.Palette
+	// changed is the part of the thumbnail the next output frame must
+	// store. The first frame stores all of it, padding included.
+	changed := image.Rectangle{Max: l.size}
+	for i, kept := range keep {
+		m := g.Image[i]
+		r, disposal := c.draw(i)
+		if opts.AutoBackground != AutoBackgroundNone {
+			// Sample the first frame only, so that the padding does not
+			// flicker and its color is added to every palette.
+			opts.Background = edgeColor(c.canvas, l.src.Add(c.canvas.Bounds().Min), opts.AutoBackground)
+			opts.AutoBackground = AutoBackgroundNone
+		}
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+		changed = changed.Union(thumbRect(r, l))
+
+		if kept {
+			if err := render(ctx, thumb, image.Point{}, c.canvas, l, opts); err != nil {
+				return nil, err
+			}
+			pal := m.Palette
+			if l.dst != thumb.Rect && opts.Background != nil {
+				pal = withColor(pal, opts.Background)
+			}
+			if l.border > 0 {
+				for _, c := range frameColors(opts) {
+					pal = withColor(pal, c)
+				}
+			}
+			if k := len(out.Image) - 1; k >= 0 {
+				// A transparent pixel leaves the one beneath showing, so
+				// pixels that dropped frames disposed of cannot be cleared
+				// by this frame. Have the previous one clear them instead.
+				if c := clearedRect(shown, thumb, changed); !c.Empty() {
+					c = c.Union(out.Image[k].Rect)
+					out.Image[k] = quantizeFrame(last, c, lastPal)
+					out.Disposal[k] = gif.DisposalBackground
+					copy(shown.Pix, before.Pix)
+					draw.Draw(shown, c, image.Transparent, image.Point{}, draw.Src)
+					changed = changed.Union(c)
+				}
+			}
+
+			frame := quantizeFrame(thumb, changed, pal)
+			out.Image = append(out.Image, frame)
+			out.Delay = append(out.Delay, delay)
+			out.Disposal = append(out.Disposal, disposal)
+			copy(before.Pix, shown.Pix)
+			draw.Draw(shown, frame.Rect, frame, frame.Rect.Min, draw.Over)
+			copy(last.Pix, thumb.Pix)
+			lastPal = pal
+
+			// The output frame may be larger than the source frame it shows,
+			// and its disposal then reaches pixels the source's did not; the
+			// next frame redraws them.
+			changed = image.Rectangle{}
+			switch disposal {
+			case gif.DisposalBackground:
+				draw.Draw(shown, frame.Rect, image.Transparent, image.Point{}, draw.Src)
+				changed = frame.Rect
+			case gif.DisposalPrevious:
+				copy(shown.Pix, before.Pix)
+				changed = frame.Rect
+			}
+		} else {
+			out.Delay[len(out.Delay)-1] += delay
+		}
+		c.dispose(r, disposal)
+	}
+	return out, nil
+}
+
+// GenerateAnimatedWebP generates a thumbnail of every frame of an animated
+// GIF, as GenerateGIF does, and writes the animation to w as WebP, which is
+// typically several times smaller. Frames are encoded like SaveWebP
+// images, honoring opts.Quality and opts.Lossless, and each stores only
+// the part of the thumbnail that differs from the frame before it. Frame
+// durations follow the GIF delays, with delays under 20ms shown for 100ms
+// as browsers show them, and the loop count is kept. The decoders of this
+// package read animated WebP sources, this output included, as their first
+// frame.
+func GenerateAnimatedWebP(g *gif.GIF, w io.Writer, opts Options) error {
+	release, err := acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if g == nil || len(g.Image) == 0 {
+		return ErrNilImage
+	}
+	c := newGIFCanvas(g)
+	l, opts, err := prepare(c.screen.Dx(), c.screen.Dy(), opts)
+	if err != nil {
+		return err
+	}
+	if l.size.X > maxWebPDimension || l.size.Y > maxWebPDimension {
+		return ErrImageTooLarge
+	}
+
+	type frame struct {
+		rect     image.Rectangle
+		duration time.Duration
+		chunks   []riffChunk
+	}
+	var frames []frame
+	alpha := false
+	ctx := context.Background()
+	keep := keptFrames(len(g.Image), func(i int) time.Duration { return gifDelay(g, i) }, opts)
+	thumb := image.NewRGBA(image.Rectangle{Max: l.size})
+	prev := image.NewRGBA(thumb.Rect)
+	for i, kept := range keep {
+		r, disposal := c.draw(i)
+		d := gifDelay(g, i)
+		if kept {
+			if err := render(ctx, thumb, image.Point{}, c.canvas, l, opts); err != nil {
+				return err
+			}
+			changed := thumb.Rect
+			if len(frames) > 0 {
+				changed = diffRect(prev, thumb)
+			}
+			if changed.Empty() {
+				// Nothing changes, so the previous frame is shown longer.
+				kept = false
+			} else {
+				// Frame offsets are stored halved.
+				changed.Min.X &^= 1
+				changed.Min.Y &^= 1
+				chunks, a := webpImage(toNRGBA(thumb.SubImage(changed)), opts)
+				frames = append(frames, frame{changed, d, chunks})
+				alpha = alpha || a
+				copy(prev.Pix, thumb.Pix)
+			}
+		}
+		if !kept {
+			frames[len(frames)-1].duration += d
+		}
+		c.dispose(r, disposal)
+	}
+
+	const (
+		alphaFlag     = 1 << 4
+		animationFlag = 1 << 1
+		noBlend       = 1 << 1
+	)
+	vp8x := make([]byte, 10)
+	vp8x[0] = animationFlag
+	if alpha {
+		vp8x[0] |= alphaFlag
+	}
+	putUint24(vp8x[4:], uint32(l.size.X-1))
+	putUint24(vp8x[7:], uint32(l.size.Y-1))
+
+	// GIF counts repeats after the first run, or -1 for a single run; WebP
+	// counts runs, with 0 meaning forever.
+	loops := 0
+	switch {
+	case g.LoopCount < 0:
+		loops = 1
+	case g.LoopCount > 0:
+		loops = minInt(g.LoopCount+1, 0xffff)
+	}
+	anim := make([]byte, 6) // transparent background
+	anim[4], anim[5] = byte(loops), byte(loops>>8)
+
+	chunks := []riffChunk{{"VP8X", vp8x}, {"ANIM", anim}}
+	for _, f := range frames {
+		data := make([]byte, 16)
+		putUint24(data[0:], uint32(f.rect.Min.X/2))
+		putUint24(data[3:], uint32(f.rect.Min.Y/2))
+		putUint24(data[6:], uint32(f.rect.Dx()-1))
+		putUint24(data[9:], uint32(f.rect.Dy()-1))
+		putUint24(data[12:], uint32(minInt(int(f.duration/time.Millisecond), 1<<24-1)))
+		// Frames replace what they cover, transparent pixels included,
+		// and are not disposed of.
+		data[15] = noBlend
+		chunks = append(chunks, riffChunk{"ANMF", appendChunks(data, f.chunks...)})
+	}
+	return writeRIFF(w, chunks...)
+}
+
+// gifCanvas composites the frames of a GIF as a viewer shows them. Areas
+// disposed of to the background become transparent, as in browsers.
+type gifCanvas struct {
+	g      *gif.GIF
+	screen image.Rectangle
+	canvas *image.RGBA
+	saved  *image.RGBA // canvas before a frame disposed of to the previous
+}
+
+func newGIFCanvas(g *gif.GIF) *gifCanvas {
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		// Hand-built GIFs may leave Config unset; use the frames' extent.
+		for _, m := range g.Image {
+			screen = screen.Union(m.Rect)
+		}
+		screen.Min = image.Point{}
+	}
+	return &gifCanvas{g: g, screen: screen, canvas: image.NewRGBA(screen)}
+}
+
+// draw draws frame i onto the canvas, returning the part of the canvas it
+// covers and its disposal method.
+func (c *gifCanvas) draw(i int) (image.Rectangle, byte) {
+	var disposal byte
+	if i < len(c.g.Disposal) {
+		disposal = c.g.Disposal[i]
+	}
+	m := c.g.Image[i]
+	r := m.Rect.Intersect(c.screen)
+	if disposal == gif.DisposalPrevious {
+		if c.saved == nil {
+			c.saved = image.NewRGBA(c.screen)
+		}
+		copy(c.saved.Pix, c.canvas.Pix)
+	}
+	draw.Draw(c.canvas, r, m, r.Min, draw.Over)
+	return r, disposal
+}
+
+// dispose applies the disposal method of the frame drawn over r.
+func (c *gifCanvas) dispose(r image.Rectangle, disposal byte) {
+	switch disposal {
+	case gif.DisposalBackground:
+		draw.Draw(c.canvas, r, image.Transparent, image.Point{}, draw.Src)
+	case gif.DisposalPrevious:
+		c.canvas, c.saved = c.saved, c.canvas
+	}
+}
+
+// gifDelay returns how long frame i of g is shown. Like browsers, it shows
+// frames with delays under 20ms for 100ms.
+func gifDelay(g *gif.GIF, i int) time.Duration {
+	if i >= len(g.Delay) || g.Delay[i] < 2 {
+		return 100 * time.Millisecond
+	}
+	return time.Duration(g.Delay[i]) * 10 * time.Millisecond
+}
+
+// keptFrames reports which of n frames, shown for delay(i) each, to keep
+// under opts.MaxDuration and opts.MaxFrames. Frames past MaxDuration are
+// cut, and the rest are dropped evenly to leave at most MaxFrames; the
+// first frame is always kept. The result does not extend past the last
+// frame shown.
+func keptFrames(n int, delay func(i int) time.Duration, opts Options) []bool {
+	if opts.MaxDuration > 0 {
+		var t time.Duration
+		for i := 0; i < n; i++ {
+			if i > 0 && t >= opts.MaxDuration {
+				n = i
+				break
+			}
+			t += delay(i)
+		}
+	}
+	keep := make([]bool, n)
+	if max := opts.MaxFrames; max > 0 && max < n {
+		for k := 0; k < max; k++ {
+			keep[k*n/max] = true
+		}
+		return keep
+	}
+	for i := range keep {
+		keep[i] = true
+	}
+	return keep
+}
+
+// thumbRect returns the part of a thumbnail laid out by l that the source
+// rectangle r affects. It is grown by a pixel to cover the reach of the
+// resampling filter.
+func thumbRect(r image.Rectangle, l layout) image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	sx := float64(l.dst.Dx()) / float64(l.src.Dx())
+	sy := float64(l.dst.Dy()) / float64(l.src.Dy())
+	t := image.Rect(
+		l.dst.Min.X+int(math.Floor(float64(r.Min.X-l.src.Min.X)*sx)),
+		l.dst.Min.Y+int(math.Floor(float64(r.Min.Y-l.src.Min.Y)*sy)),
+		l.dst.Min.X+int(math.Ceil(float64(r.Max.X-l.src.Min.X)*sx)),
+		l.dst.Min.Y+int(math.Ceil(float64(r.Max.Y-l.src.Min.Y)*sy)),
+	)
+	return t.Inset(-1).Intersect(l.dst)
+}
+
+// diffRect returns the bounds of the pixels that differ between a and b,
+// which have the same bounds.
+func diffRect(a, b *image.RGBA) image.Rectangle {
+	var d image.Rectangle
+	r := a.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pa := a.Pix[a.PixOffset(r.Min.X, y):a.PixOffset(r.Max.X, y)]
+		pb := b.Pix[b.PixOffset(r.Min.X, y):b.PixOffset(r.Max.X, y)]
+		if bytes.Equal(pa, pb) {
+			continue
+		}
+		x0, x1 := 0, len(pa)
+		for pa[x0] == pb[x0] {
+			x0++
+		}
+		for pa[x1-1] == pb[x1-1] {
+			x1--
+		}
+		d = d.Union(image.Rect(r.Min.X+x0/4, y, r.Min.X+(x1+3)/4, y+1))
+	}
+	return d
+}
+
+// clearedRect returns the bounds of the pixels within r that are opaque in
+// shown but transparent in want.
+func clearedRect(shown, want *image.RGBA, r image.Rectangle) image.Rectangle {
+	var c image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if want.RGBAAt(x, y).A < 0x80 && shown.RGBAAt(x, y).A >= 0x80 {
+				c = c.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return c
+}
+
+// withColor returns pal with c added, replacing the last entry of a full
+// palette, unless pal already holds it.
+func withColor(pal color.Palette, c color.Color) color.Palette {
+	r, g, b, a := c.RGBA()
+	for _, e := range pal {
+		if er, eg, eb, ea := e.RGBA(); er == r && eg == g && eb == b && ea == a {
+			return pal
+		}
+	}
+	n := len(pal)
+	if n == 256 {
+		n--
+	}
+	return append(append(color.Palette(nil), pal[:n]...), c)
+}
+
+// quantizeFrame maps the r part of img to pal for an animation frame.
+// Pixels under half opaque become transparent, which in a GIF frame leaves
+// the pixel beneath showing. An empty r yields a single transparent pixel,
+// so that the frame's delay is kept even though nothing changes.
+func quantizeFrame(img *image.RGBA, r image.Rectangle, pal color.Palette) *image.Paletted {
+	empty := r.Empty()
+	if empty {
+		r = image.Rect(0, 0, 1, 1)
+	}
+	if len(pal) == 0 {
+		pal = palette.Plan9
+	}
+
+	ti := -1
+	if empty || hasTransparency(img.SubImage(r)) {
+		for i, c := range pal {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				ti = i
+				break
+			}
+		}
+		if ti < 0 {
+			pal = withColor(pal, color.Transparent)
+			ti = len(pal) - 1
+		}
+	}
+
+	p := image.NewPaletted(r, pal)
+	cache := make(map[color.RGBA]uint8)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if empty || c.A < 0x80 {
+				p.SetColorIndex(x, y, uint8(ti))
+				continue
+			}
+			if c.A != 0xff {
+				// Match the color as it would look at full opacity.
+				c = color.RGBA{
+					uint8(uint(c.R) * 0xff / uint(c.A)),
+					uint8(uint(c.G) * 0xff / uint(c.A)),
+					uint8(uint(c.B) * 0xff / uint(c.A)),
+					0xff,
+				}
+			}
+			idx, ok := cache[c]
+			if !ok {
+				idx = uint8(pal.Index(c))
+				cache[c] = idx
+			}
+			p.SetColorIndex(x, y, idx)
+		}
+	}
+	return p
+}
@@ -0,0 +1,77 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// testGIF returns a w×h GIF of a red frame followed by a blue one that
+// covers the middle of the canvas.
+func testGIF(w, h int) *gif.GIF {
+	pal := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	first := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	second := image.NewPaletted(image.Rect(w/4, h/4, 3*w/4, 3*h/4), pal)
+	for i := range second.Pix {
+		second.Pix[i] = 1
+	}
+	return &gif.GIF{
+		Image:  []*image.Paletted{first, second},
+		Delay:  []int{10, 10},
+		Config: image.Config{ColorModel: pal, Width: w, Height: h},
+	}
+}
+
+func TestAnimatedWebPReadsFirstFrame(t *testing.T) {
+	g := testGIF(120, 80)
+	for _, lossless := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := GenerateAnimatedWebP(g, &buf, Options{Width: 60, Height: 60, Lossless: lossless}); err != nil {
+			t.Fatal(err)
+		}
+		img, format, err := GenerateFromReader(&buf, Options{Width: 60, Height: 60})
+		if err != nil {
+			t.Fatalf("lossless %v: reading the animation back: %v", lossless, err)
+		}
+		if format != WEBP || img.Bounds().Dx() != 60 || img.Bounds().Dy() != 40 {
+			t.Fatalf("lossless %v: read a %s of %v, want a webp of 60x40", lossless, format, img.Bounds().Size())
+		}
+		if r, _, b, _ := img.At(30, 20).RGBA(); r < 0xc000 || b > 0x4000 {
+			t.Errorf("lossless %v: center %v, want the red of the first frame", lossless, img.At(30, 20))
+		}
+	}
+}
+
+func TestAnimatedWebPFirstFrameOffset(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpAnimationFlag
+	putUint24(vp8x[4:], 99)
+	putUint24(vp8x[7:], 79)
+	src := testImage(40, 30, false)
+	chunks, _ := webpImage(src, Options{Lossless: true})
+	anmf := make([]byte, 16)
+	putUint24(anmf[0:], 10) // x = 20
+	putUint24(anmf[3:], 5)  // y = 10
+	putUint24(anmf[6:], 39)
+	putUint24(anmf[9:], 29)
+	var buf bytes.Buffer
+	if err := writeRIFF(&buf, riffChunk{"VP8X", vp8x}, riffChunk{"ANIM", make([]byte, 6)},
+		riffChunk{"ANMF", appendChunks(anmf, chunks...)}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeAnimatedWebPFirst(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 100, 80) {
+		t.Fatalf("canvas %v, want 100x80", img.Bounds())
+	}
+	if _, _, _, a := img.At(5, 5).RGBA(); a != 0 {
+		t.Errorf("pixel outside the frame has alpha %d, want transparent", a)
+	}
+	if got, want := color.NRGBAModel.Convert(img.At(25, 12)), src.At(5, 2); got != want {
+		t.Errorf("pixel of the frame %v, want %v", got, want)
+	}
+}
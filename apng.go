@@ -0,0 +1,438 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"time"
+)
+
+// Disposal and blend operations of APNG frames.
+const (
+	APNGDisposeNone       = 0 // leave the frame on the canvas
+	APNGDisposeBackground = 1 // clear the frame's area to transparent
+	APNGDisposePrevious   = 2 // restore the frame's area to what it was
+
+	APNGBlendSource = 0 // replace the frame's area, alpha included
+	APNGBlendOver   = 1 // composite the frame over the canvas
+)
+
+// APNG is an animated PNG, as read by DecodeAPNG and written by EncodeAPNG.
+type APNG struct {
+	Width, Height int // canvas size
+	Frames        []APNGFrame
+
+	// LoopCount is the number of times the animation plays, with 0
+	// meaning forever.
+	LoopCount int
+}
+
+// APNGFrame is a frame of an APNG.
+type APNGFrame struct {
+	// Image holds the frame's pixels; its bounds place it on the canvas.
+	Image   image.Image
+	Delay   time.Duration
+	Dispose byte // one of the APNGDispose* operations
+	Blend   byte // one of the APNGBlend* operations
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// apngChunk is a raw PNG chunk.
+type apngChunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFile is a parsed APNG whose frames have not been decoded yet.
+type apngFile struct {
+	ihdr     []byte
+	shared   []apngChunk // chunks before the image data that all frames need
+	fallback [][]byte    // image data that is not part of the animation
+	plays    int
+	frames   []apngFrameData
+	width    int
+	height   int
+	animate  bool // whether the file has an animation control chunk
+}
+
+type apngFrameData struct {
+	fctl []byte   // the frame control chunk's data, sequence number included
+	data [][]byte // image data, without sequence numbers
+}
+
+// isAPNG reports whether header is the start of an animated PNG: the
+// animation control chunk must come before the image data.
+func isAPNG(header []byte) bool {
+	if !bytes.HasPrefix(header, pngSignature) {
+		return false
+	}
+	for b := header[len(pngSignature):]; len(b) >= 8; {
+		n := binary.BigEndian.Uint32(b)
+		switch string(b[4:8]) {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+		if uint64(n)+12 > uint64(len(b)) {
+			return false
+		}
+		b = b[12+n:]
+	}
+	return false
+}
+
+// parseAPNG splits a PNG file into its frames.
+func parseAPNG(data []byte) (*apngFile, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("%w: not a PNG file", image.ErrFormat)
+	}
+	f := new(apngFile)
+	var cur *apngFrameData
+	seenData := false
+	for b := data[len(pngSignature):]; ; {
+		if len(b) < 12 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n)+12 > uint64(len(b)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		typ, body := string(b[4:8]), b[8:8+n]
+		b = b[12+n:]
+
+		switch typ {
+		case "IHDR":
+			if len(body) != 13 {
+				return nil, fmt.Errorf("%w: bad PNG header", image.ErrFormat)
+			}
+			f.ihdr = body
+			f.width = int(binary.BigEndian.Uint32(body))
+			f.height = int(binary.BigEndian.Uint32(body[4:]))
+		case "acTL":
+			if len(body) == 8 {
+				f.animate = true
+				f.plays = int(binary.BigEndian.Uint32(body[4:]))
+			}
+		case "fcTL":
+			if len(body) != 26 {
+				return nil, fmt.Errorf("%w: bad APNG frame control chunk", image.ErrFormat)
+			}
+			f.frames = append(f.frames, apngFrameData{fctl: body})
+			cur = &f.frames[len(f.frames)-1]
+		case "IDAT":
+			// Image data not preceded by a frame control chunk is a
+			// fallback image that is not part of the animation.
+			seenData = true
+			if cur != nil {
+				cur.data = append(cur.data, body)
+			} else {
+				f.fallback = append(f.fallback, body)
+			}
+		case "fdAT":
+			if cur != nil && len(body) >= 4 {
+				cur.data = append(cur.data, body[4:])
+			}
+		case "IEND":
+			if f.ihdr == nil {
+				return nil, fmt.Errorf("%w: missing PNG header", image.ErrFormat)
+			}
+			return f, nil
+		default:
+			if !seenData && cur == nil {
+				f.shared = append(f.shared, apngChunk{typ, body})
+			}
+		}
+	}
+}
+
+// frame decodes frame i as a standalone PNG, placed on the canvas.
+func (f *apngFile) frame(i int) (APNGFrame, error) {
+	fd := f.frames[i]
+	c := fd.fctl
+	w, h := binary.BigEndian.Uint32(c[4:]), binary.BigEndian.Uint32(c[8:])
+	x, y := binary.BigEndian.Uint32(c[12:]), binary.BigEndian.Uint32(c[16:])
+	r := image.Rect(int(x), int(y), int(x)+int(w), int(y)+int(h))
+	if w == 0 || h == 0 || !r.In(image.Rect(0, 0, f.width, f.height)) {
+		return APNGFrame{}, fmt.Errorf("%w: APNG frame %d outside the canvas", image.ErrFormat, i)
+	}
+	num, den := binary.BigEndian.Uint16(c[20:]), binary.BigEndian.Uint16(c[22:])
+	if den == 0 {
+		den = 100
+	}
+	frame := APNGFrame{
+		Delay:   time.Duration(num) * time.Second / time.Duration(den),
+		Dispose: c[24],
+		Blend:   c[25],
+	}
+	if i == 0 && frame.Dispose == APNGDisposePrevious {
+		// As the specification requires, there is nothing to restore.
+		frame.Dispose = APNGDisposeBackground
+	}
+
+	ihdr := append([]byte(nil), f.ihdr...)
+	binary.BigEndian.PutUint32(ihdr, w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	chunks := append([]apngChunk{{"IHDR", ihdr}}, f.shared...)
+	chunks = append(chunks, apngChunk{"IDAT", bytes.Join(fd.data, nil)}, apngChunk{"IEND", nil})
+	m, err := png.Decode(bytes.NewReader(appendPNGChunks(append([]byte(nil), pngSignature...), chunks...)))
+	if err != nil {
+		return APNGFrame{}, err
+	}
+	dst := image.NewNRGBA(r)
+	draw.Draw(dst, r, m, m.Bounds().Min, draw.Src)
+	frame.Image = dst
+	return frame, nil
+}
+
+// appendPNGChunks appends chunks to b with their lengths and checksums.
+func appendPNGChunks(b []byte, chunks ...apngChunk) []byte {
+	for _, c := range chunks {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(c.data)))
+		b = append(b, n[:]...)
+		start := len(b)
+		b = append(b, c.typ...)
+		b = append(b, c.data...)
+		binary.BigEndian.PutUint32(n[:], crc32.ChecksumIEEE(b[start:]))
+		b = append(b, n[:]...)
+	}
+	return b
+}
+
+// DecodeAPNG reads every frame of an animated PNG. A PNG without animation
+// is returned as a single frame.
+func DecodeAPNG(r io.Reader) (*APNG, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseAPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	if !f.animate || len(f.frames) == 0 {
+		m, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		b := m.Bounds()
+		return &APNG{Width: b.Dx(), Height: b.Dy(), Frames: []APNGFrame{{Image: m}}}, nil
+	}
+	a := &APNG{Width: f.width, Height: f.height, LoopCount: f.plays}
+	for i := range f.frames {
+		frame, err := f.frame(i)
+		if err != nil {
+			return nil, err
+		}
+		a.Frames = append(a.Frames, frame)
+	}
+	return a, nil
+}
+
+// decodeAPNGFirst decodes the first frame of an animated PNG, which may
+// differ from the fallback image that image/png decodes.
+func decodeAPNGFirst(data []byte) (image.Image, error) {
+	f, err := parseAPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	if !f.animate || len(f.frames) == 0 || len(f.frames[0].data) == 0 {
+		return png.Decode(bytes.NewReader(data))
+	}
+	frame, err := f.frame(0)
+	if err != nil {
+		return nil, err
+	}
+	// The first frame covers the canvas, so blending and disposal do not
+	// apply to it.
+	return frame.Image, nil
+}
+
+// GenerateAPNG generates a thumbnail of every frame of an animated PNG.
+// Frames are composited as a viewer would show them before scaling, and
+// each output frame stores only the part of the thumbnail that differs
+// from the frame before it. Delays and the loop count are kept, and
+// opts.MaxDuration and opts.MaxFrames limit the frames as for GenerateGIF.
+func GenerateAPNG(a *APNG, opts Options) (*APNG, error) {
+	release, err := acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if a == nil || len(a.Frames) == 0 {
+		return nil, ErrNilImage
+	}
+	l, opts, err := prepare(a.Width, a.Height, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	out := &APNG{Width: l.size.X, Height: l.size.Y, LoopCount: a.LoopCount}
+	keep := keptFrames(len(a.Frames), func(i int) time.Duration { return a.Frames[i].Delay }, opts)
+	screen := image.Rect(0, 0, a.Width, a.Height)
+	canvas := image.NewRGBA(screen)
+	var saved *image.RGBA
+	thumb := image.NewRGBA(image.Rectangle{Max: l.size})
+	prev := image.NewRGBA(thumb.Rect)
+	for i, kept := range keep {
+		f := a.Frames[i]
+		if f.Image == nil {
+			return nil, ErrNilImage
+		}
+		r := f.Image.Bounds().Intersect(screen)
+		if f.Dispose == APNGDisposePrevious {
+			if saved == nil {
+				saved = image.NewRGBA(screen)
+			}
+			copy(saved.Pix, canvas.Pix)
+		}
+		op := draw.Over
+		if f.Blend == APNGBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, r, f.Image, r.Min, op)
+		if opts.AutoBackground != AutoBackgroundNone {
+			// Sample the first frame only, so that the padding does not
+			// flicker.
+			opts.Background = edgeColor(canvas, l.src, opts.AutoBackground)
+			opts.AutoBackground = AutoBackgroundNone
+		}
+
+		if kept {
+			if err := render(ctx, thumb, image.Point{}, canvas, l, opts); err != nil {
+				return nil, err
+			}
+			changed := thumb.Rect
+			if len(out.Frames) > 0 {
+				changed = diffRect(prev, thumb)
+			}
+			if changed.Empty() {
+				// Nothing changes, so the previous frame is shown longer.
+				kept = false
+			} else {
+				m := image.NewNRGBA(changed)
+				draw.Draw(m, changed, thumb, changed.Min, draw.Src)
+				out.Frames = append(out.Frames, APNGFrame{Image: m, Delay: f.Delay})
+				copy(prev.Pix, thumb.Pix)
+			}
+		}
+		if !kept {
+			out.Frames[len(out.Frames)-1].Delay += f.Delay
+		}
+
+		switch f.Dispose {
+		case APNGDisposeBackground:
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		case APNGDisposePrevious:
+			canvas, saved = saved, canvas
+		}
+	}
+	return out, nil
+}
+
+// translucent makes image/png store an image with an alpha channel even
+// when it is opaque, so that every frame of an APNG has the same format.
+type translucent struct{ image.Image }
+
+func (translucent) Opaque() bool { return false }
+
+// EncodeAPNG writes a as an animated PNG. The first frame must cover the
+// whole canvas, as it does in the output of GenerateAPNG.
+func EncodeAPNG(w io.Writer, a *APNG) error {
+	if a == nil || len(a.Frames) == 0 {
+		return ErrNilImage
+	}
+	screen := image.Rect(0, 0, a.Width, a.Height)
+	if screen.Empty() {
+		return ErrEmptyImage
+	}
+	alpha := false
+	for _, f := range a.Frames {
+		if f.Image == nil {
+			return ErrNilImage
+		}
+		if !f.Image.Bounds().In(screen) || f.Image.Bounds().Empty() {
+			return fmt.Errorf("thumbnail: APNG frame %v outside the %v canvas", f.Image.Bounds(), screen)
+		}
+		alpha = alpha || hasTransparency(f.Image)
+	}
+	if a.Frames[0].Image.Bounds() != screen {
+		return fmt.Errorf("thumbnail: first APNG frame %v does not cover the %v canvas", a.Frames[0].Image.Bounds(), screen)
+	}
+
+	out := append([]byte(nil), pngSignature...)
+	var actl [8]byte
+	binary.BigEndian.PutUint32(actl[:], uint32(len(a.Frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(a.LoopCount))
+	seq := uint32(0)
+	for i, f := range a.Frames {
+		// Each frame is encoded as a PNG of the same format, whose image
+		// data is then moved into the animation.
+		var m image.Image = toNRGBA(f.Image)
+		if alpha {
+			m = translucent{m}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, m); err != nil {
+			return err
+		}
+		f2, err := parseAPNG(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			out = appendPNGChunks(out, apngChunk{"IHDR", f2.ihdr}, apngChunk{"acTL", actl[:]})
+			out = appendPNGChunks(out, f2.shared...)
+		}
+
+		b := f.Image.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl, seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(b.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(b.Min.Y))
+		num, den := apngDelay(f.Delay)
+		binary.BigEndian.PutUint16(fctl[20:], num)
+		binary.BigEndian.PutUint16(fctl[22:], den)
+		fctl[24], fctl[25] = f.Dispose, f.Blend
+		out = appendPNGChunks(out, apngChunk{"fcTL", fctl})
+		seq++
+
+		data := bytes.Join(f2.fallback, nil)
+		if i == 0 {
+			out = appendPNGChunks(out, apngChunk{"IDAT", data})
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, seq)
+		out = appendPNGChunks(out, apngChunk{"fdAT", append(fdat, data...)})
+		seq++
+	}
+	out = appendPNGChunks(out, apngChunk{"IEND", nil})
+	_, err := w.Write(out)
+	return err
+}
+
+// apngDelay expresses d as a fraction of a second for a frame control
+// chunk.
+func apngDelay(d time.Duration) (num, den uint16) {
+	if ms := d / time.Millisecond; ms <= 0xffff {
+		return uint16(ms), 1000
+	}
+	cs := d / (10 * time.Millisecond)
+	if cs > 0xffff {
+		cs = 0xffff
+	}
+	return uint16(cs), 100
+}
@@ -0,0 +1,58 @@
+package thumbnail
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseAspectRatio parses an aspect ratio written as "16:9", "16/9" or a
+// plain number such as "1.5", returning width divided by height.
+func ParseAspectRatio(s string) (float64, error) {
+	var r float64
+	if i := strings.IndexAny(s, ":/"); i >= 0 {
+		w, err1 := strconv.ParseFloat(s[:i], 64)
+		h, err2 := strconv.ParseFloat(s[i+1:], 64)
+		if err1 != nil || err2 != nil || h == 0 {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAspectRatio, s)
+		}
+		r = w / h
+	} else {
+		var err error
+		if r, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAspectRatio, s)
+		}
+	}
+	if !(r > 0) || math.IsInf(r, 0) {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAspectRatio, s)
+	}
+	return r, nil
+}
+
+// applyAspectRatio derives the box of opts from opts.AspectRatio. A single
+// given dimension determines the other; when both are given the box is the
+// largest one with the ratio that fits within them. Fit becomes Fill, so
+// that the source is cropped to the ratio.
+func applyAspectRatio(opts Options) (Options, error) {
+	ratio := opts.AspectRatio
+	w, h := opts.Width, opts.Height
+	switch {
+	case opts.Scale > 0 || h == 0:
+		h = int(math.Round(float64(w) / ratio))
+	case w == 0:
+		w = int(math.Round(float64(h) * ratio))
+	case float64(w)/float64(h) > ratio:
+		w = int(math.Round(float64(h) * ratio))
+	default:
+		h = int(math.Round(float64(w) / ratio))
+	}
+	if w > MaxDimension || h > MaxDimension {
+		return opts, &OptionError{"AspectRatio", ratio, ErrInvalidDimensions}
+	}
+	opts.Width, opts.Height = maxInt(w, 1), maxInt(h, 1)
+	if opts.Mode == Fit {
+		opts.Mode = Fill
+	}
+	return opts, nil
+}
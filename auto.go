@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+	"image"
+	"io"
+	"math"
+)
+
+func init() {
+	RegisterEncoder(Auto, EncoderFunc(encodeAuto))
+}
+
+// autoSamples is the number of pixels BestFormat inspects at most; larger
+// images are sampled on a regular grid.
+const autoSamples = 1 << 18
+
+// BestFormat picks the output format that suits img: PNG for flat
+// graphics, such as logos, screenshots and diagrams, which it recognizes
+// by having few colors or large areas of identical pixels; WebP for
+// photographic images with transparency, which JPEG cannot store and PNG
+// stores large; and JPEG for other photographic images. It is what the
+// Auto format encodes with.
+func BestFormat(img image.Image) string {
+	if img.Bounds().Empty() {
+		return PNG
+	}
+	if flatGraphics(img) {
+		return PNG
+	}
+	if hasTransparency(img) {
+		return WEBP
+	}
+	return JPEG
+}
+
+// flatGraphics reports whether img has no more than 256 colors, or at
+// least half of its pixels equal to their right neighbor, which photos
+// rarely have even in their smoothest areas.
+func flatGraphics(img image.Image) bool {
+	b := img.Bounds()
+	step := int(math.Ceil(math.Sqrt(float64(b.Dx()) * float64(b.Dy()) / autoSamples)))
+	colors := make(map[[4]uint16]struct{})
+	same, pairs := 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := pixelKey(img, x, y)
+			if len(colors) <= 256 {
+				colors[c] = struct{}{}
+			}
+			if x+1 < b.Max.X {
+				pairs++
+				if pixelKey(img, x+1, y) == c {
+					same++
+				}
+			}
+		}
+	}
+	return len(colors) <= 256 || 2*same >= pairs
+}
+
+// pixelKey returns the premultiplied color of img at (x, y) reduced to
+// 8 bits per channel.
+func pixelKey(img image.Image, x, y int) [4]uint16 {
+	r, g, b, a := img.At(x, y).RGBA()
+	return [4]uint16{uint16(r >> 8), uint16(g >> 8), uint16(b >> 8), uint16(a >> 8)}
+}
+
+// encodedFormat returns the format Encode writes img in for format: its
+// canonical name, or the one BestFormat picks for Auto.
+func encodedFormat(img image.Image, format string) string {
+	format = normalizeFormat(format)
+	if format == Auto {
+		return BestFormat(img)
+	}
+	return format
+}
+
+// encodeAuto is the encoder of the Auto format.
+func encodeAuto(w io.Writer, img image.Image, opts Options) error {
+	opts.Format = BestFormat(img)
+	return Encode(w, img, opts)
+}
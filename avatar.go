@@ -0,0 +1,101 @@
+package thumbnail
+
+import (
+	"context"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/draw"
+)
+
+// Initials generates a placeholder avatar for a user without a photo: the
+// initials of name, the first letters of its first and last words, in
+// white Go Regular, which covers Latin, Greek and Cyrillic scripts, on a
+// background color derived from name, so that each user keeps theirs. The
+// avatar is opts.Width by opts.Height pixels, square when one of them is
+// zero, and is otherwise made like a thumbnail with opts, so that Circle,
+// Border and the output options apply as for photos.
+func Initials(ctx context.Context, name string, opts Options) (*Result, error) {
+	w, h := opts.Width, opts.Height
+	if w == 0 {
+		w = h
+	}
+	if h == 0 {
+		h = w
+	}
+	if w <= 0 || h <= 0 || w > MaxDimension || h > MaxDimension {
+		return nil, &OptionError{"Width", opts.Width, ErrInvalidDimensions}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, image.NewUniform(avatarColor(name)), image.Point{}, draw.Src)
+	step := Text(Caption{
+		Text:  initials(name),
+		Size:  math.Max(1, 0.4*float64(minInt(w, h))),
+		Color: color.White,
+	})
+	img, err := step.Apply(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	opts.Width, opts.Height = w, h
+	return Process(ctx, img, opts)
+}
+
+// initials returns the initials of name in upper case, or "?" if it has
+// no letters or digits.
+func initials(name string) string {
+	var first, last rune
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				if first == 0 {
+					first = r
+				} else {
+					last = r
+				}
+				break
+			}
+		}
+	}
+	switch {
+	case first == 0:
+		return "?"
+	case last == 0:
+		return string(unicode.ToUpper(first))
+	}
+	return string([]rune{unicode.ToUpper(first), unicode.ToUpper(last)})
+}
+
+// avatarColor returns the background color of the avatar of name: a hue
+// picked by a hash of the name, at a saturation and lightness that white
+// text reads well on.
+func avatarColor(name string) color.RGBA {
+	f := fnv.New32a()
+	f.Write([]byte(strings.ToLower(strings.TrimSpace(name))))
+	hue := float64(f.Sum32()%360) / 60
+	const s, l = 0.55, 0.45
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = c, x
+	case 1:
+		r, g = x, c
+	case 2:
+		g, b = c, x
+	case 3:
+		g, b = x, c
+	case 4:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.RGBA{uint8(math.Round((r + m) * 255)), uint8(math.Round((g + m) * 255)), uint8(math.Round((b + m) * 255)), 0xff}
+}
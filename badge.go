@@ -0,0 +1,151 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// badgeScale is the size of a Badge icon's longer side, and
+	// playScale the diameter of the PlayBadge button, relative to the
+	// thumbnail's shorter side.
+	badgeScale = 0.2
+	playScale  = 0.3
+	// badgeMargin is the gap between badges and the edges they are
+	// placed against, relative to the thumbnail's shorter side.
+	badgeMargin = 0.04
+)
+
+// badgeColor is the translucent dark backing of PlayBadge and TagBadge,
+// which keeps them legible on any image.
+var badgeColor = color.NRGBA{0, 0, 0, 0x99}
+
+// Badge returns a step that composites icon, such as a camera or a
+// gallery symbol, onto the image at g, in from the edges by a small
+// margin. The icon is scaled with the image, to a fifth of its shorter
+// side, so it looks the same on thumbnails of any size. The result is an
+// *image.RGBA with the image's bounds.
+func Badge(icon image.Image, g Gravity) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if icon == nil {
+			return nil, ErrNilImage
+		}
+		ib := icon.Bounds()
+		if ib.Empty() {
+			return img, nil
+		}
+		side := float64(minInt(img.Bounds().Dx(), img.Bounds().Dy()))
+		f := badgeScale * side / float64(maxInt(ib.Dx(), ib.Dy()))
+		scaled := image.NewRGBA(image.Rect(0, 0, maxInt(1, int(math.Round(float64(ib.Dx())*f))), maxInt(1, int(math.Round(float64(ib.Dy())*f)))))
+		draw.CatmullRom.Scale(scaled, scaled.Rect, icon, ib, draw.Src, nil)
+		return drawBadge(img, scaled, g), nil
+	})
+}
+
+// PlayBadge returns a step that draws a play button, a white triangle in a
+// translucent dark circle, over the middle of the image, as video poster
+// thumbnails have. The result is an *image.RGBA with the image's bounds.
+func PlayBadge() Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		if b.Empty() {
+			return img, nil
+		}
+		d := maxInt(1, int(math.Round(playScale*float64(minInt(b.Dx(), b.Dy())))))
+		return drawBadge(img, playButton(d), Center), nil
+	})
+}
+
+// TagBadge returns a step that draws text, such as "VIDEO", a duration
+// like "0:42" or a count like "+3", in white on a translucent dark pill at
+// g. The text is sized in proportion to the image. The result is an
+// *image.RGBA with the image's bounds.
+func TagBadge(text string, g Gravity) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		if text == "" || b.Empty() {
+			return img, nil
+		}
+		f, err := fontOrDefault(nil)
+		if err != nil {
+			return nil, err
+		}
+		c := Caption{Text: text, Color: color.White}
+		mark, err := c.render(f, math.Max(8, 0.09*float64(minInt(b.Dx(), b.Dy()))))
+		if err != nil {
+			return nil, err
+		}
+		mb := mark.Bounds()
+		h := mb.Dy() * 5 / 4
+		pill := image.NewRGBA(image.Rect(0, 0, mb.Dx()+h, h))
+		draw.Draw(pill, pill.Rect, image.NewUniform(badgeColor), image.Point{}, draw.Src)
+		roundCorners(pill, pill.Rect, Options{CornerRadius: h / 2})
+		draw.Draw(pill, mb.Add(pill.Rect.Size().Sub(mb.Size()).Div(2)), mark, image.Point{}, draw.Over)
+		return drawBadge(img, pill, g), nil
+	})
+}
+
+// drawBadge returns a copy of img with badge composited at g, in from the
+// edges by badgeMargin.
+func drawBadge(img image.Image, badge image.Image, g Gravity) *image.RGBA {
+	b := img.Bounds()
+	o := Overlay{Gravity: g}
+	if g != Center && g != Smart {
+		m := int(math.Round(badgeMargin * float64(minInt(b.Dx(), b.Dy()))))
+		o.OffsetX, o.OffsetY = m, m
+	}
+	return drawOverlay(img, badge, o)
+}
+
+// playButton returns a d×d play button for PlayBadge, with antialiased
+// edges.
+func playButton(d int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, d, d))
+	draw.Draw(m, m.Rect, image.NewUniform(badgeColor), image.Point{}, draw.Src)
+	roundCorners(m, m.Rect, Options{Circle: true})
+
+	// The triangle points right, with its centroid a little right of
+	// the center so that it looks centered.
+	s := float64(d)
+	x0, x1 := 0.38*s, 0.72*s
+	half := 0.2 * s
+	mid := s / 2
+	for y := 0; y < d; y++ {
+		for x := 0; x < d; x++ {
+			n := 0
+			for sy := 0; sy < maskSamples; sy++ {
+				for sx := 0; sx < maskSamples; sx++ {
+					px := float64(x) + (float64(sx)+0.5)/maskSamples
+					py := float64(y) + (float64(sy)+0.5)/maskSamples
+					// The half height shrinks linearly to the tip at x1.
+					if px >= x0 && px <= x1 && math.Abs(py-mid) <= half*(x1-px)/(x1-x0) {
+						n++
+					}
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			// White over the backing, premultiplied.
+			a := uint32(n) * 0xff / (maskSamples * maskSamples)
+			p := m.Pix[y*m.Stride+4*x : y*m.Stride+4*x+4]
+			for c := range p {
+				p[c] = uint8(a + uint32(p[c])*(0xff-a)/0xff)
+			}
+		}
+	}
+	return m
+}
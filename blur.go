@@ -0,0 +1,115 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// blurPadShrink is the factor the source is reduced by before it is
+	// blurred for the BlurPad background; the blur is then cheap whatever
+	// its width, and enlarging the result smooths it further.
+	blurPadShrink = 16
+	// blurPadSigma is the standard deviation of the BlurPad blur at the
+	// reduced size, so about 48 pixels on the canvas.
+	blurPadSigma = 3
+)
+
+// Blur returns a step that applies a Gaussian blur with standard deviation
+// sigma, in pixels, to the image, as for spoiler or placeholder images.
+// Alpha is blurred with the color. The result is an *image.RGBA. A sigma of
+// zero or less leaves the image unchanged.
+func Blur(sigma float64) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		if sigma <= 0 || b.Empty() {
+			return img, nil
+		}
+		src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+		return gaussianBlur(ctx, src, sigma)
+	})
+}
+
+// gaussianBlur returns src, which must have its origin at zero, blurred
+// with standard deviation sigma. Pixels beyond the edges are taken to
+// repeat the edge ones.
+func gaussianBlur(ctx context.Context, src *image.RGBA, sigma float64) (*image.RGBA, error) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	kernel := gaussianKernel(sigma)
+	r := len(kernel) / 2
+
+	// Blur rows into tmp, then its columns into dst. Colors are
+	// premultiplied, so transparent pixels do not darken their neighbors.
+	tmp := make([]float32, 4*w*h)
+	for y := 0; y < h; y++ {
+		if y%bandSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		row := src.Pix[y*src.Stride:]
+		out := tmp[4*w*y:]
+		for x := 0; x < w; x++ {
+			var s [4]float32
+			for k, weight := range kernel {
+				i := 4 * clampInt(x+k-r, 0, w-1)
+				s[0] += weight * float32(row[i])
+				s[1] += weight * float32(row[i+1])
+				s[2] += weight * float32(row[i+2])
+				s[3] += weight * float32(row[i+3])
+			}
+			copy(out[4*x:4*x+4], s[:])
+		}
+	}
+
+	dst := image.NewRGBA(src.Rect)
+	for y := 0; y < h; y++ {
+		if y%bandSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		dp := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var s [4]float32
+			for k, weight := range kernel {
+				i := 4 * (w*clampInt(y+k-r, 0, h-1) + x)
+				s[0] += weight * tmp[i]
+				s[1] += weight * tmp[i+1]
+				s[2] += weight * tmp[i+2]
+				s[3] += weight * tmp[i+3]
+			}
+			i := 4 * x
+			a := clamp255(int(s[3] + 0.5))
+			for c := 0; c < 3; c++ {
+				dp[i+c] = clamp255(minInt(int(s[c]+0.5), int(a)))
+			}
+			dp[i+3] = a
+		}
+	}
+	return dst, nil
+}
+
+// blurredFill covers canvas in dst with the middle of the sr part of src,
+// enlarged and blurred, as the background of BlurPad mode.
+func blurredFill(ctx context.Context, dst draw.Image, canvas image.Rectangle, src image.Image, sr image.Rectangle) error {
+	size := canvas.Size()
+	small := image.Pt(ceilDiv(size.X, blurPadShrink), ceilDiv(size.Y, blurPadShrink))
+	s := math.Max(float64(small.X)/float64(sr.Dx()), float64(small.Y)/float64(sr.Dy()))
+	cw := clampInt(int(math.Round(float64(small.X)/s)), 1, sr.Dx())
+	ch := clampInt(int(math.Round(float64(small.Y)/s)), 1, sr.Dy())
+	win := image.Rect(0, 0, cw, ch).Add(sr.Min).Add(image.Pt((sr.Dx()-cw)/2, (sr.Dy()-ch)/2))
+
+	tmp := image.NewRGBA(image.Rectangle{Max: small})
+	draw.BiLinear.Scale(tmp, tmp.Rect, src, win, draw.Src, nil)
+	blurred, err := gaussianBlur(ctx, tmp, blurPadSigma)
+	if err != nil {
+		return err
+	}
+	draw.BiLinear.Scale(dst, canvas, blurred, blurred.Rect, draw.Src, nil)
+	return nil
+}
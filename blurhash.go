@@ -0,0 +1,129 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// blurHashSize is the size of the longer side of the reduced copy of an
+// image that BlurHash is computed from; the few components it keeps need
+// no more detail.
+const blurHashSize = 64
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns the BlurHash (https://blurha.sh) of img with x by y
+// components, each from 1 to 9, a short string from which clients draw a
+// blurred placeholder while the image loads. Four by three components suit
+// most landscape images. Transparent parts are taken to be white.
+func BlurHash(img image.Image, x, y int) (string, error) {
+	if x < 1 || x > 9 || y < 1 || y > 9 {
+		return "", ErrInvalidBlurHash
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return "", ErrEmptyImage
+	}
+	scale := math.Min(1, blurHashSize/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Rect, img, b, draw.Src, nil)
+
+	var toLinear [256]float64
+	for i := range toLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			toLinear[i] = v / 12.92
+		} else {
+			toLinear[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	lin := make([][3]float64, w*h)
+	for i := range lin {
+		p := small.Pix[4*i : 4*i+4]
+		// Premultiplied colors over white.
+		for c := 0; c < 3; c++ {
+			lin[i][c] = toLinear[int(p[c])+255-int(p[3])]
+		}
+	}
+
+	// Each component is the image's correlation with a product of cosines
+	// of i half-periods across and j down.
+	factors := make([][3]float64, 0, x*y)
+	cosX := make([]float64, w)
+	cosY := make([]float64, h)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			for px := range cosX {
+				cosX[px] = math.Cos(math.Pi * float64(i) * float64(px) / float64(w))
+			}
+			for py := range cosY {
+				cosY[py] = math.Cos(math.Pi * float64(j) * float64(py) / float64(h))
+			}
+			var f [3]float64
+			for py := 0; py < h; py++ {
+				for px := 0; px < w; px++ {
+					basis := cosX[px] * cosY[py]
+					p := lin[py*w+px]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			s := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * s, f[1] * s, f[2] * s})
+		}
+	}
+
+	hash := make([]byte, 0, 6+2*len(factors))
+	hash = appendBase83(hash, (x-1)+(y-1)*9, 1)
+	maxAC := 1.0
+	if len(factors) > 1 {
+		var actual float64
+		for _, f := range factors[1:] {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		q := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(q+1) / 166
+		hash = appendBase83(hash, q, 1)
+	} else {
+		hash = appendBase83(hash, 0, 1)
+	}
+	dc := factors[0]
+	hash = appendBase83(hash, linearToSRGB8(dc[0])<<16|linearToSRGB8(dc[1])<<8|linearToSRGB8(dc[2]), 4)
+	for _, f := range factors[1:] {
+		q := func(v float64) int {
+			v /= maxAC
+			sp := math.Copysign(math.Sqrt(math.Abs(v)), v)
+			return int(math.Max(0, math.Min(18, math.Floor(sp*9+9.5))))
+		}
+		hash = appendBase83(hash, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return string(hash), nil
+}
+
+// linearToSRGB8 returns the 8-bit sRGB encoding of the linear value v.
+func linearToSRGB8(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	return int(sRGBEncode(v)*255 + 0.5)
+}
+
+// appendBase83 appends v to dst as n base 83 digits, most significant
+// first.
+func appendBase83(dst []byte, v, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		d := v
+		for k := 0; k < i; k++ {
+			d /= 83
+		}
+		dst = append(dst, base83[d%83])
+	}
+	return dst
+}
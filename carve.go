@@ -0,0 +1,174 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// carveLimit is the largest factor by which Carve narrows or shortens the
+// scaled source by removing seams. Greater changes of aspect ratio are
+// partly made by stretching, since carving away more than half of an image
+// leaves too little of it intact.
+const carveLimit = 2
+
+// carve scales the sr part of src into the dr part of dst for Carve mode:
+// it scales the source so that it covers dr, by at most carveLimit along
+// one axis, then removes the seams of least detail until it fits.
+func carve(ctx context.Context, dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, opts Options) error {
+	w, h := dr.Dx(), dr.Dy()
+	s := math.Max(float64(w)/float64(sr.Dx()), float64(h)/float64(sr.Dy()))
+	iw := clampInt(int(math.Round(float64(sr.Dx())*s)), w, carveLimit*w)
+	ih := clampInt(int(math.Round(float64(sr.Dy())*s)), h, carveLimit*h)
+	m := image.NewRGBA(image.Rect(0, 0, iw, ih))
+	if err := scale(ctx, m, m.Rect, src, sr, opts); err != nil {
+		return err
+	}
+	var err error
+	if iw > w {
+		if m, err = carveSeams(ctx, m, w); err != nil {
+			return err
+		}
+	}
+	if ih > h {
+		if m, err = carveSeams(ctx, transpose(m), h); err != nil {
+			return err
+		}
+		m = transpose(m)
+	}
+	draw.Draw(dst, dr, m, image.Point{}, draw.Src)
+	return nil
+}
+
+// carveSeams narrows m, which must have its origin at zero, to width w by
+// repeatedly removing the vertical seam, a path of one pixel per row each
+// touching the last, that crosses the least detail.
+func carveSeams(ctx context.Context, m *image.RGBA, w int) (*image.RGBA, error) {
+	cw, h := m.Rect.Dx(), m.Rect.Dy()
+	// Rows are kept at their full stride as pixels are removed, with the
+	// first cw of each in use.
+	pix := make([]uint32, cw*h)
+	lum := make([]int32, cw*h)
+	for y := 0; y < h; y++ {
+		row := m.Pix[y*m.Stride:]
+		for x := 0; x < cw; x++ {
+			p := row[4*x : 4*x+4]
+			pix[y*cw+x] = uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+			lum[y*cw+x] = int32(luma(p[0], p[1], p[2]))
+		}
+	}
+	stride := cw
+	energy := make([]int32, stride*h)
+	cost := make([]int32, stride*h)
+	seam := make([]int, h)
+	// measure sets the energy of the pixels from x0 to x1 of row y: the
+	// luma differences across them horizontally and vertically.
+	measure := func(y, x0, x1 int) {
+		row := lum[y*stride : y*stride+cw]
+		above := lum[maxInt(y-1, 0)*stride:][:cw]
+		below := lum[minInt(y+1, h-1)*stride:][:cw]
+		e := energy[y*stride:]
+		for x := maxInt(x0, 0); x < minInt(x1, cw); x++ {
+			l, r := maxInt(x-1, 0), minInt(x+1, cw-1)
+			e[x] = abs32(row[r]-row[l]) + abs32(below[x]-above[x])
+		}
+	}
+	for y := 0; y < h; y++ {
+		measure(y, 0, cw)
+	}
+
+	for cw > w {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// cost holds the least energy of a seam from the top row to each
+		// pixel.
+		copy(cost[:cw], energy[:cw])
+		for y := 1; y < h; y++ {
+			c := cost[y*stride : y*stride+cw]
+			e := energy[y*stride : y*stride+cw]
+			prev := cost[(y-1)*stride : (y-1)*stride+cw]
+			if cw == 1 {
+				c[0] = e[0] + prev[0]
+				continue
+			}
+			c[0] = e[0] + min32(prev[0], prev[1])
+			for x := 1; x < cw-1; x++ {
+				c[x] = e[x] + min32(prev[x-1], min32(prev[x], prev[x+1]))
+			}
+			c[cw-1] = e[cw-1] + min32(prev[cw-2], prev[cw-1])
+		}
+		// Trace the cheapest seam back up from the bottom row.
+		last := (h - 1) * stride
+		x := cw / 2
+		for i := 0; i < cw; i++ {
+			if cost[last+i] < cost[last+x] {
+				x = i
+			}
+		}
+		seam[h-1] = x
+		for y := h - 2; y >= 0; y-- {
+			at := y * stride
+			best := x
+			if x > 0 && cost[at+x-1] < cost[at+best] {
+				best = x - 1
+			}
+			if x < cw-1 && cost[at+x+1] < cost[at+best] {
+				best = x + 1
+			}
+			x = best
+			seam[y] = x
+		}
+		for y, x := range seam {
+			at := y * stride
+			copy(pix[at+x:at+cw-1], pix[at+x+1:at+cw])
+			copy(lum[at+x:at+cw-1], lum[at+x+1:at+cw])
+			copy(energy[at+x:at+cw-1], energy[at+x+1:at+cw])
+		}
+		cw--
+		// Removing the seam only changes the neighbors of pixels next to
+		// it, since it moves by at most one column from row to row.
+		for y, x := range seam {
+			measure(y, x-2, x+2)
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			p := pix[y*stride+x]
+			row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = uint8(p), uint8(p>>8), uint8(p>>16), uint8(p>>24)
+		}
+	}
+	return out, nil
+}
+
+// transpose returns m, which must have its origin at zero, mirrored along
+// its diagonal.
+func transpose(m *image.RGBA) *image.RGBA {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	t := image.NewRGBA(image.Rect(0, 0, h, w))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			copy(t.Pix[x*t.Stride+4*y:x*t.Stride+4*y+4], m.Pix[y*m.Stride+4*x:y*m.Stride+4*x+4])
+		}
+	}
+	return t
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,147 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+)
+
+// cmykGrid is the number of points per channel of the lookup table that
+// ICC-based CMYK conversion interpolates in.
+const cmykGrid = 17
+
+// jpegComponents returns the number of color components in the frame
+// header of a JPEG stream, or 0 if none precedes the first scan.
+func jpegComponents(data []byte) int {
+	for _, s := range jpegSegments(data) {
+		switch s.marker {
+		case 0xc4, 0xc8, 0xcc: // DHT, JPG, DAC
+			continue
+		}
+		if s.marker >= 0xc0 && s.marker <= 0xcf && len(s.payload) >= 6 {
+			return int(s.payload[5])
+		}
+	}
+	return 0
+}
+
+// decodeCMYK decodes a 4-component JPEG stream. Streams without an Adobe
+// APP14 marker, which image/jpeg rejects, are read as plain CMYK as
+// libjpeg does; with one, the marker's transform tells inverted CMYK from
+// YCCK. The result is converted to sRGB through the ICC profile embedded
+// in the stream or else opts.CMYKProfile, and is otherwise the
+// *image.CMYK image/jpeg decodes, converted naively as color.CMYK is.
+func decodeCMYK(ctx context.Context, data []byte, opts *Options) (image.Image, error) {
+	adobe := false
+	for _, s := range jpegSegments(data) {
+		if s.marker == 0xee && bytes.HasPrefix(s.payload, []byte("Adobe")) {
+			adobe = true
+			break
+		}
+	}
+	src := data
+	if !adobe {
+		// Mark the stream as Adobe CMYK (transform 0) and undo the
+		// inversion that implies below.
+		app14 := []byte{0xff, 0xee, 0, 14, 'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0}
+		src = make([]byte, 0, len(data)+len(app14))
+		src = append(append(append(src, data[:2]...), app14...), data[2:]...)
+	}
+	img, err := jpeg.Decode(ctxReader{ctx, bytes.NewReader(src)})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	m, ok := img.(*image.CMYK)
+	if !ok {
+		return img, nil
+	}
+	if !adobe {
+		for i := range m.Pix {
+			m.Pix[i] = 0xff - m.Pix[i]
+		}
+	}
+
+	var profile *ICCProfile
+	if p, err := ParseICCProfile(jpegICC(data)); err == nil && p.ColorSpace == "CMYK" {
+		profile = p
+	} else if opts != nil {
+		profile = opts.CMYKProfile
+	}
+	if profile == nil {
+		return m, nil
+	}
+	return cmykToRGB(ctx, m, profile)
+}
+
+// cmykToRGB converts m to sRGB through p. The profile is sampled on a
+// cmykGrid⁴ grid once, and pixels are interpolated between grid points.
+func cmykToRGB(ctx context.Context, m *image.CMYK, p *ICCProfile) (*image.RGBA, error) {
+	lut := make([][3]float32, cmykGrid*cmykGrid*cmykGrid*cmykGrid)
+	in := make([]float64, 4)
+	for i := range lut {
+		v := i
+		for c := 3; c >= 0; c-- {
+			in[c] = float64(v%cmykGrid) / (cmykGrid - 1)
+			v /= cmykGrid
+		}
+		r, g, b := p.sRGB(in)
+		lut[i] = [3]float32{float32(r), float32(g), float32(b)}
+	}
+
+	b := m.Bounds()
+	dst := image.NewRGBA(b)
+	var strides [4]int
+	for c, s := 3, 1; c >= 0; c, s = c-1, s*cmykGrid {
+		strides[c] = s
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if (y-b.Min.Y)%bandSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		si := m.PixOffset(b.Min.X, y)
+		di := dst.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, si, di = x+1, si+4, di+4 {
+			var base int
+			var frac [4]float32
+			for c := 0; c < 4; c++ {
+				f := float32(m.Pix[si+c]) * (cmykGrid - 1) / 0xff
+				k := int(f)
+				if k >= cmykGrid-1 {
+					k = cmykGrid - 2
+				}
+				frac[c] = f - float32(k)
+				base += k * strides[c]
+			}
+			var rgb [3]float32
+			for corner := 0; corner < 16; corner++ {
+				w, idx := float32(1), base
+				for c := 0; c < 4; c++ {
+					if corner&(1<<uint(c)) != 0 {
+						w *= frac[c]
+						idx += strides[c]
+					} else {
+						w *= 1 - frac[c]
+					}
+				}
+				if w == 0 {
+					continue
+				}
+				e := &lut[idx]
+				rgb[0] += w * e[0]
+				rgb[1] += w * e[1]
+				rgb[2] += w * e[2]
+			}
+			for c := 0; c < 3; c++ {
+				dst.Pix[di+c] = clamp255(int(rgb[c]*0xff + 0.5))
+			}
+			dst.Pix[di+3] = 0xff
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,27 @@
+package thumbnail
+
+import (
+	"image"
+	"io"
+)
+
+// Codec decodes an image format that the standard library and
+// golang.org/x/image do not, typically by binding an external library.
+// A Codec that also implements Encoder can write the format too.
+type Codec interface {
+	Decode(r io.Reader) (image.Image, error)
+	DecodeConfig(r io.Reader) (image.Config, error)
+}
+
+// RegisterCodec makes c decode sources that start with any of magic, which
+// may use "?" to match any byte as in image.RegisterFormat, and reports
+// them as format name. If c implements Encoder it is also registered as the
+// encoder for name, with extensions as for RegisterEncoder.
+func RegisterCodec(name string, c Codec, magic []string, extensions ...string) {
+	for _, m := range magic {
+		image.RegisterFormat(name, m, c.Decode, c.DecodeConfig)
+	}
+	if enc, ok := c.(Encoder); ok {
+		RegisterEncoder(name, enc, extensions...)
+	}
+}
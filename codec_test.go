@@ -0,0 +1,37 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// testImage returns a w×h image of smooth gradients, hard edges and a
+// little noise, with alpha varying across it when alpha is set.
+func testImage(w, h int, alpha bool) *image.NRGBA {
+	rng := rand.New(rand.NewSource(int64(w*h) + 1))
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(255 * x / w), uint8(255 * y / h), uint8(128 + rng.Intn(16)), 255}
+			if dx, dy := x-w/2, y-h/2; dx*dx+dy*dy < w*h/16 {
+				c.R, c.G = 240, 40
+			}
+			if alpha {
+				c.A = uint8(255 * (x + y) / (w + h))
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func psnr(t *testing.T, img, ref image.Image) float64 {
+	t.Helper()
+	p, err := PSNR(img, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,170 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// CollageLayout selects how a Collage arranges its images.
+type CollageLayout int
+
+const (
+	// CollageGrid arranges the images in equal cells of a grid about as
+	// wide as it is tall, 2×2 for four, with the cells of a short last row
+	// widened to fill it. It is the default.
+	CollageGrid CollageLayout = iota
+	// CollageFeatured gives the first image the left two thirds and
+	// stacks the others, usually three, in the column to its right.
+	CollageFeatured
+	// CollageMosaic arranges the images in rows of equal height, with
+	// widths that follow their aspect ratios, so that little is cropped.
+	CollageMosaic
+)
+
+// valid reports whether l is a known collage layout.
+func (l CollageLayout) valid() bool {
+	return l >= CollageGrid && l <= CollageMosaic
+}
+
+// Collage describes a composite thumbnail made by MakeCollage from several
+// images, as chat apps show for group and album previews.
+type Collage struct {
+	Layout CollageLayout
+
+	// Width and Height are the size of the collage.
+	Width, Height int
+
+	// Spacing is the gap in pixels between images.
+	Spacing int
+
+	// Background shows in the gaps. Nil is transparent.
+	Background color.Color
+
+	// Options are the options the images are made into thumbnails with to
+	// fill their cells, apart from Width, Height and Mode; its Gravity,
+	// for example, places the crops.
+	Options Options
+}
+
+// MakeCollage combines images into one thumbnail as c describes. Each image
+// fills its cell, cropped as by Fill mode.
+func MakeCollage(ctx context.Context, images []image.Image, c Collage) (*image.RGBA, error) {
+	if len(images) == 0 || !c.Layout.valid() || c.Width <= 0 || c.Height <= 0 || c.Spacing < 0 {
+		return nil, ErrInvalidCollage
+	}
+	for _, img := range images {
+		if img == nil {
+			return nil, ErrNilImage
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+	if c.Background != nil {
+		draw.Draw(dst, dst.Rect, image.NewUniform(c.Background), image.Point{}, draw.Src)
+	}
+	opts := c.Options
+	opts.Mode = Fill
+	for i, cell := range c.cells(images) {
+		if cell.Empty() {
+			continue
+		}
+		opts.Width, opts.Height = cell.Dx(), cell.Dy()
+		r, err := Process(ctx, images[i], opts)
+		if err != nil {
+			return nil, err
+		}
+		draw.Draw(dst, cell, r.Image, r.Image.Bounds().Min, draw.Src)
+	}
+	return dst, nil
+}
+
+// cells returns the cell of each of images in the collage.
+func (c Collage) cells(images []image.Image) []image.Rectangle {
+	n := len(images)
+	cells := make([]image.Rectangle, 0, n)
+	switch {
+	case n == 1:
+		cells = append(cells, image.Rect(0, 0, c.Width, c.Height))
+	case c.Layout == CollageFeatured:
+		xs := spans(c.Width, []float64{2, 1}, c.Spacing)
+		cells = append(cells, image.Rect(xs[0], 0, xs[1], c.Height))
+		ys := spans(c.Height, equalWeights(n-1), c.Spacing)
+		for i := 0; i < n-1; i++ {
+			cells = append(cells, image.Rect(xs[2], ys[2*i], xs[3], ys[2*i+1]))
+		}
+	case c.Layout == CollageMosaic:
+		// With r rows the row height is Height/r, so rows hold aspect
+		// ratios adding up to Width*r/Height and r*r = A*Height/Width,
+		// for A the sum of the ratios.
+		aspects := make([]float64, n)
+		var total float64
+		for i, img := range images {
+			size := img.Bounds().Size()
+			aspects[i] = float64(maxInt(size.X, 1)) / float64(maxInt(size.Y, 1))
+			total += aspects[i]
+		}
+		rows := clampInt(int(math.Round(math.Sqrt(total*float64(c.Height)/float64(c.Width)))), 1, n)
+		// Break rows where the running sum of ratios is nearest each
+		// multiple of total/rows, keeping at least one image per row.
+		starts := []int{0}
+		sum := aspects[0]
+		for i := 1; i < n && len(starts) < rows; i++ {
+			if n-i == rows-len(starts) || sum+aspects[i]/2 >= total*float64(len(starts))/float64(rows) {
+				starts = append(starts, i)
+			}
+			sum += aspects[i]
+		}
+		starts = append(starts, n)
+		ys := spans(c.Height, equalWeights(rows), c.Spacing)
+		for r := 0; r < rows; r++ {
+			xs := spans(c.Width, aspects[starts[r]:starts[r+1]], c.Spacing)
+			for i := 0; i < len(xs)/2; i++ {
+				cells = append(cells, image.Rect(xs[2*i], ys[2*r], xs[2*i+1], ys[2*r+1]))
+			}
+		}
+	default:
+		cols := int(math.Ceil(math.Sqrt(float64(n))))
+		rows := ceilDiv(n, cols)
+		ys := spans(c.Height, equalWeights(rows), c.Spacing)
+		for r := 0; r < rows; r++ {
+			k := minInt(cols, n-r*cols)
+			xs := spans(c.Width, equalWeights(k), c.Spacing)
+			for i := 0; i < k; i++ {
+				cells = append(cells, image.Rect(xs[2*i], ys[2*r], xs[2*i+1], ys[2*r+1]))
+			}
+		}
+	}
+	return cells
+}
+
+// spans divides total pixels among len(weights) parts in proportion to the
+// weights, with spacing pixels between them, returning the start and end
+// of each part in turn.
+func spans(total int, weights []float64, spacing int) []int {
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	avail := maxInt(0, total-spacing*(len(weights)-1))
+	out := make([]int, 0, 2*len(weights))
+	var acc float64
+	for i, w := range weights {
+		start := int(math.Round(acc/sum*float64(avail))) + i*spacing
+		acc += w
+		end := int(math.Round(acc/sum*float64(avail))) + i*spacing
+		out = append(out, start, end)
+	}
+	return out
+}
+
+// equalWeights returns n weights of one, for spans to divide evenly.
+func equalWeights(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 1
+	}
+	return w
+}
@@ -0,0 +1,62 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// newThumb returns the image a thumbnail of the given size is scaled
+// into: an *image.RGBA64 for sources with 16 bits per channel when
+// opts.HighBitDepth is set, otherwise an *image.RGBA. It is taken from
+// opts.pool when that is set.
+func newThumb(size image.Point, src image.Image, opts Options) draw.Image {
+	r := image.Rectangle{Max: size}
+	deep := opts.HighBitDepth && highBitDepth(src.ColorModel())
+	switch {
+	case opts.pool != nil && deep:
+		return opts.pool.rgba64(r, true)
+	case opts.pool != nil:
+		return opts.pool.rgba(r, true)
+	case deep:
+		return image.NewRGBA64(r)
+	}
+	return image.NewRGBA(r)
+}
+
+// highBitDepth reports whether m has 16 bits per channel.
+func highBitDepth(m color.Model) bool {
+	return m == color.RGBA64Model || m == color.NRGBA64Model || m == color.Gray16Model
+}
+
+// matchColorModel converts a scaled thumbnail back to the color model of
+// its source when opts.PreserveColorModel is set. Grayscale sources give
+// *image.Gray thumbnails, or *image.Gray16 ones for 16-bit sources with
+// opts.HighBitDepth, and paletted sources give *image.Paletted ones
+// quantized to the source palette. Other sources, and all sources when the
+// option is off, keep the image the thumbnail was scaled into.
+func matchColorModel(thumb draw.Image, src image.Image, opts Options) image.Image {
+	if !opts.PreserveColorModel {
+		return thumb
+	}
+	b := thumb.Bounds()
+	if pal, ok := src.ColorModel().(color.Palette); ok {
+		p := image.NewPaletted(b, pal)
+		// Nearest-color mapping keeps the flat areas typical of
+		// paletted images free of dithering noise.
+		draw.Draw(p, b, thumb, b.Min, draw.Src)
+		return p
+	}
+	if src.ColorModel() == color.Gray16Model && opts.HighBitDepth {
+		g := image.NewGray16(b)
+		draw.Draw(g, b, thumb, b.Min, draw.Src)
+		return g
+	}
+	if m := src.ColorModel(); m == color.GrayModel || m == color.Gray16Model {
+		g := image.NewGray(b)
+		draw.Draw(g, b, thumb, b.Min, draw.Src)
+		return g
+	}
+	return thumb
+}
@@ -0,0 +1,47 @@
+package thumbnail
+
+import (
+	"context"
+	"sync"
+)
+
+var concurrency struct {
+	sync.RWMutex
+	slots chan struct{} // nil when unbounded
+}
+
+// SetMaxConcurrent bounds the number of thumbnails decoded and scaled at
+// once across the process to n, so that a burst of requests queues instead
+// of driving the process into swap. Process, ProcessReader, ProcessFile,
+// ProcessSizes, GenerateInto, GenerateGIF, GenerateAnimatedWebP,
+// GenerateAPNG, the functions built on them and Pipeline decoding each
+// hold a slot while they run; calls past the bound wait for one, or until
+// their context is done. n <= 0 removes the bound, which is the default.
+// Calls already running keep the slots they hold under the previous bound.
+func SetMaxConcurrent(n int) {
+	var slots chan struct{}
+	if n > 0 {
+		slots = make(chan struct{}, n)
+	}
+	concurrency.Lock()
+	concurrency.slots = slots
+	concurrency.Unlock()
+}
+
+// acquire waits for a slot under the bound SetMaxConcurrent sets and
+// returns the function that gives it back, or ctx.Err() if ctx is done
+// first.
+func acquire(ctx context.Context) (release func(), err error) {
+	concurrency.RLock()
+	slots := concurrency.slots
+	concurrency.RUnlock()
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,318 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Config is the JSON file format read by LoadConfig. Presets may be given
+// either as an object of option fields or as a transformation string in
+// the syntax of ParseTransform:
+//
+//	{
+//	  "defaults": {"quality": 80, "filter": "lanczos"},
+//	  "presets": {
+//	    "avatar": {"width": 64, "height": 64, "mode": "fill"},
+//	    "card": "w_320,h_180,c_fill,g_north"
+//	  }
+//	}
+//
+// Preset fields that are not given are taken from the defaults.
+type Config struct {
+	Defaults ConfigOptions            `json:"defaults"`
+	Presets  map[string]ConfigOptions `json:"presets"`
+}
+
+// ConfigOptions is the JSON form of Options used in a Config. Only fields
+// that are present override the options they are applied to.
+type ConfigOptions struct {
+	Width              *int           `json:"width,omitempty"`
+	Height             *int           `json:"height,omitempty"`
+	Quality            *int           `json:"quality,omitempty"`
+	Filter             *Filter        `json:"filter,omitempty"`
+	Mode               *Mode          `json:"mode,omitempty"`
+	Gravity            *Gravity       `json:"gravity,omitempty"`
+	LinearLight        *bool          `json:"linear_light,omitempty"`
+	Parallelism        *int           `json:"parallelism,omitempty"`
+	NoPrefilter        *bool          `json:"no_prefilter,omitempty"`
+	NoUpscale          *bool          `json:"no_upscale,omitempty"`
+	Background         *string        `json:"background,omitempty"` // as for the b_ transformation
+	Border             *int           `json:"border,omitempty"`
+	BorderColor        *string        `json:"border_color,omitempty"` // as for Background
+	Frame              *Frame         `json:"frame,omitempty"`
+	CornerRadius       *int           `json:"corner_radius,omitempty"`
+	Circle             *bool          `json:"circle,omitempty"`
+	Obscure            *Obscure       `json:"obscure,omitempty"`
+	Matte              *string        `json:"matte,omitempty"` // as for Background
+	Scale              *float64       `json:"scale,omitempty"`
+	Passthrough        *bool          `json:"passthrough,omitempty"`
+	PreserveColorModel *bool          `json:"preserve_color_model,omitempty"`
+	NoAutoOrient       *bool          `json:"no_auto_orient,omitempty"`
+	NoColorConvert     *bool          `json:"no_color_convert,omitempty"`
+	HighBitDepth       *bool          `json:"high_bit_depth,omitempty"`
+	EmbeddedThumbnail  *bool          `json:"embedded_thumbnail,omitempty"`
+	NoDraft            *bool          `json:"no_draft,omitempty"`
+	KeepMetadata       *MetadataKind  `json:"keep_metadata,omitempty"`
+	StripMetadata      *MetadataStrip `json:"strip_metadata,omitempty"`
+	AspectRatio        *string        `json:"aspect_ratio,omitempty"` // as accepted by ParseAspectRatio
+	DominantColor      *bool          `json:"dominant_color,omitempty"`
+	BlurHash           *bool          `json:"blur_hash,omitempty"`
+	ThumbHash          *bool          `json:"thumb_hash,omitempty"`
+	LQIP               *string        `json:"lqip,omitempty"`
+	Hash               *HashKind      `json:"hash,omitempty"`
+	Format             *string        `json:"format,omitempty"`
+	Lossless           *bool          `json:"lossless,omitempty"`
+	Progressive        *bool          `json:"progressive,omitempty"`
+	Subsampling        *Subsampling   `json:"subsampling,omitempty"`
+	Compression        *Compression   `json:"compression,omitempty"`
+	Palette            *bool          `json:"palette,omitempty"`
+	Quantizer          *Quantizer     `json:"quantizer,omitempty"`
+	Dither             *Dither        `json:"dither,omitempty"`
+	MaxFrames          *int           `json:"max_frames,omitempty"`
+	MaxDuration        *string        `json:"max_duration,omitempty"` // as accepted by time.ParseDuration
+	Page               *int           `json:"page,omitempty"`
+	MaxPixels          *int           `json:"max_pixels,omitempty"`
+	MaxWidth           *int           `json:"max_width,omitempty"`
+	MaxHeight          *int           `json:"max_height,omitempty"`
+
+	// Transform holds a transformation string when the options were given
+	// as a JSON string. It is applied before the other fields.
+	Transform string `json:"-"`
+}
+
+// UnmarshalJSON accepts either an object of option fields or a
+// transformation string.
+func (c *ConfigOptions) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*c = ConfigOptions{}
+		return json.Unmarshal(data, &c.Transform)
+	}
+	type plain ConfigOptions // without this method
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*plain)(c))
+}
+
+// Apply returns opts overridden by the fields present in c.
+func (c ConfigOptions) Apply(opts Options) (Options, error) {
+	opts, err := parseTransform(opts, c.Transform)
+	if err != nil {
+		return Options{}, err
+	}
+	if c.Width != nil {
+		opts.Width = *c.Width
+	}
+	if c.Height != nil {
+		opts.Height = *c.Height
+	}
+	if c.Quality != nil {
+		opts.Quality = *c.Quality
+	}
+	if c.Filter != nil {
+		opts.Filter = *c.Filter
+	}
+	if c.Mode != nil {
+		opts.Mode = *c.Mode
+	}
+	if c.Gravity != nil {
+		opts.Gravity = *c.Gravity
+	}
+	if c.LinearLight != nil {
+		opts.LinearLight = *c.LinearLight
+	}
+	if c.Parallelism != nil {
+		opts.Parallelism = *c.Parallelism
+	}
+	if c.NoPrefilter != nil {
+		opts.NoPrefilter = *c.NoPrefilter
+	}
+	if c.NoUpscale != nil {
+		opts.NoUpscale = *c.NoUpscale
+	}
+	if c.Background != nil {
+		if err = opts.setBackground(*c.Background); err != nil {
+			return Options{}, err
+		}
+	}
+	if c.Border != nil {
+		opts.Border = *c.Border
+	}
+	if c.BorderColor != nil {
+		if opts.BorderColor, err = parseColor(*c.BorderColor); err != nil {
+			return Options{}, err
+		}
+	}
+	if c.Frame != nil {
+		opts.Frame = *c.Frame
+	}
+	if c.CornerRadius != nil {
+		opts.CornerRadius = *c.CornerRadius
+	}
+	if c.Circle != nil {
+		opts.Circle = *c.Circle
+	}
+	if c.Obscure != nil {
+		opts.Obscure = *c.Obscure
+	}
+	if c.Matte != nil {
+		if opts.Matte, err = parseColor(*c.Matte); err != nil {
+			return Options{}, err
+		}
+	}
+	if c.Scale != nil {
+		opts.Scale = *c.Scale
+	}
+	if c.Passthrough != nil {
+		opts.Passthrough = *c.Passthrough
+	}
+	if c.PreserveColorModel != nil {
+		opts.PreserveColorModel = *c.PreserveColorModel
+	}
+	if c.NoAutoOrient != nil {
+		opts.NoAutoOrient = *c.NoAutoOrient
+	}
+	if c.NoColorConvert != nil {
+		opts.NoColorConvert = *c.NoColorConvert
+	}
+	if c.HighBitDepth != nil {
+		opts.HighBitDepth = *c.HighBitDepth
+	}
+	if c.EmbeddedThumbnail != nil {
+		opts.EmbeddedThumbnail = *c.EmbeddedThumbnail
+	}
+	if c.NoDraft != nil {
+		opts.NoDraft = *c.NoDraft
+	}
+	if c.KeepMetadata != nil {
+		opts.KeepMetadata = *c.KeepMetadata
+	}
+	if c.StripMetadata != nil {
+		opts.StripMetadata = *c.StripMetadata
+	}
+	if c.AspectRatio != nil {
+		if opts.AspectRatio, err = ParseAspectRatio(*c.AspectRatio); err != nil {
+			return Options{}, err
+		}
+	}
+	if c.DominantColor != nil {
+		opts.DominantColor = *c.DominantColor
+	}
+	if c.BlurHash != nil {
+		opts.BlurHash = *c.BlurHash
+	}
+	if c.ThumbHash != nil {
+		opts.ThumbHash = *c.ThumbHash
+	}
+	if c.LQIP != nil {
+		opts.LQIP = *c.LQIP
+	}
+	if c.Hash != nil {
+		opts.Hash = *c.Hash
+	}
+	if c.Format != nil {
+		opts.Format = *c.Format
+	}
+	if c.Lossless != nil {
+		opts.Lossless = *c.Lossless
+	}
+	if c.Progressive != nil {
+		opts.Progressive = *c.Progressive
+	}
+	if c.Subsampling != nil {
+		opts.Subsampling = *c.Subsampling
+	}
+	if c.Compression != nil {
+		opts.Compression = *c.Compression
+	}
+	if c.Palette != nil {
+		opts.Palette = *c.Palette
+	}
+	if c.Quantizer != nil {
+		opts.Quantizer = *c.Quantizer
+	}
+	if c.Dither != nil {
+		opts.Dither = *c.Dither
+	}
+	if c.MaxFrames != nil {
+		opts.MaxFrames = *c.MaxFrames
+	}
+	if c.MaxDuration != nil {
+		if opts.MaxDuration, err = time.ParseDuration(*c.MaxDuration); err != nil {
+			return Options{}, err
+		}
+	}
+	if c.Page != nil {
+		opts.Page = *c.Page
+	}
+	if c.MaxPixels != nil {
+		opts.MaxPixels = *c.MaxPixels
+	}
+	if c.MaxWidth != nil {
+		opts.MaxWidth = *c.MaxWidth
+	}
+	if c.MaxHeight != nil {
+		opts.MaxHeight = *c.MaxHeight
+	}
+	return opts, nil
+}
+
+// LoadConfig reads a JSON Config from r, replaces the options returned by
+// DefaultOptions with its defaults, applied to the built-in ones rather
+// than to those of an earlier load, and registers its presets. Nothing is
+// changed if any part of the configuration is invalid.
+//
+// Only JSON is read, with encoding/json: YAML would need a parser from
+// outside the standard library, which this package does not depend on.
+// YAML configurations can be converted to JSON before they are loaded.
+func LoadConfig(r io.Reader) error {
+	var cfg Config
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return fmt.Errorf("thumbnail: config: %w", err)
+	}
+
+	def, err := cfg.Defaults.Apply(builtinDefaults)
+	if err != nil {
+		return fmt.Errorf("thumbnail: config defaults: %w", err)
+	}
+	if err := def.Validate(); err != nil {
+		return fmt.Errorf("thumbnail: config defaults: %w", err)
+	}
+	named := make(map[string]Options, len(cfg.Presets))
+	for name, c := range cfg.Presets {
+		opts, err := c.Apply(def)
+		if err == nil {
+			err = opts.Validate()
+		}
+		if err != nil {
+			return fmt.Errorf("thumbnail: config preset %q: %w", name, err)
+		}
+		named[name] = opts
+	}
+
+	defaults.Lock()
+	defaults.opts = def
+	defaults.Unlock()
+	presets.Lock()
+	for name, opts := range named {
+		presets.m[name] = opts
+	}
+	presets.Unlock()
+	return nil
+}
+
+// LoadConfigFile is like LoadConfig but reads the named file.
+func LoadConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return LoadConfig(f)
+}
@@ -0,0 +1,97 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// DropShadow returns a step that casts a soft shadow of the image, offset
+// by (dx, dy) pixels and blurred with standard deviation sigma, onto a
+// transparent canvas enlarged to hold it, as for cards in gallery UIs. The
+// shadow follows the image's alpha, so it also suits cut-outs and
+// thumbnails with rounded corners. A nil c is black at half opacity. The
+// result is an *image.RGBA with its origin at zero.
+func DropShadow(dx, dy int, sigma float64, c color.Color) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		if b.Empty() {
+			return img, nil
+		}
+		if c == nil {
+			c = color.NRGBA{0, 0, 0, 0x80}
+		}
+		spread := 0
+		if sigma > 0 {
+			spread = int(math.Ceil(3 * sigma))
+		}
+		// The canvas spreads the blur on every side and extends towards
+		// the offset.
+		pad := image.Rect(spread-minInt(dx, 0), spread-minInt(dy, 0), spread+maxInt(dx, 0), spread+maxInt(dy, 0))
+		size := b.Size().Add(pad.Min).Add(pad.Max)
+		at := pad.Min
+
+		shadow := image.NewRGBA(image.Rectangle{Max: size})
+		draw.DrawMask(shadow, image.Rectangle{Min: at, Max: at.Add(b.Size())}.Add(image.Pt(dx, dy)),
+			image.NewUniform(c), image.Point{}, img, b.Min, draw.Src)
+		var err error
+		if sigma > 0 {
+			if shadow, err = gaussianBlur(ctx, shadow, sigma); err != nil {
+				return nil, err
+			}
+		}
+		draw.Draw(shadow, image.Rectangle{Min: at, Max: at.Add(b.Size())}, img, b.Min, draw.Over)
+		return shadow, nil
+	})
+}
+
+// polaroidCaptionColor is the color of Polaroid captions, the dark gray of
+// handwriting on the print.
+var polaroidCaptionColor = color.RGBA{0x40, 0x40, 0x40, 0xff}
+
+// Polaroid returns a step that mounts the image in a white border like an
+// instant print, narrow at the top and sides and deep at the bottom, with
+// caption centered in the bottom strip when it is not empty. The borders
+// are in proportion to the image, so the print looks the same at any size.
+// The result is an *image.RGBA with its origin at zero.
+func Polaroid(caption string) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		if b.Empty() {
+			return img, nil
+		}
+		side := maxInt(1, int(math.Round(0.06*float64(minInt(b.Dx(), b.Dy())))))
+		bottom := 4 * side
+		card := image.NewRGBA(image.Rect(0, 0, b.Dx()+2*side, b.Dy()+side+bottom))
+		draw.Draw(card, card.Rect, image.White, image.Point{}, draw.Src)
+		draw.Draw(card, image.Rect(side, side, side+b.Dx(), side+b.Dy()), img, b.Min, draw.Over)
+		if caption == "" {
+			return card, nil
+		}
+
+		f, err := fontOrDefault(nil)
+		if err != nil {
+			return nil, err
+		}
+		c := Caption{Text: caption, Color: polaroidCaptionColor}
+		size := 1.6 * float64(side)
+		mark, err := c.render(f, size)
+		if err != nil {
+			return nil, err
+		}
+		// Shrink long captions to fit between the side borders.
+		if room := b.Dx(); mark.Bounds().Dx() > room {
+			if mark, err = c.render(f, size*float64(room)/float64(mark.Bounds().Dx())); err != nil {
+				return nil, err
+			}
+		}
+		mb := mark.Bounds()
+		strip := image.Rect(side, side+b.Dy(), side+b.Dx(), card.Rect.Max.Y)
+		off := strip.Min.Add(strip.Size().Sub(mb.Size()).Div(2))
+		draw.Draw(card, mb.Add(off), mark, image.Point{}, draw.Over)
+		return card, nil
+	})
+}
@@ -0,0 +1,70 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+)
+
+// Region is a region of interest found in an image by a Detector.
+type Region struct {
+	Rect  image.Rectangle // in the coordinate space of the image
+	Score float64         // the detector's confidence, higher for surer
+}
+
+// Detector finds regions of interest, such as faces, in an image, for
+// Fill and Crop to keep in frame (see Options.Detector). Detectors are
+// plugged in so that the package does not depend on any one; the
+// facedetect package adapts the pure-Go pigo face detector.
+type Detector interface {
+	Detect(ctx context.Context, img image.Image) ([]Region, error)
+}
+
+// DetectorFunc adapts an ordinary function to the Detector interface.
+type DetectorFunc func(ctx context.Context, img image.Image) ([]Region, error)
+
+// Detect calls f(ctx, img).
+func (f DetectorFunc) Detect(ctx context.Context, img image.Image) ([]Region, error) {
+	return f(ctx, img)
+}
+
+// cropCache holds what autoCrop learns about a source, so that several
+// layouts of it can share the work.
+type cropCache struct {
+	energy   *energyMap
+	detected bool
+	regions  image.Rectangle // bounding box of the regions found, relative to the source's origin
+}
+
+// autoCrop moves the crop window of l to the regions opts.Detector finds
+// in src, or, when it has none or finds none, to the most detailed part of
+// src for the Smart gravity. Explicit Focus takes precedence over both.
+func autoCrop(ctx context.Context, l layout, src image.Image, opts Options, c *cropCache) (layout, error) {
+	full := image.Rectangle{Max: src.Bounds().Size()}
+	if opts.Focus != nil || l.src == full || opts.Detector == nil && opts.Gravity != Smart {
+		return l, nil
+	}
+	if opts.Detector != nil {
+		if !c.detected {
+			regions, err := opts.Detector.Detect(ctx, src)
+			if err != nil {
+				return l, err
+			}
+			for _, r := range regions {
+				c.regions = c.regions.Union(r.Rect.Sub(src.Bounds().Min).Intersect(full))
+			}
+			c.detected = true
+		}
+		if !c.regions.Empty() {
+			l.src = cropWindow(l.src.Size(), full, Options{Focus: &Focus{Rect: c.regions}})
+			return l, nil
+		}
+	}
+	if opts.Gravity != Smart {
+		return l, nil
+	}
+	if c.energy == nil {
+		c.energy = newEnergyMap(src)
+	}
+	l.src = c.energy.window(l.src.Size(), full)
+	return l, nil
+}
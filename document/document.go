@@ -0,0 +1,193 @@
+// Package document renders the first page of PDF documents so that they
+// can be thumbnailed like images.
+//
+// Rasterizing PDF is left to a PageRenderer, such as a libmupdf binding or
+// an external converter run with Command. Register installs one for the
+// thumbnail package:
+//
+//	document.Register(document.Pdftoppm)
+//	img, err := thumbnail.GenerateFromFile("report.pdf", opts)
+package document
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	thumbnail "github.com/imgutils-org/imgutils-thumbnail"
+)
+
+// PDF is the source format name reported for PDF documents.
+const PDF = "pdf"
+
+// ErrNoPage is returned when a document has no page to render.
+var ErrNoPage = errors.New("document: no page to render")
+
+// PageRenderer rasterizes pages of a document. Pages are numbered from 1.
+type PageRenderer interface {
+	// PageSize reports the size of page in points (1/72 inch).
+	PageSize(ctx context.Context, data []byte, page int) (width, height float64, err error)
+
+	// RenderPage rasterizes page scaled to width×height pixels.
+	RenderPage(ctx context.Context, data []byte, page, width, height int) (image.Image, error)
+}
+
+// Register makes the thumbnail package render the first page of PDF
+// sources with r, so that GenerateFromFile, GenerateFromReader and their
+// Process counterparts accept PDF files.
+func Register(r PageRenderer) {
+	thumbnail.RegisterRenderer(PDF, isPDF, Renderer(r))
+}
+
+// Renderer adapts r to a thumbnail.Renderer that draws the first page of a
+// document.
+func Renderer(r PageRenderer) thumbnail.Renderer {
+	return firstPage{r}
+}
+
+type firstPage struct {
+	r PageRenderer
+}
+
+func (f firstPage) Size(data []byte) (int, int, error) {
+	w, h, err := f.r.PageSize(context.Background(), data, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(math.Ceil(w)), int(math.Ceil(h)), nil
+}
+
+func (f firstPage) Render(ctx context.Context, data []byte, width, height int) (image.Image, error) {
+	return f.r.RenderPage(ctx, data, 1, width, height)
+}
+
+// isPDF reports whether header is the start of a PDF file. The signature
+// may be preceded by junk, which readers tolerate.
+func isPDF(header []byte) bool {
+	return bytes.Contains(header, []byte("%PDF-"))
+}
+
+// letter is the size in points of a US Letter page, assumed when a page
+// size cannot be found.
+var letter = [2]float64{612, 792}
+
+// MediaBox returns the size in points of the first page box found in a PDF
+// file, taking a 90° or 270° rotation into account, or the US Letter size
+// when none is found. It scans the file rather than parsing it, so boxes
+// inside compressed object streams are not seen.
+func MediaBox(data []byte) (width, height float64) {
+	i := bytes.Index(data, []byte("/MediaBox"))
+	if i < 0 {
+		return letter[0], letter[1]
+	}
+	rest := data[i+len("/MediaBox"):]
+	open, end := bytes.IndexByte(rest, '['), bytes.IndexByte(rest, ']')
+	if open < 0 || end < open || open > 16 {
+		return letter[0], letter[1]
+	}
+	f := strings.Fields(string(rest[open+1 : end]))
+	if len(f) != 4 {
+		return letter[0], letter[1]
+	}
+	var v [4]float64
+	for k, s := range f {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return letter[0], letter[1]
+		}
+		v[k] = n
+	}
+	width, height = math.Abs(v[2]-v[0]), math.Abs(v[3]-v[1])
+	if width == 0 || height == 0 {
+		return letter[0], letter[1]
+	}
+	if j := bytes.Index(data, []byte("/Rotate")); j >= 0 {
+		if f := strings.Fields(string(data[j+len("/Rotate") : minInt(len(data), j+24)])); len(f) > 0 {
+			if r, err := strconv.Atoi(strings.TrimRight(f[0], "/>")); err == nil && (r/90)%2 != 0 {
+				width, height = height, width
+			}
+		}
+	}
+	return width, height
+}
+
+// Command renders pages by running an external converter that writes the
+// page as an image (such as PNG) to its standard output. The document is
+// passed in a temporary file. In Args, "{input}" is replaced by that
+// file's path, and "{page}", "{width}" and "{height}" by the page number
+// and pixel size requested.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Pdftoppm runs pdftoppm from Poppler.
+var Pdftoppm = Command{
+	Name: "pdftoppm",
+	Args: []string{"-f", "{page}", "-l", "{page}", "-scale-to-x", "{width}", "-scale-to-y", "{height}", "-png", "-singlefile", "{input}"},
+}
+
+// PageSize returns the MediaBox of the document; c cannot report the size
+// of individual pages.
+func (c Command) PageSize(_ context.Context, data []byte, page int) (float64, float64, error) {
+	if page < 1 {
+		return 0, 0, ErrNoPage
+	}
+	w, h := MediaBox(data)
+	return w, h, nil
+}
+
+// RenderPage runs the command for page and decodes its output.
+func (c Command) RenderPage(ctx context.Context, data []byte, page, width, height int) (image.Image, error) {
+	if page < 1 {
+		return nil, ErrNoPage
+	}
+	f, err := os.CreateTemp("", "document-*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := strings.NewReplacer(
+		"{input}", f.Name(),
+		"{page}", strconv.Itoa(page),
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height),
+	)
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = r.Replace(a)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("document: %s: %w: %s", c.Name, err, msg)
+		}
+		return nil, fmt.Errorf("document: %s: %w", c.Name, err)
+	}
+	img, _, err := image.Decode(&stdout)
+	return img, err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
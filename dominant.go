@@ -0,0 +1,54 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// dominantSamples is the number of pixels DominantColor inspects at most;
+// larger images are sampled on a regular grid.
+const dominantSamples = 1 << 14
+
+// DominantColor returns the most common color of img, for UIs to paint as a
+// placeholder while the image loads. Similar colors, those within 16
+// levels of each other in every channel, are counted together, and the
+// result is the average of the most common group. Pixels less than half
+// opaque are ignored. The result is opaque, except for images with no
+// such pixels, for which it is fully transparent.
+func DominantColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	if b.Empty() {
+		return color.RGBA{}
+	}
+	type bin struct {
+		r, g, b uint64
+		n       int
+	}
+	var bins [4096]bin
+	step := int(math.Ceil(math.Sqrt(float64(b.Dx()) * float64(b.Dy()) / dominantSamples)))
+	best := -1
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			if a != 0xffff {
+				r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+			}
+			i := int(r>>12)<<8 | int(g>>12)<<4 | int(bl>>12)
+			c := &bins[i]
+			c.r, c.g, c.b, c.n = c.r+uint64(r), c.g+uint64(g), c.b+uint64(bl), c.n+1
+			if best < 0 || c.n > bins[best].n {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return color.RGBA{}
+	}
+	c := bins[best]
+	n := uint64(c.n) * 0x101
+	return color.RGBA{uint8(c.r / n), uint8(c.g / n), uint8(c.b / n), 0xff}
+}
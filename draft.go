@@ -0,0 +1,736 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// This file implements a JPEG decoder that reduces the image by 2, 4 or 8
+// while decoding it, as libjpeg does for its scaled output: only the
+// low-frequency n×n corner of each block's coefficients is transformed,
+// with an n-point inverse DCT, into n×n pixels. This skips most of the
+// transform, color conversion and memory of a full decode. Subsampled
+// chroma blocks are transformed into as many more pixels as luma blocks
+// cover, so that all components come out at the reduced size without
+// upsampling. It reads baseline and progressive Huffman-coded streams of
+// 8-bit grayscale or YCbCr; anything else is left to image/jpeg.
+
+// draftMargin is how many times larger than the thumbnail, along each
+// axis, the reduced decode of the source region must stay, so that the
+// scaler still has the detail to filter down from.
+const draftMargin = 2
+
+// errDraftUnsupported reports a stream the draft decoder does not read.
+var errDraftUnsupported = errors.New("thumbnail: unsupported JPEG for draft decoding")
+
+// draftCos holds, for each output size n of 1, 2, 4 and 8, the scaled
+// cosines of the n-point inverse DCT of the low n coefficients of a block
+// side, indexed by n, sample and then frequency. The scaling keeps the
+// average of the block: a lone DC coefficient decodes to DC/8 as in the
+// full 8-point transform.
+var draftCos = func() (c [9][8][8]float32) {
+	for n := 1; n <= 8; n *= 2 {
+		for x := 0; x < n; x++ {
+			for u := 0; u < n; u++ {
+				s := 0.5
+				if u == 0 {
+					s = 0.5 / math.Sqrt2
+				}
+				c[n][x][u] = float32(s * math.Cos(float64(2*x+1)*float64(u)*math.Pi/float64(2*n)))
+			}
+		}
+	}
+	return c
+}()
+
+// decodeDraft decodes the JPEG stream data reduced by the largest of 2, 4
+// and 8 that keeps the source region opts selects draftMargin times the
+// size of the thumbnail, unless opts.NoDraft is set. Like decodeEmbedded,
+// it rescales the options measured in source pixels, applies them to the
+// image as turned upright for orientation o, and reports false when the
+// full image has to be decoded. Results still report the source size and
+// scale of the full image, as reportFullSize sets them.
+func decodeDraft(ctx context.Context, data []byte, o int, opts *Options) (image.Image, bool) {
+	if opts == nil || opts.NoDraft {
+		return nil, false
+	}
+	full, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil || full.Width <= 0 || full.Height <= 0 {
+		return nil, false
+	}
+	w, h := full.Width, full.Height
+	if o >= 5 {
+		w, h = h, w
+	}
+	l, resolved, err := prepare(w, h, *opts)
+	if err != nil {
+		return nil, false
+	}
+	n := 8
+	for n > 1 && l.src.Dx()*(n/2) >= 8*draftMargin*l.dst.Dx() && l.src.Dy()*(n/2) >= 8*draftMargin*l.dst.Dy() {
+		n /= 2
+	}
+	if n == 8 {
+		return nil, false
+	}
+	d := &draftDecoder{ctx: ctx, data: data, n: n}
+	img, err := d.decode()
+	if err != nil {
+		return nil, false
+	}
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if o >= 5 {
+		sw, sh = sh, sw
+	}
+	rescaleOptions(opts, l, resolved, float64(sw)/float64(w), float64(sh)/float64(h))
+	reportFullSize(opts, w, h, l)
+	return orient(img, o), true
+}
+
+// reportFullSize has Results for *opts report a w×h source, the one l was
+// planned for, and the scale l reduces it by, in place of the size and
+// scale of the reduced image decoded from it.
+func reportFullSize(opts *Options, w, h int, l layout) {
+	opts.source = image.Pt(w, h)
+	opts.scale = float64(l.dst.Dx()) / float64(l.src.Dx())
+}
+
+// draftComponent is a color component of the image being decoded.
+type draftComponent struct {
+	id     byte
+	h, v   int // sampling factors
+	tq     int // quantization table
+	bw, bh int // blocks per row and column, padded to whole MCUs
+	cw, ch int // blocks that hold image data, used by single-component scans
+	nx, ny int // samples per block row and column
+	td, ta int // Huffman tables of the current scan
+	pred   int32
+	pix    []byte
+	coefs  [][64]int16
+}
+
+// draftHuffman is a Huffman decoding table.
+type draftHuffman struct {
+	// lookup holds the length and symbol of the codes of at most 8 bits,
+	// indexed by the next 8 bits of the stream, or 0.
+	lookup  [256]uint16
+	maxcode [17]int32 // largest code of each length, or -1
+	valptr  [17]int32 // index in vals of the first code of each length
+	mincode [17]int32
+	vals    []byte
+	ok      bool
+}
+
+type draftDecoder struct {
+	ctx  context.Context
+	data []byte
+	pos  int
+	n    int // samples per block side
+
+	acc    uint32
+	nbits  uint
+	marker bool // the bit reader has reached a marker
+	eof    bool // the bit reader has run out of data
+
+	width, height  int
+	hmax, vmax     int
+	mcusX, mcusY   int
+	progressive    bool
+	comps          []*draftComponent
+	quant          [4][64]int32 // natural order
+	huff           [2][4]draftHuffman
+	restart        int
+	eobRun         int
+	adobe          bool
+	adobeTransform byte
+	frame          bool
+}
+
+// decode reads the stream and returns the reduced image, an *image.Gray
+// or *image.YCbCr.
+func (d *draftDecoder) decode() (image.Image, error) {
+	if len(d.data) < 2 || d.data[0] != 0xff || d.data[1] != 0xd8 {
+		return nil, errDraftUnsupported
+	}
+	d.pos = 2
+	for {
+		m, payload, err := d.segment()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m == 0xd9: // EOI
+			return d.image()
+		case m == 0xdb:
+			err = d.parseDQT(payload)
+		case m == 0xc4:
+			err = d.parseDHT(payload)
+		case m == 0xc0 || m == 0xc1 || m == 0xc2:
+			err = d.parseSOF(payload, m == 0xc2)
+		case m >= 0xc3 && m <= 0xcf && m != 0xc8 && m != 0xcc:
+			// Lossless, hierarchical and arithmetic-coded frames.
+			return nil, errDraftUnsupported
+		case m == 0xdd:
+			if len(payload) < 2 {
+				return nil, errDraftUnsupported
+			}
+			d.restart = int(payload[0])<<8 | int(payload[1])
+		case m == 0xee:
+			if len(payload) >= 12 && bytes.HasPrefix(payload, []byte("Adobe")) {
+				d.adobe, d.adobeTransform = true, payload[11]
+			}
+		case m == 0xda:
+			err = d.scan(payload)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// segment returns the next marker and, for markers that have one, its
+// payload.
+func (d *draftDecoder) segment() (byte, []byte, error) {
+	// Skip to the next marker, past padding and stray entropy data.
+	for d.pos+1 < len(d.data) && (d.data[d.pos] != 0xff || d.data[d.pos+1] == 0 || d.data[d.pos+1] == 0xff) {
+		d.pos++
+	}
+	if d.pos+1 >= len(d.data) {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	m := d.data[d.pos+1]
+	d.pos += 2
+	if m == 0xd9 || m == 0x01 || m >= 0xd0 && m <= 0xd7 {
+		return m, nil, nil
+	}
+	if d.pos+2 > len(d.data) {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	size := int(d.data[d.pos])<<8 | int(d.data[d.pos+1])
+	if size < 2 || d.pos+size > len(d.data) {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	payload := d.data[d.pos+2 : d.pos+size]
+	d.pos += size
+	return m, payload, nil
+}
+
+func (d *draftDecoder) parseDQT(p []byte) error {
+	for len(p) > 0 {
+		pq, tq := p[0]>>4, int(p[0]&15)
+		if tq > 3 || pq > 1 || len(p) < 1+64*int(pq+1) {
+			return errDraftUnsupported
+		}
+		for k := 0; k < 64; k++ {
+			if pq == 0 {
+				d.quant[tq][jpegZigzag[k]] = int32(p[1+k])
+			} else {
+				d.quant[tq][jpegZigzag[k]] = int32(p[1+2*k])<<8 | int32(p[2+2*k])
+			}
+		}
+		p = p[1+64*int(pq+1):]
+	}
+	return nil
+}
+
+func (d *draftDecoder) parseDHT(p []byte) error {
+	for len(p) > 0 {
+		if len(p) < 17 || p[0]>>4 > 1 || p[0]&15 > 3 {
+			return errDraftUnsupported
+		}
+		t := &d.huff[p[0]>>4][p[0]&15]
+		total := 0
+		for _, c := range p[1:17] {
+			total += int(c)
+		}
+		if total > 256 || len(p) < 17+total {
+			return errDraftUnsupported
+		}
+		*t = draftHuffman{vals: p[17 : 17+total], ok: true}
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			count := int32(p[l])
+			t.valptr[l], t.mincode[l], t.maxcode[l] = k, code, -1
+			if count > 0 {
+				t.maxcode[l] = code + count - 1
+			}
+			if code+count > 1<<uint(l) {
+				return errDraftUnsupported
+			}
+			if l <= 8 {
+				for i := int32(0); i < count; i++ {
+					entry := uint16(l)<<8 | uint16(t.vals[k+i])
+					first := (code + i) << uint(8-l)
+					for j := int32(0); j < 1<<uint(8-l); j++ {
+						t.lookup[first+j] = entry
+					}
+				}
+			}
+			code, k = (code+count)<<1, k+count
+		}
+		p = p[17+total:]
+	}
+	return nil
+}
+
+func (d *draftDecoder) parseSOF(p []byte, progressive bool) error {
+	if d.frame || len(p) < 6 || p[0] != 8 {
+		return errDraftUnsupported
+	}
+	d.frame, d.progressive = true, progressive
+	d.height = int(p[1])<<8 | int(p[2])
+	d.width = int(p[3])<<8 | int(p[4])
+	nc := int(p[5])
+	if d.width == 0 || d.height == 0 || nc != 1 && nc != 3 || len(p) < 6+3*nc {
+		return errDraftUnsupported
+	}
+	d.hmax, d.vmax = 1, 1
+	for i := 0; i < nc; i++ {
+		q := p[6+3*i:]
+		c := &draftComponent{id: q[0], h: int(q[1] >> 4), v: int(q[1] & 15), tq: int(q[2])}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errDraftUnsupported
+		}
+		d.hmax, d.vmax = maxInt(d.hmax, c.h), maxInt(d.vmax, c.v)
+		d.comps = append(d.comps, c)
+	}
+	d.mcusX = ceilDiv(d.width, 8*d.hmax)
+	d.mcusY = ceilDiv(d.height, 8*d.vmax)
+	for _, c := range d.comps {
+		c.bw, c.bh = d.mcusX*c.h, d.mcusY*c.v
+		c.cw = ceilDiv(ceilDiv(d.width*c.h, d.hmax), 8)
+		c.ch = ceilDiv(ceilDiv(d.height*c.v, d.vmax), 8)
+		c.nx, c.ny = d.n, d.n
+		if nc == 1 {
+			// A lone component has blocks of its own size, whatever its
+			// declared sampling factors.
+			c.bw, c.bh = c.cw, c.ch
+		} else if !draftSize(d.n*d.hmax, c.h) || !draftSize(d.n*d.vmax, c.v) {
+			return errDraftUnsupported
+		} else {
+			c.nx, c.ny = d.n*d.hmax/c.h, d.n*d.vmax/c.v
+		}
+		c.pix = make([]byte, c.bw*c.nx*c.bh*c.ny)
+		if progressive {
+			c.coefs = make([][64]int16, c.bw*c.bh)
+		}
+	}
+	return nil
+}
+
+// draftSize reports whether a component sampled f times along an MCU side
+// of nf output samples gets a whole number of samples per block that
+// draftCos has a transform for.
+func draftSize(nf, f int) bool {
+	n := nf / f
+	return n*f == nf && n <= 8 && n&(n-1) == 0
+}
+
+// scan decodes the entropy-coded data following the scan header p.
+func (d *draftDecoder) scan(p []byte) error {
+	if !d.frame || len(p) < 1 {
+		return errDraftUnsupported
+	}
+	ns := int(p[0])
+	if ns < 1 || ns > len(d.comps) || len(p) < 4+2*ns {
+		return errDraftUnsupported
+	}
+	comps := make([]*draftComponent, ns)
+	for i := range comps {
+		id, tables := p[1+2*i], p[2+2*i]
+		for _, c := range d.comps {
+			if c.id == id {
+				comps[i] = c
+			}
+		}
+		if comps[i] == nil || tables>>4 > 3 || tables&15 > 3 {
+			return errDraftUnsupported
+		}
+		comps[i].td, comps[i].ta = int(tables>>4), int(tables&15)
+	}
+	q := p[1+2*ns:]
+	ss, se, ah, al := int(q[0]), int(q[1]), uint(q[2]>>4), uint(q[2]&15)
+	if !d.progressive {
+		ss, se, ah, al = 0, 63, 0, 0
+	} else if ss > se || se > 63 || ss == 0 && se != 0 || ss > 0 && ns != 1 || al > 13 {
+		return errDraftUnsupported
+	}
+
+	d.acc, d.nbits, d.marker, d.eof, d.eobRun = 0, 0, false, false, 0
+	for _, c := range comps {
+		c.pred = 0
+	}
+	block := func(c *draftComponent, bx, by int) error {
+		if !d.progressive {
+			return d.baselineBlock(c, bx, by)
+		}
+		blk := &c.coefs[by*c.bw+bx]
+		switch {
+		case ss == 0 && ah == 0:
+			return d.dcFirst(c, blk, al)
+		case ss == 0:
+			blk[0] |= int16(d.bits(1) << al)
+			return nil
+		case ah == 0:
+			return d.acFirst(c, blk, ss, se, al)
+		}
+		return d.acRefine(c, blk, ss, se, al)
+	}
+
+	mcus, rows, cols := 0, d.mcusY, d.mcusX
+	if ns == 1 {
+		rows, cols = comps[0].ch, comps[0].cw
+	}
+	for my := 0; my < rows; my++ {
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
+		for mx := 0; mx < cols; mx++ {
+			if d.restart > 0 && mcus > 0 && mcus%d.restart == 0 {
+				if err := d.reset(comps); err != nil {
+					return err
+				}
+			}
+			mcus++
+			if ns == 1 {
+				if err := block(comps[0], mx, my); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, c := range comps {
+				for j := 0; j < c.v; j++ {
+					for i := 0; i < c.h; i++ {
+						if err := block(c, mx*c.h+i, my*c.v+j); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+		if d.eof {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// reset handles the restart marker that ends a restart interval.
+func (d *draftDecoder) reset(comps []*draftComponent) error {
+	// The bit reader stops short of markers, so the bits it holds are
+	// padding.
+	d.acc, d.nbits, d.marker, d.eobRun = 0, 0, false, 0
+	for d.pos < len(d.data) && d.data[d.pos] == 0xff && d.pos+1 < len(d.data) && d.data[d.pos+1] == 0xff {
+		d.pos++
+	}
+	if d.pos+1 >= len(d.data) || d.data[d.pos] != 0xff || d.data[d.pos+1] < 0xd0 || d.data[d.pos+1] > 0xd7 {
+		return errDraftUnsupported
+	}
+	d.pos += 2
+	for _, c := range comps {
+		c.pred = 0
+	}
+	return nil
+}
+
+// fill loads bytes into the bit reader until it holds more than 24 bits,
+// unstuffing zero bytes after 0xff and padding with zeros at markers.
+func (d *draftDecoder) fill() {
+	for d.nbits <= 24 {
+		var b byte
+		switch {
+		case d.marker:
+		case d.pos >= len(d.data):
+			d.eof = true
+		case d.data[d.pos] != 0xff:
+			b = d.data[d.pos]
+			d.pos++
+		case d.pos+1 < len(d.data) && d.data[d.pos+1] == 0:
+			b = 0xff
+			d.pos += 2
+		default:
+			d.marker = true
+		}
+		d.acc |= uint32(b) << (24 - d.nbits)
+		d.nbits += 8
+	}
+}
+
+// bits reads n bits, at most 16.
+func (d *draftDecoder) bits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if d.nbits < n {
+		d.fill()
+	}
+	v := d.acc >> (32 - n)
+	d.acc <<= n
+	d.nbits -= n
+	return int32(v)
+}
+
+// receive reads an s-bit magnitude and extends it to a signed value.
+func (d *draftDecoder) receive(s uint) int32 {
+	v := d.bits(s)
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v
+}
+
+// symbol decodes a Huffman-coded symbol with t.
+func (d *draftDecoder) symbol(t *draftHuffman) (byte, error) {
+	if !t.ok {
+		return 0, errDraftUnsupported
+	}
+	if d.nbits < 16 {
+		d.fill()
+	}
+	if e := t.lookup[d.acc>>24]; e != 0 {
+		n := uint(e >> 8)
+		d.acc <<= n
+		d.nbits -= n
+		return byte(e), nil
+	}
+	for l := uint(9); l <= 16; l++ {
+		code := int32(d.acc >> (32 - l))
+		if code <= t.maxcode[l] {
+			d.acc <<= l
+			d.nbits -= l
+			return t.vals[t.valptr[l]+code-t.mincode[l]], nil
+		}
+	}
+	return 0, errDraftUnsupported
+}
+
+// baselineBlock decodes the next block of a sequential scan and
+// transforms it into c's samples at block (bx, by).
+func (d *draftDecoder) baselineBlock(c *draftComponent, bx, by int) error {
+	var blk [64]int32
+	t, err := d.symbol(&d.huff[0][c.td])
+	if err != nil {
+		return err
+	}
+	if t > 11 {
+		return errDraftUnsupported
+	}
+	if t > 0 {
+		c.pred += d.receive(uint(t))
+	}
+	blk[0] = c.pred
+	ac := &d.huff[1][c.ta]
+	for k := 1; k < 64; {
+		rs, err := d.symbol(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r != 15 {
+				break
+			}
+			k += 16
+			continue
+		}
+		if k += r; k > 63 {
+			return errDraftUnsupported
+		}
+		blk[jpegZigzag[k]] = d.receive(s)
+		k++
+	}
+	d.idct(c, &blk, bx, by)
+	return nil
+}
+
+func (d *draftDecoder) dcFirst(c *draftComponent, blk *[64]int16, al uint) error {
+	s, err := d.symbol(&d.huff[0][c.td])
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return errDraftUnsupported
+	}
+	if s > 0 {
+		c.pred += d.receive(uint(s))
+	}
+	blk[0] = int16(c.pred << al)
+	return nil
+}
+
+func (d *draftDecoder) acFirst(c *draftComponent, blk *[64]int16, ss, se int, al uint) error {
+	if d.eobRun > 0 {
+		d.eobRun--
+		return nil
+	}
+	ac := &d.huff[1][c.ta]
+	for k := ss; k <= se; {
+		rs, err := d.symbol(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r < 15 {
+				// The block is the first of a run of 2^r plus more blocks
+				// that end in zeros.
+				d.eobRun = 1<<uint(r) - 1 + int(d.bits(uint(r)))
+				break
+			}
+			k += 16
+			continue
+		}
+		if k += r; k > 63 {
+			return errDraftUnsupported
+		}
+		blk[jpegZigzag[k]] = int16(d.receive(s) << al)
+		k++
+	}
+	return nil
+}
+
+// acRefine decodes a refinement scan of a spectral band, as in section
+// G.1.2.3 of the JPEG specification: a bit for every coefficient already
+// nonzero, interleaved with newly nonzero coefficients of magnitude 1.
+func (d *draftDecoder) acRefine(c *draftComponent, blk *[64]int16, ss, se int, al uint) error {
+	p1, m1 := int16(1)<<al, int16(-1)<<al
+	refine := func(z *int16) {
+		if d.bits(1) == 1 && *z&p1 == 0 {
+			if *z >= 0 {
+				*z += p1
+			} else {
+				*z += m1
+			}
+		}
+	}
+	k := ss
+	if d.eobRun == 0 {
+		ac := &d.huff[1][c.ta]
+		for ; k <= se; k++ {
+			rs, err := d.symbol(ac)
+			if err != nil {
+				return err
+			}
+			r, s := int(rs>>4), rs&15
+			var val int16
+			if s != 0 {
+				if s != 1 {
+					return errDraftUnsupported
+				}
+				val = m1
+				if d.bits(1) == 1 {
+					val = p1
+				}
+			} else if r != 15 {
+				d.eobRun = 1<<uint(r) + int(d.bits(uint(r)))
+				break
+			}
+			// Skip r coefficients that are still zero, refining the
+			// nonzero ones passed, up to the one the new value goes to.
+			for ; k <= se; k++ {
+				z := &blk[jpegZigzag[k]]
+				if *z != 0 {
+					refine(z)
+				} else if r--; r < 0 {
+					break
+				}
+			}
+			if val != 0 && k <= se {
+				blk[jpegZigzag[k]] = val
+			}
+		}
+	}
+	if d.eobRun > 0 {
+		for ; k <= se; k++ {
+			if z := &blk[jpegZigzag[k]]; *z != 0 {
+				refine(z)
+			}
+		}
+		d.eobRun--
+	}
+	return nil
+}
+
+// idct dequantizes the low c.ny×c.nx coefficients of blk, in natural
+// order, and transforms them into the samples of c at block (bx, by).
+func (d *draftDecoder) idct(c *draftComponent, blk *[64]int32, bx, by int) {
+	nx, ny := c.nx, c.ny
+	q := &d.quant[c.tq]
+	stride := c.bw * nx
+	off := by*ny*stride + bx*nx
+	if nx == 1 && ny == 1 {
+		c.pix[off] = clamp255(int(math.Floor(float64(blk[0]*q[0])/8 + 128.5)))
+		return
+	}
+	cx, cy := &draftCos[nx], &draftCos[ny]
+	// Rows, then columns.
+	var tmp [8][8]float32
+	for v := 0; v < ny; v++ {
+		for x := 0; x < nx; x++ {
+			var s float32
+			for u := 0; u < nx; u++ {
+				s += cx[x][u] * float32(blk[8*v+u]*q[8*v+u])
+			}
+			tmp[v][x] = s
+		}
+	}
+	for y := 0; y < ny; y++ {
+		row := c.pix[off+y*stride : off+y*stride+nx]
+		for x := range row {
+			var s float32
+			for v := 0; v < ny; v++ {
+				s += cy[y][v] * tmp[v][x]
+			}
+			row[x] = clamp255(int(math.Floor(float64(s) + 128.5)))
+		}
+	}
+}
+
+// image transforms the coefficients of a progressive stream and returns
+// the decoded samples as an image.
+func (d *draftDecoder) image() (image.Image, error) {
+	if !d.frame {
+		return nil, errDraftUnsupported
+	}
+	if d.progressive {
+		for _, c := range d.comps {
+			for by := 0; by < c.bh; by++ {
+				if err := d.ctx.Err(); err != nil {
+					return nil, err
+				}
+				for bx := 0; bx < c.bw; bx++ {
+					var blk [64]int32
+					for i, v := range c.coefs[by*c.bw+bx] {
+						blk[i] = int32(v)
+					}
+					d.idct(c, &blk, bx, by)
+				}
+			}
+		}
+	}
+
+	n := d.n
+	r := image.Rect(0, 0, ceilDiv(d.width*n, 8), ceilDiv(d.height*n, 8))
+	if len(d.comps) == 1 {
+		c := d.comps[0]
+		return &image.Gray{Pix: c.pix, Stride: c.bw * c.nx, Rect: r}, nil
+	}
+	if d.adobe && d.adobeTransform == 0 || !d.adobe && d.comps[0].id == 'R' && d.comps[1].id == 'G' && d.comps[2].id == 'B' {
+		// RGB streams, which image/jpeg converts itself.
+		return nil, errDraftUnsupported
+	}
+	// Every component has the same number of samples.
+	stride := d.comps[0].bw * d.comps[0].nx
+	return &image.YCbCr{
+		Y: d.comps[0].pix, Cb: d.comps[1].pix, Cr: d.comps[2].pix,
+		YStride: stride, CStride: stride,
+		SubsampleRatio: image.YCbCrSubsampleRatio444,
+		Rect:           r,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"testing"
+)
+
+func TestDraftDecoder(t *testing.T) {
+	src := testImage(413, 278, false)
+	encodings := map[string]func(*bytes.Buffer) error{
+		"image/jpeg": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, &jpeg.Options{Quality: 95}) },
+		"progressive": func(b *bytes.Buffer) error {
+			return SaveJPEGOptions(src, b, Options{Quality: 95, Progressive: true})
+		},
+		"4:4:4": func(b *bytes.Buffer) error {
+			return SaveJPEGOptions(src, b, Options{Quality: 95, Subsampling: Subsample444})
+		},
+	}
+	for name, encode := range encodings {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		full, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{1, 2, 4} {
+			d := &draftDecoder{ctx: context.Background(), data: buf.Bytes(), n: n}
+			img, err := d.decode()
+			if err != nil {
+				t.Fatalf("%s at 1/%d: %v", name, 8/n, err)
+			}
+			w, h := ceilDiv(src.Bounds().Dx()*n, 8), ceilDiv(src.Bounds().Dy()*n, 8)
+			if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+				t.Fatalf("%s at 1/%d: size %v, want %dx%d", name, 8/n, b.Size(), w, h)
+			}
+			ref, err := Generate(full, Options{Width: w, Height: h, Mode: Stretch, Filter: Box})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := psnr(t, img, ref); p < 30 {
+				t.Errorf("%s at 1/%d: PSNR %.1f dB against the reduced full decode, want at least 30", name, 8/n, p)
+			}
+		}
+	}
+}
+
+func TestDraftResultSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(1600, 1200, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ProcessReader(context.Background(), &buf, Options{Width: 100, Height: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SourceWidth != 1600 || res.SourceHeight != 1200 || res.Width != 100 || res.Height != 75 || res.Scale != 0.0625 {
+		t.Errorf("got %dx%d from %dx%d at %v, want 100x75 from 1600x1200 at 0.0625",
+			res.Width, res.Height, res.SourceWidth, res.SourceHeight, res.Scale)
+	}
+}
@@ -0,0 +1,75 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// AutoBackground selects how Options.AutoBackground derives the padding
+// color from the source's edges, so that the letterbox blends with the
+// picture instead of framing it in white or black.
+type AutoBackground int
+
+const (
+	// AutoBackgroundNone pads with Background. It is the default.
+	AutoBackgroundNone AutoBackground = iota
+	// AutoBackgroundAverage pads with the mean color of the edges, which
+	// suits edges of gradual color such as skies and walls.
+	AutoBackgroundAverage
+	// AutoBackgroundDominant pads with the DominantColor of the edges,
+	// which matches the backdrop of product shots and screenshots
+	// exactly even where something else touches the edge.
+	AutoBackgroundDominant
+)
+
+// edgeSize is the size of the longer side of the reduced copy of the
+// source that edge colors are sampled from.
+const edgeSize = 64
+
+// valid reports whether a is a known automatic background.
+func (a AutoBackground) valid() bool {
+	return a >= AutoBackgroundNone && a <= AutoBackgroundDominant
+}
+
+// edgeColor returns the padding color for the sr part of src that a
+// derives from the edges of a reduced copy.
+func edgeColor(src image.Image, sr image.Rectangle, a AutoBackground) color.Color {
+	scale := math.Min(1, edgeSize/float64(maxInt(sr.Dx(), sr.Dy())))
+	w := maxInt(1, int(math.Round(float64(sr.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(sr.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Rect, src, sr, draw.Src, nil)
+
+	// Gather the outermost ring of pixels into a strip.
+	edge := image.NewRGBA(image.Rect(0, 0, 2*(w+h), 1))
+	n := 0
+	add := func(x, y int) {
+		copy(edge.Pix[4*n:4*n+4], small.Pix[small.PixOffset(x, y):])
+		n++
+	}
+	for x := 0; x < w; x++ {
+		add(x, 0)
+		if h > 1 {
+			add(x, h-1)
+		}
+	}
+	for y := 1; y < h-1; y++ {
+		add(0, y)
+		if w > 1 {
+			add(w-1, y)
+		}
+	}
+	edge.Rect.Max.X = n
+
+	if a == AutoBackgroundDominant {
+		return DominantColor(edge)
+	}
+	var sum [4]int
+	for i := 0; i < 4*n; i++ {
+		sum[i%4] += int(edge.Pix[i])
+	}
+	return color.RGBA{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n), uint8((sum[3] + n/2) / n)}
+}
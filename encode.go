@@ -0,0 +1,230 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Output format names accepted in Options.Format.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	GIF  = "gif"
+	WEBP = "webp"
+	TIFF = "tiff"
+	BMP  = "bmp"
+	ICO  = "ico"
+
+	// AVIF is only available when built with the libheif tag.
+	AVIF = "avif"
+	// JXL is only available when built with the libjxl tag.
+	JXL = "jxl"
+
+	// Auto writes each image in the format BestFormat picks for it.
+	Auto = "auto"
+)
+
+// ErrUnsupportedFormat is returned when no encoder exists for the requested
+// output format.
+var ErrUnsupportedFormat = errors.New("thumbnail: unsupported format")
+
+// Encoder writes images in a particular output format.
+type Encoder interface {
+	Encode(w io.Writer, img image.Image, opts Options) error
+}
+
+// EncoderFunc adapts an ordinary function to the Encoder interface.
+type EncoderFunc func(w io.Writer, img image.Image, opts Options) error
+
+// Encode calls f(w, img, opts).
+func (f EncoderFunc) Encode(w io.Writer, img image.Image, opts Options) error {
+	return f(w, img, opts)
+}
+
+var encoders = struct {
+	sync.RWMutex
+	m       map[string]Encoder
+	aliases map[string]string // extension or alternative name -> format
+}{
+	m: map[string]Encoder{
+		JPEG: EncoderFunc(func(w io.Writer, img image.Image, opts Options) error {
+			if opts.Progressive || opts.Subsampling != Subsample420 || opts.Metadata != nil || opts.Matte != nil {
+				return SaveJPEGOptions(img, w, opts)
+			}
+			return SaveJPEG(img, w, opts.Quality)
+		}),
+		PNG: EncoderFunc(func(w io.Writer, img image.Image, opts Options) error {
+			return SavePNGOptions(img, w, opts)
+		}),
+		GIF: EncoderFunc(func(w io.Writer, img image.Image, opts Options) error {
+			return SaveGIFOptions(img, w, opts)
+		}),
+		WEBP: EncoderFunc(func(w io.Writer, img image.Image, opts Options) error {
+			return SaveWebP(img, w, opts)
+		}),
+		TIFF: EncoderFunc(func(w io.Writer, img image.Image, _ Options) error {
+			return SaveTIFF(img, w)
+		}),
+		BMP: EncoderFunc(func(w io.Writer, img image.Image, _ Options) error {
+			return SaveBMP(img, w)
+		}),
+		ICO: EncoderFunc(func(w io.Writer, img image.Image, _ Options) error {
+			return SaveICO(w, img)
+		}),
+	},
+	aliases: map[string]string{
+		"jpg": JPEG,
+		"tif": TIFF,
+	},
+}
+
+// RegisterEncoder makes enc available to Encode, Save and GenerateAndSave
+// under the given format name. Files whose extension is the format name
+// or one of extensions (with or without the leading dot) are written with
+// it. Registering a format again replaces its encoder, which allows the
+// built-in encoders to be overridden.
+func RegisterEncoder(format string, enc Encoder, extensions ...string) {
+	format = strings.ToLower(format)
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[format] = enc
+	for _, ext := range extensions {
+		encoders.aliases[strings.ToLower(strings.TrimPrefix(ext, "."))] = format
+	}
+}
+
+// LookupEncoder returns the encoder registered for a format name or file
+// extension.
+func LookupEncoder(format string) (Encoder, bool) {
+	format = normalizeFormat(format)
+	encoders.RLock()
+	enc, ok := encoders.m[format]
+	encoders.RUnlock()
+	return enc, ok
+}
+
+func hasEncoder(format string) bool {
+	_, ok := LookupEncoder(format)
+	return ok
+}
+
+// normalizeFormat returns the canonical name of a format or extension.
+func normalizeFormat(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	encoders.RLock()
+	defer encoders.RUnlock()
+	if format, ok := encoders.aliases[name]; ok {
+		return format
+	}
+	return name
+}
+
+// outputFormat returns the format to write path in: opts.Format when set,
+// otherwise the format named by the path's extension, and JPEG for paths
+// without one.
+func outputFormat(path string, opts Options) string {
+	if opts.Format != "" {
+		return normalizeFormat(opts.Format)
+	}
+	if ext := filepath.Ext(path); ext != "" {
+		return normalizeFormat(ext)
+	}
+	return JPEG
+}
+
+// Encode writes img to w in opts.Format using the registered encoder.
+func Encode(w io.Writer, img image.Image, opts Options) error {
+	enc, ok := LookupEncoder(opts.Format)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, opts.Format)
+	}
+	return enc.Encode(w, img, opts)
+}
+
+// Save encodes img to the file at path. The format is opts.Format when set
+// and is otherwise chosen from the path's extension, so "thumb.png" is
+// written as PNG; paths without an extension are written as JPEG. On
+// failure the partially written file is removed.
+func Save(img image.Image, path string, opts Options) error {
+	opts.Format = outputFormat(path, opts)
+	if !hasEncoder(opts.Format) {
+		return fmt.Errorf("%w %q for %s", ErrUnsupportedFormat, opts.Format, path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Encode(f, img, opts); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// EncodeFormats encodes img in each of formats, such as WebP with a JPEG
+// fallback, returning the encoded data in the same order. opts applies to
+// all of them apart from its Format. The encodings run concurrently and
+// share img, which encoders do not modify.
+func EncodeFormats(img image.Image, opts Options, formats ...string) ([][]byte, error) {
+	for _, format := range formats {
+		if !hasEncoder(format) {
+			return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+		}
+	}
+	data := make([][]byte, len(formats))
+	errs := make([]error, len(formats))
+	var wg sync.WaitGroup
+	for i, format := range formats {
+		opts := opts
+		opts.Format = format
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			errs[i] = Encode(&buf, img, opts)
+			data[i] = buf.Bytes()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("thumbnail: %s: %w", formats[i], err)
+		}
+	}
+	return data, nil
+}
+
+// SaveFormats encodes img in each of formats as EncodeFormats does and
+// writes each encoding next to path, named after it with the format as
+// extension: "thumb.jpg" with WebP and JPEG gives "thumb.webp" and
+// "thumb.jpeg". It returns the paths written, in the order of formats. On
+// failure no files are left behind.
+func SaveFormats(img image.Image, path string, opts Options, formats ...string) ([]string, error) {
+	data, err := EncodeFormats(img, opts, formats...)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	paths := make([]string, 0, len(formats))
+	for i, format := range formats {
+		p := base + "." + encodedFormat(img, format)
+		if err := os.WriteFile(p, data[i], 0o666); err != nil {
+			os.Remove(p)
+			for _, p := range paths {
+				os.Remove(p)
+			}
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
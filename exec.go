@@ -0,0 +1,227 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecEncoder is an Encoder that runs an external program, such as
+// mozjpeg's cjpeg, cwebp or avifenc, to do the encoding. The image is
+// written to the program in an intermediate format the program reads, and
+// the program's output is copied to the writer. Register one to replace a
+// built-in encoder:
+//
+//	enc := thumbnail.NewExecEncoder("cjpeg", "-quality", "{quality}", "-optimize")
+//	enc.Input = thumbnail.BMP
+//	thumbnail.RegisterEncoder(thumbnail.JPEG, enc, "jpg")
+//
+// Programs that only work on files are given temporary ones through the
+// "{in}" and "{out}" arguments:
+//
+//	thumbnail.NewExecEncoder("avifenc", "-q", "{quality}", "{in}", "{out}")
+//
+// Metadata in Options is not passed to the program. An ExecEncoder is safe
+// for concurrent use; its fields must not be changed once it is in use.
+type ExecEncoder struct {
+	// Path is the program to run, looked up in PATH if it has no slashes.
+	Path string
+
+	// Args are the program's arguments. In each, "{quality}" is replaced
+	// by Options.Quality (85 when unset), "{in}" by the path of a
+	// temporary file holding the input and "{out}" by that of a temporary
+	// file the program writes to. Without "{in}" the input is written to
+	// the program's standard input, and without "{out}" the output is
+	// read from its standard output.
+	Args []string
+
+	// Input is the format the image is handed to the program in, PNG by
+	// default. PNG, BMP and TIFF are written by this package's own
+	// encoders, so that an ExecEncoder can be registered for those formats
+	// as well; others are written with the registered encoder.
+	Input string
+
+	// Timeout limits how long each run may take. Zero means no limit.
+	Timeout time.Duration
+
+	// MaxProcs limits the number of runs at a time; further calls to
+	// Encode wait for one to finish. NewExecEncoder sets it to
+	// runtime.GOMAXPROCS(0); zero means no limit.
+	MaxProcs int
+
+	once    sync.Once
+	slots   chan struct{}
+	buffers sync.Pool // of *bytes.Buffer
+}
+
+// NewExecEncoder returns an ExecEncoder that runs path with args, giving
+// it PNG input and running as many programs at a time as there are CPUs.
+func NewExecEncoder(path string, args ...string) *ExecEncoder {
+	return &ExecEncoder{
+		Path:     path,
+		Args:     args,
+		Input:    PNG,
+		MaxProcs: runtime.GOMAXPROCS(0),
+	}
+}
+
+// Encode runs the program on img and writes its output to w. It fails if
+// the program exits with an error, whose standard error output is then
+// included, or if it runs longer than e.Timeout. Output in JPEG is
+// flattened onto opts.Matte first.
+func (e *ExecEncoder) Encode(w io.Writer, img image.Image, opts Options) error {
+	e.once.Do(func() {
+		if e.MaxProcs > 0 {
+			e.slots = make(chan struct{}, e.MaxProcs)
+		}
+	})
+
+	if normalizeFormat(opts.Format) == JPEG {
+		img = flatten(img, opts.Matte)
+	}
+	in := e.getBuffer()
+	defer e.putBuffer(in)
+	input := normalizeFormat(e.Input)
+	var err error
+	switch input {
+	case "", PNG:
+		// The program decodes the input straight away, so it need not
+		// be compressed.
+		input = PNG
+		err = SavePNGOptions(img, in, Options{Compression: CompressionNone})
+	case BMP:
+		err = SaveBMP(img, in)
+	case TIFF:
+		err = SaveTIFF(img, in)
+	default:
+		err = Encode(in, img, Options{Format: input})
+	}
+	if err != nil {
+		return err
+	}
+
+	if e.slots != nil {
+		e.slots <- struct{}{}
+		defer func() { <-e.slots }()
+	}
+	ctx := context.Background()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 85
+	}
+	var inPath, outPath string
+	defer func() {
+		for _, p := range []string{inPath, outPath} {
+			if p != "" {
+				os.Remove(p)
+			}
+		}
+	}()
+	args := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		a = strings.ReplaceAll(a, "{quality}", strconv.Itoa(quality))
+		if strings.Contains(a, "{in}") {
+			if inPath == "" {
+				p, err := tempFile(in.Bytes(), "."+input)
+				if err != nil {
+					return err
+				}
+				inPath = p
+			}
+			a = strings.ReplaceAll(a, "{in}", inPath)
+		}
+		if strings.Contains(a, "{out}") {
+			if outPath == "" {
+				ext := ""
+				if f := normalizeFormat(opts.Format); f != "" {
+					ext = "." + f
+				}
+				p, err := tempFile(nil, ext)
+				if err != nil {
+					return err
+				}
+				outPath = p
+			}
+			a = strings.ReplaceAll(a, "{out}", outPath)
+		}
+		args[i] = a
+	}
+
+	out := e.getBuffer()
+	defer e.putBuffer(out)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Path, args...)
+	if inPath == "" {
+		cmd.Stdin = bytes.NewReader(in.Bytes())
+	}
+	if outPath == "" {
+		cmd.Stdout = out
+	}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("thumbnail: %s: %w: %s", e.Path, err, msg)
+		}
+		return fmt.Errorf("thumbnail: %s: %w", e.Path, err)
+	}
+
+	if outPath != "" {
+		f, err := os.Open(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func (e *ExecEncoder) getBuffer() *bytes.Buffer {
+	if b, ok := e.buffers.Get().(*bytes.Buffer); ok {
+		b.Reset()
+		return b
+	}
+	return new(bytes.Buffer)
+}
+
+func (e *ExecEncoder) putBuffer(b *bytes.Buffer) {
+	e.buffers.Put(b)
+}
+
+// tempFile creates a temporary file with the given extension holding data
+// and returns its path.
+func tempFile(data []byte, ext string) (string, error) {
+	f, err := os.CreateTemp("", "thumbnail-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
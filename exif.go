@@ -0,0 +1,428 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// errBadTIFF is returned when TIFF or EXIF structures are malformed.
+var errBadTIFF = errors.New("thumbnail: malformed TIFF structure")
+
+// EXIF and TIFF tags used by this package.
+const (
+	tagCompression         = 0x0103
+	tagMake                = 0x010f
+	tagStripOffsets        = 0x0111
+	tagOrientation         = 0x0112
+	tagStripByteCounts     = 0x0117
+	tagSubIFDs             = 0x014a
+	tagJPEGInterchange     = 0x0201
+	tagJPEGInterchangeSize = 0x0202
+	tagICCProfile          = 0x8773
+	tagDNGVersion          = 0xc612
+)
+
+// exifHeader starts the payload of a JPEG APP1 segment holding EXIF data.
+const exifHeader = "Exif\x00\x00"
+
+// jpegSegment is a marker segment of a JPEG stream.
+type jpegSegment struct {
+	marker  byte
+	offset  int // offset of the 0xFF byte of the marker
+	payload []byte
+}
+
+// jpegSegments returns the marker segments of a JPEG stream that precede
+// the first scan. It stops early, without error, when data is truncated.
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	var segs []jpegSegment
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return segs
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // fill byte
+			i++
+			continue
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd8: // no payload
+			i += 2
+			continue
+		case marker == 0xd9 || marker == 0xda: // EOI, SOS
+			return segs
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return segs
+		}
+		segs = append(segs, jpegSegment{marker: marker, offset: i, payload: data[i+4 : i+2+n]})
+		i += 2 + n
+	}
+	return segs
+}
+
+// jpegEXIF returns the TIFF-structured EXIF payload of a JPEG stream, or
+// nil if it has none.
+func jpegEXIF(data []byte) []byte {
+	for _, s := range jpegSegments(data) {
+		if s.marker == 0xe1 && bytes.HasPrefix(s.payload, []byte(exifHeader)) {
+			return s.payload[len(exifHeader):]
+		}
+	}
+	return nil
+}
+
+// exifThumbnail returns the JPEG thumbnail stored in the second IFD of a
+// TIFF-structured EXIF payload, or nil if it has none.
+func exifThumbnail(exif []byte) []byte {
+	t, off, err := newTIFFReader(exif)
+	if err != nil {
+		return nil
+	}
+	_, next, err := t.ifd(off)
+	if err != nil || next == 0 {
+		return nil
+	}
+	entries, _, err := t.ifd(next)
+	if err != nil {
+		return nil
+	}
+	start, ok1 := t.field(entries, tagJPEGInterchange)
+	size, ok2 := t.field(entries, tagJPEGInterchangeSize)
+	if !ok1 || !ok2 || size < 2 || uint64(start)+uint64(size) > uint64(len(exif)) {
+		return nil
+	}
+	b := exif[start : start+size]
+	if b[0] != 0xff || b[1] != 0xd8 {
+		return nil
+	}
+	return b
+}
+
+// decodeEmbedded decodes the EXIF thumbnail of a JPEG stream in place of
+// the full image when it has the image's aspect ratio and enough pixels
+// for the thumbnail opts describe, so that scaling it does not enlarge it.
+// Options measured in source pixels are rescaled to the thumbnail, which
+// is why opts is updated. They apply to the thumbnail as turned upright
+// for orientation o, and so is the result. It reports false when the full
+// image has to be decoded.
+func decodeEmbedded(data []byte, o int, opts *Options) (image.Image, bool) {
+	preview := exifThumbnail(jpegEXIF(data))
+	if preview == nil {
+		return nil, false
+	}
+	full, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, false
+	}
+	if o >= 5 {
+		full.Width, full.Height = full.Height, full.Width
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	// Previews of images with other aspect ratios are letterboxed.
+	if math.Abs(float64(cfg.Width)-float64(cfg.Height*full.Width)/float64(full.Height)) > 1 {
+		return nil, false
+	}
+	l, resolved, err := prepare(full.Width, full.Height, *opts)
+	if err != nil {
+		return nil, false
+	}
+	sx := float64(cfg.Width) / float64(full.Width)
+	sy := float64(cfg.Height) / float64(full.Height)
+	if float64(l.src.Dx())*sx < float64(l.dst.Dx()) || float64(l.src.Dy())*sy < float64(l.dst.Dy()) {
+		return nil, false
+	}
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return nil, false
+	}
+
+	rescaleOptions(opts, l, resolved, sx, sy)
+	return orient(img, o), true
+}
+
+// rescaleOptions updates the options measured in source pixels, for
+// a source decoded at sx and sy times its size in place of the one l and
+// resolved were prepared for. Fit thumbnails are stretched to the size l
+// plans, which fitting the reduced source, whose size is rounded, could
+// miss by a pixel.
+func rescaleOptions(opts *Options, l layout, resolved Options, sx, sy float64) {
+	switch {
+	case resolved.Mode == Fit:
+		opts.Mode, opts.Width, opts.Height, opts.Scale = Stretch, l.size.X, l.size.Y, 0
+	case opts.Scale > 0:
+		opts.Width, opts.Height, opts.Scale = resolved.Width, resolved.Height, 0
+	}
+	if f := opts.Focus; f != nil && !f.Rect.Empty() {
+		r := f.Rect
+		opts.Focus = &Focus{X: f.X, Y: f.Y, Rect: image.Rect(
+			int(math.Floor(float64(r.Min.X)*sx)), int(math.Floor(float64(r.Min.Y)*sy)),
+			int(math.Ceil(float64(r.Max.X)*sx)), int(math.Ceil(float64(r.Max.Y)*sy)),
+		)}
+	}
+}
+
+// tiffReader reads IFDs from a TIFF-structured byte slice, such as a TIFF
+// file or an EXIF payload, or from a TIFF file read through an
+// io.ReaderAt. BigTIFF files are read too, as long as their offsets and
+// counts fit in 32 bits, which they do for any slice a 32-bit TIFF offset
+// could address.
+type tiffReader struct {
+	b     []byte
+	ra    io.ReaderAt // read instead of b when set
+	size  uint64      // of the data
+	order binary.ByteOrder
+	big   bool // BigTIFF, with 64-bit offsets and 20-byte IFD entries
+}
+
+// maxTIFFValue bounds the out-of-line field values read through an
+// io.ReaderAt, so that the large private blocks some writers store next
+// to the image data, such as layers, are not read with the directory.
+const maxTIFFValue = 1 << 24
+
+// ifdEntry is a single field of an image file directory.
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte // the field's value bytes
+}
+
+// newTIFFReader parses the TIFF header of b and returns a reader and the
+// offset of the first IFD.
+func newTIFFReader(b []byte) (*tiffReader, uint32, error) {
+	if len(b) < 8 {
+		return nil, 0, errBadTIFF
+	}
+	t := &tiffReader{b: b, size: uint64(len(b))}
+	switch string(b[:4]) {
+	case "II*\x00", "IIRO", "IIRS", "IIU\x00": // TIFF, Olympus, Panasonic
+		t.order = binary.LittleEndian
+	case "MM\x00*", "MMOR":
+		t.order = binary.BigEndian
+	case "II+\x00":
+		t.order, t.big = binary.LittleEndian, true
+	case "MM\x00+":
+		t.order, t.big = binary.BigEndian, true
+	default:
+		return nil, 0, errBadTIFF
+	}
+	if !t.big {
+		return t, t.order.Uint32(b[4:]), nil
+	}
+	// The BigTIFF header holds the offset size, 8, and the first offset
+	// after it.
+	if len(b) < 16 || t.order.Uint16(b[4:]) != 8 {
+		return nil, 0, errBadTIFF
+	}
+	off := t.order.Uint64(b[8:])
+	if off > math.MaxUint32 {
+		return nil, 0, errBadTIFF
+	}
+	return t, uint32(off), nil
+}
+
+// newTIFFReaderAt is newTIFFReader for the size bytes of ra, which it
+// reads as they are needed.
+func newTIFFReaderAt(ra io.ReaderAt, size int64) (*tiffReader, uint32, error) {
+	head := make([]byte, 16)
+	n, _ := ra.ReadAt(head, 0)
+	t, off, err := newTIFFReader(head[:n])
+	if err != nil {
+		return nil, 0, err
+	}
+	t.b, t.ra, t.size = nil, ra, uint64(size)
+	return t, off, nil
+}
+
+// at returns the n bytes at off, or an error if they are not all there.
+// Bytes read through t.ra come from scratch, and may be put back once
+// used.
+func (t *tiffReader) at(off, n uint64) ([]byte, error) {
+	if off > t.size || n > t.size-off {
+		return nil, errBadTIFF
+	}
+	if t.ra == nil {
+		return t.b[off : off+n], nil
+	}
+	if uint64(int(n)) != n || int(n) < 0 {
+		return nil, errBadTIFF
+	}
+	b := scratch.get(int(n))
+	if k, err := t.ra.ReadAt(b, int64(off)); k < len(b) {
+		if err == nil || err == io.EOF {
+			err = errBadTIFF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// value returns the size bytes of the out-of-line field value at off, if
+// they are there and, for t.ra, within maxTIFFValue.
+func (t *tiffReader) value(off, size uint64) ([]byte, bool) {
+	if t.ra != nil && size > maxTIFFValue {
+		return nil, false
+	}
+	b, err := t.at(off, size)
+	return b, err == nil
+}
+
+// typeSizes holds the size in bytes of each TIFF field type, including the
+// 64-bit integers of BigTIFF.
+var typeSizes = [...]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4, 16: 8, 17: 8, 18: 8}
+
+// ifd reads the directory at off, returning its entries and the offset of
+// the next directory (zero for the last).
+func (t *tiffReader) ifd(off uint32) ([]ifdEntry, uint32, error) {
+	if t.big {
+		return t.bigIFD(off)
+	}
+	if off < 8 {
+		return nil, 0, errBadTIFF
+	}
+	c, err := t.at(uint64(off), 2)
+	if err != nil {
+		return nil, 0, errBadTIFF
+	}
+	n := uint64(t.order.Uint16(c))
+	d, err := t.at(uint64(off)+2, n*12+4)
+	if err != nil {
+		return nil, 0, errBadTIFF
+	}
+	entries := make([]ifdEntry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e := d[i*12:]
+		ent := ifdEntry{
+			tag:   t.order.Uint16(e),
+			typ:   t.order.Uint16(e[2:]),
+			count: t.order.Uint32(e[4:]),
+		}
+		size := uint64(0)
+		if int(ent.typ) < len(typeSizes) {
+			size = uint64(typeSizes[ent.typ]) * uint64(ent.count)
+		}
+		if size <= 4 {
+			ent.data = e[8 : 8+size]
+		} else {
+			var ok bool
+			if ent.data, ok = t.value(uint64(t.order.Uint32(e[8:])), size); !ok {
+				continue // skip fields pointing outside the data
+			}
+		}
+		entries = append(entries, ent)
+	}
+	return entries, t.order.Uint32(d[n*12:]), nil
+}
+
+// bigIFD is ifd for BigTIFF, whose directories count entries in 64 bits
+// and hold 20-byte entries with 64-bit counts and offsets.
+func (t *tiffReader) bigIFD(off uint32) ([]ifdEntry, uint32, error) {
+	if off < 16 {
+		return nil, 0, errBadTIFF
+	}
+	c, err := t.at(uint64(off), 8)
+	if err != nil {
+		return nil, 0, errBadTIFF
+	}
+	n := t.order.Uint64(c)
+	if n > t.size/20 {
+		return nil, 0, errBadTIFF
+	}
+	d, err := t.at(uint64(off)+8, n*20+8)
+	if err != nil {
+		return nil, 0, errBadTIFF
+	}
+	entries := make([]ifdEntry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e := d[i*20:]
+		count := t.order.Uint64(e[4:])
+		if count > math.MaxUint32 {
+			continue
+		}
+		ent := ifdEntry{tag: t.order.Uint16(e), typ: t.order.Uint16(e[2:]), count: uint32(count)}
+		size := uint64(0)
+		if int(ent.typ) < len(typeSizes) {
+			size = uint64(typeSizes[ent.typ]) * count
+		}
+		if size <= 8 {
+			ent.data = e[12 : 12+size]
+		} else {
+			var ok bool
+			if ent.data, ok = t.value(t.order.Uint64(e[12:]), size); !ok {
+				continue
+			}
+		}
+		entries = append(entries, ent)
+	}
+	next := t.order.Uint64(d[n*20:])
+	if next > math.MaxUint32 {
+		next = 0
+	}
+	return entries, uint32(next), nil
+}
+
+// uint returns the i'th value of a BYTE, SHORT, LONG or IFD field, or of a
+// BigTIFF LONG8 or IFD8 field whose value fits in 32 bits.
+func (t *tiffReader) uint(e ifdEntry, i int) (uint32, bool) {
+	switch e.typ {
+	case 1, 7:
+		if i < len(e.data) {
+			return uint32(e.data[i]), true
+		}
+	case 3:
+		if 2*i+2 <= len(e.data) {
+			return uint32(t.order.Uint16(e.data[2*i:])), true
+		}
+	case 4, 13:
+		if 4*i+4 <= len(e.data) {
+			return t.order.Uint32(e.data[4*i:]), true
+		}
+	case 16, 18:
+		if 8*i+8 <= len(e.data) {
+			if v := t.order.Uint64(e.data[8*i:]); v <= math.MaxUint32 {
+				return uint32(v), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// field returns the first value of the field with the given tag.
+func (t *tiffReader) field(entries []ifdEntry, tag uint16) (uint32, bool) {
+	for _, e := range entries {
+		if e.tag == tag {
+			return t.uint(e, 0)
+		}
+	}
+	return 0, false
+}
+
+// exifOrientation returns the orientation recorded in a TIFF-structured
+// EXIF payload, or 1 (no transform) if it is absent or invalid.
+func exifOrientation(exif []byte) int {
+	t, off, err := newTIFFReader(exif)
+	if err != nil {
+		return 1
+	}
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return 1
+	}
+	if o, ok := t.field(entries, tagOrientation); ok && o >= 1 && o <= 8 {
+		return int(o)
+	}
+	return 1
+}
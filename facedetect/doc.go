@@ -0,0 +1,15 @@
+// Package facedetect finds faces for the thumbnail package to keep in frame
+// when cropping, so that avatars and photos of people are centered on
+// faces:
+//
+//	cascade, err := os.ReadFile("facefinder") // from the pigo repository
+//	d, err := facedetect.New(cascade)
+//	opts.Mode = thumbnail.Fill
+//	opts.Detector = d
+//
+// The detector is an adapter for pigo (github.com/esimov/pigo), a pure-Go
+// face detector, and is only built with the pigo build tag, so that the
+// thumbnail package and programs that do not use it do not depend on pigo.
+// Other detectors plug in the same way through the thumbnail.Detector
+// interface.
+package facedetect
@@ -0,0 +1,118 @@
+//go:build pigo
+// +build pigo
+
+package facedetect
+
+import (
+	"context"
+	"image"
+	"math"
+
+	pigo "github.com/esimov/pigo/core"
+	"golang.org/x/image/draw"
+
+	thumbnail "github.com/imgutils-org/imgutils-thumbnail"
+)
+
+// Detector is a thumbnail.Detector that finds faces with a pigo cascade.
+// It is safe for concurrent use; its fields must not be changed once it is
+// in use.
+type Detector struct {
+	// Resolution is the size of the longer side that images are reduced
+	// to before detection, which is much faster than searching the full
+	// image and finds faces of any useful size. Zero uses 640.
+	Resolution int
+
+	// MinSize is the size of the smallest face to find, as a fraction of
+	// the shorter side of the image. Zero uses 0.05.
+	MinSize float64
+
+	// MinQuality is the confidence, as pigo scores it, below which
+	// detections are dropped. Zero uses 5.
+	MinQuality float64
+
+	classifier *pigo.Pigo
+}
+
+// New returns a Detector for the pigo cascade in cascade, typically the
+// facefinder file that pigo's repository provides.
+func New(cascade []byte) (*Detector, error) {
+	c, err := pigo.NewPigo().Unpack(cascade)
+	if err != nil {
+		return nil, err
+	}
+	return &Detector{classifier: c}, nil
+}
+
+// Detect returns the faces in img, with pigo's detection quality as their
+// scores.
+func (d *Detector) Detect(ctx context.Context, img image.Image) ([]thumbnail.Region, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, nil
+	}
+	res := d.Resolution
+	if res <= 0 {
+		res = 640
+	}
+	scale := math.Min(1, float64(res)/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(gray, gray.Rect, img, b, draw.Src, nil)
+
+	minSize := d.MinSize
+	if minSize <= 0 {
+		minSize = 0.05
+	}
+	minQ := d.MinQuality
+	if minQ <= 0 {
+		minQ = 5
+	}
+	params := pigo.CascadeParams{
+		MinSize:     maxInt(20, int(minSize*float64(minInt(w, h)))),
+		MaxSize:     maxInt(w, h),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{Pixels: gray.Pix, Rows: h, Cols: w, Dim: gray.Stride},
+	}
+	dets := d.classifier.RunCascade(params, 0)
+	dets = d.classifier.ClusterDetections(dets, 0.2)
+
+	var regions []thumbnail.Region
+	for _, det := range dets {
+		if float64(det.Q) < minQ {
+			continue
+		}
+		// Detections are squares centered on (Col, Row), in the reduced
+		// image; map them back to img.
+		half := float64(det.Scale) / 2
+		r := image.Rect(
+			int(math.Floor((float64(det.Col)-half)/scale)),
+			int(math.Floor((float64(det.Row)-half)/scale)),
+			int(math.Ceil((float64(det.Col)+half)/scale)),
+			int(math.Ceil((float64(det.Row)+half)/scale)),
+		).Add(b.Min).Intersect(b)
+		if !r.Empty() {
+			regions = append(regions, thumbnail.Region{Rect: r, Score: float64(det.Q)})
+		}
+	}
+	return regions, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
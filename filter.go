@@ -0,0 +1,92 @@
+package thumbnail
+
+import (
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Filter selects the interpolation used to resample the source image.
+type Filter int
+
+const (
+	// CatmullRom is a sharp cubic filter. It is the default.
+	CatmullRom Filter = iota
+	// Lanczos is a three-lobed Lanczos filter. It is slightly sharper than
+	// CatmullRom and somewhat slower.
+	Lanczos
+	// BiLinear is a smooth tent filter; softer but faster than the cubic
+	// filters.
+	BiLinear
+	// ApproxBiLinear mixes the four nearest source pixels. It is very fast
+	// but aliases badly on large reductions.
+	ApproxBiLinear
+	// NearestNeighbor copies the nearest source pixel. It is the fastest
+	// filter and keeps hard pixel edges, which suits pixel art.
+	NearestNeighbor
+	// Mitchell is the Mitchell-Netravali cubic (B = C = 1/3), which trades
+	// a little sharpness for less ringing than CatmullRom.
+	Mitchell
+	// Box averages the source pixels covered by each output pixel. It is
+	// fast and alias-free for large integer reductions but blurs when
+	// enlarging.
+	Box
+)
+
+// lanczos3 is a Lanczos kernel with a support of three lobes.
+var lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+// mitchell is the Mitchell-Netravali cubic with B = C = 1/3.
+var mitchell = &draw.Kernel{Support: 2, At: func(t float64) float64 {
+	const b, c = 1.0 / 3, 1.0 / 3
+	if t < 1 {
+		return ((12-9*b-6*c)*t*t*t + (-18+12*b+6*c)*t*t + (6 - 2*b)) / 6
+	}
+	return ((-b-6*c)*t*t*t + (6*b+30*c)*t*t + (-12*b-48*c)*t + (8*b + 24*c)) / 6
+}}
+
+// box is a box filter; its support grows with the reduction factor, so
+// every covered source pixel is weighted equally.
+var box = &draw.Kernel{Support: 0.5, At: func(float64) float64 {
+	return 1
+}}
+
+// valid reports whether f is a known filter.
+func (f Filter) valid() bool {
+	return f >= CatmullRom && f <= Box
+}
+
+// interpolator returns the draw.Interpolator implementing f.
+func (f Filter) interpolator() draw.Interpolator {
+	switch f {
+	case Lanczos:
+		return lanczos3
+	case BiLinear:
+		return draw.BiLinear
+	case ApproxBiLinear:
+		return draw.ApproxBiLinear
+	case NearestNeighbor:
+		return draw.NearestNeighbor
+	case Mitchell:
+		return mitchell
+	case Box:
+		return box
+	default:
+		return draw.CatmullRom
+	}
+}
+
+// scaler returns the scaler selected by o: o.Scaler when set and the
+// interpolator for o.Filter otherwise.
+func (o Options) scaler() draw.Scaler {
+	if o.Scaler != nil {
+		return o.Scaler
+	}
+	return o.Filter.interpolator()
+}
@@ -0,0 +1,48 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+)
+
+// Focus marks the part of the source that Fill and Crop keep in frame.
+// When set it takes precedence over Gravity.
+type Focus struct {
+	// X and Y locate the focal point as fractions of the source width and
+	// height, from 0 (left, top) to 1 (right, bottom).
+	X, Y float64
+
+	// Rect, when non-empty, is a region of interest measured in pixels
+	// from the top-left corner of the source. The crop is centered on it
+	// and contains all of it whenever the crop is large enough; X and Y
+	// are then ignored.
+	Rect image.Rectangle
+}
+
+// valid reports whether the focal point lies within the source.
+func (f *Focus) valid() bool {
+	return f.X >= 0 && f.X <= 1 && f.Y >= 0 && f.Y <= 1
+}
+
+// center returns the point of full the crop should be centered on.
+func (f *Focus) center(full image.Rectangle) image.Point {
+	if r := f.Rect.Intersect(full); !r.Empty() {
+		return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+	}
+	return image.Pt(
+		int(math.Round(f.X*float64(full.Dx()))),
+		int(math.Round(f.Y*float64(full.Dy()))),
+	)
+}
+
+// cropWindow positions a crop of the given size within full, honoring
+// opts.Focus when set and opts.Gravity otherwise.
+func cropWindow(size image.Point, full image.Rectangle, opts Options) image.Rectangle {
+	if opts.Focus == nil {
+		return opts.Gravity.place(size, full)
+	}
+	c := opts.Focus.center(full)
+	x := clampInt(c.X-size.X/2, full.Min.X, full.Max.X-size.X)
+	y := clampInt(c.Y-size.Y/2, full.Min.Y, full.Max.Y-size.Y)
+	return image.Rect(x, y, x+size.X, y+size.Y)
+}
@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Frame selects the style of the border drawn by Options.Border.
+type Frame int
+
+const (
+	// FrameSolid fills the border with BorderColor. It is the default.
+	FrameSolid Frame = iota
+	// FrameDouble draws two lines of BorderColor, each about a third of
+	// the border wide, with the Background showing between them.
+	FrameDouble
+	// FrameRaised shades the top and left of the border lighter and the
+	// bottom and right darker than BorderColor, so that the thumbnail
+	// seems to stand out of the page.
+	FrameRaised
+	// FrameSunken shades the border the other way round, so that the
+	// thumbnail seems set into the page.
+	FrameSunken
+)
+
+// valid reports whether f is a known frame style.
+func (f Frame) valid() bool {
+	return f >= FrameSolid && f <= FrameSunken
+}
+
+// frameColors returns the colors the border of opts is drawn in: the
+// border color, then its lighter and darker shades for bevelled styles.
+func frameColors(opts Options) []color.Color {
+	c := opts.BorderColor
+	if c == nil {
+		c = color.Black
+	}
+	if opts.Frame != FrameRaised && opts.Frame != FrameSunken {
+		return []color.Color{c}
+	}
+	return []color.Color{c, shade(c, 0xffff), shade(c, 0)}
+}
+
+// shade returns c mixed half and half with the gray level v, keeping its
+// alpha.
+func shade(c color.Color, v uint32) color.Color {
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	mix := func(x uint16) uint16 { return uint16((uint32(x) + v) / 2) }
+	return color.NRGBA64{mix(n.R), mix(n.G), mix(n.B), n.A}
+}
+
+// drawFrame draws a border of width b around the inside of r in dst, in
+// the style and color opts give.
+func drawFrame(dst draw.Image, r image.Rectangle, b int, opts Options) {
+	colors := frameColors(opts)
+	fill := func(r image.Rectangle, c color.Color) {
+		draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	ring := func(r image.Rectangle, b int, c color.Color) {
+		in := r.Inset(b)
+		fill(image.Rect(r.Min.X, r.Min.Y, r.Max.X, in.Min.Y), c)
+		fill(image.Rect(r.Min.X, in.Max.Y, r.Max.X, r.Max.Y), c)
+		fill(image.Rect(r.Min.X, in.Min.Y, in.Min.X, in.Max.Y), c)
+		fill(image.Rect(in.Max.X, in.Min.Y, r.Max.X, in.Max.Y), c)
+	}
+
+	switch opts.Frame {
+	case FrameDouble:
+		line := (b + 2) / 3
+		ring(r, line, colors[0])
+		ring(r.Inset(b-line), line, colors[0])
+	case FrameRaised, FrameSunken:
+		light, dark := colors[1], colors[2]
+		if opts.Frame == FrameSunken {
+			light, dark = dark, light
+		}
+		// Draw one pixel wide rings from the outside in, so that the light
+		// and dark sides meet on the diagonals at the corners.
+		for i := 0; i < b; i++ {
+			q := r.Inset(i)
+			fill(image.Rect(q.Min.X, q.Min.Y, q.Max.X-1, q.Min.Y+1), light)
+			fill(image.Rect(q.Min.X, q.Min.Y+1, q.Min.X+1, q.Max.Y), light)
+			fill(image.Rect(q.Min.X+1, q.Max.Y-1, q.Max.X, q.Max.Y), dark)
+			fill(image.Rect(q.Max.X-1, q.Min.Y, q.Max.X, q.Max.Y-1), dark)
+		}
+	default:
+		ring(r, b, colors[0])
+	}
+}
@@ -0,0 +1,29 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"io/fs"
+)
+
+// GenerateFromFS reads the named image file from fsys and generates a
+// thumbnail. It works with any fs.FS, such as embed.FS, zip archives or
+// fstest.MapFS.
+func GenerateFromFS(fsys fs.FS, name string, opts Options) (image.Image, error) {
+	res, err := ProcessFS(context.Background(), fsys, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return res.Image, nil
+}
+
+// ProcessFS is like ProcessFile but reads the named file from fsys.
+func ProcessFS(ctx context.Context, fsys fs.FS, name string, opts Options) (*Result, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ProcessReader(ctx, f, opts)
+}
@@ -0,0 +1,112 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Quantizer selects how a palette is chosen for GIF output.
+type Quantizer int
+
+const (
+	// QuantizePlan9 uses the fixed Plan 9 palette. It is fast and the
+	// default, but renders photos with visible banding.
+	QuantizePlan9 Quantizer = iota
+	// QuantizeMedianCut builds a palette by repeatedly splitting the
+	// image's colors at the median of their widest channel.
+	QuantizeMedianCut
+	// QuantizeOctree builds a palette by merging the least used branches
+	// of an octree of the image's colors.
+	QuantizeOctree
+)
+
+// valid reports whether q is a known quantizer.
+func (q Quantizer) valid() bool {
+	return q >= QuantizePlan9 && q <= QuantizeOctree
+}
+
+// Dither selects how colors between palette entries are approximated.
+type Dither int
+
+const (
+	// DitherFloydSteinberg diffuses the error of each pixel to its
+	// neighbors. It is the default.
+	DitherFloydSteinberg Dither = iota
+	// DitherNone maps each pixel to the nearest palette color, which
+	// keeps flat areas clean but bands gradients.
+	DitherNone
+)
+
+// valid reports whether d is a known dithering method.
+func (d Dither) valid() bool {
+	return d >= DitherFloydSteinberg && d <= DitherNone
+}
+
+// SaveGIFOptions writes img to w as GIF with a palette chosen by
+// opts.Quantizer and dithered as opts.Dither selects. Paletted images are
+// written with their own palette. Pixels under half opaque become the
+// transparent color, for which one palette entry is kept, and the others
+// are quantized at full opacity, so that soft edges do not turn dark.
+func SaveGIFOptions(img image.Image, w io.Writer, opts Options) error {
+	return gif.Encode(w, toPaletted(img, opts), nil)
+}
+
+// toPaletted converts img for GIF encoding as described by SaveGIFOptions.
+func toPaletted(img image.Image, opts Options) *image.Paletted {
+	if p, ok := img.(*image.Paletted); ok {
+		return p
+	}
+
+	// GIF has no partial transparency: cut alpha at one half and keep
+	// the color of the pixels that stay.
+	src := toNRGBA(img)
+	flat := image.NewNRGBA(src.Rect)
+	transparent := false
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] < 0x80 {
+			transparent = true
+			continue
+		}
+		copy(flat.Pix[i:i+3], src.Pix[i:i+3])
+		flat.Pix[i+3] = 0xff
+	}
+
+	var pal color.Palette
+	switch opts.Quantizer {
+	case QuantizeMedianCut:
+		pal = medianCut(flat, 256)
+	case QuantizeOctree:
+		pal = octree(flat, 256)
+	default:
+		pal = palette.Plan9
+		if transparent {
+			pal = append(pal[:len(pal)-1:len(pal)-1], color.Transparent)
+		}
+	}
+	if len(pal) == 0 {
+		pal = color.Palette{color.Transparent}
+	}
+
+	if opts.Dither == DitherNone {
+		return toPalette(flat, pal)
+	}
+	b := flat.Bounds()
+	p := image.NewPaletted(b, pal)
+	draw.FloydSteinberg.Draw(p, b, flat, b.Min)
+	if transparent {
+		// Error diffusion can carry error into transparent pixels; set
+		// them to the transparent entry afterwards.
+		ti := uint8(pal.Index(color.Transparent))
+		for i, j := 3, 0; i < len(flat.Pix); i, j = i+4, j+1 {
+			if flat.Pix[i] == 0 {
+				p.Pix[j] = ti
+			}
+		}
+	}
+	return p
+}
@@ -0,0 +1,67 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+)
+
+// Gravity selects which part of the source is kept when Fill or Crop cut
+// away its overflow, and where Pad places the scaled source on the canvas.
+type Gravity int
+
+const (
+	// Center keeps the middle of the source. It is the default.
+	Center Gravity = iota
+	Top
+	Bottom
+	Left
+	Right
+	TopLeft
+	TopRight
+	BottomLeft
+	BottomRight
+	// Smart keeps the part of the source with the most detail, measured
+	// as edge energy, rather than a fixed one, so that crops tend to keep
+	// faces and subjects that a center crop would cut off. It applies to
+	// Fill and Crop of single images; elsewhere, as in Pad or for
+	// animations, it acts as Center.
+	Smart
+)
+
+// valid reports whether g is a known gravity.
+func (g Gravity) valid() bool {
+	return g >= Center && g <= Smart
+}
+
+// anchor returns the horizontal and vertical position of g as fractions of
+// the available slack, from 0 (left, top) to 1 (right, bottom).
+func (g Gravity) anchor() (float64, float64) {
+	switch g {
+	case Top:
+		return 0.5, 0
+	case Bottom:
+		return 0.5, 1
+	case Left:
+		return 0, 0.5
+	case Right:
+		return 1, 0.5
+	case TopLeft:
+		return 0, 0
+	case TopRight:
+		return 1, 0
+	case BottomLeft:
+		return 0, 1
+	case BottomRight:
+		return 1, 1
+	default:
+		return 0.5, 0.5
+	}
+}
+
+// place positions a rectangle of the given size inside outer according to g.
+func (g Gravity) place(size image.Point, outer image.Rectangle) image.Rectangle {
+	fx, fy := g.anchor()
+	x := outer.Min.X + int(math.Round(fx*float64(outer.Dx()-size.X)))
+	y := outer.Min.Y + int(math.Round(fy*float64(outer.Dy()-size.Y)))
+	return image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+size.X, y+size.Y)}
+}
@@ -0,0 +1,532 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrInvalidProfile is returned by ParseICCProfile for data that is not an
+// ICC profile this package can convert from.
+var ErrInvalidProfile = errors.New("thumbnail: invalid or unsupported ICC profile")
+
+// ICCProfile is a parsed ICC color profile describing the colors of a
+// source image, used to convert it to sRGB.
+type ICCProfile struct {
+	// ColorSpace is the profile's data color space signature, such as
+	// "CMYK" or "RGB ".
+	ColorSpace string
+
+	pcs string  // profile connection space, "XYZ " or "Lab "
+	a2b *iccLut // device to PCS
+
+	// Matrix/TRC RGB profiles, which have no a2b, map device values
+	// through trc and then matrix (row-major) to D50 XYZ.
+	trc    []iccCurve
+	matrix []float64
+}
+
+// iccLut is a device-to-PCS transform of one of the lut8, lut16 or lutAtoB
+// tag types. Values are normalized to 0-1 throughout; the PCS encoding
+// differs between tag types and is undone by decodePCS.
+type iccLut struct {
+	typ     string // "mft1", "mft2" or "mAB "
+	in, out int
+	aCurves []iccCurve // input curves, one per input channel
+	grid    []int      // CLUT grid points per input channel
+	clut    []float64
+	mCurves []iccCurve // lutAtoB only
+	matrix  []float64  // lutAtoB only: 3×3 then offsets
+	bCurves []iccCurve // output curves, one per output channel
+}
+
+// iccCurve is a tone curve: a sampled table, a pure gamma, or one of the
+// parametric functions of the parametricCurveType.
+type iccCurve struct {
+	table  []float64
+	gamma  float64
+	params []float64 // g, a, b, c, d, e, f as given
+	fn     int       // parametric function type, -1 for table or gamma
+}
+
+// ParseICCProfile parses an ICC profile. Profiles with a device to PCS
+// lookup table (the A2B0 or A2B1 tag), as used for CMYK, are supported,
+// as are RGB profiles defined by colorants and tone curves, as used for
+// Adobe RGB, ProPhoto and Display P3.
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || !bytes.Equal(data[36:40], []byte("acsp")) {
+		return nil, ErrInvalidProfile
+	}
+	p := &ICCProfile{ColorSpace: string(data[16:20]), pcs: string(data[20:24])}
+	if p.pcs != "XYZ " && p.pcs != "Lab " {
+		return nil, ErrInvalidProfile
+	}
+	tags := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(data); i++ {
+		e := data[132+12*i:]
+		off := binary.BigEndian.Uint32(e[4:])
+		size := binary.BigEndian.Uint32(e[8:])
+		if uint64(off)+uint64(size) > uint64(len(data)) {
+			return nil, ErrInvalidProfile
+		}
+		tags[string(e[:4])] = data[off : off+size]
+	}
+	for _, sig := range []string{"A2B0", "A2B1"} {
+		if t, ok := tags[sig]; ok {
+			lut, err := parseICCLut(t)
+			if err != nil {
+				return nil, err
+			}
+			if lut.out != 3 || lut.in != iccChannels(p.ColorSpace) {
+				return nil, ErrInvalidProfile
+			}
+			p.a2b = lut
+			return p, nil
+		}
+	}
+	if p.ColorSpace != "RGB " || p.pcs != "XYZ " {
+		return nil, ErrInvalidProfile
+	}
+	p.matrix = make([]float64, 9)
+	for i, c := range []string{"r", "g", "b"} {
+		xyz, trc := tags[c+"XYZ"], tags[c+"TRC"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " || len(trc) < 12 {
+			return nil, ErrInvalidProfile
+		}
+		for j := 0; j < 3; j++ {
+			p.matrix[3*j+i] = s15Fixed16(xyz[8+4*j:])
+		}
+		curve, _, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		p.trc = append(p.trc, curve)
+	}
+	return p, nil
+}
+
+// iccChannels returns the number of channels of an ICC color space
+// signature, or 0 for ones this package does not handle.
+func iccChannels(space string) int {
+	switch space {
+	case "GRAY":
+		return 1
+	case "RGB ", "Lab ", "XYZ ":
+		return 3
+	case "CMYK":
+		return 4
+	}
+	return 0
+}
+
+// parseICCLut parses a lut8, lut16 or lutAtoB tag.
+func parseICCLut(t []byte) (*iccLut, error) {
+	if len(t) < 32 {
+		return nil, ErrInvalidProfile
+	}
+	l := &iccLut{typ: string(t[:4]), in: int(t[8]), out: int(t[9])}
+	if l.in < 1 || l.in > 8 || l.out < 1 || l.out > 8 {
+		return nil, ErrInvalidProfile
+	}
+	switch l.typ {
+	case "mft1", "mft2":
+		if len(t) < 52 {
+			return nil, ErrInvalidProfile
+		}
+		g := int(t[10])
+		if g < 2 {
+			return nil, ErrInvalidProfile
+		}
+		width, inEntries, outEntries, pos := 1, 256, 256, 48
+		if l.typ == "mft2" {
+			width = 2
+			inEntries = int(binary.BigEndian.Uint16(t[48:]))
+			outEntries = int(binary.BigEndian.Uint16(t[50:]))
+			pos = 52
+		}
+		if inEntries < 2 || outEntries < 2 {
+			return nil, ErrInvalidProfile
+		}
+		var ok bool
+		if l.aCurves, pos, ok = iccTables(t, pos, l.in, inEntries, width); !ok {
+			return nil, ErrInvalidProfile
+		}
+		l.grid = make([]int, l.in)
+		for i := range l.grid {
+			l.grid[i] = g
+		}
+		if l.clut, pos, ok = iccSamples(t, pos, iccGridSize(l.grid)*l.out, width); !ok {
+			return nil, ErrInvalidProfile
+		}
+		if l.bCurves, _, ok = iccTables(t, pos, l.out, outEntries, width); !ok {
+			return nil, ErrInvalidProfile
+		}
+	case "mAB ":
+		offB := binary.BigEndian.Uint32(t[12:])
+		offMatrix := binary.BigEndian.Uint32(t[16:])
+		offM := binary.BigEndian.Uint32(t[20:])
+		offCLUT := binary.BigEndian.Uint32(t[24:])
+		offA := binary.BigEndian.Uint32(t[28:])
+		var err error
+		if offB == 0 {
+			return nil, ErrInvalidProfile
+		}
+		if l.bCurves, err = iccCurves(t, offB, l.out); err != nil {
+			return nil, err
+		}
+		if offA != 0 {
+			if l.aCurves, err = iccCurves(t, offA, l.in); err != nil {
+				return nil, err
+			}
+		}
+		if offM != 0 {
+			if l.mCurves, err = iccCurves(t, offM, l.out); err != nil {
+				return nil, err
+			}
+		}
+		if offMatrix != 0 {
+			if uint64(offMatrix)+48 > uint64(len(t)) || l.out != 3 {
+				return nil, ErrInvalidProfile
+			}
+			l.matrix = make([]float64, 12)
+			for i := range l.matrix {
+				l.matrix[i] = s15Fixed16(t[int(offMatrix)+4*i:])
+			}
+		}
+		if offCLUT != 0 {
+			c := int(offCLUT)
+			if c+20 > len(t) {
+				return nil, ErrInvalidProfile
+			}
+			l.grid = make([]int, l.in)
+			for i := range l.grid {
+				if l.grid[i] = int(t[c+i]); l.grid[i] < 2 {
+					return nil, ErrInvalidProfile
+				}
+			}
+			width := int(t[c+16])
+			if width != 1 && width != 2 {
+				return nil, ErrInvalidProfile
+			}
+			var ok bool
+			if l.clut, _, ok = iccSamples(t, c+20, iccGridSize(l.grid)*l.out, width); !ok {
+				return nil, ErrInvalidProfile
+			}
+		} else if l.in != l.out {
+			return nil, ErrInvalidProfile
+		}
+	default:
+		return nil, ErrInvalidProfile
+	}
+	return l, nil
+}
+
+// iccGridSize returns the number of grid points of a CLUT.
+func iccGridSize(grid []int) int {
+	n := 1
+	for _, g := range grid {
+		n *= g
+		if n > 1<<24 {
+			return 1 << 30 // more than any profile holds
+		}
+	}
+	return n
+}
+
+// iccSamples reads n samples of the given byte width at pos, normalized
+// to 0-1, and returns the position after them.
+func iccSamples(t []byte, pos, n, width int) ([]float64, int, bool) {
+	if n < 0 || pos+n*width > len(t) {
+		return nil, pos, false
+	}
+	s := make([]float64, n)
+	for i := range s {
+		if width == 1 {
+			s[i] = float64(t[pos+i]) / 0xff
+		} else {
+			s[i] = float64(binary.BigEndian.Uint16(t[pos+2*i:])) / 0xffff
+		}
+	}
+	return s, pos + n*width, true
+}
+
+// iccTables reads the per-channel tables of a lut8 or lut16 tag.
+func iccTables(t []byte, pos, channels, entries, width int) ([]iccCurve, int, bool) {
+	curves := make([]iccCurve, channels)
+	for i := range curves {
+		s, next, ok := iccSamples(t, pos, entries, width)
+		if !ok {
+			return nil, pos, false
+		}
+		curves[i] = iccCurve{table: s, fn: -1}
+		pos = next
+	}
+	return curves, pos, true
+}
+
+// iccCurves reads the n curveType or parametricCurveType elements that
+// follow each other, 4-byte aligned, from offset off of a lutAtoB tag.
+func iccCurves(t []byte, off uint32, n int) ([]iccCurve, error) {
+	curves := make([]iccCurve, n)
+	pos := int(off)
+	for i := range curves {
+		if pos < 0 || pos+12 > len(t) {
+			return nil, ErrInvalidProfile
+		}
+		c, size, err := parseICCCurve(t[pos:])
+		if err != nil {
+			return nil, err
+		}
+		curves[i] = c
+		pos += (size + 3) &^ 3
+	}
+	return curves, nil
+}
+
+// parseICCCurve parses a curveType or parametricCurveType element and
+// returns it with its size in bytes.
+func parseICCCurve(t []byte) (iccCurve, int, error) {
+	switch string(t[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(t[8:]))
+		size := 12 + 2*n
+		if n < 0 || size > len(t) {
+			return iccCurve{}, 0, ErrInvalidProfile
+		}
+		switch n {
+		case 0:
+			return iccCurve{gamma: 1, fn: -1}, size, nil
+		case 1:
+			return iccCurve{gamma: float64(binary.BigEndian.Uint16(t[12:])) / 256, fn: -1}, size, nil
+		}
+		table, _, _ := iccSamples(t, 12, n, 2)
+		return iccCurve{table: table, fn: -1}, size, nil
+	case "para":
+		fn := int(binary.BigEndian.Uint16(t[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if fn >= len(counts) || 12+4*counts[fn] > len(t) {
+			return iccCurve{}, 0, ErrInvalidProfile
+		}
+		params := make([]float64, counts[fn])
+		for i := range params {
+			params[i] = s15Fixed16(t[12+4*i:])
+		}
+		return iccCurve{params: params, fn: fn}, 12 + 4*len(params), nil
+	}
+	return iccCurve{}, 0, ErrInvalidProfile
+}
+
+// s15Fixed16 decodes an ICC s15Fixed16Number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// apply evaluates the curve at x in 0-1.
+func (c iccCurve) apply(x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	if c.table != nil {
+		f := x * float64(len(c.table)-1)
+		i := int(f)
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		return c.table[i] + (c.table[i+1]-c.table[i])*(f-float64(i))
+	}
+	if c.fn < 0 {
+		return math.Pow(x, c.gamma)
+	}
+	p := c.params
+	g := p[0]
+	var y float64
+	switch c.fn {
+	case 0:
+		y = math.Pow(x, g)
+	case 1:
+		if x >= -p[2]/p[1] {
+			y = math.Pow(p[1]*x+p[2], g)
+		}
+	case 2:
+		y = p[3]
+		if x >= -p[2]/p[1] {
+			y = math.Pow(p[1]*x+p[2], g) + p[3]
+		}
+	case 3:
+		y = p[3] * x
+		if x >= p[4] {
+			y = math.Pow(p[1]*x+p[2], g)
+		}
+	case 4:
+		y = p[3]*x + p[6]
+		if x >= p[4] {
+			y = math.Pow(p[1]*x+p[2], g) + p[5]
+		}
+	}
+	return math.Max(0, math.Min(1, y))
+}
+
+// eval maps device values in to normalized PCS values in out.
+func (l *iccLut) eval(in, out []float64) {
+	v := make([]float64, l.in)
+	for i := range v {
+		v[i] = in[i]
+		if l.aCurves != nil {
+			v[i] = l.aCurves[i].apply(v[i])
+		}
+	}
+	if l.clut != nil {
+		l.interpolate(v, out)
+	} else {
+		copy(out, v)
+	}
+	if l.mCurves != nil {
+		for i := range out {
+			out[i] = l.mCurves[i].apply(out[i])
+		}
+	}
+	if l.matrix != nil {
+		m := l.matrix
+		x, y, z := out[0], out[1], out[2]
+		out[0] = m[0]*x + m[1]*y + m[2]*z + m[9]
+		out[1] = m[3]*x + m[4]*y + m[5]*z + m[10]
+		out[2] = m[6]*x + m[7]*y + m[8]*z + m[11]
+	}
+	for i := range out {
+		out[i] = l.bCurves[i].apply(out[i])
+	}
+}
+
+// interpolate looks v up in the CLUT by multilinear interpolation.
+func (l *iccLut) interpolate(v, out []float64) {
+	base := 0
+	frac := make([]float64, l.in)
+	strides := make([]int, l.in)
+	stride := l.out
+	for i := l.in - 1; i >= 0; i-- {
+		strides[i] = stride
+		f := math.Max(0, math.Min(1, v[i])) * float64(l.grid[i]-1)
+		k := int(f)
+		if k >= l.grid[i]-1 {
+			k = l.grid[i] - 2
+		}
+		frac[i] = f - float64(k)
+		base += k * stride
+		stride *= l.grid[i]
+	}
+	for o := range out {
+		out[o] = 0
+	}
+	for corner := 0; corner < 1<<uint(l.in); corner++ {
+		w, idx := 1.0, base
+		for i := 0; i < l.in; i++ {
+			if corner&(1<<uint(i)) != 0 {
+				w *= frac[i]
+				idx += strides[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += w * l.clut[idx+o]
+		}
+	}
+}
+
+// sRGB converts device values of the profile's color space, normalized to
+// 0-1, to gamma-encoded sRGB in 0-1.
+func (p *ICCProfile) sRGB(in []float64) (r, g, b float64) {
+	r, g, b = p.linearSRGB(in)
+	return sRGBEncode(r), sRGBEncode(g), sRGBEncode(b)
+}
+
+// linearSRGB converts device values as sRGB does, to linear sRGB values
+// that lie outside 0-1 for colors outside its gamut.
+func (p *ICCProfile) linearSRGB(in []float64) (r, g, b float64) {
+	var x, y, z float64
+	if p.a2b != nil {
+		var pcs [3]float64
+		p.a2b.eval(in, pcs[:])
+		x, y, z = p.decodePCS(pcs)
+	} else {
+		var v [3]float64
+		for i := range v {
+			v[i] = p.trc[i].apply(in[i])
+		}
+		m := p.matrix
+		x = m[0]*v[0] + m[1]*v[1] + m[2]*v[2]
+		y = m[3]*v[0] + m[4]*v[1] + m[5]*v[2]
+		z = m[6]*v[0] + m[7]*v[1] + m[8]*v[2]
+	}
+	return xyzToLinearSRGB(x, y, z)
+}
+
+// xyzToLinearSRGB converts XYZ relative to the D50 PCS illuminant to
+// linear sRGB, Bradford-adapted to the D65 white of sRGB.
+func xyzToLinearSRGB(x, y, z float64) (r, g, b float64) {
+	r = 3.1338561*x - 1.6168667*y - 0.4906146*z
+	g = -0.9787684*x + 1.9161415*y + 0.0334540*z
+	b = 0.0719453*x - 0.2289914*y + 1.4052427*z
+	return r, g, b
+}
+
+// decodePCS turns normalized PCS values into D50 XYZ with Y = 1 for white.
+func (p *ICCProfile) decodePCS(v [3]float64) (x, y, z float64) {
+	if p.pcs == "XYZ " {
+		// u1Fixed15: 0xffff is 1+32767/32768.
+		s := 65535.0 / 32768
+		return v[0] * s, v[1] * s, v[2] * s
+	}
+	var l, a, b float64
+	if p.a2b.typ == "mft2" {
+		// lut16 uses the legacy encoding, in which 0xff00 is L* 100.
+		s := 65535.0 / 65280
+		l, a, b = v[0]*s*100, v[1]*s*255-128, v[2]*s*255-128
+	} else {
+		l, a, b = v[0]*100, v[1]*255-128, v[2]*255-128
+	}
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	finv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	return 0.9642 * finv(fx), finv(fy), 0.8249 * finv(fz)
+}
+
+// sRGBEncode applies the sRGB transfer function to a linear value,
+// clamping it to 0-1.
+func sRGBEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return math.Max(0, 12.92*v)
+	}
+	return math.Min(1, 1.055*math.Pow(v, 1/2.4)-0.055)
+}
+
+// jpegICC returns the ICC profile embedded in a JPEG stream, reassembled
+// from its APP2 chunks, or nil if it has none.
+func jpegICC(data []byte) []byte {
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for _, s := range jpegSegments(data) {
+		if s.marker == 0xe2 && len(s.payload) >= len(iccHeader)+2 && bytes.HasPrefix(s.payload, []byte(iccHeader)) {
+			chunks = append(chunks, chunk{s.payload[len(iccHeader)], s.payload[len(iccHeader)+2:]})
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	var profile []byte
+	for _, c := range chunks {
+		profile = append(profile, c.data...)
+	}
+	return profile
+}
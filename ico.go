@@ -0,0 +1,153 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxICODimension is the largest width or height of an image in an ICO file.
+const maxICODimension = 256
+
+// SaveICO writes images to w as a single ICO file, one entry per image, so
+// that several resolutions of the same icon can share a file. Each entry is
+// stored as PNG and may be at most 256×256.
+func SaveICO(w io.Writer, images ...image.Image) error {
+	if len(images) == 0 {
+		return ErrNilImage
+	}
+	entries := make([][]byte, len(images))
+	for i, img := range images {
+		if img == nil {
+			return ErrNilImage
+		}
+		b := img.Bounds()
+		if b.Empty() {
+			return ErrEmptyImage
+		}
+		if b.Dx() > maxICODimension || b.Dy() > maxICODimension {
+			return ErrImageTooLarge
+		}
+		var buf bytes.Buffer
+		if err := SavePNG(img, &buf); err != nil {
+			return err
+		}
+		entries[i] = buf.Bytes()
+	}
+
+	// ICONDIR header followed by one 16-byte ICONDIRENTRY per image.
+	hdr := make([]byte, 6+16*len(images))
+	binary.LittleEndian.PutUint16(hdr[2:], 1) // type: icon
+	binary.LittleEndian.PutUint16(hdr[4:], uint16(len(images)))
+	offset := len(hdr)
+	for i, img := range images {
+		e := hdr[6+16*i:]
+		b := img.Bounds()
+		e[0], e[1] = byte(b.Dx()), byte(b.Dy())  // 256 is stored as 0
+		binary.LittleEndian.PutUint16(e[4:], 1)  // color planes
+		binary.LittleEndian.PutUint16(e[6:], 32) // bits per pixel
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(e[12:], uint32(offset))
+		offset += len(entries[i])
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	for _, data := range entries {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FaviconSizes are the resolutions bundled into favicon.ico by
+// GenerateFavicons.
+var FaviconSizes = []int{16, 32, 48}
+
+// FaviconIcons maps the PNG icon files produced by GenerateFavicons to
+// their sizes: the browser favicons, the Apple touch icon and the web app
+// manifest icons.
+var FaviconIcons = map[string]int{
+	"favicon-16x16.png":          16,
+	"favicon-32x32.png":          32,
+	"apple-touch-icon.png":       180,
+	"android-chrome-192x192.png": 192,
+	"android-chrome-512x512.png": 512,
+}
+
+// Favicons is a favicon bundle generated by GenerateFavicons.
+type Favicons struct {
+	// ICO is the encoded favicon.ico holding every size in FaviconSizes.
+	ICO []byte
+
+	// Icons holds the square PNG icons keyed by file name, as listed in
+	// FaviconIcons.
+	Icons map[string]image.Image
+}
+
+// GenerateFavicons generates a favicon.ico and the standard PNG icons from
+// a single source. Width, Height and Format in opts are ignored; the other
+// options apply to every icon. Icons are square, so the default Fit mode is
+// treated as Pad, centering the source on a transparent canvas unless a
+// Background is set.
+func GenerateFavicons(src image.Image, opts Options) (*Favicons, error) {
+	if opts.Mode == Fit {
+		opts.Mode = Pad
+	}
+	opts.Scale, opts.AspectRatio = 0, 0
+
+	// Each distinct size is generated once.
+	index := make(map[int]int)
+	var sizes []Options
+	add := func(size int) {
+		if _, ok := index[size]; ok {
+			return
+		}
+		o := opts
+		o.Width, o.Height = size, size
+		index[size] = len(sizes)
+		sizes = append(sizes, o)
+	}
+	for _, size := range FaviconSizes {
+		add(size)
+	}
+	for _, size := range FaviconIcons {
+		add(size)
+	}
+	results, err := ProcessSizes(context.Background(), src, sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	icoImages := make([]image.Image, len(FaviconSizes))
+	for i, size := range FaviconSizes {
+		icoImages[i] = results[index[size]].Image
+	}
+	var buf bytes.Buffer
+	if err := SaveICO(&buf, icoImages...); err != nil {
+		return nil, err
+	}
+	f := &Favicons{ICO: buf.Bytes(), Icons: make(map[string]image.Image, len(FaviconIcons))}
+	for name, size := range FaviconIcons {
+		f.Icons[name] = results[index[size]].Image
+	}
+	return f, nil
+}
+
+// Save writes favicon.ico and the PNG icons into dir, which must exist.
+func (f *Favicons) Save(dir string) error {
+	if err := os.WriteFile(filepath.Join(dir, "favicon.ico"), f.ICO, 0o644); err != nil {
+		return err
+	}
+	for name, img := range f.Icons {
+		if err := Save(img, filepath.Join(dir, name), Options{Format: PNG}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
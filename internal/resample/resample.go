@@ -0,0 +1,145 @@
+// Package resample holds the inner loops of the thumbnail package's kernel
+// filter passes, over rows of pixels of four float32 or big-endian 16-bit
+// channels. Each loop has a pure-Go version and, on CPUs that have the
+// instructions for it, an AVX2 or NEON one that gives the same results;
+// building with the purego tag keeps the pure-Go versions.
+//
+// The assembly lives here rather than in the thumbnail package because Go
+// does not allow assembly in packages that use cgo, which the thumbnail
+// package does with its libheif, libjxl and libvips build tags.
+package resample
+
+// The implementations in use, replaced by the assembly versions at init.
+var (
+	widen8         = widen8Generic
+	widen16        = widen16Generic
+	convolveRow    = convolveRowGeneric
+	pack16         = pack16Generic
+	accumulateRows = accumulateRowsGeneric
+	packPremul8    = packPremul8Generic
+	packPremul16   = packPremul16Generic
+)
+
+// Widen8 sets row to the 8-bit channels of pix scaled to 16 bits.
+func Widen8(row []float32, pix []byte) { widen8(row, pix) }
+
+// Widen16 sets row to the big-endian 16-bit channels of pix.
+func Widen16(row []float32, pix []byte) { widen16(row, pix) }
+
+// ConvolveRow sets each pixel x of acc to the pixels of row from start[x]
+// on, weighted by weights[x*taps:(x+1)*taps]. Every window must lie inside
+// row.
+func ConvolveRow(acc, row []float32, start []int, weights []float32, taps int) {
+	convolveRow(acc, row, start, weights, taps)
+}
+
+// Pack16 stores acc rounded and clamped to big-endian 16 bits in pix.
+func Pack16(pix []byte, acc []float32) { pack16(pix, acc) }
+
+// AccumulateRows sets acc to the sum of the rows of big-endian 16-bit
+// channels that start every stride bytes of pix, weighted by ws.
+func AccumulateRows(acc []float32, pix []byte, stride int, ws []float32) {
+	accumulateRows(acc, pix, stride, ws)
+}
+
+// PackPremul8 stores acc in pix as 8-bit premultiplied colors, rounded and
+// clamped like Pack16 and with colors no brighter than alpha, which
+// kernels with negative lobes may overshoot.
+func PackPremul8(pix []byte, acc []float32) { packPremul8(pix, acc) }
+
+// PackPremul16 is like PackPremul8 with big-endian 16-bit channels.
+func PackPremul16(pix []byte, acc []float32) { packPremul16(pix, acc) }
+
+func widen8Generic(row []float32, pix []byte) {
+	for i, v := range pix {
+		row[i] = float32(v) * 0x101
+	}
+}
+
+func widen16Generic(row []float32, pix []byte) {
+	for i := range row {
+		row[i] = float32(uint32(pix[2*i])<<8 | uint32(pix[2*i+1]))
+	}
+}
+
+func convolveRowGeneric(acc, row []float32, start []int, weights []float32, taps int) {
+	for x, s := range start {
+		var r, g, b, a float32
+		p := row[4*s : 4*(s+taps)]
+		for j, w := range weights[x*taps : (x+1)*taps] {
+			q := p[4*j : 4*j+4]
+			r += w * q[0]
+			g += w * q[1]
+			b += w * q[2]
+			a += w * q[3]
+		}
+		acc[4*x], acc[4*x+1], acc[4*x+2], acc[4*x+3] = r, g, b, a
+	}
+}
+
+func pack16Generic(pix []byte, acc []float32) {
+	for i, v := range acc {
+		u := to16(v)
+		pix[2*i], pix[2*i+1] = uint8(u>>8), uint8(u)
+	}
+}
+
+func accumulateRowsGeneric(acc []float32, pix []byte, stride int, ws []float32) {
+	for i := range acc {
+		acc[i] = 0
+	}
+	for j, w := range ws {
+		if w == 0 {
+			continue
+		}
+		p := pix[j*stride : j*stride+2*len(acc)]
+		for i := range acc {
+			acc[i] += w * float32(uint32(p[2*i])<<8|uint32(p[2*i+1]))
+		}
+	}
+}
+
+func packPremul8Generic(pix []byte, acc []float32) {
+	for i := 0; i < len(acc); i += 4 {
+		r, g, b, a := premul16(acc[i:])
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+}
+
+func packPremul16Generic(pix []byte, acc []float32) {
+	for i := 0; i < len(acc); i += 4 {
+		r, g, b, a := premul16(acc[i:])
+		pix[2*i], pix[2*i+1] = uint8(r>>8), uint8(r)
+		pix[2*i+2], pix[2*i+3] = uint8(g>>8), uint8(g)
+		pix[2*i+4], pix[2*i+5] = uint8(b>>8), uint8(b)
+		pix[2*i+6], pix[2*i+7] = uint8(a>>8), uint8(a)
+	}
+}
+
+// to16 rounds v and clamps it to 16 bits.
+func to16(v float32) uint32 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 0xffff:
+		return 0xffff
+	}
+	return uint32(v + 0.5)
+}
+
+// premul16 rounds and clamps the premultiplied color p to 16 bits, with
+// colors no brighter than alpha.
+func premul16(p []float32) (r, g, b, a uint32) {
+	a = to16(p[3])
+	r, g, b = to16(p[0]), to16(p[1]), to16(p[2])
+	if r > a {
+		r = a
+	}
+	if g > a {
+		g = a
+	}
+	if b > a {
+		b = a
+	}
+	return r, g, b, a
+}
@@ -0,0 +1,66 @@
+//go:build gc && !purego
+// +build gc,!purego
+
+package resample
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	if !cpu.X86.HasAVX2 {
+		return
+	}
+	// The assembly handles whole blocks of channels, and the generic
+	// loops the rest.
+	widen8 = func(row []float32, pix []byte) {
+		n := len(pix) &^ 7
+		widen8AVX2(row[:n], pix[:n])
+		widen8Generic(row[n:], pix[n:])
+	}
+	widen16 = func(row []float32, pix []byte) {
+		n := len(row) &^ 7
+		widen16AVX2(row[:n], pix[:2*n])
+		widen16Generic(row[n:], pix[2*n:])
+	}
+	convolveRow = convolveRowAVX2
+	pack16 = func(pix []byte, acc []float32) {
+		n := len(acc) &^ 7
+		pack16AVX2(pix[:2*n], acc[:n])
+		pack16Generic(pix[2*n:], acc[n:])
+	}
+	accumulateRows = func(acc []float32, pix []byte, stride int, ws []float32) {
+		n := len(acc) &^ 15
+		accumulateRowsAVX2(acc[:n], pix, stride, ws)
+		accumulateRowsGeneric(acc[n:], pix[2*n:], stride, ws)
+	}
+	packPremul8 = func(pix []byte, acc []float32) {
+		n := len(acc) &^ 7
+		packPremul8AVX2(pix[:n], acc[:n])
+		packPremul8Generic(pix[n:], acc[n:])
+	}
+	packPremul16 = func(pix []byte, acc []float32) {
+		n := len(acc) &^ 7
+		packPremul16AVX2(pix[:2*n], acc[:n])
+		packPremul16Generic(pix[2*n:], acc[n:])
+	}
+}
+
+//go:noescape
+func widen8AVX2(row []float32, pix []byte)
+
+//go:noescape
+func widen16AVX2(row []float32, pix []byte)
+
+//go:noescape
+func convolveRowAVX2(acc, row []float32, start []int, weights []float32, taps int)
+
+//go:noescape
+func pack16AVX2(pix []byte, acc []float32)
+
+//go:noescape
+func accumulateRowsAVX2(acc []float32, pix []byte, stride int, ws []float32)
+
+//go:noescape
+func packPremul8AVX2(pix []byte, acc []float32)
+
+//go:noescape
+func packPremul16AVX2(pix []byte, acc []float32)
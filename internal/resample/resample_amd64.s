@@ -0,0 +1,316 @@
+//go:build gc && !purego
+// +build gc,!purego
+
+#include "textflag.h"
+
+// swap16 swaps the bytes of each 16-bit word in PSHUFB.
+DATA swap16<>+0x00(SB)/8, $0x0607040502030001
+DATA swap16<>+0x08(SB)/8, $0x0e0f0c0d0a0b0809
+DATA swap16<>+0x10(SB)/8, $0x0607040502030001
+DATA swap16<>+0x18(SB)/8, $0x0e0f0c0d0a0b0809
+GLOBL swap16<>(SB), RODATA|NOPTR, $32
+
+DATA f257<>+0x00(SB)/4, $0x43808000 // 257
+GLOBL f257<>(SB), RODATA|NOPTR, $4
+
+DATA fhalf<>+0x00(SB)/4, $0x3f000000 // 0.5
+GLOBL fhalf<>(SB), RODATA|NOPTR, $4
+
+DATA fmax16<>+0x00(SB)/4, $0x477fff00 // 65535
+GLOBL fmax16<>(SB), RODATA|NOPTR, $4
+
+// func widen8AVX2(row []float32, pix []byte)
+// len(pix) is a multiple of 8.
+TEXT ·widen8AVX2(SB), NOSPLIT, $0-48
+	MOVQ         row_base+0(FP), DI
+	MOVQ         pix_base+24(FP), SI
+	MOVQ         pix_len+32(FP), CX
+	VBROADCASTSS f257<>(SB), Y15
+	SHRQ         $3, CX
+	JZ           widen8done
+
+widen8loop:
+	VPMOVZXBD (SI), Y0
+	VCVTDQ2PS Y0, Y0
+	VMULPS    Y15, Y0, Y0
+	VMOVUPS   Y0, (DI)
+	ADDQ      $8, SI
+	ADDQ      $32, DI
+	DECQ      CX
+	JNZ       widen8loop
+
+widen8done:
+	VZEROUPPER
+	RET
+
+// func widen16AVX2(row []float32, pix []byte)
+// len(row) is a multiple of 8.
+TEXT ·widen16AVX2(SB), NOSPLIT, $0-48
+	MOVQ    row_base+0(FP), DI
+	MOVQ    row_len+8(FP), CX
+	MOVQ    pix_base+24(FP), SI
+	VMOVDQU swap16<>(SB), X15
+	SHRQ    $3, CX
+	JZ      widen16done
+
+widen16loop:
+	VMOVDQU   (SI), X0
+	VPSHUFB   X15, X0, X0
+	VPMOVZXWD X0, Y0
+	VCVTDQ2PS Y0, Y0
+	VMOVUPS   Y0, (DI)
+	ADDQ      $16, SI
+	ADDQ      $32, DI
+	DECQ      CX
+	JNZ       widen16loop
+
+widen16done:
+	VZEROUPPER
+	RET
+
+// func convolveRowAVX2(acc, row []float32, start []int, weights []float32, taps int)
+// Pixels are accumulated four at a time, one per XMM register, so that
+// the additions of the taps of one pixel overlap with those of the others.
+TEXT ·convolveRowAVX2(SB), NOSPLIT, $0-104
+	MOVQ acc_base+0(FP), DI
+	MOVQ row_base+24(FP), SI
+	MOVQ start_base+48(FP), BX
+	MOVQ start_len+56(FP), AX
+	MOVQ weights_base+72(FP), DX
+	MOVQ taps+96(FP), CX
+	MOVQ CX, R12
+	SHLQ $2, R12                 // bytes of weights per pixel
+	LEAQ (R12)(R12*2), R14
+	CMPQ AX, $4
+	JB   convsingle
+
+convquad:
+	MOVQ   0(BX), R8
+	SHLQ   $4, R8
+	ADDQ   SI, R8
+	MOVQ   8(BX), R9
+	SHLQ   $4, R9
+	ADDQ   SI, R9
+	MOVQ   16(BX), R10
+	SHLQ   $4, R10
+	ADDQ   SI, R10
+	MOVQ   24(BX), R11
+	SHLQ   $4, R11
+	ADDQ   SI, R11
+	VXORPS X0, X0, X0
+	VXORPS X1, X1, X1
+	VXORPS X2, X2, X2
+	VXORPS X3, X3, X3
+	MOVQ   DX, R15
+	MOVQ   CX, R13
+
+convquadtap:
+	VBROADCASTSS (R15), X4
+	VBROADCASTSS (R15)(R12*1), X5
+	VBROADCASTSS (R15)(R12*2), X6
+	VBROADCASTSS (R15)(R14*1), X7
+	VMULPS       (R8), X4, X4
+	VMULPS       (R9), X5, X5
+	VMULPS       (R10), X6, X6
+	VMULPS       (R11), X7, X7
+	VADDPS       X4, X0, X0
+	VADDPS       X5, X1, X1
+	VADDPS       X6, X2, X2
+	VADDPS       X7, X3, X3
+	ADDQ         $16, R8
+	ADDQ         $16, R9
+	ADDQ         $16, R10
+	ADDQ         $16, R11
+	ADDQ         $4, R15
+	DECQ         R13
+	JNZ          convquadtap
+
+	VMOVUPS X0, 0(DI)
+	VMOVUPS X1, 16(DI)
+	VMOVUPS X2, 32(DI)
+	VMOVUPS X3, 48(DI)
+	ADDQ    $64, DI
+	ADDQ    $32, BX
+	LEAQ    (DX)(R12*4), DX
+	SUBQ    $4, AX
+	CMPQ    AX, $4
+	JAE     convquad
+
+convsingle:
+	TESTQ  AX, AX
+	JZ     convdone
+	MOVQ   (BX), R8
+	SHLQ   $4, R8
+	ADDQ   SI, R8
+	VXORPS X0, X0, X0
+	MOVQ   CX, R13
+
+convsingletap:
+	VBROADCASTSS (DX), X4
+	VMULPS       (R8), X4, X4
+	VADDPS       X4, X0, X0
+	ADDQ         $16, R8
+	ADDQ         $4, DX
+	DECQ         R13
+	JNZ          convsingletap
+
+	VMOVUPS X0, (DI)
+	ADDQ    $16, DI
+	ADDQ    $8, BX
+	DECQ    AX
+	JMP     convsingle
+
+convdone:
+	VZEROUPPER
+	RET
+
+// func pack16AVX2(pix []byte, acc []float32)
+// len(acc) is a multiple of 8.
+TEXT ·pack16AVX2(SB), NOSPLIT, $0-48
+	MOVQ         pix_base+0(FP), DI
+	MOVQ         acc_base+24(FP), SI
+	MOVQ         acc_len+32(FP), CX
+	VXORPS       Y15, Y15, Y15
+	VBROADCASTSS fhalf<>(SB), Y14
+	VBROADCASTSS fmax16<>(SB), Y13
+	VMOVDQU      swap16<>(SB), Y12
+	SHRQ         $3, CX
+	JZ           pack16done
+
+pack16loop:
+	VMOVUPS    (SI), Y0
+	VMAXPS     Y15, Y0, Y0
+	VADDPS     Y14, Y0, Y0
+	VMINPS     Y13, Y0, Y0
+	VCVTTPS2DQ Y0, Y0
+	VPACKUSDW  Y0, Y0, Y0
+	VPSHUFB    Y12, Y0, Y0
+	VPERMQ     $0x08, Y0, Y0
+	VMOVDQU    X0, (DI)
+	ADDQ       $32, SI
+	ADDQ       $16, DI
+	DECQ       CX
+	JNZ        pack16loop
+
+pack16done:
+	VZEROUPPER
+	RET
+
+// func accumulateRowsAVX2(acc []float32, pix []byte, stride int, ws []float32)
+// len(acc) is a multiple of 16.
+TEXT ·accumulateRowsAVX2(SB), NOSPLIT, $0-80
+	MOVQ    acc_base+0(FP), DI
+	MOVQ    acc_len+8(FP), AX
+	MOVQ    pix_base+24(FP), SI
+	MOVQ    stride+48(FP), R9
+	MOVQ    ws_base+56(FP), DX
+	MOVQ    ws_len+64(FP), CX
+	VMOVDQU swap16<>(SB), X15
+	SHRQ    $4, AX
+	JZ      accdone
+
+accblock:
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	MOVQ   SI, R8
+	MOVQ   DX, R10
+	MOVQ   CX, R11
+
+acctap:
+	VBROADCASTSS (R10), Y2
+	VMOVDQU      (R8), X3
+	VMOVDQU      16(R8), X4
+	VPSHUFB      X15, X3, X3
+	VPSHUFB      X15, X4, X4
+	VPMOVZXWD    X3, Y3
+	VPMOVZXWD    X4, Y4
+	VCVTDQ2PS    Y3, Y3
+	VCVTDQ2PS    Y4, Y4
+	VMULPS       Y2, Y3, Y3
+	VMULPS       Y2, Y4, Y4
+	VADDPS       Y3, Y0, Y0
+	VADDPS       Y4, Y1, Y1
+	ADDQ         R9, R8
+	ADDQ         $4, R10
+	DECQ         R11
+	JNZ          acctap
+
+	VMOVUPS Y0, (DI)
+	VMOVUPS Y1, 32(DI)
+	ADDQ    $64, DI
+	ADDQ    $32, SI
+	DECQ    AX
+	JNZ     accblock
+
+accdone:
+	VZEROUPPER
+	RET
+
+// func packPremul8AVX2(pix []byte, acc []float32)
+// len(acc) is a multiple of 8.
+TEXT ·packPremul8AVX2(SB), NOSPLIT, $0-48
+	MOVQ         pix_base+0(FP), DI
+	MOVQ         acc_base+24(FP), SI
+	MOVQ         acc_len+32(FP), CX
+	VXORPS       Y15, Y15, Y15
+	VBROADCASTSS fhalf<>(SB), Y14
+	VBROADCASTSS fmax16<>(SB), Y13
+	SHRQ         $3, CX
+	JZ           premul8done
+
+premul8loop:
+	VMOVUPS      (SI), Y0
+	VMAXPS       Y15, Y0, Y0
+	VADDPS       Y14, Y0, Y0
+	VMINPS       Y13, Y0, Y0
+	VCVTTPS2DQ   Y0, Y0
+	VPSHUFD      $0xff, Y0, Y1 // alpha of each pixel
+	VPMINUD      Y1, Y0, Y0
+	VPSRLD       $8, Y0, Y0
+	VPACKUSDW    Y0, Y0, Y0
+	VPACKUSWB    Y0, Y0, Y0
+	VEXTRACTI128 $1, Y0, X1
+	VMOVD        X0, 0(DI)
+	VMOVD        X1, 4(DI)
+	ADDQ         $32, SI
+	ADDQ         $8, DI
+	DECQ         CX
+	JNZ          premul8loop
+
+premul8done:
+	VZEROUPPER
+	RET
+
+// func packPremul16AVX2(pix []byte, acc []float32)
+// len(acc) is a multiple of 8.
+TEXT ·packPremul16AVX2(SB), NOSPLIT, $0-48
+	MOVQ         pix_base+0(FP), DI
+	MOVQ         acc_base+24(FP), SI
+	MOVQ         acc_len+32(FP), CX
+	VXORPS       Y15, Y15, Y15
+	VBROADCASTSS fhalf<>(SB), Y14
+	VBROADCASTSS fmax16<>(SB), Y13
+	VMOVDQU      swap16<>(SB), Y12
+	SHRQ         $3, CX
+	JZ           premul16done
+
+premul16loop:
+	VMOVUPS    (SI), Y0
+	VMAXPS     Y15, Y0, Y0
+	VADDPS     Y14, Y0, Y0
+	VMINPS     Y13, Y0, Y0
+	VCVTTPS2DQ Y0, Y0
+	VPSHUFD    $0xff, Y0, Y1
+	VPMINUD    Y1, Y0, Y0
+	VPACKUSDW  Y0, Y0, Y0
+	VPSHUFB    Y12, Y0, Y0
+	VPERMQ     $0x08, Y0, Y0
+	VMOVDQU    X0, (DI)
+	ADDQ       $32, SI
+	ADDQ       $16, DI
+	DECQ       CX
+	JNZ        premul16loop
+
+premul16done:
+	VZEROUPPER
+	RET
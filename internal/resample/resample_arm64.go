@@ -0,0 +1,24 @@
+//go:build gc && !purego
+// +build gc,!purego
+
+package resample
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	if !cpu.ARM64.HasASIMD {
+		return
+	}
+	convolveRow = convolveRowNEON
+	accumulateRows = func(acc []float32, pix []byte, stride int, ws []float32) {
+		n := len(acc) &^ 7
+		accumulateRowsNEON(acc[:n], pix, stride, ws)
+		accumulateRowsGeneric(acc[n:], pix[2*n:], stride, ws)
+	}
+}
+
+//go:noescape
+func convolveRowNEON(acc, row []float32, start []int, weights []float32, taps int)
+
+//go:noescape
+func accumulateRowsNEON(acc []float32, pix []byte, stride int, ws []float32)
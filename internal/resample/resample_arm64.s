@@ -0,0 +1,108 @@
+//go:build gc && !purego
+// +build gc,!purego
+
+#include "textflag.h"
+
+// The vector floating-point instructions are spelled as WORDs, which the
+// assembler of older Go releases has no mnemonics for.
+
+// func convolveRowNEON(acc, row []float32, start []int, weights []float32, taps int)
+// Pixels are accumulated two at a time, so that the multiply-adds of the
+// taps of one pixel overlap with those of the other.
+TEXT ·convolveRowNEON(SB), NOSPLIT, $0-104
+	MOVD acc_base+0(FP), R0
+	MOVD row_base+24(FP), R1
+	MOVD start_base+48(FP), R2
+	MOVD start_len+56(FP), R3
+	MOVD weights_base+72(FP), R4
+	MOVD taps+96(FP), R5
+	LSL  $2, R5, R10             // bytes of weights per pixel
+	CMP  $2, R3
+	BLT  convsingle
+
+convpair:
+	MOVD.P 8(R2), R6
+	ADD    R6<<4, R1, R6
+	MOVD.P 8(R2), R7
+	ADD    R7<<4, R1, R7
+	ADD    R10, R4, R9
+	VEOR   V0.B16, V0.B16, V0.B16
+	VEOR   V3.B16, V3.B16, V3.B16
+	MOVD   R5, R8
+
+convpairtap:
+	VLD1R.P 4(R4), [V1.S4]
+	VLD1R.P 4(R9), [V4.S4]
+	VLD1.P  16(R6), [V2.S4]
+	VLD1.P  16(R7), [V5.S4]
+	WORD    $0x4e21cc40          // FMLA V0.4S, V2.4S, V1.4S
+	WORD    $0x4e24cca3          // FMLA V3.4S, V5.4S, V4.4S
+	SUB     $1, R8
+	CBNZ    R8, convpairtap
+
+	VST1.P [V0.S4], 16(R0)
+	VST1.P [V3.S4], 16(R0)
+	MOVD   R9, R4
+	SUB    $2, R3
+	CMP    $2, R3
+	BGE    convpair
+
+convsingle:
+	CBZ    R3, convdone
+	MOVD   (R2), R6
+	ADD    R6<<4, R1, R6
+	VEOR   V0.B16, V0.B16, V0.B16
+	MOVD   R5, R8
+
+convsingletap:
+	VLD1R.P 4(R4), [V1.S4]
+	VLD1.P  16(R6), [V2.S4]
+	WORD    $0x4e21cc40          // FMLA V0.4S, V2.4S, V1.4S
+	SUB     $1, R8
+	CBNZ    R8, convsingletap
+
+	VST1 [V0.S4], (R0)
+
+convdone:
+	RET
+
+// func accumulateRowsNEON(acc []float32, pix []byte, stride int, ws []float32)
+// len(acc) is a multiple of 8.
+TEXT ·accumulateRowsNEON(SB), NOSPLIT, $0-80
+	MOVD acc_base+0(FP), R0
+	MOVD acc_len+8(FP), R2
+	MOVD pix_base+24(FP), R1
+	MOVD stride+48(FP), R3
+	MOVD ws_base+56(FP), R4
+	MOVD ws_len+64(FP), R5
+	LSR  $3, R2
+	CBZ  R2, accdone
+
+accblock:
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	MOVD R1, R6
+	MOVD R4, R7
+	MOVD R5, R8
+
+acctap:
+	VLD1R.P 4(R7), [V4.S4]
+	VLD1    (R6), [V2.B16]
+	ADD     R3, R6
+	WORD    $0x4e201842          // REV16 V2.16B, V2.16B
+	WORD    $0x2f10a443          // UXTL V3.4S, V2.4H
+	WORD    $0x6f10a445          // UXTL2 V5.4S, V2.8H
+	WORD    $0x6e21d863          // UCVTF V3.4S, V3.4S
+	WORD    $0x6e21d8a5          // UCVTF V5.4S, V5.4S
+	WORD    $0x4e24cc60          // FMLA V0.4S, V3.4S, V4.4S
+	WORD    $0x4e24cca1          // FMLA V1.4S, V5.4S, V4.4S
+	SUB     $1, R8
+	CBNZ    R8, acctap
+
+	VST1.P [V0.S4, V1.S4], 32(R0)
+	ADD    $16, R1
+	SUB    $1, R2
+	CBNZ   R2, accblock
+
+accdone:
+	RET
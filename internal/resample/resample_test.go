@@ -0,0 +1,136 @@
+package resample
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// lengths covers empty rows, rows shorter than a vector, and rows with
+// every remainder after the blocks of 8 and 16 channels the assembly takes.
+var lengths = []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 15, 16, 17, 31, 33, 64, 100, 257}
+
+func randomAcc(rng *rand.Rand, n int) []float32 {
+	acc := make([]float32, n)
+	for i := range acc {
+		switch rng.Intn(8) {
+		case 0:
+			acc[i] = -rng.Float32() * 1000
+		case 1:
+			acc[i] = 0xffff + rng.Float32()*1000
+		case 2:
+			// Ties and near ties of the rounding.
+			acc[i] = float32(rng.Intn(0x10000)) + 0.5
+		default:
+			acc[i] = rng.Float32() * 0xffff
+		}
+	}
+	return acc
+}
+
+func equalFloats(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Float32bits(a[i]) != math.Float32bits(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWiden(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range lengths {
+		pix := make([]byte, 2*n)
+		rng.Read(pix)
+		got, want := make([]float32, n), make([]float32, n)
+		Widen8(got, pix[:n])
+		widen8Generic(want, pix[:n])
+		if !equalFloats(got, want) {
+			t.Errorf("Widen8 of %d channels = %v, want %v", n, got, want)
+		}
+		Widen16(got, pix)
+		widen16Generic(want, pix)
+		if !equalFloats(got, want) {
+			t.Errorf("Widen16 of %d channels = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, px := range lengths {
+		acc := randomAcc(rng, 4*px)
+		for _, c := range []struct {
+			name      string
+			size      int
+			got, want func(pix []byte, acc []float32)
+		}{
+			{"Pack16", 8, Pack16, pack16Generic},
+			{"PackPremul8", 4, PackPremul8, packPremul8Generic},
+			{"PackPremul16", 8, PackPremul16, packPremul16Generic},
+		} {
+			got, want := make([]byte, c.size*px), make([]byte, c.size*px)
+			c.got(got, acc)
+			c.want(want, acc)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s of %d pixels = %v, want %v", c.name, px, got, want)
+			}
+		}
+	}
+}
+
+func TestConvolveRow(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, dw := range lengths {
+		for _, taps := range []int{1, 2, 3, 7, 13} {
+			sw := taps + rng.Intn(3*dw+1)
+			row := make([]float32, 4*sw)
+			for i := range row {
+				row[i] = float32(rng.Intn(0x10000))
+			}
+			start := make([]int, dw)
+			weights := make([]float32, dw*taps)
+			for x := range start {
+				start[x] = rng.Intn(sw - taps + 1)
+			}
+			for i := range weights {
+				weights[i] = rng.Float32()*1.5 - 0.25
+			}
+			got, want := make([]float32, 4*dw), make([]float32, 4*dw)
+			ConvolveRow(got, row, start, weights, taps)
+			convolveRowGeneric(want, row, start, weights, taps)
+			if !equalFloats(got, want) {
+				t.Errorf("ConvolveRow of %d pixels with %d taps = %v, want %v", dw, taps, got, want)
+			}
+		}
+	}
+}
+
+func TestAccumulateRows(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, px := range lengths {
+		for _, taps := range []int{1, 2, 5, 12} {
+			n := 4 * px
+			stride := 2*n + 2*rng.Intn(8)
+			pix := make([]byte, stride*taps)
+			rng.Read(pix)
+			ws := make([]float32, taps)
+			for i := range ws {
+				ws[i] = rng.Float32()*1.5 - 0.25
+			}
+			if taps > 2 {
+				ws[0] = 0 // padding taps are zero
+			}
+			got, want := randomAcc(rng, n), make([]float32, n)
+			AccumulateRows(got, pix, stride, ws)
+			accumulateRowsGeneric(want, pix, stride, ws)
+			if !equalFloats(got, want) {
+				t.Errorf("AccumulateRows of %d pixels with %d taps = %v, want %v", px, taps, got, want)
+			}
+		}
+	}
+}
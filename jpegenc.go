@@ -0,0 +1,634 @@
+package thumbnail
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// maxJPEGDimension is the largest width or height of a JPEG image.
+const maxJPEGDimension = 65535
+
+// Subsampling selects the resolution at which JPEG output stores color
+// information relative to brightness.
+type Subsampling int
+
+const (
+	// Subsample420 halves the chroma resolution in both directions, as
+	// image/jpeg does. It is the default.
+	Subsample420 Subsampling = iota
+	// Subsample422 halves the chroma resolution horizontally only.
+	Subsample422
+	// Subsample444 keeps chroma at full resolution, so that small colored
+	// detail such as red text stays sharp, at the cost of larger files.
+	Subsample444
+)
+
+// valid reports whether s is a known subsampling.
+func (s Subsampling) valid() bool {
+	return s >= Subsample420 && s <= Subsample444
+}
+
+// factors returns the luma sampling factors for s.
+func (s Subsampling) factors() (h, v int) {
+	switch s {
+	case Subsample422:
+		return 2, 1
+	case Subsample444:
+		return 1, 1
+	}
+	return 2, 2
+}
+
+// SaveJPEGOptions writes img to w as JPEG at opts.Quality, which defaults
+// to 85 like SaveJPEG. With opts.Progressive the image is stored in
+// several scans, coarse detail first, so that browsers can show a preview
+// of it before it has fully loaded. Color is stored at the resolution
+// opts.Subsampling selects, and opts.Metadata is written in APP segments.
+// Huffman tables are optimized for the image, which keeps progressive
+// files as small as baseline ones. Transparency is flattened onto
+// opts.Matte.
+func SaveJPEGOptions(img image.Image, w io.Writer, opts Options) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return ErrEmptyImage
+	}
+	if b.Dx() > maxJPEGDimension || b.Dy() > maxJPEGDimension {
+		return ErrImageTooLarge
+	}
+	img = flatten(img, opts.Matte)
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 85
+	}
+
+	h, v := opts.Subsampling.factors()
+	e := newJPEGEncoder(img, quality, h, v)
+	bw := bufio.NewWriter(w)
+	e.w = bw
+	e.writeHeaders(opts.Progressive, opts.Metadata.strip(opts.StripMetadata))
+	if opts.Progressive {
+		for _, s := range e.progressiveScans() {
+			e.writeScan(s)
+		}
+	} else {
+		all := make([]int, len(e.comps))
+		for i := range all {
+			all[i] = i
+		}
+		e.writeScan(jpegScan{comps: all, ss: 0, se: 63})
+	}
+	e.marker(0xd9, nil)
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// jpegZigzag maps the zig-zag position of a coefficient to its index in
+// natural (row-major) order.
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegBaseQuant holds the example luminance and chrominance quantization
+// tables of the JPEG specification (Annex K), in natural order. They are
+// scaled by quality as image/jpeg scales them.
+var jpegBaseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegDCTCos holds the cosines of the 8-point DCT-II, scaled so that the
+// transform is orthonormal.
+var jpegDCTCos = func() (c [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		s := math.Sqrt(2.0 / 8)
+		if u == 0 {
+			s = math.Sqrt(1.0 / 8)
+		}
+		for x := 0; x < 8; x++ {
+			c[u][x] = s * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return c
+}()
+
+// jpegComponent is a color component of the image being encoded, holding
+// its quantized DCT coefficients in natural order.
+type jpegComponent struct {
+	id     byte
+	h, v   int // sampling factors
+	tq     int // quantization and Huffman table index: 0 luma, 1 chroma
+	bw, bh int // blocks per row and column, padded to whole MCUs
+	cw, ch int // blocks that hold image data, used by single-component scans
+	blocks [][64]int32
+	pred   int32 // DC predictor
+}
+
+// jpegScan selects the components and spectral band a scan codes.
+type jpegScan struct {
+	comps  []int
+	ss, se int
+}
+
+// jpegSymbol is a Huffman-coded symbol followed by extra bits.
+type jpegSymbol struct {
+	table int // 0-1 DC, 2-3 AC
+	sym   uint8
+	bits  uint16
+	nbits uint8
+}
+
+type jpegEncoder struct {
+	w          *bufio.Writer
+	err        error
+	width      int
+	height     int
+	mcuX, mcuY int
+	comps      []*jpegComponent
+	quant      [2][64]int
+	syms       []jpegSymbol
+	acc        uint32
+	nacc       uint
+
+	// eobRun counts the blocks of a progressive AC scan that end in zeros
+	// and have not been coded yet.
+	eobRun   int
+	eobTable int
+}
+
+// newJPEGEncoder converts img to quantized coefficients. Color images
+// sample luma at h×v times the chroma resolution.
+func newJPEGEncoder(img image.Image, quality, h, v int) *jpegEncoder {
+	b := img.Bounds()
+	e := &jpegEncoder{width: b.Dx(), height: b.Dy()}
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for t := range e.quant {
+		for i, q := range jpegBaseQuant[t] {
+			e.quant[t][i] = minInt(maxInt((q*scale+50)/100, 1), 255)
+		}
+	}
+
+	_, gray := img.(*image.Gray)
+	if gray {
+		h, v = 1, 1
+		e.comps = []*jpegComponent{{id: 1, h: 1, v: 1}}
+	} else {
+		e.comps = []*jpegComponent{{id: 1, h: h, v: v}, {id: 2, h: 1, v: 1, tq: 1}, {id: 3, h: 1, v: 1, tq: 1}}
+	}
+	e.mcuX = (e.width + 8*h - 1) / (8 * h)
+	e.mcuY = (e.height + 8*v - 1) / (8 * v)
+
+	// Full-resolution planes covering whole MCUs, with the last row and
+	// column repeated into the padding.
+	pw, ph := e.mcuX*8*h, e.mcuY*8*v
+	planes := make([][]float32, len(e.comps))
+	for i := range planes {
+		planes[i] = make([]float32, pw*ph)
+	}
+	if g, ok := img.(*image.Gray); ok {
+		for y := 0; y < ph; y++ {
+			row := g.Pix[g.PixOffset(b.Min.X, b.Min.Y+minInt(y, e.height-1)):]
+			for x := 0; x < pw; x++ {
+				planes[0][y*pw+x] = float32(row[minInt(x, e.width-1)])
+			}
+		}
+	} else {
+		// Like image/jpeg, transparent pixels are encoded as black.
+		m := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+		draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+		for y := 0; y < ph; y++ {
+			row := m.Pix[minInt(y, e.height-1)*m.Stride:]
+			for x := 0; x < pw; x++ {
+				p := row[4*minInt(x, e.width-1):]
+				r, g, b := float32(p[0]), float32(p[1]), float32(p[2])
+				i := y*pw + x
+				planes[0][i] = 0.299*r + 0.587*g + 0.114*b
+				planes[1][i] = -0.168736*r - 0.331264*g + 0.5*b + 128
+				planes[2][i] = 0.5*r - 0.418688*g - 0.081312*b + 128
+			}
+		}
+	}
+
+	for ci, c := range e.comps {
+		sx, sy := h/c.h, v/c.v
+		c.bw, c.bh = e.mcuX*c.h, e.mcuY*c.v
+		cwPix := (e.width*c.h + h - 1) / h
+		chPix := (e.height*c.v + v - 1) / v
+		c.cw, c.ch = (cwPix+7)/8, (chPix+7)/8
+		c.blocks = make([][64]int32, c.bw*c.bh)
+		var px [64]float64
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						// Average the sx×sy full-resolution samples a
+						// subsampled one covers.
+						var s float32
+						for dy := 0; dy < sy; dy++ {
+							off := ((by*8+y)*sy+dy)*pw + (bx*8+x)*sx
+							for dx := 0; dx < sx; dx++ {
+								s += planes[ci][off+dx]
+							}
+						}
+						px[y*8+x] = float64(s)/float64(sx*sy) - 128
+					}
+				}
+				fdct8x8(&px)
+				blk := &c.blocks[by*c.bw+bx]
+				q := &e.quant[c.tq]
+				for i, f := range px {
+					blk[i] = int32(math.Round(f / float64(q[i])))
+				}
+			}
+		}
+	}
+	return e
+}
+
+// fdct8x8 replaces the samples of a block with their DCT coefficients.
+func fdct8x8(b *[64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < 8; x++ {
+				s += jpegDCTCos[u][x] * b[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var s float64
+			for y := 0; y < 8; y++ {
+				s += jpegDCTCos[v][y] * tmp[y*8+u]
+			}
+			b[v*8+u] = s
+		}
+	}
+}
+
+// progressiveScans returns the scan script for progressive output: the DC
+// coefficients of all components, then the lowest luma frequencies, the
+// chroma, and the remaining luma detail.
+func (e *jpegEncoder) progressiveScans() []jpegScan {
+	if len(e.comps) == 1 {
+		return []jpegScan{{[]int{0}, 0, 0}, {[]int{0}, 1, 5}, {[]int{0}, 6, 63}}
+	}
+	return []jpegScan{
+		{[]int{0, 1, 2}, 0, 0},
+		{[]int{0}, 1, 5},
+		{[]int{1}, 1, 63},
+		{[]int{2}, 1, 63},
+		{[]int{0}, 6, 63},
+	}
+}
+
+func (e *jpegEncoder) marker(m byte, data []byte) {
+	if e.err != nil {
+		return
+	}
+	hdr := []byte{0xff, m}
+	if m != 0xd8 && m != 0xd9 {
+		hdr = append(hdr, byte((len(data)+2)>>8), byte(len(data)+2))
+	}
+	if _, e.err = e.w.Write(hdr); e.err == nil {
+		_, e.err = e.w.Write(data)
+	}
+}
+
+// writeHeaders writes the markers that precede the first scan.
+func (e *jpegEncoder) writeHeaders(progressive bool, md *Metadata) {
+	e.marker(0xd8, nil)
+	for _, s := range md.jpegSegments() {
+		e.marker(s.marker, s.payload)
+	}
+	for t := 0; t < 2 && t < len(e.comps); t++ {
+		dqt := []byte{byte(t)}
+		for k := 0; k < 64; k++ {
+			dqt = append(dqt, byte(e.quant[t][jpegZigzag[k]]))
+		}
+		e.marker(0xdb, dqt)
+	}
+	sof := []byte{8, byte(e.height >> 8), byte(e.height), byte(e.width >> 8), byte(e.width), byte(len(e.comps))}
+	for _, c := range e.comps {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), byte(c.tq))
+	}
+	m := byte(0xc0) // baseline
+	if progressive {
+		m = 0xc2
+	}
+	e.marker(m, sof)
+}
+
+// writeScan codes a scan with Huffman tables built for it.
+func (e *jpegEncoder) writeScan(s jpegScan) {
+	e.syms = e.syms[:0]
+	for _, ci := range s.comps {
+		e.comps[ci].pred = 0
+	}
+	if len(s.comps) == 1 {
+		// Single-component scans cover only the blocks holding image data.
+		c := e.comps[s.comps[0]]
+		for by := 0; by < c.ch; by++ {
+			for bx := 0; bx < c.cw; bx++ {
+				e.codeBlock(c, &c.blocks[by*c.bw+bx], s)
+			}
+		}
+	} else {
+		for my := 0; my < e.mcuY; my++ {
+			for mx := 0; mx < e.mcuX; mx++ {
+				for _, ci := range s.comps {
+					c := e.comps[ci]
+					for y := 0; y < c.v; y++ {
+						for x := 0; x < c.h; x++ {
+							e.codeBlock(c, &c.blocks[(my*c.v+y)*c.bw+mx*c.h+x], s)
+						}
+					}
+				}
+			}
+		}
+	}
+	e.flushEOBRun()
+
+	var freq [4][257]int
+	for _, sym := range e.syms {
+		freq[sym.table][sym.sym]++
+	}
+	var codes [4]*jpegHuffman
+	var dht []byte
+	for t := range freq {
+		used := false
+		for _, n := range freq[t][:256] {
+			used = used || n > 0
+		}
+		if !used {
+			continue
+		}
+		codes[t] = newJPEGHuffman(&freq[t])
+		dht = append(dht, byte(t/2<<4|t%2))
+		dht = append(dht, codes[t].counts[1:]...)
+		dht = append(dht, codes[t].values...)
+	}
+	e.marker(0xc4, dht)
+
+	sos := []byte{byte(len(s.comps))}
+	for _, ci := range s.comps {
+		c := e.comps[ci]
+		sos = append(sos, c.id, byte(c.tq<<4|c.tq))
+	}
+	sos = append(sos, byte(s.ss), byte(s.se), 0)
+	e.marker(0xda, sos)
+
+	for _, sym := range e.syms {
+		h := codes[sym.table]
+		e.emit(uint32(h.code[sym.sym]), uint(h.size[sym.sym]))
+		if sym.nbits > 0 {
+			e.emit(uint32(sym.bits), uint(sym.nbits))
+		}
+	}
+	// Pad the last byte with ones.
+	e.emit(0x7f, 7)
+	e.nacc = 0
+	e.acc = 0
+}
+
+// codeBlock appends the symbols coding band s of blk.
+func (e *jpegEncoder) codeBlock(c *jpegComponent, blk *[64]int32, s jpegScan) {
+	dc, ac := c.tq, 2+c.tq
+	if s.ss == 0 {
+		d := blk[0] - c.pred
+		c.pred = blk[0]
+		n, bits := jpegMagnitude(d)
+		e.syms = append(e.syms, jpegSymbol{dc, n, bits, n})
+		if s.se == 0 {
+			return
+		}
+	}
+	ss := s.ss
+	if ss == 0 {
+		ss = 1
+	}
+	progressive := s.ss > 0
+	run := 0
+	for k := ss; k <= s.se; k++ {
+		v := blk[jpegZigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		if progressive {
+			e.flushEOBRun()
+		}
+		for run > 15 {
+			e.syms = append(e.syms, jpegSymbol{table: ac, sym: 0xf0})
+			run -= 16
+		}
+		n, bits := jpegMagnitude(v)
+		e.syms = append(e.syms, jpegSymbol{ac, uint8(run<<4) | n, bits, n})
+		run = 0
+	}
+	if run > 0 {
+		if !progressive {
+			e.syms = append(e.syms, jpegSymbol{table: ac}) // EOB
+			return
+		}
+		e.eobTable = ac
+		if e.eobRun++; e.eobRun == 0x7fff {
+			e.flushEOBRun()
+		}
+	}
+}
+
+// flushEOBRun codes the pending EOB run, if any.
+func (e *jpegEncoder) flushEOBRun() {
+	if e.eobRun == 0 {
+		return
+	}
+	n := uint8(0)
+	for e.eobRun>>(n+1) > 0 {
+		n++
+	}
+	e.syms = append(e.syms, jpegSymbol{e.eobTable, n << 4, uint16(e.eobRun) & (1<<n - 1), n})
+	e.eobRun = 0
+}
+
+// jpegMagnitude returns the magnitude category of v and the bits that
+// code its value within the category.
+func jpegMagnitude(v int32) (uint8, uint16) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	n := uint8(0)
+	for a > 0 {
+		n++
+		a >>= 1
+	}
+	return n, uint16(v) & (1<<n - 1)
+}
+
+// emit writes the low n bits of v, stuffing a zero byte after each 0xFF.
+func (e *jpegEncoder) emit(v uint32, n uint) {
+	e.acc = e.acc<<n | v&(1<<n-1)
+	e.nacc += n
+	for e.nacc >= 8 {
+		e.nacc -= 8
+		b := byte(e.acc >> e.nacc)
+		if e.err == nil {
+			e.err = e.w.WriteByte(b)
+		}
+		if b == 0xff && e.err == nil {
+			e.err = e.w.WriteByte(0)
+		}
+	}
+}
+
+// jpegHuffman is a Huffman table in the form stored in a DHT segment,
+// with the code of each symbol.
+type jpegHuffman struct {
+	counts [17]byte // number of codes of each length, from 1
+	values []byte   // symbols in order of code length
+	code   [256]uint16
+	size   [256]uint8
+}
+
+// newJPEGHuffman builds the optimal table, with codes of at most 16 bits
+// and no code of all ones, for symbol frequencies freq, as in Annex K.2 of
+// the JPEG specification. freq is modified.
+func newJPEGHuffman(freq *[257]int) *jpegHuffman {
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	freq[256] = 1 // reserves the all-ones code
+	for {
+		c1, c2 := -1, -1
+		for i, f := range freq {
+			if f > 0 && (c1 < 0 || f <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i, f := range freq {
+			if f > 0 && i != c1 && (c2 < 0 || f <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, n := range codesize {
+		if n > 0 {
+			bits[n]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]-- // drop the reserved code
+
+	h := new(jpegHuffman)
+	for n := 1; n <= 16; n++ {
+		h.counts[n] = byte(bits[n])
+	}
+	for n := 1; n <= 32; n++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == n {
+				h.values = append(h.values, byte(s))
+			}
+		}
+	}
+	code, k := uint16(0), 0
+	for n := 1; n <= 16; n++ {
+		for j := 0; j < int(h.counts[n]); j++ {
+			s := h.values[k]
+			h.code[s], h.size[s] = code, uint8(n)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// flatten composites img onto an opaque matte color, white when matte is
+// nil, if it has any transparency. Opaque images are returned unchanged.
+func flatten(img image.Image, matte color.Color) image.Image {
+	if !hasTransparency(img) {
+		return img
+	}
+	if matte == nil {
+		matte = color.White
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b, image.NewUniform(matte), image.Point{}, draw.Over)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,68 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// roundTripJPEG encodes src with opts, checks that it decodes to an image
+// of its size within min dB of it, and returns the encoded bytes and the
+// decoded image.
+func roundTripJPEG(t *testing.T, src image.Image, opts Options, min float64) ([]byte, image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := SaveJPEGOptions(src, &buf, opts); err != nil {
+		t.Fatalf("%+v: %v", opts, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%+v: decoding: %v", opts, err)
+	}
+	if img.Bounds() != src.Bounds() {
+		t.Fatalf("%+v: decoded bounds %v, want %v", opts, img.Bounds(), src.Bounds())
+	}
+	if p := psnr(t, img, src); p < min {
+		t.Errorf("%+v: PSNR %.1f dB, want at least %.0f", opts, p, min)
+	}
+	return buf.Bytes(), img
+}
+
+func TestSaveJPEGProgressive(t *testing.T) {
+	src := testImage(203, 141, false)
+	for _, c := range []struct {
+		quality int
+		min     float64
+	}{{90, 30}, {40, 26}} {
+		for _, progressive := range []bool{false, true} {
+			data, _ := roundTripJPEG(t, src, Options{Quality: c.quality, Progressive: progressive}, c.min)
+			// Progressive frames start with SOF2, baseline ones with SOF0.
+			if sof2 := bytes.Contains(data, []byte{0xff, 0xc2}); sof2 != progressive {
+				t.Errorf("quality %d, progressive %v: SOF2 written %v", c.quality, progressive, sof2)
+			}
+		}
+	}
+}
+
+func TestSaveJPEGSubsampling(t *testing.T) {
+	src := testImage(203, 141, false)
+	for _, c := range []struct {
+		opts  Options
+		ratio image.YCbCrSubsampleRatio
+	}{
+		{Options{Quality: 90}, image.YCbCrSubsampleRatio420},
+		{Options{Quality: 90, Subsampling: Subsample422}, image.YCbCrSubsampleRatio422},
+		{Options{Quality: 90, Subsampling: Subsample444}, image.YCbCrSubsampleRatio444},
+		{Options{Quality: 90, Subsampling: Subsample444, Progressive: true}, image.YCbCrSubsampleRatio444},
+	} {
+		_, img := roundTripJPEG(t, src, c.opts, 30)
+		m, ok := img.(*image.YCbCr)
+		if !ok {
+			t.Fatalf("%+v: decoded as %T", c.opts, img)
+		}
+		if m.SubsampleRatio != c.ratio {
+			t.Errorf("%+v: subsample ratio %v, want %v", c.opts, m.SubsampleRatio, c.ratio)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+//go:build libheif && cgo
+// +build libheif,cgo
+
+package thumbnail
+
+// Building with the libheif tag links libheif (1.13 or later) to read HEIF
+// (including HEIC photos from phones) and to read and write AVIF. libheif
+// must have been built with the matching decoders (libde265 for HEIC, dav1d
+// or libaom for AVIF) and, for AVIF output, an AV1 encoder.
+
+/*
+#cgo pkg-config: libheif
+#include <stdlib.h>
+#include <string.h>
+#include <libheif/heif.h>
+
+typedef struct {
+	unsigned char *data;
+	size_t len;
+} heifBuffer;
+
+static struct heif_error heifBufferWrite(struct heif_context *ctx, const void *data, size_t size, void *userdata) {
+	heifBuffer *b = userdata;
+	struct heif_error err = { heif_error_Ok, heif_suberror_Unspecified, "" };
+	unsigned char *p = realloc(b->data, b->len + size);
+	if (p == NULL) {
+		err.code = heif_error_Memory_allocation_error;
+		err.message = "out of memory";
+		return err;
+	}
+	memcpy(p + b->len, data, size);
+	b->data = p;
+	b->len += size;
+	return err;
+}
+
+static struct heif_error heifWrite(struct heif_context *ctx, heifBuffer *b) {
+	struct heif_writer w = { 1, heifBufferWrite };
+	return heif_context_write(ctx, &w, b);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"unsafe"
+)
+
+// heifBrands are the major brands of HEIF files other than AVIF.
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs", "mif1", "msf1"}
+
+func init() {
+	C.heif_init(nil)
+	for _, brand := range heifBrands {
+		image.RegisterFormat("heif", "????ftyp"+brand, decodeHEIF, decodeHEIFConfig)
+	}
+	image.RegisterFormat(AVIF, "????ftypavif", decodeHEIF, decodeHEIFConfig)
+	RegisterEncoder(AVIF, EncoderFunc(func(w io.Writer, img image.Image, opts Options) error {
+		return SaveAVIF(img, w, opts)
+	}))
+}
+
+// heifError converts a libheif error result to a Go error.
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New("thumbnail: libheif: " + C.GoString(err.message))
+}
+
+// heifContext reads the whole of r into a new libheif context. The returned
+// function frees it.
+func heifContext(r io.Reader) (*C.struct_heif_context, func(), error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	// libheif keeps pointers into the input, so it lives in C memory.
+	mem := C.CBytes(data)
+	ctx := C.heif_context_alloc()
+	free := func() {
+		C.heif_context_free(ctx)
+		C.free(mem)
+	}
+	if err := heifError(C.heif_context_read_from_memory_without_copy(ctx, mem, C.size_t(len(data)), nil)); err != nil {
+		free()
+		return nil, nil, err
+	}
+	return ctx, free, nil
+}
+
+// decodeHEIF decodes the primary image of a HEIF or AVIF file.
+func decodeHEIF(r io.Reader) (image.Image, error) {
+	ctx, free, err := heifContext(r)
+	if err != nil {
+		return nil, err
+	}
+	defer free()
+
+	var handle *C.struct_heif_image_handle
+	if err := heifError(C.heif_context_get_primary_image_handle(ctx, &handle)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_handle_release(handle)
+
+	var img *C.struct_heif_image
+	if err := heifError(C.heif_decode_image(handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(img)
+
+	w := int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	h := int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &stride)
+	if plane == nil || w <= 0 || h <= 0 {
+		return nil, errors.New("thumbnail: libheif: no image data")
+	}
+	src := C.GoBytes(unsafe.Pointer(plane), stride*C.int(h))
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		copy(m.Pix[y*m.Stride:y*m.Stride+4*w], src[y*int(stride):])
+	}
+	return m, nil
+}
+
+// decodeHEIFConfig reports the size of the primary image of a HEIF or
+// AVIF file.
+func decodeHEIFConfig(r io.Reader) (image.Config, error) {
+	ctx, free, err := heifContext(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer free()
+
+	var handle *C.struct_heif_image_handle
+	if err := heifError(C.heif_context_get_primary_image_handle(ctx, &handle)); err != nil {
+		return image.Config{}, err
+	}
+	defer C.heif_image_handle_release(handle)
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(C.heif_image_handle_get_width(handle)),
+		Height:     int(C.heif_image_handle_get_height(handle)),
+	}, nil
+}
+
+// SaveAVIF writes img to w as AVIF at opts.Quality, which defaults to 85
+// like SaveJPEG, or with the encoder's lossless mode when opts.Lossless is
+// set. It is only available when built with the libheif tag.
+func SaveAVIF(img image.Image, w io.Writer, opts Options) error {
+	return saveHEIF(img, w, opts, C.heif_compression_AV1)
+}
+
+// saveHEIF encodes img with the libheif encoder for format.
+func saveHEIF(img image.Image, w io.Writer, opts Options, format C.enum_heif_compression_format) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return ErrEmptyImage
+	}
+	ctx := C.heif_context_alloc()
+	defer C.heif_context_free(ctx)
+
+	var enc *C.struct_heif_encoder
+	if err := heifError(C.heif_context_get_encoder_for_format(ctx, format, &enc)); err != nil {
+		return err
+	}
+	defer C.heif_encoder_release(enc)
+	if opts.Lossless {
+		if err := heifError(C.heif_encoder_set_lossless(enc, 1)); err != nil {
+			return err
+		}
+	} else {
+		quality := opts.Quality
+		if quality <= 0 || quality > 100 {
+			quality = 85
+		}
+		if err := heifError(C.heif_encoder_set_lossy_quality(enc, C.int(quality))); err != nil {
+			return err
+		}
+	}
+
+	var him *C.struct_heif_image
+	if err := heifError(C.heif_image_create(C.int(b.Dx()), C.int(b.Dy()), C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, &him)); err != nil {
+		return err
+	}
+	defer C.heif_image_release(him)
+	if err := heifError(C.heif_image_add_plane(him, C.heif_channel_interleaved, C.int(b.Dx()), C.int(b.Dy()), 8)); err != nil {
+		return err
+	}
+	var stride C.int
+	plane := C.heif_image_get_plane(him, C.heif_channel_interleaved, &stride)
+	n := int(stride) * b.Dy()
+	dst := (*[1 << 30]byte)(unsafe.Pointer(plane))[:n:n]
+	src := toNRGBA(img)
+	for y := 0; y < b.Dy(); y++ {
+		copy(dst[y*int(stride):], src.Pix[y*src.Stride:y*src.Stride+4*b.Dx()])
+	}
+
+	if err := heifError(C.heif_context_encode_image(ctx, him, enc, nil, nil)); err != nil {
+		return err
+	}
+	var buf C.heifBuffer
+	defer C.free(unsafe.Pointer(buf.data))
+	if err := heifError(C.heifWrite(ctx, &buf)); err != nil {
+		return err
+	}
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(buf.data), C.int(buf.len)))
+	return err
+}
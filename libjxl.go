@@ -0,0 +1,260 @@
+//go:build libjxl && cgo
+// +build libjxl,cgo
+
+package thumbnail
+
+// Building with the libjxl tag links libjxl (0.7 or later) to read and
+// write JPEG XL.
+
+/*
+#cgo pkg-config: libjxl
+#include <stdlib.h>
+#include <string.h>
+#include <jxl/decode.h>
+#include <jxl/encode.h>
+
+// jxlDecode decodes the first frame of a JPEG XL image to 8-bit RGBA. With
+// info_only set it stops after reading the image header. The caller frees
+// *pix.
+static int jxlDecode(const uint8_t *data, size_t size, int info_only, uint32_t *w, uint32_t *h, uint8_t **pix) {
+	JxlDecoder *dec = JxlDecoderCreate(NULL);
+	if (dec == NULL) {
+		return -1;
+	}
+	int ret = -1;
+	JxlPixelFormat format = {4, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+	*pix = NULL;
+	if (JxlDecoderSubscribeEvents(dec, JXL_DEC_BASIC_INFO | JXL_DEC_FULL_IMAGE) != JXL_DEC_SUCCESS ||
+		JxlDecoderSetInput(dec, data, size) != JXL_DEC_SUCCESS) {
+		goto done;
+	}
+	JxlDecoderCloseInput(dec);
+	for (;;) {
+		JxlDecoderStatus status = JxlDecoderProcessInput(dec);
+		if (status == JXL_DEC_BASIC_INFO) {
+			JxlBasicInfo info;
+			if (JxlDecoderGetBasicInfo(dec, &info) != JXL_DEC_SUCCESS) {
+				goto done;
+			}
+			*w = info.xsize;
+			*h = info.ysize;
+			if (info_only) {
+				ret = 0;
+				goto done;
+			}
+		} else if (status == JXL_DEC_NEED_IMAGE_OUT_BUFFER) {
+			size_t n;
+			if (JxlDecoderImageOutBufferSize(dec, &format, &n) != JXL_DEC_SUCCESS) {
+				goto done;
+			}
+			*pix = malloc(n);
+			if (*pix == NULL || JxlDecoderSetImageOutBuffer(dec, &format, *pix, n) != JXL_DEC_SUCCESS) {
+				goto done;
+			}
+		} else if (status == JXL_DEC_FULL_IMAGE || status == JXL_DEC_SUCCESS) {
+			ret = *pix != NULL ? 0 : -1;
+			goto done;
+		} else {
+			// JXL_DEC_ERROR or JXL_DEC_NEED_MORE_INPUT for a truncated file.
+			goto done;
+		}
+	}
+done:
+	JxlDecoderDestroy(dec);
+	if (ret != 0) {
+		free(*pix);
+		*pix = NULL;
+	}
+	return ret;
+}
+
+// jxlEncode encodes w×h 8-bit RGBA pixels. A distance of zero encodes
+// losslessly. The caller frees *out.
+static int jxlEncode(const uint8_t *pix, uint32_t w, uint32_t h, int alpha, float distance, uint8_t **out, size_t *out_size) {
+	JxlEncoder *enc = JxlEncoderCreate(NULL);
+	if (enc == NULL) {
+		return -1;
+	}
+	int ret = -1;
+	size_t cap = 1 << 16;
+	*out = NULL;
+
+	JxlBasicInfo info;
+	JxlEncoderInitBasicInfo(&info);
+	info.xsize = w;
+	info.ysize = h;
+	info.bits_per_sample = 8;
+	info.num_color_channels = 3;
+	if (alpha) {
+		info.alpha_bits = 8;
+		info.num_extra_channels = 1;
+	}
+	info.uses_original_profile = distance == 0 ? JXL_TRUE : JXL_FALSE;
+	if (JxlEncoderSetBasicInfo(enc, &info) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlColorEncoding color;
+	JxlColorEncodingSetToSRGB(&color, JXL_FALSE);
+	if (JxlEncoderSetColorEncoding(enc, &color) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlEncoderFrameSettings *settings = JxlEncoderFrameSettingsCreate(enc, NULL);
+	if (distance == 0) {
+		if (JxlEncoderSetFrameLossless(settings, JXL_TRUE) != JXL_ENC_SUCCESS) {
+			goto done;
+		}
+	} else if (JxlEncoderSetFrameDistance(settings, distance) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlPixelFormat format = {alpha ? 4 : 3, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+	if (JxlEncoderAddImageFrame(settings, &format, pix, (size_t)w * h * format.num_channels) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlEncoderCloseInput(enc);
+
+	*out = malloc(cap);
+	if (*out == NULL) {
+		goto done;
+	}
+	uint8_t *next = *out;
+	size_t avail = cap;
+	for (;;) {
+		JxlEncoderStatus status = JxlEncoderProcessOutput(enc, &next, &avail);
+		if (status == JXL_ENC_SUCCESS) {
+			*out_size = next - *out;
+			ret = 0;
+			goto done;
+		}
+		if (status != JXL_ENC_NEED_MORE_OUTPUT) {
+			goto done;
+		}
+		size_t used = next - *out;
+		uint8_t *grown = realloc(*out, cap * 2);
+		if (grown == NULL) {
+			goto done;
+		}
+		*out = grown;
+		cap *= 2;
+		next = *out + used;
+		avail = cap - used;
+	}
+done:
+	JxlEncoderDestroy(enc);
+	if (ret != 0) {
+		free(*out);
+		*out = NULL;
+	}
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"unsafe"
+)
+
+// errJXL is returned when libjxl fails to decode or encode an image.
+var errJXL = errors.New("thumbnail: libjxl: cannot process JPEG XL image")
+
+func init() {
+	RegisterCodec(JXL, jxlCodec{}, []string{
+		"\xff\x0a",                             // bare codestream
+		"\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a", // ISO BMFF container
+	})
+}
+
+// jxlCodec decodes and encodes JPEG XL with libjxl.
+type jxlCodec struct{}
+
+func (jxlCodec) Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var w, h C.uint32_t
+	var pix *C.uint8_t
+	if C.jxlDecode((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), 0, &w, &h, &pix) != 0 {
+		return nil, errJXL
+	}
+	defer C.free(unsafe.Pointer(pix))
+	m := image.NewNRGBA(image.Rect(0, 0, int(w), int(h)))
+	copy(m.Pix, C.GoBytes(unsafe.Pointer(pix), C.int(len(m.Pix))))
+	return m, nil
+}
+
+func (jxlCodec) DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if len(data) == 0 {
+		return image.Config{}, io.ErrUnexpectedEOF
+	}
+	var w, h C.uint32_t
+	var pix *C.uint8_t
+	if C.jxlDecode((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), 1, &w, &h, &pix) != 0 {
+		return image.Config{}, errJXL
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(w), Height: int(h)}, nil
+}
+
+func (jxlCodec) Encode(w io.Writer, img image.Image, opts Options) error {
+	return SaveJXL(img, w, opts)
+}
+
+// SaveJXL writes img to w as JPEG XL at opts.Quality, which defaults to 85
+// like SaveJPEG, or losslessly with opts.Lossless. It is only available
+// when built with the libjxl tag.
+func SaveJXL(img image.Image, w io.Writer, opts Options) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return ErrEmptyImage
+	}
+	src := toNRGBA(img)
+	alpha := hasTransparency(src)
+	pix := src.Pix
+	if !alpha {
+		pix = make([]byte, 0, 3*b.Dx()*b.Dy())
+		for i := 0; i < len(src.Pix); i += 4 {
+			pix = append(pix, src.Pix[i], src.Pix[i+1], src.Pix[i+2])
+		}
+	}
+
+	var out *C.uint8_t
+	var size C.size_t
+	a := C.int(0)
+	if alpha {
+		a = 1
+	}
+	pinned := C.CBytes(pix)
+	defer C.free(pinned)
+	if C.jxlEncode((*C.uint8_t)(pinned), C.uint32_t(b.Dx()), C.uint32_t(b.Dy()), a, C.float(jxlDistance(opts)), &out, &size) != 0 {
+		return errJXL
+	}
+	defer C.free(unsafe.Pointer(out))
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
+
+// jxlDistance maps opts to a Butteraugli distance as cjxl maps its quality
+// setting, with zero meaning lossless.
+func jxlDistance(opts Options) float64 {
+	if opts.Lossless {
+		return 0
+	}
+	q := float64(opts.Quality)
+	if q <= 0 || q > 100 {
+		q = 85
+	}
+	if q >= 30 {
+		return 0.1 + (100-q)*0.09
+	}
+	return 53.0/3000*q*q - 23.0/20*q + 25
+}
@@ -0,0 +1,427 @@
+//go:build libvips && cgo
+// +build libvips,cgo
+
+package thumbnail
+
+// Building with the libvips tag links libvips (8.12 or later) and hands it
+// the work it does much faster than the pure-Go pipeline: ProcessReader,
+// and so the Generate functions that read encoded sources, decode and
+// scale JPEG, PNG, GIF and WebP sources with vips_thumbnail, which shrinks
+// JPEGs and WebPs while decoding them, and Encode writes JPEG, PNG and
+// WebP with libvips. Options libvips cannot honor as the pipeline does,
+// such as Pad, Focus, decorations, the Fit and Fill filters other than
+// CatmullRom and Lanczos, or metadata to write, keep the pure-Go code
+// paths, as do sources libvips fails to read. Thumbnails are then close to
+// but not identical with pure-Go ones: libvips filters with its own
+// Lanczos3 and rounds sizes its own way.
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <string.h>
+#include <vips/vips.h>
+
+// vipsStart initializes libvips. Operations keep pointers to the buffers
+// they read, which are Go memory that must not outlive a call, so no
+// operation is cached.
+static int vipsStart(void) {
+	if (VIPS_INIT("thumbnail")) {
+		return -1;
+	}
+	vips_cache_set_max(0);
+	return 0;
+}
+
+static void vipsFree(void *p) {
+	g_free(p);
+}
+
+// vipsHeader reads the size, EXIF orientation and loader nickname of an
+// encoded image without decoding its pixels.
+static int vipsHeader(const void *data, size_t size, int *w, int *h, int *orientation, char *loader, size_t n) {
+	VipsImage *img = vips_image_new_from_buffer(data, size, "", "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+	if (img == NULL) {
+		vips_error_clear();
+		return -1;
+	}
+	*w = vips_image_get_width(img);
+	*h = vips_image_get_height(img);
+	*orientation = 1;
+	if (vips_image_get_typeof(img, VIPS_META_ORIENTATION)) {
+		vips_image_get_int(img, VIPS_META_ORIENTATION, orientation);
+	}
+	const char *name = "";
+	if (vips_image_get_typeof(img, "vips-loader")) {
+		vips_image_get_string(img, "vips-loader", &name);
+	}
+	strncpy(loader, name, n - 1);
+	loader[n - 1] = 0;
+	g_object_unref(img);
+	return 0;
+}
+
+// vipsThumbnail decodes an encoded image and scales it into a w×h box as
+// vips_thumbnail does with the given VipsSize and VipsInteresting, then
+// converts it to 8-bit sRGB. The caller frees *pix with vipsFree.
+static int vipsThumbnail(const void *data, size_t size, int w, int h, int vsize, int crop, int linear, int no_rotate, int *ow, int *oh, int *bands, void **pix) {
+	VipsImage *thumb = NULL, *srgb = NULL, *out = NULL;
+	int ret = -1;
+	*pix = NULL;
+	if (vips_thumbnail_buffer((void *)data, size, &thumb, w,
+			"height", h,
+			"size", vsize,
+			"crop", crop,
+			"linear", linear,
+			"no_rotate", no_rotate,
+			"export_profile", "srgb",
+			NULL) ||
+		vips_colourspace(thumb, &srgb, VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_cast_uchar(srgb, &out, NULL)) {
+		goto done;
+	}
+	size_t n;
+	*pix = vips_image_write_to_memory(out, &n);
+	if (*pix == NULL) {
+		goto done;
+	}
+	*ow = vips_image_get_width(out);
+	*oh = vips_image_get_height(out);
+	*bands = vips_image_get_bands(out);
+	ret = 0;
+done:
+	if (thumb != NULL) {
+		g_object_unref(thumb);
+	}
+	if (srgb != NULL) {
+		g_object_unref(srgb);
+	}
+	if (out != NULL) {
+		g_object_unref(out);
+	}
+	if (ret != 0) {
+		vips_error_clear();
+	}
+	return ret;
+}
+
+// vipsSave encodes w×h 8-bit sRGB pixels of bands channels as JPEG (format
+// 0), PNG (1) or WebP (2), without metadata. The caller frees *out with
+// vipsFree.
+static int vipsSave(const void *pix, int w, int h, int bands, int format, int quality, int lossless, int interlace, int subsample, int compression, void **out, size_t *out_size) {
+	VipsImage *img = vips_image_new_from_memory(pix, (size_t)w * h * bands, w, h, bands, VIPS_FORMAT_UCHAR);
+	if (img == NULL) {
+		vips_error_clear();
+		return -1;
+	}
+	int ret;
+	switch (format) {
+	case 0:
+		ret = vips_jpegsave_buffer(img, out, out_size,
+			"Q", quality,
+			"interlace", interlace,
+			"subsample_mode", subsample ? VIPS_FOREIGN_SUBSAMPLE_ON : VIPS_FOREIGN_SUBSAMPLE_OFF,
+			"strip", TRUE,
+			NULL);
+		break;
+	case 1:
+		ret = vips_pngsave_buffer(img, out, out_size,
+			"compression", compression,
+			"strip", TRUE,
+			NULL);
+		break;
+	default:
+		ret = vips_webpsave_buffer(img, out, out_size,
+			"Q", quality,
+			"lossless", lossless,
+			"strip", TRUE,
+			NULL);
+	}
+	g_object_unref(img);
+	if (ret != 0) {
+		vips_error_clear();
+	}
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+// errVIPS is returned when libvips fails to encode an image.
+var errVIPS = errors.New("thumbnail: libvips: cannot encode image")
+
+// vipsLoaders maps the libvips loaders of the sources the engine takes to
+// their format names.
+var vipsLoaders = map[string]string{
+	"jpegload": JPEG,
+	"pngload":  PNG,
+	"gifload":  GIF,
+	"webpload": WEBP,
+}
+
+// The VipsSize and VipsInteresting values vipsThumbnail takes.
+const (
+	vipsSizeBoth  = C.VIPS_SIZE_BOTH
+	vipsSizeDown  = C.VIPS_SIZE_DOWN
+	vipsSizeForce = C.VIPS_SIZE_FORCE
+
+	vipsCropNone      = C.VIPS_INTERESTING_NONE
+	vipsCropCentre    = C.VIPS_INTERESTING_CENTRE
+	vipsCropAttention = C.VIPS_INTERESTING_ATTENTION
+	vipsCropLow       = C.VIPS_INTERESTING_LOW
+	vipsCropHigh      = C.VIPS_INTERESTING_HIGH
+)
+
+func init() {
+	if C.vipsStart() != 0 {
+		// Without libvips everything stays in Go.
+		return
+	}
+	engine = vipsThumbnail
+	for _, format := range []string{JPEG, PNG, WEBP} {
+		if prev, ok := LookupEncoder(format); ok {
+			RegisterEncoder(format, vipsEncoder{format, prev})
+		}
+	}
+}
+
+// vipsHonors reports whether vips_thumbnail can generate the thumbnail
+// opts asks for: Fit, Fill with any Gravity and Stretch, with or without
+// LinearLight, NoUpscale for Fit and NoAutoOrient, and any of the options
+// that apply after scaling, which stay in Go.
+func vipsHonors(opts Options) bool {
+	switch {
+	case opts.Mode != Fit && opts.Mode != Fill && opts.Mode != Stretch,
+		opts.Mode != Fit && opts.NoUpscale,
+		opts.Filter != CatmullRom && opts.Filter != Lanczos:
+		return false
+	}
+	honored := Options{
+		Width:        opts.Width,
+		Height:       opts.Height,
+		Filter:       opts.Filter,
+		Mode:         opts.Mode,
+		Gravity:      opts.Gravity,
+		LinearLight:  opts.LinearLight,
+		NoUpscale:    opts.NoUpscale,
+		NoAutoOrient: opts.NoAutoOrient,
+
+		// libvips schedules its own threads and always prefilters.
+		Parallelism: opts.Parallelism,
+		NoPrefilter: opts.NoPrefilter,
+
+		// Backgrounds only show in Pad and when encoding.
+		Background:     opts.Background,
+		AutoBackground: opts.AutoBackground,
+		Matte:          opts.Matte,
+
+		KeepMetadata:  opts.KeepMetadata,
+		Metadata:      opts.Metadata,
+		StripMetadata: opts.StripMetadata,
+
+		DominantColor: opts.DominantColor,
+		BlurHash:      opts.BlurHash,
+		ThumbHash:     opts.ThumbHash,
+		LQIP:          opts.LQIP,
+		Hash:          opts.Hash,
+
+		Format:      opts.Format,
+		Quality:     opts.Quality,
+		Lossless:    opts.Lossless,
+		Progressive: opts.Progressive,
+		Subsampling: opts.Subsampling,
+		Compression: opts.Compression,
+		Palette:     opts.Palette,
+		Quantizer:   opts.Quantizer,
+		Dither:      opts.Dither,
+
+		// Still sources have no frames to limit.
+		MaxFrames:   opts.MaxFrames,
+		MaxDuration: opts.MaxDuration,
+
+		MaxPixels: opts.MaxPixels,
+		MaxWidth:  opts.MaxWidth,
+		MaxHeight: opts.MaxHeight,
+
+		pool: opts.pool,
+	}
+	return reflect.DeepEqual(opts, honored)
+}
+
+// vipsCrop returns the VipsInteresting of the Fill of an sw×sh source into
+// a w×h box: the side of the cropped axis g anchors to.
+func vipsCrop(g Gravity, sw, sh, w, h int) int {
+	if g == Smart {
+		return vipsCropAttention
+	}
+	low, high := g == Top || g == TopLeft || g == TopRight, g == Bottom || g == BottomLeft || g == BottomRight
+	if sw*h > sh*w {
+		// The source is wider than the box and loses columns.
+		low, high = g == Left || g == TopLeft || g == BottomLeft, g == Right || g == TopRight || g == BottomRight
+	}
+	switch {
+	case low:
+		return vipsCropLow
+	case high:
+		return vipsCropHigh
+	}
+	return vipsCropCentre
+}
+
+// vipsThumbnail is the engine of the libvips build.
+func vipsThumbnail(ctx context.Context, data []byte, opts Options) (*Result, error) {
+	if len(data) == 0 || !vipsHonors(opts) {
+		return nil, nil
+	}
+	p := unsafe.Pointer(&data[0])
+	var w, h, orientation C.int
+	var loader [32]C.char
+	if C.vipsHeader(p, C.size_t(len(data)), &w, &h, &orientation, &loader[0], C.size_t(len(loader))) != 0 {
+		return nil, nil
+	}
+	format, ok := vipsLoaders[strings.TrimSuffix(C.GoString(&loader[0]), "_buffer")]
+	if !ok {
+		return nil, nil
+	}
+	srcW, srcH := int(w), int(h)
+	if err := checkLimits(srcW, srcH, &opts); err != nil {
+		return nil, err
+	}
+	if orientation >= 5 && orientation <= 8 && !opts.NoAutoOrient {
+		srcW, srcH = srcH, srcW
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	size, crop := C.int(vipsSizeBoth), C.int(vipsCropNone)
+	switch {
+	case opts.Mode == Stretch:
+		size = vipsSizeForce
+	case opts.Mode == Fill:
+		crop = C.int(vipsCrop(opts.Gravity, srcW, srcH, opts.Width, opts.Height))
+	case opts.NoUpscale:
+		size = vipsSizeDown
+	}
+	var ow, oh, bands C.int
+	var pix unsafe.Pointer
+	if C.vipsThumbnail(p, C.size_t(len(data)), C.int(opts.Width), C.int(opts.Height), size, crop,
+		cBool(opts.LinearLight), cBool(opts.NoAutoOrient), &ow, &oh, &bands, &pix) != 0 {
+		// Let the pipeline decode it, or report why it cannot.
+		return nil, nil
+	}
+	defer C.vipsFree(pix)
+	if bands != 3 && bands != 4 {
+		return nil, nil
+	}
+	b := image.Rect(0, 0, int(ow), int(oh))
+	px := C.GoBytes(pix, C.int(b.Dx()*b.Dy()*int(bands)))
+	var img image.Image
+	if bands == 4 {
+		img = &image.NRGBA{Pix: px, Stride: 4 * b.Dx(), Rect: b}
+	} else {
+		m := image.NewRGBA(b)
+		for i, j := 0, 0; i < len(px); i, j = i+3, j+4 {
+			m.Pix[j], m.Pix[j+1], m.Pix[j+2], m.Pix[j+3] = px[i], px[i+1], px[i+2], 0xff
+		}
+		img = m
+	}
+
+	scale := float64(b.Dx()) / float64(srcW)
+	if opts.Mode == Fill {
+		if s := float64(b.Dy()) / float64(srcH); s > scale {
+			scale = s
+		}
+	}
+	return &Result{
+		Image:        img,
+		Format:       format,
+		SourceWidth:  srcW,
+		SourceHeight: srcH,
+		Width:        b.Dx(),
+		Height:       b.Dy(),
+		Scale:        scale,
+	}, nil
+}
+
+// vipsEncoder writes format with libvips, leaving to prev the options
+// libvips does not offer: metadata, PNG palettes, 4:2:2 JPEG subsampling,
+// and WebPs too large for the format.
+type vipsEncoder struct {
+	format string
+	prev   Encoder
+}
+
+func (e vipsEncoder) Encode(w io.Writer, img image.Image, opts Options) error {
+	b := img.Bounds()
+	switch {
+	case b.Empty(),
+		opts.Metadata.strip(opts.StripMetadata) != nil,
+		e.format == PNG && opts.Palette,
+		e.format == JPEG && opts.Subsampling == Subsample422,
+		e.format == WEBP && (b.Dx() > maxWebPDimension || b.Dy() > maxWebPDimension):
+		return e.prev.Encode(w, img, opts)
+	}
+
+	var format int
+	switch e.format {
+	case PNG:
+		format = 1
+	case WEBP:
+		format = 2
+	default:
+		img = flatten(img, opts.Matte)
+	}
+	src := toNRGBA(img)
+	bands := 4
+	pix := src.Pix
+	if !hasTransparency(src) {
+		bands = 3
+		pix = make([]byte, 0, 3*b.Dx()*b.Dy())
+		for i := 0; i < len(src.Pix); i += 4 {
+			pix = append(pix, src.Pix[i], src.Pix[i+1], src.Pix[i+2])
+		}
+	}
+	q := opts.Quality
+	if q <= 0 || q > 100 {
+		q = 85
+	}
+	compression := 6
+	switch opts.Compression {
+	case CompressionNone:
+		compression = 0
+	case CompressionFast:
+		compression = 1
+	case CompressionBest:
+		compression = 9
+	}
+
+	pinned := C.CBytes(pix)
+	defer C.free(pinned)
+	var out unsafe.Pointer
+	var size C.size_t
+	if C.vipsSave(pinned, C.int(b.Dx()), C.int(b.Dy()), C.int(bands), C.int(format), C.int(q),
+		cBool(opts.Lossless), cBool(opts.Progressive), cBool(opts.Subsampling != Subsample444),
+		C.int(compression), &out, &size) != 0 {
+		return errVIPS
+	}
+	defer C.vipsFree(out)
+	_, err := w.Write(C.GoBytes(out, C.int(size)))
+	return err
+}
+
+// cBool converts v to a C boolean.
+func cBool(v bool) C.int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// LimitError reports a source whose declared size exceeds Options.MaxWidth,
+// MaxHeight or MaxPixels. It is returned before any of its pixels are
+// decoded.
+type LimitError struct {
+	Field         string // name of the Options field exceeded
+	Limit         int    // its value
+	Width, Height int    // the size the source declares
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %dx%d exceeds %s = %d", ErrSourceTooLarge, e.Width, e.Height, e.Field, e.Limit)
+}
+
+// Unwrap returns ErrSourceTooLarge.
+func (e *LimitError) Unwrap() error {
+	return ErrSourceTooLarge
+}
+
+// limited reports whether opts limits the size of sources.
+func limited(opts *Options) bool {
+	return opts != nil && (opts.MaxWidth > 0 || opts.MaxHeight > 0 || opts.MaxPixels > 0)
+}
+
+// checkLimits returns a *LimitError if a w×h source exceeds the limits of
+// opts, which may be nil.
+func checkLimits(w, h int, opts *Options) error {
+	switch {
+	case opts == nil:
+	case opts.MaxWidth > 0 && w > opts.MaxWidth:
+		return &LimitError{"MaxWidth", opts.MaxWidth, w, h}
+	case opts.MaxHeight > 0 && h > opts.MaxHeight:
+		return &LimitError{"MaxHeight", opts.MaxHeight, w, h}
+	case opts.MaxPixels > 0 && int64(w)*int64(h) > int64(opts.MaxPixels):
+		return &LimitError{"MaxPixels", opts.MaxPixels, w, h}
+	}
+	return nil
+}
+
+// checkSize checks the size the encoded image in data declares against
+// the limits of opts. Images whose size cannot be read are left for their
+// decoder to reject.
+func checkSize(data []byte, opts *Options) error {
+	if !limited(opts) {
+		return nil
+	}
+	if w, h, ok := tiffSize(data); ok {
+		return checkLimits(w, h, opts)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return checkLimits(cfg.Width, cfg.Height, opts)
+}
@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// linearTables convert between 16-bit sRGB-encoded and 16-bit linear-light
+// channel values. They are built on first use.
+var linearTables struct {
+	once       sync.Once
+	toLinear   []uint16
+	fromLinear []uint16
+}
+
+func buildLinearTables() {
+	t := &linearTables
+	t.toLinear = make([]uint16, 1<<16)
+	t.fromLinear = make([]uint16, 1<<16)
+	for i := range t.toLinear {
+		v := float64(i) / 0xffff
+		var lin float64
+		if v <= 0.04045 {
+			lin = v / 12.92
+		} else {
+			lin = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		t.toLinear[i] = uint16(math.Round(lin * 0xffff))
+		t.fromLinear[i] = uint16(math.Round(sRGBEncode(v) * 0xffff))
+	}
+}
+
+// linearize draws the sr portion of src into dst, which has sr's size,
+// with colors converted to linear light.
+func linearize(dst *image.RGBA64, src image.Image, sr image.Rectangle) {
+	linearTables.once.Do(buildLinearTables)
+	draw.Draw(dst, dst.Rect, src, sr.Min, draw.Src)
+	convertRGBA64(dst, linearTables.toLinear)
+}
+
+// delinearize converts the colors of m from linear light back to sRGB in
+// place.
+func delinearize(m *image.RGBA64) {
+	linearTables.once.Do(buildLinearTables)
+	convertRGBA64(m, linearTables.fromLinear)
+}
+
+// convertRGBA64 maps the color channels of m through table, which applies
+// to unpremultiplied values.
+func convertRGBA64(m *image.RGBA64, table []uint16) {
+	w := m.Rect.Dx()
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		px := m.Pix[m.PixOffset(m.Rect.Min.X, y):]
+		for i := 0; i < 8*w; i += 8 {
+			a := uint32(px[i+6])<<8 | uint32(px[i+7])
+			if a == 0 {
+				continue
+			}
+			for c := 0; c < 6; c += 2 {
+				v := uint32(px[i+c])<<8 | uint32(px[i+c+1])
+				if a != 0xffff {
+					v = minUint32(v*0xffff/a, 0xffff)
+				}
+				v = uint32(table[v])
+				if a != 0xffff {
+					v = v * a / 0xffff
+				}
+				px[i+c], px[i+c+1] = byte(v>>8), byte(v)
+			}
+		}
+	}
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// lqipSize is the size of the longer side of an LQIP image.
+	lqipSize = 24
+	// lqipSigma is the standard deviation of the blur applied to LQIP
+	// images, which hides their low resolution once enlarged and lets them
+	// compress to a few hundred bytes.
+	lqipSigma = 1
+	// lqipQuality is the quality LQIP images are encoded at.
+	lqipQuality = 40
+)
+
+// LQIP returns a low-quality image placeholder for img: a data URI of a
+// blurred copy reduced to lqipSize (24) pixels on its longer side, encoded
+// in format, such as JPEG or WebP, which clients can inline in markup and
+// show enlarged until the image loads.
+func LQIP(ctx context.Context, img image.Image, format string) (string, error) {
+	enc, ok := LookupEncoder(format)
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return "", ErrEmptyImage
+	}
+	scale := math.Min(1, lqipSize/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Rect, img, b, draw.Src, nil)
+	blurred, err := gaussianBlur(ctx, small, lqipSigma)
+	if err != nil {
+		return "", err
+	}
+
+	// Auto resolves to the format BestFormat picks for the placeholder,
+	// which the data URI must name.
+	format = encodedFormat(blurred, format)
+	if enc, ok = LookupEncoder(format); !ok {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, blurred, Options{Format: format, Quality: lqipQuality}); err != nil {
+		return "", err
+	}
+	return "data:" + mediaType(format) + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// mediaType returns the media type of images in format.
+func mediaType(format string) string {
+	if format == ICO {
+		return "image/vnd.microsoft.icon"
+	}
+	return "image/" + format
+}
@@ -0,0 +1,24 @@
+package thumbnail
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLQIPMediaType(t *testing.T) {
+	img := testImage(60, 40, false)
+	for format, prefix := range map[string]string{
+		"JPEG": "data:image/jpeg;base64,/9j/",
+		"webp": "data:image/webp;base64,UklGR",
+		Auto:   "data:image/" + BestFormat(img) + ";base64,",
+	} {
+		uri, err := LQIP(context.Background(), img, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !strings.HasPrefix(uri, prefix) {
+			t.Errorf("%s: LQIP %.40s..., want %s...", format, uri, prefix)
+		}
+	}
+}
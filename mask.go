@@ -0,0 +1,97 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// maskSamples is the number of samples per axis that the coverage of
+// pixels along a rounded edge is estimated from.
+const maskSamples = 4
+
+// masked reports whether opts round the corners of the thumbnail.
+func masked(opts Options) bool {
+	return opts.CornerRadius > 0 || opts.Circle
+}
+
+// roundCorners makes the parts of r in dst outside the rounded rectangle or
+// ellipse that opts describe transparent, with antialiased edges.
+func roundCorners(dst draw.Image, r image.Rectangle, opts Options) {
+	w, h := r.Dx(), r.Dy()
+	rx, ry := float64(w)/2, float64(h)/2
+	if !opts.Circle {
+		rx = math.Min(float64(opts.CornerRadius), math.Min(rx, ry))
+		ry = rx
+	}
+	// Only the corner boxes, which make up the whole image for an
+	// ellipse, have pixels outside the shape.
+	cw, ch := int(math.Ceil(rx)), int(math.Ceil(ry))
+	if cw == 0 || ch == 0 {
+		return
+	}
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			a := coverage(x, y, rx, ry)
+			if a == 0xffff {
+				continue
+			}
+			// Each corner box mirrors the top left one. With odd sizes the
+			// boxes of an ellipse share their middle row or column.
+			x0, x1 := r.Min.X+x, r.Max.X-1-x
+			y0, y1 := r.Min.Y+y, r.Max.Y-1-y
+			fade(dst, x0, y0, a)
+			if x1 != x0 {
+				fade(dst, x1, y0, a)
+			}
+			if y1 != y0 {
+				fade(dst, x0, y1, a)
+				if x1 != x0 {
+					fade(dst, x1, y1, a)
+				}
+			}
+		}
+	}
+}
+
+// coverage returns the share, out of 0xffff, of pixel (x, y) that lies
+// within the ellipse with radii rx and ry centered at (rx, ry).
+func coverage(x, y int, rx, ry float64) uint32 {
+	in := 0
+	for j := 0; j < maskSamples; j++ {
+		dy := (float64(y) + (float64(j)+0.5)/maskSamples - ry) / ry
+		for i := 0; i < maskSamples; i++ {
+			dx := (float64(x) + (float64(i)+0.5)/maskSamples - rx) / rx
+			if dx > 0 || dy > 0 || dx*dx+dy*dy <= 1 {
+				in++
+			}
+		}
+	}
+	return uint32(in * 0xffff / (maskSamples * maskSamples))
+}
+
+// fade scales the premultiplied color of pixel (x, y) in dst by a/0xffff.
+func fade(dst draw.Image, x, y int, a uint32) {
+	switch m := dst.(type) {
+	case *image.RGBA:
+		i := m.PixOffset(x, y)
+		for c := 0; c < 4; c++ {
+			m.Pix[i+c] = uint8((uint32(m.Pix[i+c])*a + 0x7fff) / 0xffff)
+		}
+	case *image.RGBA64:
+		i := m.PixOffset(x, y)
+		for c := 0; c < 8; c += 2 {
+			v := uint32(m.Pix[i+c])<<8 | uint32(m.Pix[i+c+1])
+			v = (v*a + 0x7fff) / 0xffff
+			m.Pix[i+c], m.Pix[i+c+1] = uint8(v>>8), uint8(v)
+		}
+	default:
+		cr, cg, cb, ca := dst.At(x, y).RGBA()
+		dst.Set(x, y, color.RGBA64{
+			uint16(cr * a / 0xffff), uint16(cg * a / 0xffff),
+			uint16(cb * a / 0xffff), uint16(ca * a / 0xffff),
+		})
+	}
+}
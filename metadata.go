@@ -0,0 +1,514 @@
+package thumbnail
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// Metadata holds the metadata blocks of an image that the JPEG, PNG and
+// WebP encoders can write alongside a thumbnail (see Options.Metadata).
+type Metadata struct {
+	EXIF []byte // TIFF-structured EXIF data, without JPEG's "Exif\0\0" prefix
+	XMP  []byte // XMP packet
+	ICC  []byte // ICC color profile
+}
+
+// MetadataKind is a set of metadata blocks to carry from a source into its
+// thumbnails.
+type MetadataKind int
+
+const (
+	// MetadataCopyright keeps only the EXIF Artist and Copyright fields.
+	MetadataCopyright MetadataKind = 1 << iota
+	// MetadataEXIF keeps all EXIF data except the embedded thumbnail,
+	// with the orientation reset to upright if the thumbnail was turned
+	// upright by it, and kept otherwise.
+	MetadataEXIF
+	// MetadataXMP keeps the XMP packet.
+	MetadataXMP
+	// MetadataICC keeps the ICC profile, which the thumbnail's pixels
+	// are still encoded in unless they were converted to sRGB.
+	MetadataICC
+
+	// MetadataAll keeps all of the metadata blocks above.
+	MetadataAll = MetadataEXIF | MetadataXMP | MetadataICC
+)
+
+// valid reports whether k holds only known metadata kinds.
+func (k MetadataKind) valid() bool {
+	return k&^(MetadataCopyright|MetadataAll) == 0
+}
+
+// EXIF tags of the fields MetadataCopyright keeps.
+const (
+	tagArtist    = 0x013b
+	tagCopyright = 0x8298
+)
+
+// xmpHeader starts the payload of a JPEG APP1 segment holding XMP data.
+const xmpHeader = "http://ns.adobe.com/xap/1.0/\x00"
+
+// iccHeader starts the payload of a JPEG APP2 segment holding part of an
+// ICC profile.
+const iccHeader = "ICC_PROFILE\x00"
+
+// ReadMetadata reads r to the end and returns the EXIF, XMP and ICC
+// metadata of the JPEG, PNG or WebP image in it, or the ICC profile of a
+// TIFF image. Blocks the image does not have, and all blocks of other
+// formats, are left nil.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return readMetadata(data), nil
+}
+
+// readMetadata returns the metadata of an encoded image as ReadMetadata
+// does.
+func readMetadata(data []byte) *Metadata {
+	m := new(Metadata)
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		m.EXIF = jpegEXIF(data)
+		for _, s := range jpegSegments(data) {
+			if s.marker == 0xe1 && bytes.HasPrefix(s.payload, []byte(xmpHeader)) {
+				m.XMP = s.payload[len(xmpHeader):]
+				break
+			}
+		}
+		m.ICC = jpegICC(data)
+	case bytes.HasPrefix(data, pngSignature):
+		for b := data[len(pngSignature):]; len(b) >= 12; {
+			n := binary.BigEndian.Uint32(b)
+			if uint64(n)+12 > uint64(len(b)) {
+				break
+			}
+			typ, body := string(b[4:8]), b[8:8+n]
+			b = b[12+n:]
+			switch typ {
+			case "eXIf":
+				m.EXIF = body
+			case "iTXt":
+				m.XMP = pngXMP(body, m.XMP)
+			case "iCCP":
+				m.ICC = pngICC(body)
+			case "IDAT":
+				// Metadata after the image data is rare and would
+				// mean reading the whole stream.
+				b = nil
+			}
+		}
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for b := data[12:]; len(b) >= 8; {
+			n := binary.LittleEndian.Uint32(b[4:])
+			if uint64(n)+8 > uint64(len(b)) {
+				break
+			}
+			id, body := string(b[:4]), b[8:8+n]
+			b = b[8+n+n&1:]
+			switch id {
+			case "EXIF":
+				m.EXIF = bytes.TrimPrefix(body, []byte(exifHeader))
+			case "XMP ":
+				m.XMP = body
+			case "ICCP":
+				m.ICC = body
+			}
+		}
+	default:
+		t, off, err := newTIFFReader(data)
+		if err != nil {
+			break
+		}
+		entries, _, err := t.ifd(off)
+		if err != nil {
+			break
+		}
+		for _, e := range entries {
+			if e.tag == tagICCProfile {
+				m.ICC = e.data
+			}
+		}
+	}
+	return m
+}
+
+// pngXMP returns the XMP packet of a PNG iTXt chunk, or xmp if the chunk
+// holds other text.
+func pngXMP(body, xmp []byte) []byte {
+	const keyword = "XML:com.adobe.xmp\x00"
+	if !bytes.HasPrefix(body, []byte(keyword)) || len(body) < len(keyword)+2 {
+		return xmp
+	}
+	compressed := body[len(keyword)] != 0
+	rest := body[len(keyword)+2:]
+	// Skip the language tag and translated keyword.
+	for i := 0; i < 2; i++ {
+		k := bytes.IndexByte(rest, 0)
+		if k < 0 {
+			return xmp
+		}
+		rest = rest[k+1:]
+	}
+	if !compressed {
+		return rest
+	}
+	text, err := inflate(rest)
+	if err != nil {
+		return xmp
+	}
+	return text
+}
+
+// pngICC returns the profile of a PNG iCCP chunk, or nil if it is invalid.
+func pngICC(body []byte) []byte {
+	k := bytes.IndexByte(body, 0)
+	if k < 0 || k+2 > len(body) || body[k+1] != 0 {
+		return nil
+	}
+	profile, err := inflate(body[k+2:])
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+func inflate(b []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// Select returns the blocks of m that kinds asks for. EXIF data is
+// reduced as described for MetadataEXIF and MetadataCopyright, for an
+// image turned upright. It returns nil if nothing is left.
+func (m *Metadata) Select(kinds MetadataKind) *Metadata {
+	return m.selectFor(kinds, true)
+}
+
+// selectFor is Select for an image that upright reports whether the EXIF
+// orientation was applied to.
+func (m *Metadata) selectFor(kinds MetadataKind, upright bool) *Metadata {
+	if m == nil {
+		return nil
+	}
+	s := new(Metadata)
+	switch {
+	case kinds&MetadataEXIF != 0:
+		s.EXIF = neutralEXIF(m.EXIF, upright)
+	case kinds&MetadataCopyright != 0:
+		s.EXIF = copyrightEXIF(m.EXIF)
+	}
+	if kinds&MetadataXMP != 0 {
+		s.XMP = m.XMP
+	}
+	if kinds&MetadataICC != 0 {
+		s.ICC = m.ICC
+	}
+	if s.EXIF == nil && s.XMP == nil && s.ICC == nil {
+		return nil
+	}
+	return s
+}
+
+// neutralEXIF returns a copy of exif with the IFD holding the embedded
+// thumbnail unlinked, and with the orientation set to 1 if upright is set,
+// or nil if exif is invalid. The thumbnail's pixels are zeroed in the
+// copy, since they show the source before any cropping.
+func neutralEXIF(exif []byte, upright bool) []byte {
+	t, off, err := newTIFFReader(exif)
+	if err != nil || t.big || off < 8 || uint64(off)+2 > uint64(len(exif)) {
+		return nil
+	}
+	b := append([]byte(nil), exif...)
+	n := uint64(t.order.Uint16(b[off:]))
+	end := uint64(off) + 2 + 12*n
+	if end+4 > uint64(len(b)) {
+		return nil
+	}
+	for i := uint64(0); i < n; i++ {
+		e := b[uint64(off)+2+12*i:]
+		if upright && t.order.Uint16(e) == tagOrientation && t.order.Uint16(e[2:]) == 3 {
+			t.order.PutUint16(e[8:], 1)
+		}
+	}
+	if next := t.order.Uint32(b[end:]); next != 0 {
+		if entries, _, err := t.ifd(next); err == nil {
+			zeroThumbnail(b, t, entries)
+		}
+	}
+	t.order.PutUint32(b[end:], 0)
+	return b
+}
+
+// zeroThumbnail zeroes the bytes of b that hold the JPEG or strips of the
+// thumbnail IFD entries describes.
+func zeroThumbnail(b []byte, t *tiffReader, entries []ifdEntry) {
+	zero := func(start, size uint32) {
+		if uint64(start)+uint64(size) > uint64(len(b)) {
+			return
+		}
+		s := b[start : start+size]
+		for i := range s {
+			s[i] = 0
+		}
+	}
+	start, ok1 := t.field(entries, tagJPEGInterchange)
+	size, ok2 := t.field(entries, tagJPEGInterchangeSize)
+	if ok1 && ok2 {
+		zero(start, size)
+	}
+	offsets, counts := tiffValues(t, entries, tagStripOffsets), tiffValues(t, entries, tagStripByteCounts)
+	for i := 0; i < len(offsets) && i < len(counts); i++ {
+		zero(offsets[i], counts[i])
+	}
+}
+
+// copyrightEXIF returns EXIF data holding only the Artist and Copyright
+// fields of exif, or nil if it has neither.
+func copyrightEXIF(exif []byte) []byte {
+	t, off, err := newTIFFReader(exif)
+	if err != nil {
+		return nil
+	}
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return nil
+	}
+	var keep []ifdEntry
+	for _, e := range entries {
+		if (e.tag == tagArtist || e.tag == tagCopyright) && e.typ == 2 {
+			keep = append(keep, e)
+		}
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+
+	le := binary.LittleEndian
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	dataOff := uint32(8 + 2 + 12*len(keep) + 4)
+	var data []byte
+	b = append(b, byte(len(keep)), 0)
+	for _, e := range keep {
+		var ent [12]byte
+		le.PutUint16(ent[0:], e.tag)
+		le.PutUint16(ent[2:], e.typ)
+		le.PutUint32(ent[4:], uint32(len(e.data)))
+		if len(e.data) <= 4 {
+			copy(ent[8:], e.data)
+		} else {
+			le.PutUint32(ent[8:], dataOff+uint32(len(data)))
+			data = append(data, e.data...)
+			if len(data)&1 != 0 {
+				data = append(data, 0)
+			}
+		}
+		b = append(b, ent[:]...)
+	}
+	b = append(b, 0, 0, 0, 0)
+	return append(b, data...)
+}
+
+// jpegSegments returns the APP segments that hold m in a JPEG stream.
+// Blocks too large for a segment are left out, except for ICC profiles,
+// which are split across as many as needed.
+func (m *Metadata) jpegSegments() []jpegSegment {
+	if m == nil {
+		return nil
+	}
+	const maxPayload = 0xffff - 2
+	var segs []jpegSegment
+	if len(m.EXIF) > 0 && len(exifHeader)+len(m.EXIF) <= maxPayload {
+		segs = append(segs, jpegSegment{marker: 0xe1, payload: append([]byte(exifHeader), m.EXIF...)})
+	}
+	if len(m.XMP) > 0 && len(xmpHeader)+len(m.XMP) <= maxPayload {
+		segs = append(segs, jpegSegment{marker: 0xe1, payload: append([]byte(xmpHeader), m.XMP...)})
+	}
+	if len(m.ICC) > 0 {
+		const chunk = maxPayload - len(iccHeader) - 2
+		count := (len(m.ICC) + chunk - 1) / chunk
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				part := m.ICC[i*chunk : minInt((i+1)*chunk, len(m.ICC))]
+				p := append([]byte(iccHeader), byte(i+1), byte(count))
+				segs = append(segs, jpegSegment{marker: 0xe2, payload: append(p, part...)})
+			}
+		}
+	}
+	return segs
+}
+
+// pngChunks returns the chunks that hold m in a PNG stream.
+func (m *Metadata) pngChunks() []apngChunk {
+	if m == nil {
+		return nil
+	}
+	var chunks []apngChunk
+	if len(m.ICC) > 0 {
+		var b bytes.Buffer
+		b.WriteString("icc\x00\x00")
+		zw := zlib.NewWriter(&b)
+		zw.Write(m.ICC)
+		zw.Close()
+		chunks = append(chunks, apngChunk{"iCCP", b.Bytes()})
+	}
+	if len(m.EXIF) > 0 {
+		chunks = append(chunks, apngChunk{"eXIf", m.EXIF})
+	}
+	if len(m.XMP) > 0 {
+		chunks = append(chunks, apngChunk{"iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), m.XMP...)})
+	}
+	return chunks
+}
+
+// insertPNGMetadata returns the PNG stream data with the chunks holding m
+// inserted after its header chunk.
+func insertPNGMetadata(data []byte, m *Metadata) []byte {
+	chunks := m.pngChunks()
+	const ihdrEnd = 8 + 12 + 13
+	if len(chunks) == 0 || len(data) < ihdrEnd {
+		return data
+	}
+	b := make([]byte, 0, len(data)+len(m.ICC)+len(m.EXIF)+len(m.XMP)+64)
+	b = append(b, data[:ihdrEnd]...)
+	b = appendPNGChunks(b, chunks...)
+	return append(b, data[ihdrEnd:]...)
+}
+
+// MetadataStrip selects metadata that encoders must leave out of their
+// output whatever Options.Metadata and Options.KeepMetadata ask for.
+type MetadataStrip int
+
+const (
+	// StripNone writes the metadata that Options.Metadata holds. Without
+	// it, and without Options.KeepMetadata, thumbnails carry no metadata.
+	StripNone MetadataStrip = iota
+	// StripGPS removes location data: the GPS fields of EXIF data, and
+	// the XMP packet if it records GPS coordinates.
+	StripGPS
+	// StripAll writes no metadata at all.
+	StripAll
+)
+
+// valid reports whether s is a known strip mode.
+func (s MetadataStrip) valid() bool {
+	return s >= StripNone && s <= StripAll
+}
+
+// tagGPSIFD is the EXIF tag pointing to the GPS IFD.
+const tagGPSIFD = 0x8825
+
+// xmpGPS holds the names of the XMP properties that record a location.
+var xmpGPS = [][]byte{[]byte("GPSLatitude"), []byte("GPSLongitude")}
+
+// HasLocation reports whether m holds GPS data, in EXIF fields or in XMP
+// properties. It lets privacy-sensitive callers check the metadata
+// ReadMetadata finds in their output.
+func (m *Metadata) HasLocation() bool {
+	if m == nil {
+		return false
+	}
+	if t, off, err := newTIFFReader(m.EXIF); err == nil {
+		if entries, _, err := t.ifd(off); err == nil {
+			if _, ok := t.field(entries, tagGPSIFD); ok {
+				return true
+			}
+		}
+	}
+	for _, name := range xmpGPS {
+		if bytes.Contains(m.XMP, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip returns m without the metadata s removes; m itself is not
+// changed.
+func (m *Metadata) strip(s MetadataStrip) *Metadata {
+	switch {
+	case m == nil || s == StripNone:
+		return m
+	case s == StripAll:
+		return nil
+	}
+	c := *m
+	if c.EXIF != nil {
+		c.EXIF = stripGPS(c.EXIF)
+	}
+	for _, name := range xmpGPS {
+		if bytes.Contains(c.XMP, name) {
+			c.XMP = nil
+		}
+	}
+	return &c
+}
+
+// stripGPS returns a copy of exif without its GPS IFD. The pointer to it
+// is removed from the first IFD and the IFD's own bytes are zeroed, so no
+// location remains anywhere in the data. Invalid EXIF data yields nil.
+func stripGPS(exif []byte) []byte {
+	t, off, err := newTIFFReader(exif)
+	if err != nil || t.big || off < 8 || uint64(off)+2 > uint64(len(exif)) {
+		return nil
+	}
+	b := append([]byte(nil), exif...)
+	n := int(t.order.Uint16(b[off:]))
+	end := int(off) + 2 + 12*n // offset of the next-IFD pointer
+	if end+4 > len(b) {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		e := int(off) + 2 + 12*i
+		if t.order.Uint16(b[e:]) != tagGPSIFD {
+			continue
+		}
+		gps := t.order.Uint32(b[e+8:])
+		// Close the gap in the directory; offsets elsewhere are absolute
+		// and stay valid.
+		copy(b[e:], b[e+12:end+4])
+		for k := end - 8; k < end+4; k++ {
+			b[k] = 0
+		}
+		t.order.PutUint16(b[off:], uint16(n-1))
+		zeroIFD(t, b, gps)
+		break
+	}
+	return b
+}
+
+// zeroIFD clears the directory at off in b, and the values it points to,
+// as read through t from the original data.
+func zeroIFD(t *tiffReader, b []byte, off uint32) {
+	if off < 8 || uint64(off)+2 > uint64(len(b)) {
+		return
+	}
+	n := uint64(t.order.Uint16(t.b[off:]))
+	end := uint64(off) + 2 + 12*n + 4
+	if end > uint64(len(b)) {
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		e := t.b[uint64(off)+2+12*i:]
+		typ := t.order.Uint16(e[2:])
+		if int(typ) >= len(typeSizes) {
+			continue
+		}
+		size := uint64(typeSizes[typ]) * uint64(t.order.Uint32(e[4:]))
+		if p := uint64(t.order.Uint32(e[8:])); size > 4 && p+size <= uint64(len(b)) {
+			for k := p; k < p+size; k++ {
+				b[k] = 0
+			}
+		}
+	}
+	for k := uint64(off); k < end; k++ {
+		b[k] = 0
+	}
+}
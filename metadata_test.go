@@ -0,0 +1,178 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSelectEXIFDropsThumbnail(t *testing.T) {
+	le := binary.LittleEndian
+	thumb := []byte("\xff\xd8uncropped preview\xff\xd9")
+	exif := []byte("II*\x00\x08\x00\x00\x00")
+	entry := func(tag, typ uint16, count, value uint32) {
+		var e [12]byte
+		le.PutUint16(e[0:], tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint32(e[4:], count)
+		le.PutUint32(e[8:], value)
+		exif = append(exif, e[:]...)
+	}
+	// IFD0 with the orientation ends at 26, where IFD1 starts, and the
+	// thumbnail follows IFD1.
+	exif = append(exif, 1, 0)
+	entry(tagOrientation, 3, 1, 6)
+	exif = append(exif, 26, 0, 0, 0)
+	exif = append(exif, 2, 0)
+	entry(tagJPEGInterchange, 4, 1, 26+2+2*12+4)
+	entry(tagJPEGInterchangeSize, 4, 1, uint32(len(thumb)))
+	exif = append(exif, 0, 0, 0, 0)
+	exif = append(exif, thumb...)
+	if exifThumbnail(exif) == nil {
+		t.Fatal("test EXIF has no thumbnail")
+	}
+
+	got := (&Metadata{EXIF: exif}).Select(MetadataEXIF).EXIF
+	if o := exifOrientation(got); o != 1 {
+		t.Errorf("orientation %d, want 1", o)
+	}
+	if exifThumbnail(got) != nil || bytes.Contains(got, []byte("preview")) {
+		t.Errorf("thumbnail kept in %q", got)
+	}
+}
+
+func TestHeadCaptureKeepsProfile(t *testing.T) {
+	icc := bytes.Repeat([]byte("not really a profile "), 200)
+	opts := Options{Metadata: &Metadata{ICC: icc}}
+	for name, save := range map[string]func(*bytes.Buffer) error{
+		"png":  func(b *bytes.Buffer) error { return SavePNGOptions(testImage(300, 200, false), b, opts) },
+		"webp": func(b *bytes.Buffer) error { return SaveWebP(testImage(300, 200, false), b, opts) },
+	} {
+		var buf bytes.Buffer
+		if err := save(&buf); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		var c headCapture
+		for b := data; len(b) > 0; {
+			n := 100
+			if n > len(b) {
+				n = len(b)
+			}
+			c.Write(b[:n])
+			b = b[n:]
+		}
+		if !bytes.Equal(readMetadata(c.buf).ICC, icc) {
+			t.Errorf("%s: profile not captured", name)
+		}
+		if len(c.buf) >= len(data)-100 {
+			t.Errorf("%s: captured %d of %d bytes", name, len(c.buf), len(data))
+		}
+	}
+}
+
+// orientedEXIF returns little-endian EXIF data holding only orientation o.
+func orientedEXIF(o uint16) []byte {
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(exif[18:], o)
+	return exif
+}
+
+func TestKeptOrientation(t *testing.T) {
+	src := testImage(40, 20, false)
+	md := &Metadata{EXIF: orientedEXIF(6)}
+	for _, c := range []struct {
+		format       string
+		noAutoOrient bool
+		w, h, o      int
+	}{
+		{JPEG, false, 10, 20, 1},
+		{JPEG, true, 20, 10, 6},
+		{PNG, false, 20, 10, 6},
+		{WEBP, false, 20, 10, 6},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, Options{Format: c.format, Metadata: md, Lossless: true}); err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{Width: 20, Height: 20, NoAutoOrient: c.noAutoOrient, KeepMetadata: MetadataEXIF}
+		res, err := ProcessReader(context.Background(), &buf, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", c.format, err)
+		}
+		if res.Metadata == nil {
+			t.Fatalf("%s: EXIF data not kept", c.format)
+		}
+		if o := exifOrientation(res.Metadata.EXIF); res.Width != c.w || res.Height != c.h || o != c.o {
+			t.Errorf("%s with NoAutoOrient %v: %dx%d with orientation %d, want %dx%d with %d",
+				c.format, c.noAutoOrient, res.Width, res.Height, o, c.w, c.h, c.o)
+		}
+	}
+}
+
+func TestStripLocation(t *testing.T) {
+	le := binary.LittleEndian
+	exif := []byte("II*\x00\x08\x00\x00\x00")
+	entry := func(tag, typ uint16, count, value uint32) {
+		var e [12]byte
+		le.PutUint16(e[0:], tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint32(e[4:], count)
+		le.PutUint32(e[8:], value)
+		exif = append(exif, e[:]...)
+	}
+	// IFD0 ends at 38, where the GPS IFD starts, and the latitude it
+	// points to follows it at 68.
+	exif = append(exif, 2, 0)
+	entry(tagOrientation, 3, 1, 3)
+	entry(tagGPSIFD, 4, 1, 38)
+	exif = append(exif, 0, 0, 0, 0)
+	exif = append(exif, 2, 0)
+	entry(1, 2, 2, 'N') // GPSLatitudeRef
+	entry(2, 5, 3, 68)  // GPSLatitude
+	exif = append(exif, 0, 0, 0, 0)
+	lat := []byte("\x33\x00\x00\x00\x01\x00\x00\x00\x1e\x00\x00\x00\x01\x00\x00\x00\xd2\x04\x00\x00\x64\x00\x00\x00")
+	exif = append(exif, lat...)
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="51,30.2N" exif:GPSLongitude="0,7.5W"/>` +
+		`</rdf:RDF></x:xmpmeta>`)
+	md := &Metadata{EXIF: exif, XMP: xmp}
+	if !md.HasLocation() {
+		t.Fatal("test metadata has no location")
+	}
+
+	for _, format := range []string{JPEG, PNG, WEBP} {
+		for _, s := range []MetadataStrip{StripNone, StripGPS, StripAll} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, testImage(40, 20, false), Options{Format: format, Metadata: md, StripMetadata: s}); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+			got, err := ReadMetadata(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s, strip %d: %v", format, s, err)
+			}
+			if s == StripNone {
+				if !got.HasLocation() || !bytes.Contains(data, lat) {
+					t.Errorf("%s: location not written", format)
+				}
+				continue
+			}
+			if got.HasLocation() {
+				t.Errorf("%s, strip %d: location kept", format, s)
+			}
+			if bytes.Contains(data, lat) || bytes.Contains(data, []byte("GPSLatitude")) {
+				t.Errorf("%s, strip %d: GPS bytes written", format, s)
+			}
+			switch o := exifOrientation(got.EXIF); {
+			case s == StripGPS && o != 3:
+				t.Errorf("%s: orientation %d, want 3", format, o)
+			case s == StripGPS && (len(got.EXIF) != len(exif) || !bytes.Equal(got.EXIF[38:], make([]byte, len(exif)-38))):
+				t.Errorf("%s: GPS IFD not zeroed in % x", format, got.EXIF)
+			case s == StripAll && (got.EXIF != nil || got.XMP != nil):
+				t.Errorf("%s: metadata kept", format)
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Mode selects how the source is fitted to the requested Width×Height box.
+type Mode int
+
+const (
+	// Fit scales the source to fit within the box, preserving its aspect
+	// ratio. The thumbnail may be smaller than the box along one axis.
+	// It is the default.
+	Fit Mode = iota
+	// Fill scales the source to cover the box, preserving its aspect
+	// ratio, and crops the overflow to produce exactly Width×Height. The
+	// part of the source that is kept is chosen by Focus or Gravity.
+	Fill
+	// Crop cuts a Width×Height region out of the source at its native
+	// resolution. Sources smaller than the box are scaled up just enough
+	// to cover it.
+	Crop
+	// Stretch scales the source to exactly Width×Height, ignoring its
+	// aspect ratio.
+	Stretch
+	// Pad scales the source to fit within the box like Fit and places it
+	// on a Width×Height canvas of the Background color according to
+	// Gravity.
+	Pad
+	// BlurPad places the source like Pad, but fills the rest of the canvas
+	// with a blurred copy of the source enlarged to cover it rather than
+	// with the Background color, as is usual for video and story
+	// thumbnails.
+	BlurPad
+	// Carve resizes to exactly Width×Height with seam carving: the source
+	// is scaled to cover the box, then the paths of pixels that cross the
+	// least detail are removed from it until it fits, so that a change of
+	// aspect ratio squeezes the background rather than the subject. It
+	// suits moderate changes, such as from wide banners to squares; beyond
+	// halving one side the rest is made by stretching. It is much slower
+	// than the other modes.
+	Carve
+)
+
+// valid reports whether m is a known mode.
+func (m Mode) valid() bool {
+	return m >= Fit && m <= Carve
+}
+
+// layout describes how a source is mapped onto the thumbnail canvas.
+type layout struct {
+	size   image.Point     // canvas dimensions
+	src    image.Rectangle // region of the source to use, relative to its origin
+	dst    image.Rectangle // region of the canvas the source is scaled into
+	border int             // width of the frame around the canvas edges
+}
+
+// plan computes the layout for a srcW×srcH source under opts, which must
+// already be valid.
+func plan(srcW, srcH int, opts Options) layout {
+	if b := opts.Border; b > 0 {
+		// Fit the thumbnail within the box less the border, then grow
+		// the canvas by it, so that the border counts towards the size.
+		opts.Width = maxInt(1, opts.Width-2*b)
+		opts.Height = maxInt(1, opts.Height-2*b)
+		opts.Border = 0
+		l := plan(srcW, srcH, opts)
+		l.size = l.size.Add(image.Pt(2*b, 2*b))
+		l.dst = l.dst.Add(image.Pt(b, b))
+		l.border = b
+		return l
+	}
+	box := image.Pt(opts.Width, opts.Height)
+	full := image.Rect(0, 0, srcW, srcH)
+
+	switch opts.Mode {
+	case Fill, Crop:
+		s := math.Max(float64(box.X)/float64(srcW), float64(box.Y)/float64(srcH))
+		if opts.Mode == Crop && s < 1 {
+			s = 1
+		}
+		size := box
+		if opts.NoUpscale && s > 1 {
+			// Keep whatever part of the box the source covers natively.
+			s = 1
+			size = image.Pt(minInt(box.X, srcW), minInt(box.Y, srcH))
+		}
+		cw := clampInt(int(math.Round(float64(box.X)/s)), 1, srcW)
+		ch := clampInt(int(math.Round(float64(box.Y)/s)), 1, srcH)
+		return layout{
+			size: size,
+			src:  cropWindow(image.Pt(cw, ch), full, opts),
+			dst:  image.Rectangle{Max: size},
+		}
+	case Stretch, Carve:
+		size := box
+		if opts.NoUpscale {
+			size = image.Pt(minInt(box.X, srcW), minInt(box.Y, srcH))
+		}
+		return layout{size: size, src: full, dst: image.Rectangle{Max: size}}
+	case Pad, BlurPad:
+		w, h := fitSize(srcW, srcH, opts)
+		dst := opts.Gravity.place(image.Pt(w, h), image.Rectangle{Max: box})
+		return layout{size: box, src: full, dst: dst}
+	default:
+		w, h := fitSize(srcW, srcH, opts)
+		return layout{size: image.Pt(w, h), src: full, dst: image.Rect(0, 0, w, h)}
+	}
+}
+
+// passthrough reports whether opts.Passthrough lets a srcW×srcH source be
+// returned as is, which is when the thumbnail would otherwise be an exact
+// copy of it once enlarging is ruled out.
+func passthrough(srcW, srcH int, opts Options) bool {
+	if !opts.Passthrough || masked(opts) || opts.Obscure != ObscureNone {
+		return false
+	}
+	opts.NoUpscale = true
+	l := plan(srcW, srcH, opts)
+	full := image.Rect(0, 0, srcW, srcH)
+	return l.size == full.Size() && l.src == full && l.dst == full
+}
+
+// fitSize returns the largest size with the source's aspect ratio that fits
+// within the box requested by opts. With opts.NoUpscale it never exceeds
+// the source size.
+func fitSize(srcW, srcH int, opts Options) (int, int) {
+	if opts.NoUpscale && srcW <= opts.Width && srcH <= opts.Height {
+		return srcW, srcH
+	}
+
+	// Calculate dimensions maintaining aspect ratio
+	ratio := float64(srcW) / float64(srcH)
+	var newW, newH int
+
+	if float64(opts.Width)/float64(opts.Height) > ratio {
+		newH = opts.Height
+		newW = int(float64(newH) * ratio)
+	} else {
+		newW = opts.Width
+		newH = int(float64(newW) / ratio)
+	}
+	// Extreme aspect ratios can round the short side down to nothing.
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+	return newW, newH
+}
+
+// render draws src into dst according to l, with the canvas placed at
+// origin. Canvas pixels not covered by the scaled source are set to
+// opts.Background, or in BlurPad mode to the blurred source, and any
+// border is drawn over them. Rounded corners are cut out last.
+func render(ctx context.Context, dst draw.Image, origin image.Point, src image.Image, l layout, opts Options) error {
+	canvas := image.Rectangle{Max: l.size}.Add(origin)
+	dr := l.dst.Add(origin)
+	sr := l.src.Add(src.Bounds().Min)
+	if dr != canvas {
+		bg := image.Transparent
+		if opts.AutoBackground != AutoBackgroundNone {
+			bg = image.NewUniform(edgeColor(src, sr, opts.AutoBackground))
+		} else if opts.Background != nil {
+			bg = image.NewUniform(opts.Background)
+		}
+		draw.Draw(dst, canvas, bg, image.Point{}, draw.Src)
+		if opts.Mode == BlurPad {
+			if err := blurredFill(ctx, dst, canvas.Inset(l.border), src, sr); err != nil {
+				return err
+			}
+		}
+	}
+	if l.border > 0 {
+		drawFrame(dst, canvas, l.border, opts)
+	}
+	if opts.Mode == Carve {
+		if err := carve(ctx, dst, dr, src, sr, opts); err != nil {
+			return err
+		}
+	} else if err := scale(ctx, dst, dr, src, sr, opts); err != nil {
+		return err
+	}
+	if err := obscure(ctx, dst, dr, opts); err != nil {
+		return err
+	}
+	if masked(opts) {
+		roundCorners(dst, canvas, opts)
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,480 @@
+package thumbnail
+
+import (
+	"fmt"
+	"strings"
+)
+
+var filterNames = []string{
+	CatmullRom:      "catmullrom",
+	Lanczos:         "lanczos",
+	BiLinear:        "bilinear",
+	ApproxBiLinear:  "approxbilinear",
+	NearestNeighbor: "nearest",
+	Mitchell:        "mitchell",
+	Box:             "box",
+}
+
+var modeNames = []string{
+	Fit:     "fit",
+	Fill:    "fill",
+	Crop:    "crop",
+	Stretch: "stretch",
+	Pad:     "pad",
+	BlurPad: "blurpad",
+	Carve:   "carve",
+}
+
+var gravityNames = []string{
+	Center:      "center",
+	Top:         "top",
+	Bottom:      "bottom",
+	Left:        "left",
+	Right:       "right",
+	TopLeft:     "top_left",
+	TopRight:    "top_right",
+	BottomLeft:  "bottom_left",
+	BottomRight: "bottom_right",
+	Smart:       "smart",
+}
+
+// gravityAliases holds compass-style gravity names as used by image CDNs,
+// along with their "auto" for Smart.
+var gravityAliases = map[string]Gravity{
+	"north":      Top,
+	"south":      Bottom,
+	"west":       Left,
+	"east":       Right,
+	"north_west": TopLeft,
+	"north_east": TopRight,
+	"south_west": BottomLeft,
+	"south_east": BottomRight,
+	"auto":       Smart,
+}
+
+var subsamplingNames = []string{
+	Subsample420: "4:2:0",
+	Subsample422: "4:2:2",
+	Subsample444: "4:4:4",
+}
+
+var compressionNames = []string{
+	CompressionDefault: "default",
+	CompressionNone:    "none",
+	CompressionFast:    "fast",
+	CompressionBest:    "best",
+}
+
+var quantizerNames = []string{
+	QuantizePlan9:     "plan9",
+	QuantizeMedianCut: "mediancut",
+	QuantizeOctree:    "octree",
+}
+
+var ditherNames = []string{
+	DitherFloydSteinberg: "floydsteinberg",
+	DitherNone:           "none",
+}
+
+// metadataKindNames names the bits of MetadataKind, lowest first.
+var metadataKindNames = []string{"copyright", "exif", "xmp", "icc"}
+
+var frameNames = []string{
+	FrameSolid:  "solid",
+	FrameDouble: "double",
+	FrameRaised: "raised",
+	FrameSunken: "sunken",
+}
+
+var hashKindNames = []string{
+	HashNone:       "none",
+	HashAverage:    "ahash",
+	HashDifference: "dhash",
+	HashPerceptual: "phash",
+}
+
+var collageLayoutNames = []string{
+	CollageGrid:     "grid",
+	CollageFeatured: "featured",
+	CollageMosaic:   "mosaic",
+}
+
+var autoBackgroundNames = []string{
+	AutoBackgroundNone:     "none",
+	AutoBackgroundAverage:  "average",
+	AutoBackgroundDominant: "dominant",
+}
+
+var obscureNames = []string{
+	ObscureNone:     "none",
+	ObscurePixelate: "pixelate",
+	ObscureBlur:     "blur",
+}
+
+var metadataStripNames = []string{
+	StripNone: "none",
+	StripGPS:  "gps",
+	StripAll:  "all",
+}
+
+func (f Filter) String() string  { return enumName(filterNames, int(f), "Filter") }
+func (m Mode) String() string    { return enumName(modeNames, int(m), "Mode") }
+func (g Gravity) String() string { return enumName(gravityNames, int(g), "Gravity") }
+
+func (s Subsampling) String() string {
+	return enumName(subsamplingNames, int(s), "Subsampling")
+}
+
+func (c Compression) String() string {
+	return enumName(compressionNames, int(c), "Compression")
+}
+
+// String returns the kinds in k separated by commas, or "none".
+func (k MetadataKind) String() string {
+	if k == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range metadataKindNames {
+		if k&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := k &^ (1<<uint(len(metadataKindNames)) - 1); rest != 0 {
+		names = append(names, fmt.Sprintf("MetadataKind(%d)", int(rest)))
+	}
+	return strings.Join(names, ",")
+}
+
+func (s MetadataStrip) String() string {
+	return enumName(metadataStripNames, int(s), "MetadataStrip")
+}
+
+func (q Quantizer) String() string { return enumName(quantizerNames, int(q), "Quantizer") }
+func (d Dither) String() string    { return enumName(ditherNames, int(d), "Dither") }
+func (f Frame) String() string     { return enumName(frameNames, int(f), "Frame") }
+
+func (k HashKind) String() string { return enumName(hashKindNames, int(k), "HashKind") }
+
+func (a AutoBackground) String() string {
+	return enumName(autoBackgroundNames, int(a), "AutoBackground")
+}
+
+func (o Obscure) String() string { return enumName(obscureNames, int(o), "Obscure") }
+
+func (l CollageLayout) String() string {
+	return enumName(collageLayoutNames, int(l), "CollageLayout")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (f Filter) MarshalText() ([]byte, error) { return []byte(f.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Mode) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (g Gravity) MarshalText() ([]byte, error) { return []byte(g.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Subsampling) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Compression) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (k MetadataKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (s MetadataStrip) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (q Quantizer) MarshalText() ([]byte, error) { return []byte(q.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Dither) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (f Frame) MarshalText() ([]byte, error) { return []byte(f.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (k HashKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (a AutoBackground) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (o Obscure) MarshalText() ([]byte, error) { return []byte(o.String()), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (l CollageLayout) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (f *Filter) UnmarshalText(text []byte) error {
+	v, err := ParseFilter(string(text))
+	*f = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Mode) UnmarshalText(text []byte) error {
+	v, err := ParseMode(string(text))
+	*m = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (g *Gravity) UnmarshalText(text []byte) error {
+	v, err := ParseGravity(string(text))
+	*g = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Subsampling) UnmarshalText(text []byte) error {
+	v, err := ParseSubsampling(string(text))
+	*s = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Compression) UnmarshalText(text []byte) error {
+	v, err := ParseCompression(string(text))
+	*c = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *MetadataKind) UnmarshalText(text []byte) error {
+	v, err := ParseMetadataKind(string(text))
+	*k = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *MetadataStrip) UnmarshalText(text []byte) error {
+	v, err := ParseMetadataStrip(string(text))
+	*s = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (q *Quantizer) UnmarshalText(text []byte) error {
+	v, err := ParseQuantizer(string(text))
+	*q = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Dither) UnmarshalText(text []byte) error {
+	v, err := ParseDither(string(text))
+	*d = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (f *Frame) UnmarshalText(text []byte) error {
+	v, err := ParseFrame(string(text))
+	*f = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *HashKind) UnmarshalText(text []byte) error {
+	v, err := ParseHashKind(string(text))
+	*k = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *AutoBackground) UnmarshalText(text []byte) error {
+	v, err := ParseAutoBackground(string(text))
+	*a = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *Obscure) UnmarshalText(text []byte) error {
+	v, err := ParseObscure(string(text))
+	*o = v
+	return err
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *CollageLayout) UnmarshalText(text []byte) error {
+	v, err := ParseCollageLayout(string(text))
+	*l = v
+	return err
+}
+
+// ParseFilter returns the filter with the given case-insensitive name, such
+// as "lanczos".
+func ParseFilter(name string) (Filter, error) {
+	i, ok := enumIndex(filterNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidFilter, name)
+	}
+	return Filter(i), nil
+}
+
+// ParseMode returns the mode with the given case-insensitive name, such as
+// "fill".
+func ParseMode(name string) (Mode, error) {
+	i, ok := enumIndex(modeNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidMode, name)
+	}
+	return Mode(i), nil
+}
+
+// ParseGravity returns the gravity with the given case-insensitive name.
+// Both position names ("top_left") and compass names ("north_west") are
+// accepted, and "auto" as well as "smart" for Smart.
+func ParseGravity(name string) (Gravity, error) {
+	if g, ok := gravityAliases[strings.ToLower(name)]; ok {
+		return g, nil
+	}
+	i, ok := enumIndex(gravityNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidGravity, name)
+	}
+	return Gravity(i), nil
+}
+
+// ParseSubsampling returns the chroma subsampling with the given name, such
+// as "4:4:4"; the colons may be omitted.
+func ParseSubsampling(name string) (Subsampling, error) {
+	for i, n := range subsamplingNames {
+		if name == n || name == strings.ReplaceAll(n, ":", "") {
+			return Subsampling(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidSubsampling, name)
+}
+
+// ParseCompression returns the compression level with the given
+// case-insensitive name, such as "best".
+func ParseCompression(name string) (Compression, error) {
+	i, ok := enumIndex(compressionNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidCompression, name)
+	}
+	return Compression(i), nil
+}
+
+// ParseQuantizer returns the quantizer with the given case-insensitive
+// name, such as "octree".
+func ParseQuantizer(name string) (Quantizer, error) {
+	i, ok := enumIndex(quantizerNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidQuantizer, name)
+	}
+	return Quantizer(i), nil
+}
+
+// ParseDither returns the dithering method with the given case-insensitive
+// name, such as "none".
+func ParseDither(name string) (Dither, error) {
+	i, ok := enumIndex(ditherNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidDither, name)
+	}
+	return Dither(i), nil
+}
+
+// ParseFrame returns the frame style with the given case-insensitive name,
+// such as "double".
+func ParseFrame(name string) (Frame, error) {
+	i, ok := enumIndex(frameNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidFrame, name)
+	}
+	return Frame(i), nil
+}
+
+// ParseHashKind returns the hash kind with the given case-insensitive
+// name, such as "phash".
+func ParseHashKind(name string) (HashKind, error) {
+	i, ok := enumIndex(hashKindNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidHashKind, name)
+	}
+	return HashKind(i), nil
+}
+
+// ParseAutoBackground returns the automatic background with the given
+// case-insensitive name, such as "dominant".
+func ParseAutoBackground(name string) (AutoBackground, error) {
+	i, ok := enumIndex(autoBackgroundNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAutoBackground, name)
+	}
+	return AutoBackground(i), nil
+}
+
+// ParseObscure returns the obscuring method with the given
+// case-insensitive name, such as "pixelate".
+func ParseObscure(name string) (Obscure, error) {
+	i, ok := enumIndex(obscureNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidObscure, name)
+	}
+	return Obscure(i), nil
+}
+
+// ParseCollageLayout returns the collage layout with the given
+// case-insensitive name, such as "mosaic".
+func ParseCollageLayout(name string) (CollageLayout, error) {
+	i, ok := enumIndex(collageLayoutNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidCollage, name)
+	}
+	return CollageLayout(i), nil
+}
+
+// ParseMetadataKind returns the set of metadata kinds named in a
+// comma-separated, case-insensitive list such as "copyright,icc". The
+// names "all" and "none" are also accepted.
+func ParseMetadataKind(list string) (MetadataKind, error) {
+	var k MetadataKind
+	for _, name := range strings.Split(list, ",") {
+		switch name = strings.TrimSpace(strings.ToLower(name)); name {
+		case "all":
+			k |= MetadataAll
+		case "none", "":
+		default:
+			i, ok := enumIndex(metadataKindNames, name)
+			if !ok {
+				return 0, fmt.Errorf("%w %q", ErrInvalidMetadataKind, name)
+			}
+			k |= 1 << uint(i)
+		}
+	}
+	return k, nil
+}
+
+// ParseMetadataStrip returns the strip mode with the given
+// case-insensitive name, such as "gps".
+func ParseMetadataStrip(name string) (MetadataStrip, error) {
+	i, ok := enumIndex(metadataStripNames, name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrInvalidMetadataStrip, name)
+	}
+	return MetadataStrip(i), nil
+}
+
+func enumName(names []string, i int, typ string) string {
+	if i >= 0 && i < len(names) {
+		return names[i]
+	}
+	return fmt.Sprintf("%s(%d)", typ, i)
+}
+
+func enumIndex(names []string, name string) (int, bool) {
+	name = strings.ToLower(name)
+	for i, n := range names {
+		if n == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
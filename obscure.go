@@ -0,0 +1,53 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Obscure selects how Options.Obscure makes a thumbnail unrecognizable,
+// as for content pending moderation.
+type Obscure int
+
+const (
+	// ObscureNone leaves the thumbnail as it is. It is the default.
+	ObscureNone Obscure = iota
+	// ObscurePixelate reduces the thumbnail to a mosaic of flat squares.
+	ObscurePixelate
+	// ObscureBlur blurs the thumbnail heavily.
+	ObscureBlur
+)
+
+// obscureCells is the number of mosaic squares, or of pixels the blur is
+// computed at, along the longer side of an obscured thumbnail, whatever
+// its size: too few to make out faces or text.
+const obscureCells = 12
+
+// valid reports whether o is a known obscuring method.
+func (o Obscure) valid() bool {
+	return o >= ObscureNone && o <= ObscureBlur
+}
+
+// obscure makes the r part of dst unrecognizable as opts.Obscure selects.
+func obscure(ctx context.Context, dst draw.Image, r image.Rectangle, opts Options) error {
+	if opts.Obscure == ObscureNone || r.Empty() {
+		return nil
+	}
+	cell := maxInt(1, ceilDiv(maxInt(r.Dx(), r.Dy()), obscureCells))
+	small := image.NewRGBA(image.Rect(0, 0, ceilDiv(r.Dx(), cell), ceilDiv(r.Dy(), cell)))
+	// Box averages the pixels of each cell, which become the squares of
+	// the mosaic.
+	box.Scale(small, small.Rect, dst, r, draw.Src, nil)
+	if opts.Obscure == ObscurePixelate {
+		draw.NearestNeighbor.Scale(dst, r, small, small.Rect, draw.Src, nil)
+		return nil
+	}
+	blurred, err := gaussianBlur(ctx, small, 1)
+	if err != nil {
+		return err
+	}
+	draw.BiLinear.Scale(dst, r, blurred, blurred.Rect, draw.Src, nil)
+	return nil
+}
@@ -0,0 +1,396 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Option configures thumbnail generation. Options are applied in order on
+// top of DefaultOptions, so later options win.
+type Option func(*Options)
+
+// NewOptions returns DefaultOptions with opts applied.
+func NewOptions(opts ...Option) Options {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithSize sets the bounding box the thumbnail must fit within.
+func WithSize(width, height int) Option {
+	return func(o *Options) {
+		o.Width = width
+		o.Height = height
+	}
+}
+
+// WithQuality sets the JPEG quality (1-100).
+func WithQuality(quality int) Option {
+	return func(o *Options) {
+		o.Quality = quality
+	}
+}
+
+// WithFilter sets the interpolation filter used for scaling.
+func WithFilter(f Filter) Option {
+	return func(o *Options) {
+		o.Filter = f
+	}
+}
+
+// WithScaler sets a custom scaler used instead of the Filter.
+func WithScaler(s draw.Scaler) Option {
+	return func(o *Options) {
+		o.Scaler = s
+	}
+}
+
+// WithMode sets how the source is fitted to the requested box.
+func WithMode(m Mode) Option {
+	return func(o *Options) {
+		o.Mode = m
+	}
+}
+
+// WithGravity sets the anchor used when cropping or padding.
+func WithGravity(g Gravity) Option {
+	return func(o *Options) {
+		o.Gravity = g
+	}
+}
+
+// WithFocalPoint keeps the point (x, y), given as fractions of the source
+// width and height, in frame when cropping.
+func WithFocalPoint(x, y float64) Option {
+	return func(o *Options) {
+		o.Focus = &Focus{X: x, Y: y}
+	}
+}
+
+// WithFocusRect keeps r, in source pixels, in frame when cropping.
+func WithFocusRect(r image.Rectangle) Option {
+	return func(o *Options) {
+		o.Focus = &Focus{Rect: r}
+	}
+}
+
+// WithDetector keeps the regions of interest d finds in frame when
+// cropping.
+func WithDetector(d Detector) Option {
+	return func(o *Options) {
+		o.Detector = d
+	}
+}
+
+// WithLinearLight resamples in linear light.
+func WithLinearLight() Option {
+	return func(o *Options) {
+		o.LinearLight = true
+	}
+}
+
+// WithParallelism scales on up to n goroutines.
+func WithParallelism(n int) Option {
+	return func(o *Options) {
+		o.Parallelism = n
+	}
+}
+
+// WithNoPrefilter applies the filter to the whole source, without box
+// shrinking it first.
+func WithNoPrefilter() Option {
+	return func(o *Options) {
+		o.NoPrefilter = true
+	}
+}
+
+// WithNoUpscale keeps sources smaller than the box at their native size.
+func WithNoUpscale() Option {
+	return func(o *Options) {
+		o.NoUpscale = true
+	}
+}
+
+// WithBackground sets the canvas color used around the source in Pad mode.
+func WithBackground(c color.Color) Option {
+	return func(o *Options) {
+		o.Background = c
+	}
+}
+
+// WithAutoBackground pads with a color sampled from the source's edges,
+// their average or their dominant color as a says.
+func WithAutoBackground(a AutoBackground) Option {
+	return func(o *Options) {
+		o.AutoBackground = a
+	}
+}
+
+// WithBorder frames the thumbnail with a border of width pixels in color c
+// and the given style, within the box.
+func WithBorder(width int, c color.Color, style Frame) Option {
+	return func(o *Options) {
+		o.Border = width
+		o.BorderColor = c
+		o.Frame = style
+	}
+}
+
+// WithCornerRadius rounds the corners of the thumbnail with radius r.
+func WithCornerRadius(r int) Option {
+	return func(o *Options) {
+		o.CornerRadius = r
+	}
+}
+
+// WithCircle cuts the thumbnail to a circle.
+func WithCircle() Option {
+	return func(o *Options) {
+		o.Circle = true
+	}
+}
+
+// WithObscure makes the thumbnail unrecognizable with method m.
+func WithObscure(m Obscure) Option {
+	return func(o *Options) {
+		o.Obscure = m
+	}
+}
+
+// WithDominantColor reports the thumbnail's dominant color in the Result.
+func WithDominantColor() Option {
+	return func(o *Options) {
+		o.DominantColor = true
+	}
+}
+
+// WithBlurHash reports the thumbnail's BlurHash in the Result.
+func WithBlurHash() Option {
+	return func(o *Options) {
+		o.BlurHash = true
+	}
+}
+
+// WithThumbHash reports the thumbnail's ThumbHash in the Result.
+func WithThumbHash() Option {
+	return func(o *Options) {
+		o.ThumbHash = true
+	}
+}
+
+// WithLQIP reports an LQIP of the thumbnail in the given format in the
+// Result.
+func WithLQIP(format string) Option {
+	return func(o *Options) {
+		o.LQIP = format
+	}
+}
+
+// WithHash reports a perceptual hash of the thumbnail of kind k in the
+// Result.
+func WithHash(k HashKind) Option {
+	return func(o *Options) {
+		o.Hash = k
+	}
+}
+
+// WithMatte sets the color JPEG output flattens transparency onto.
+func WithMatte(c color.Color) Option {
+	return func(o *Options) {
+		o.Matte = c
+	}
+}
+
+// WithScale sizes the thumbnail as a fraction of the source dimensions.
+func WithScale(factor float64) Option {
+	return func(o *Options) {
+		o.Scale = factor
+	}
+}
+
+// WithFormat sets the output format used by Encode and Save.
+func WithFormat(format string) Option {
+	return func(o *Options) {
+		o.Format = format
+	}
+}
+
+// WithPreserveColorModel keeps grayscale and paletted sources in their
+// original color model.
+func WithPreserveColorModel() Option {
+	return func(o *Options) {
+		o.PreserveColorModel = true
+	}
+}
+
+// WithPassthrough returns sources that already fit within the box
+// unchanged.
+func WithPassthrough() Option {
+	return func(o *Options) {
+		o.Passthrough = true
+	}
+}
+
+// WithAspectRatio sets the width/height ratio of the thumbnail.
+func WithAspectRatio(ratio float64) Option {
+	return func(o *Options) {
+		o.AspectRatio = ratio
+	}
+}
+
+// WithNoAutoOrient leaves sources as stored, ignoring their EXIF
+// orientation.
+func WithNoAutoOrient() Option {
+	return func(o *Options) {
+		o.NoAutoOrient = true
+	}
+}
+
+// WithNoColorConvert leaves sources with an RGB ICC profile in their own
+// color space instead of converting them to sRGB.
+func WithNoColorConvert() Option {
+	return func(o *Options) {
+		o.NoColorConvert = true
+	}
+}
+
+// WithHighBitDepth keeps 16-bit sources at 16 bits per channel.
+func WithHighBitDepth() Option {
+	return func(o *Options) {
+		o.HighBitDepth = true
+	}
+}
+
+// WithCMYKProfile converts CMYK JPEG sources without an embedded profile
+// through p.
+func WithCMYKProfile(p *ICCProfile) Option {
+	return func(o *Options) {
+		o.CMYKProfile = p
+	}
+}
+
+// WithEmbeddedThumbnail lets JPEG sources be decoded from their EXIF
+// thumbnail when it is large enough.
+func WithEmbeddedThumbnail() Option {
+	return func(o *Options) {
+		o.EmbeddedThumbnail = true
+	}
+}
+
+// WithNoDraft decodes JPEG sources at full size instead of reduced.
+func WithNoDraft() Option {
+	return func(o *Options) {
+		o.NoDraft = true
+	}
+}
+
+// WithKeepMetadata carries the given kinds of source metadata into saved
+// thumbnails.
+func WithKeepMetadata(k MetadataKind) Option {
+	return func(o *Options) {
+		o.KeepMetadata = k
+	}
+}
+
+// WithStripMetadata removes metadata from output as s selects.
+func WithStripMetadata(s MetadataStrip) Option {
+	return func(o *Options) {
+		o.StripMetadata = s
+	}
+}
+
+// WithLossless asks for lossless output from formats that support it.
+func WithLossless() Option {
+	return func(o *Options) {
+		o.Lossless = true
+	}
+}
+
+// WithProgressive asks for progressive JPEG output.
+func WithProgressive() Option {
+	return func(o *Options) {
+		o.Progressive = true
+	}
+}
+
+// WithSubsampling sets the chroma subsampling of JPEG output.
+func WithSubsampling(s Subsampling) Option {
+	return func(o *Options) {
+		o.Subsampling = s
+	}
+}
+
+// WithCompression sets how hard PNG output is compressed.
+func WithCompression(c Compression) Option {
+	return func(o *Options) {
+		o.Compression = c
+	}
+}
+
+// WithPalette asks for PNG output quantized to a palette of at most 256
+// colors.
+func WithPalette() Option {
+	return func(o *Options) {
+		o.Palette = true
+	}
+}
+
+// WithQuantizer sets how the palette of GIF output is chosen.
+func WithQuantizer(q Quantizer) Option {
+	return func(o *Options) {
+		o.Quantizer = q
+	}
+}
+
+// WithDither sets how GIF output is dithered.
+func WithDither(d Dither) Option {
+	return func(o *Options) {
+		o.Dither = d
+	}
+}
+
+// WithMaxFrames limits animated thumbnails to n frames.
+func WithMaxFrames(n int) Option {
+	return func(o *Options) {
+		o.MaxFrames = n
+	}
+}
+
+// WithPage selects the page of a multi-page source, numbered from 1.
+func WithPage(page int) Option {
+	return func(o *Options) {
+		o.Page = page
+	}
+}
+
+// WithMaxDuration limits animated thumbnails to their first d of playback.
+func WithMaxDuration(d time.Duration) Option {
+	return func(o *Options) {
+		o.MaxDuration = d
+	}
+}
+
+// WithMaxPixels rejects sources of more than n pixels before decoding them.
+func WithMaxPixels(n int) Option {
+	return func(o *Options) {
+		o.MaxPixels = n
+	}
+}
+
+// WithMaxSourceSize rejects sources wider than width or taller than
+// height before decoding them. Zero leaves a side unlimited.
+func WithMaxSourceSize(width, height int) Option {
+	return func(o *Options) {
+		o.MaxWidth, o.MaxHeight = width, height
+	}
+}
+
+// GenerateWith is like Generate but is configured with functional options.
+func GenerateWith(src image.Image, opts ...Option) (image.Image, error) {
+	return Generate(src, NewOptions(opts...))
+}
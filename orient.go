@@ -0,0 +1,97 @@
+package thumbnail
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// orient returns img transformed as EXIF orientation o requires for it to
+// display upright: mirrored for 2 and 4, rotated for 3, 6 and 8, and both
+// for 5 and 7. Images that are already upright are returned unchanged.
+// Gray, RGBA, CMYK and paletted images keep their type, at any depth;
+// others are converted to *image.RGBA.
+func orient(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	r := image.Rect(0, 0, dw, dh)
+	switch src := img.(type) {
+	case *image.Gray:
+		dst := image.NewGray(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 1, w, h, o)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 2, w, h, o)
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 4, w, h, o)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 8, w, h, o)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 8, w, h, o)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 4, w, h, o)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(r, src.Palette)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, 1, w, h, o)
+		return dst
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	dst := image.NewRGBA(r)
+	orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.Stride, 4, w, h, o)
+	return dst
+}
+
+// orientPix copies the w×h source pixels of bpp bytes each in src into
+// dst, transformed for orientation o. Each destination pixel (x, y) is
+// read from the source pixel that maps to it.
+func orientPix(dst []byte, dstStride int, src []byte, srcStride, bpp, w, h, o int) {
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	for y := 0; y < dh; y++ {
+		d := dst[y*dstStride:]
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			s := sy*srcStride + sx*bpp
+			copy(d[x*bpp:x*bpp+bpp], src[s:s+bpp])
+		}
+	}
+}
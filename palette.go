@@ -0,0 +1,54 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// paletteSize is the size of the longer side of the reduced copy of an
+// image that Palette takes colors from.
+const paletteSize = 128
+
+// Palette returns up to n colors that represent img, most common first, for
+// theming a UI around it as Android's Palette API does. The colors are
+// found by median cut on a reduced copy of img, which also smooths away
+// noise, and are opaque; pixels less than half opaque are ignored. Images
+// with fewer distinct colors than n give fewer colors.
+func Palette(img image.Image, n int) color.Palette {
+	b := img.Bounds()
+	if n <= 0 || b.Empty() {
+		return nil
+	}
+	scale := math.Min(1, paletteSize/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	small := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Rect, img, b, draw.Src, nil)
+
+	hist := make(map[[4]uint8]int)
+	for i := 0; i < len(small.Pix); i += 4 {
+		p := small.Pix[i : i+4 : i+4]
+		if p[3] >= 0x80 {
+			hist[[4]uint8{p[0], p[1], p[2], 0xff}]++
+		}
+	}
+	if len(hist) == 0 {
+		return nil
+	}
+	box := quantizeBox{entries: make([]quantizeEntry, 0, len(hist))}
+	for c, count := range hist {
+		box.entries = append(box.entries, quantizeEntry{c, count})
+		box.count += count
+	}
+	boxes := splitBoxes(box, n)
+	sort.SliceStable(boxes, func(i, j int) bool { return boxes[i].count > boxes[j].count })
+	pal := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		pal[i] = box.mean()
+	}
+	return pal
+}
@@ -0,0 +1,162 @@
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Hash is a 64-bit perceptual hash of an image: similar images have hashes
+// that differ in few bits, so that duplicates survive resizing,
+// recompression and small edits.
+type Hash uint64
+
+// Distance returns the number of bits in which h and o differ. Hashes of
+// the same kind 10 or fewer bits apart usually belong to the same picture.
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// String returns h as 16 hexadecimal digits.
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (h Hash) MarshalText() ([]byte, error) { return []byte(h.String()), nil }
+
+// HashKind selects the perceptual hash Options.Hash reports.
+type HashKind int
+
+const (
+	// HashNone computes no hash. It is the default.
+	HashNone HashKind = iota
+	// HashAverage selects AverageHash.
+	HashAverage
+	// HashDifference selects DifferenceHash.
+	HashDifference
+	// HashPerceptual selects PerceptualHash.
+	HashPerceptual
+)
+
+// valid reports whether k is a known hash kind.
+func (k HashKind) valid() bool {
+	return k >= HashNone && k <= HashPerceptual
+}
+
+// hash returns the hash of kind k of img.
+func (k HashKind) hash(img image.Image) Hash {
+	switch k {
+	case HashAverage:
+		return AverageHash(img)
+	case HashDifference:
+		return DifferenceHash(img)
+	case HashPerceptual:
+		return PerceptualHash(img)
+	}
+	return 0
+}
+
+// AverageHash returns the average hash (aHash) of img: each bit tells
+// whether a cell of an 8×8 grid over the image is lighter than the mean.
+// It is the cheapest hash but the most easily fooled by changes of
+// brightness or contrast.
+func AverageHash(img image.Image) Hash {
+	lum := hashLuma(img, 8, 8)
+	var mean float64
+	for _, v := range lum {
+		mean += v
+	}
+	mean /= float64(len(lum))
+	return hashBits(func(i int) bool { return lum[i] > mean })
+}
+
+// DifferenceHash returns the difference hash (dHash) of img: each bit
+// tells whether a cell of a 9×8 grid over the image is lighter than the
+// one to its left. It follows gradients rather than levels, so is robust
+// to changes of brightness.
+func DifferenceHash(img image.Image) Hash {
+	lum := hashLuma(img, 9, 8)
+	var h Hash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if lum[y*9+x+1] > lum[y*9+x] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// PerceptualHash returns the perceptual hash (pHash) of img: each bit
+// tells whether one of the 8×8 lowest frequencies of the discrete cosine
+// transform of a 32×32 copy of the image is above their median. It is the
+// slowest hash and the most robust, including to gamma changes and
+// compression artifacts.
+func PerceptualHash(img image.Image) Hash {
+	const n, k = 32, 8
+	lum := hashLuma(img, n, n)
+	// The DCT-II of the rows then the columns, keeping the low k
+	// frequencies of each.
+	var cos [k][n]float64
+	for u := 0; u < k; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(math.Pi * float64(u) * (2*float64(x) + 1) / (2 * n))
+		}
+	}
+	rows := make([]float64, n*k)
+	for y := 0; y < n; y++ {
+		for u := 0; u < k; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += lum[y*n+x] * cos[u][x]
+			}
+			rows[y*k+u] = s
+		}
+	}
+	freq := make([]float64, k*k)
+	for v := 0; v < k; v++ {
+		for u := 0; u < k; u++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y*k+u] * cos[v][y]
+			}
+			freq[v*k+u] = s
+		}
+	}
+	sorted := append([]float64(nil), freq...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	return hashBits(func(i int) bool { return freq[i] > median })
+}
+
+// hashLuma returns the luma of img reduced to w×h, row by row. Transparent
+// pixels count as black.
+func hashLuma(img image.Image, w, h int) []float64 {
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Rect, img, img.Bounds(), draw.Src, nil)
+	lum := make([]float64, w*h)
+	for i := range lum {
+		p := small.Pix[4*i : 4*i+4]
+		lum[i] = float64(luma(p[0], p[1], p[2]))
+	}
+	return lum
+}
+
+// hashBits returns the hash whose bits, most significant first, are set
+// where bit reports so for the indexes 0 to 63.
+func hashBits(bit func(i int) bool) Hash {
+	var h Hash
+	for i := 0; i < 64; i++ {
+		h <<= 1
+		if bit(i) {
+			h |= 1
+		}
+	}
+	return h
+}
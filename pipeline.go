@@ -0,0 +1,166 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"io"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// Step is a single operation in a Pipeline. It receives the output of the
+// previous step and returns the image passed to the next.
+type Step interface {
+	Apply(ctx context.Context, img image.Image) (image.Image, error)
+}
+
+// StepFunc adapts an ordinary function to the Step interface.
+type StepFunc func(ctx context.Context, img image.Image) (image.Image, error)
+
+// Apply calls f(ctx, img).
+func (f StepFunc) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	return f(ctx, img)
+}
+
+// Pipeline runs a sequence of steps, such as resizing, cropping and
+// encoding, on a decoded image. Operations are added as steps rather than
+// as Options fields.
+type Pipeline struct {
+	steps []Step
+}
+
+// NewPipeline returns a Pipeline that runs steps in order.
+func NewPipeline(steps ...Step) *Pipeline {
+	return &Pipeline{steps: append([]Step(nil), steps...)}
+}
+
+// Then appends steps to p and returns p, so calls can be chained.
+func (p *Pipeline) Then(steps ...Step) *Pipeline {
+	p.steps = append(p.steps, steps...)
+	return p
+}
+
+// Run applies the steps of p to img in order, stopping at the first error
+// or once ctx is done.
+func (p *Pipeline) Run(ctx context.Context, img image.Image) (image.Image, error) {
+	if img == nil {
+		return nil, ErrNilImage
+	}
+	for _, s := range p.steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var err error
+		if img, err = s.Apply(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// RunReader decodes an image from r and runs p on it. It also returns the
+// detected source format name.
+func (p *Pipeline) RunReader(ctx context.Context, r io.Reader) (image.Image, string, error) {
+	// Steps that scale take slots of their own.
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	src, format, err := decodeFor(ctx, r, nil)
+	release()
+	if err != nil {
+		return nil, "", err
+	}
+	img, err := p.Run(ctx, src)
+	if err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+// RunFile reads an image file and runs p on it.
+func (p *Pipeline) RunFile(ctx context.Context, path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := p.RunReader(ctx, f)
+	return img, err
+}
+
+// Resize returns a step that generates a thumbnail as GenerateContext does.
+func Resize(opts Options) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return GenerateContext(ctx, img, opts)
+	})
+}
+
+// CropRect returns a step that crops the image to r, given in the image's
+// coordinate space. It fails with ErrEmptyImage if r does not overlap the
+// image.
+func CropRect(r image.Rectangle) Step {
+	return StepFunc(func(_ context.Context, img image.Image) (image.Image, error) {
+		return cropImage(img, r)
+	})
+}
+
+// Rotate returns a step that rotates the image clockwise by degrees, a
+// multiple of 90 such as a rotation users asked for and had stored;
+// negative values rotate counterclockwise. It is independent of the EXIF
+// orientation, which decoding has already applied. Other angles fail with
+// ErrInvalidRotation. Like orientation, rotation keeps the type of common
+// images and converts others to *image.RGBA.
+func Rotate(degrees int) Step {
+	return StepFunc(func(_ context.Context, img image.Image) (image.Image, error) {
+		if degrees%90 != 0 {
+			return nil, ErrInvalidRotation
+		}
+		// The EXIF orientations that rotate by 90, 180 and 270 degrees.
+		return orient(img, [4]int{1, 6, 3, 8}[(degrees/90%4+4)%4]), nil
+	})
+}
+
+// FlipHorizontal returns a step that mirrors the image left to right.
+func FlipHorizontal() Step {
+	return StepFunc(func(_ context.Context, img image.Image) (image.Image, error) {
+		return orient(img, 2), nil
+	})
+}
+
+// FlipVertical returns a step that mirrors the image top to bottom.
+func FlipVertical() Step {
+	return StepFunc(func(_ context.Context, img image.Image) (image.Image, error) {
+		return orient(img, 4), nil
+	})
+}
+
+// EncodeTo returns a step that writes the image to w as Encode does and
+// passes it on unchanged, typically as the last step of a pipeline.
+func EncodeTo(w io.Writer, opts Options) Step {
+	return StepFunc(func(_ context.Context, img image.Image) (image.Image, error) {
+		if err := Encode(w, img, opts); err != nil {
+			return nil, err
+		}
+		return img, nil
+	})
+}
+
+// cropImage returns the part of img within r, sharing pixels with img when
+// it supports SubImage.
+func cropImage(img image.Image, r image.Rectangle) (image.Image, error) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return nil, ErrEmptyImage
+	}
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r), nil
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: r.Size()})
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst, nil
+}
@@ -0,0 +1,65 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+)
+
+// Compression selects how hard lossless encoders such as PNG's work to
+// make output small.
+type Compression int
+
+const (
+	// CompressionDefault balances speed and size. It is the default.
+	CompressionDefault Compression = iota
+	// CompressionNone stores data uncompressed.
+	CompressionNone
+	// CompressionFast compresses quickly at some cost in size.
+	CompressionFast
+	// CompressionBest compresses as much as possible, more slowly.
+	CompressionBest
+)
+
+// valid reports whether c is a known compression level.
+func (c Compression) valid() bool {
+	return c >= CompressionDefault && c <= CompressionBest
+}
+
+// pngLevels maps each Compression to the image/png level.
+var pngLevels = []png.CompressionLevel{
+	CompressionDefault: png.DefaultCompression,
+	CompressionNone:    png.NoCompression,
+	CompressionFast:    png.BestSpeed,
+	CompressionBest:    png.BestCompression,
+}
+
+// SavePNGOptions writes img to w as PNG at opts.Compression. With
+// opts.Palette it first quantizes img to an 8-bit palette of at most 256
+// colors, transparency included, by median cut; images that already have
+// no more colors than that keep them exactly. Paletted images are written
+// with their own palette either way. opts.Metadata is written after the
+// header.
+func SavePNGOptions(img image.Image, w io.Writer, opts Options) error {
+	if opts.Palette {
+		if _, ok := img.(*image.Paletted); !ok && !img.Bounds().Empty() {
+			src := toNRGBA(img)
+			img = toPalette(src, medianCut(src, 256))
+		}
+	}
+	enc := png.Encoder{CompressionLevel: png.DefaultCompression}
+	if opts.Compression.valid() {
+		enc.CompressionLevel = pngLevels[opts.Compression]
+	}
+	md := opts.Metadata.strip(opts.StripMetadata)
+	if md == nil {
+		return enc.Encode(w, img)
+	}
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, img); err != nil {
+		return err
+	}
+	_, err := w.Write(insertPNGMetadata(buf.Bytes(), md))
+	return err
+}
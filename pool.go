@@ -0,0 +1,101 @@
+package thumbnail
+
+import (
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// minClassBits is the log2 of the capacity of the smallest pooled buffer.
+const minClassBits = 12
+
+// pixelPool recycles pixel buffers by size class. Classes step by a
+// quarter of a power of two, so that a buffer serves any request of its
+// class while holding at most a quarter more bytes than asked for.
+type pixelPool struct {
+	classes [4 * (64 - minClassBits)]sync.Pool // of []byte
+}
+
+// scratch pools the intermediate images of scaling, which do not outlive
+// a call.
+var scratch pixelPool
+
+// sizeClass returns the class of buffers of n bytes and their capacity.
+func sizeClass(n int) (class, size int) {
+	if n <= 1<<minClassBits {
+		return 0, 1 << minClassBits
+	}
+	e := bits.Len(uint(n - 1)) // 2^(e-1) < n <= 2^e
+	step := 1 << uint(e-3)
+	k := ceilDiv(n-1<<uint(e-1), step)
+	return 4*(e-1-minClassBits) + k, 1<<uint(e-1) + k*step
+}
+
+// get returns a buffer of n bytes with unspecified contents.
+func (p *pixelPool) get(n int) []byte {
+	c, size := sizeClass(n)
+	if b, ok := p.classes[c].Get().([]byte); ok {
+		return b[:n]
+	}
+	return make([]byte, n, size)
+}
+
+// put returns b to p. It goes to the largest class its capacity fills, so
+// buffers allocated elsewhere may be put too.
+func (p *pixelPool) put(b []byte) {
+	c, size := sizeClass(cap(b))
+	if size > cap(b) {
+		if c == 0 {
+			return
+		}
+		c--
+	}
+	p.classes[c].Put(b[:0])
+}
+
+// rgba returns an *image.RGBA with bounds r, transparent black when clear
+// is set and with unspecified contents otherwise.
+func (p *pixelPool) rgba(r image.Rectangle, clear bool) *image.RGBA {
+	m := &image.RGBA{Pix: p.get(4 * r.Dx() * r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+	if clear {
+		clearBytes(m.Pix)
+	}
+	return m
+}
+
+// rgba64 is like rgba for an *image.RGBA64.
+func (p *pixelPool) rgba64(r image.Rectangle, clear bool) *image.RGBA64 {
+	m := &image.RGBA64{Pix: p.get(8 * r.Dx() * r.Dy()), Stride: 8 * r.Dx(), Rect: r}
+	if clear {
+		clearBytes(m.Pix)
+	}
+	return m
+}
+
+// release returns the pixels of img to p if it is one of the image
+// package's types, which hold them in a single buffer. img must not be
+// used afterwards.
+func (p *pixelPool) release(img image.Image) {
+	switch m := img.(type) {
+	case *image.RGBA:
+		p.put(m.Pix)
+	case *image.RGBA64:
+		p.put(m.Pix)
+	case *image.NRGBA:
+		p.put(m.Pix)
+	case *image.NRGBA64:
+		p.put(m.Pix)
+	case *image.Gray:
+		p.put(m.Pix)
+	case *image.Gray16:
+		p.put(m.Pix)
+	case *image.Paletted:
+		p.put(m.Pix)
+	}
+}
+
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,78 @@
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownPreset is returned when no preset is registered under a name.
+var ErrUnknownPreset = errors.New("thumbnail: unknown preset")
+
+var presets = struct {
+	sync.RWMutex
+	m map[string]Options
+}{m: make(map[string]Options)}
+
+// RegisterPreset registers opts under name so that call sites can share
+// sizes and quality by name. Registering a name again replaces the earlier
+// preset. It returns an error if opts are invalid.
+func RegisterPreset(name string, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("thumbnail: preset %q: %w", name, err)
+	}
+	presets.Lock()
+	presets.m[name] = opts
+	presets.Unlock()
+	return nil
+}
+
+// LookupPreset returns the options registered under name.
+func LookupPreset(name string) (Options, bool) {
+	presets.RLock()
+	opts, ok := presets.m[name]
+	presets.RUnlock()
+	return opts, ok
+}
+
+// Presets returns the names of all registered presets in sorted order.
+func Presets() []string {
+	presets.RLock()
+	names := make([]string, 0, len(presets.m))
+	for name := range presets.m {
+		names = append(names, name)
+	}
+	presets.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// GeneratePreset generates a thumbnail using the preset registered under
+// name.
+func GeneratePreset(src image.Image, name string) (image.Image, error) {
+	opts, err := preset(name)
+	if err != nil {
+		return nil, err
+	}
+	return Generate(src, opts)
+}
+
+// GeneratePresetFromFile reads an image file and generates a thumbnail
+// using the preset registered under name.
+func GeneratePresetFromFile(path, name string) (image.Image, error) {
+	opts, err := preset(name)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateFromFile(path, opts)
+}
+
+func preset(name string) (Options, error) {
+	opts, ok := LookupPreset(name)
+	if !ok {
+		return Options{}, fmt.Errorf("%w %q", ErrUnknownPreset, name)
+	}
+	return opts, nil
+}
@@ -0,0 +1,112 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// Info describes an encoded image as reported by Probe.
+type Info struct {
+	Width  int
+	Height int
+	Format string
+
+	// Orientation is the EXIF orientation, from 1 (stored upright) to 8.
+	// It is 1 when the image carries no orientation.
+	Orientation int
+
+	// Pages is the number of pages of a multi-page TIFF, which
+	// Options.Page selects from, and 1 for other images. Width and Height
+	// are those of the first page.
+	Pages int
+}
+
+// OrientedSize returns the dimensions of the image once its orientation
+// has been applied: orientations 5 to 8 swap width and height.
+func (i Info) OrientedSize() (width, height int) {
+	if i.Orientation >= 5 {
+		return i.Height, i.Width
+	}
+	return i.Width, i.Height
+}
+
+// Probe reads only as much of r as needed to report the image dimensions,
+// format and orientation, without decoding any pixels. It lets callers
+// reject oversized inputs and plan target sizes before a full decode.
+func Probe(r io.Reader) (Info, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, _ := br.Peek(sniffLen)
+	if format, rend := lookupRenderer(header); rend != nil {
+		// Documents are read whole; there is no cheaper way to size them.
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return Info{}, err
+		}
+		w, h, err := rend.Size(data)
+		if err != nil {
+			return Info{}, err
+		}
+		return Info{Width: w, Height: h, Format: format, Orientation: 1, Pages: 1}, nil
+	}
+
+	var src io.Reader = br
+	pages := 1
+	if _, _, err := newTIFFReader(header); err == nil {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return Info{}, err
+		}
+		if format := rawFormat(data); format != "" {
+			// Report the embedded preview, which is what gets decoded
+			// unless a RAWDecoder is set.
+			preview, orientation := rawPreview(data)
+			if preview == nil {
+				return Info{}, errNoPreview(format)
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+			if err != nil {
+				return Info{}, err
+			}
+			return Info{Width: cfg.Width, Height: cfg.Height, Format: format, Orientation: orientation, Pages: 1}, nil
+		}
+		pages = len(tiffIFDs(data))
+		if t, _, _ := newTIFFReader(data); t.big {
+			// image/tiff does not read BigTIFF headers.
+			w, h, ok := tiffSize(data)
+			if !ok {
+				return Info{}, errBadTIFF
+			}
+			return Info{Width: w, Height: h, Format: TIFF, Orientation: 1, Pages: pages}, nil
+		}
+		src = bytes.NewReader(data)
+	}
+
+	var head bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(src, &head))
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{Width: cfg.Width, Height: cfg.Height, Format: format, Orientation: 1, Pages: pages}
+	if format == JPEG {
+		// The metadata segments precede the frame header, so DecodeConfig
+		// has already read past any EXIF data.
+		info.Orientation = exifOrientation(jpegEXIF(head.Bytes()))
+	}
+	return info, nil
+}
+
+// ProbeFile is like Probe but reads the named file.
+func ProbeFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	return Probe(f)
+}
@@ -0,0 +1,284 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// quantizeEntry is one distinct color of an image and its pixel count.
+type quantizeEntry struct {
+	c     [4]uint8 // non-premultiplied R, G, B, A
+	count int
+}
+
+// quantizeBox is a set of histogram entries that median cut splits until
+// there are as many boxes as palette colors.
+type quantizeBox struct {
+	entries []quantizeEntry
+	count   int
+}
+
+// colorHistogram counts the pixels of each distinct color of img other
+// than fully transparent ones, and reports whether there were any of
+// those.
+func colorHistogram(img *image.NRGBA) (hist map[[4]uint8]int, transparent bool) {
+	hist = make(map[[4]uint8]int)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+4 {
+			p := img.Pix[i : i+4 : i+4]
+			if p[3] == 0 {
+				transparent = true
+				continue
+			}
+			hist[[4]uint8{p[0], p[1], p[2], p[3]}]++
+		}
+	}
+	return hist, transparent
+}
+
+// medianCut returns a palette of at most n colors for img. Images with no
+// more than n distinct colors get exactly those colors, so that flat UI
+// graphics quantize losslessly. Fully transparent pixels share a single
+// color.Transparent entry; partial alpha is quantized like the other
+// channels.
+func medianCut(img *image.NRGBA, n int) color.Palette {
+	hist, transparent := colorHistogram(img)
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.Transparent)
+		n--
+	}
+	if len(hist) == 0 || n <= 0 {
+		return pal
+	}
+
+	box := quantizeBox{entries: make([]quantizeEntry, 0, len(hist))}
+	for c, count := range hist {
+		box.entries = append(box.entries, quantizeEntry{c, count})
+		box.count += count
+	}
+	if len(box.entries) <= n {
+		for _, e := range box.entries {
+			pal = append(pal, color.NRGBA{e.c[0], e.c[1], e.c[2], e.c[3]})
+		}
+		return pal
+	}
+
+	for _, box := range splitBoxes(box, n) {
+		pal = append(pal, box.mean())
+	}
+	return pal
+}
+
+// splitBoxes divides box by median cut into at most n boxes.
+func splitBoxes(box quantizeBox, n int) []quantizeBox {
+	boxes := []quantizeBox{box}
+	for len(boxes) < n {
+		// Split the box whose widest channel, weighted by the pixels in
+		// it, is largest; single-color boxes cannot be split.
+		best, bestScore, bestCh := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.entries) < 2 {
+				continue
+			}
+			ch, r := box.widest()
+			if score := r * box.count; score > bestScore {
+				best, bestScore, bestCh = i, score, ch
+			}
+		}
+		if best < 0 {
+			break
+		}
+		lo, hi := boxes[best].split(bestCh)
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+	return boxes
+}
+
+// widest returns the channel with the largest range of values in b and
+// that range.
+func (b quantizeBox) widest() (ch, r int) {
+	lo := [4]uint8{255, 255, 255, 255}
+	var hi [4]uint8
+	for _, e := range b.entries {
+		for c := 0; c < 4; c++ {
+			if e.c[c] < lo[c] {
+				lo[c] = e.c[c]
+			}
+			if e.c[c] > hi[c] {
+				hi[c] = e.c[c]
+			}
+		}
+	}
+	for c := 0; c < 4; c++ {
+		if d := int(hi[c]) - int(lo[c]); d > r {
+			ch, r = c, d
+		}
+	}
+	return ch, r
+}
+
+// split divides b along channel ch at the median pixel, leaving at least
+// one entry on each side.
+func (b quantizeBox) split(ch int) (lo, hi quantizeBox) {
+	sort.Slice(b.entries, func(i, j int) bool {
+		return b.entries[i].c[ch] < b.entries[j].c[ch]
+	})
+	k, sum := 1, b.entries[0].count
+	for k < len(b.entries)-1 && 2*sum < b.count {
+		sum += b.entries[k].count
+		k++
+	}
+	return quantizeBox{b.entries[:k:k], sum}, quantizeBox{b.entries[k:], b.count - sum}
+}
+
+// mean returns the pixel-weighted average color of b. Color channels are
+// averaged premultiplied, so that nearly transparent entries do not pull
+// the color of opaque ones.
+func (b quantizeBox) mean() color.NRGBA {
+	var r, g, bl, a int
+	for _, e := range b.entries {
+		ea := int(e.c[3]) * e.count
+		r += int(e.c[0]) * ea
+		g += int(e.c[1]) * ea
+		bl += int(e.c[2]) * ea
+		a += ea
+	}
+	return color.NRGBA{
+		uint8((r + a/2) / a),
+		uint8((g + a/2) / a),
+		uint8((bl + a/2) / a),
+		uint8((a + b.count/2) / b.count),
+	}
+}
+
+// toPalette maps img to pal by nearest color, without dithering.
+func toPalette(img *image.NRGBA, pal color.Palette) *image.Paletted {
+	b := img.Bounds()
+	p := image.NewPaletted(b, pal)
+	cache := make(map[color.NRGBA]uint8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			idx, ok := cache[c]
+			if !ok {
+				idx = uint8(pal.Index(c))
+				cache[c] = idx
+			}
+			p.SetColorIndex(x, y, idx)
+		}
+	}
+	return p
+}
+
+// octreeNode is a node of the color octree built by octree. Leaves hold
+// the sums of the colors that fell into them.
+type octreeNode struct {
+	children      [8]*octreeNode
+	r, g, b, a    int
+	count, pixels int
+	leaf          bool
+}
+
+// octree returns a palette of at most n colors for img, built by inserting
+// its distinct colors into an octree eight levels deep and then merging
+// the least used nodes of the deepest level until at most n leaves
+// remain. Transparent pixels are handled as by medianCut.
+func octree(img *image.NRGBA, n int) color.Palette {
+	hist, transparent := colorHistogram(img)
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.Transparent)
+		n--
+	}
+	if len(hist) == 0 || n <= 0 {
+		return pal
+	}
+
+	root := &octreeNode{}
+	var levels [8][]*octreeNode // inner nodes by depth
+	leaves := 0
+	for c, count := range hist {
+		node := root
+		for depth := 0; depth < 8; depth++ {
+			shift := 7 - uint(depth)
+			k := (c[0]>>shift&1)<<2 | (c[1]>>shift&1)<<1 | c[2]>>shift&1
+			child := node.children[k]
+			if child == nil {
+				child = &octreeNode{leaf: depth == 7}
+				node.children[k] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[depth+1] = append(levels[depth+1], child)
+				}
+			}
+			node.pixels += count
+			node = child
+		}
+		a := int(c[3]) * count
+		node.r += int(c[0]) * a
+		node.g += int(c[1]) * a
+		node.b += int(c[2]) * a
+		node.a += a
+		node.count += count
+		node.pixels += count
+	}
+	levels[0] = []*octreeNode{root}
+
+	for depth := 7; depth >= 0 && leaves > n; depth-- {
+		nodes := levels[depth]
+		// Merge the nodes holding the fewest pixels first.
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].pixels < nodes[j].pixels })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			merged := 0
+			for k, child := range node.children {
+				if child == nil {
+					continue
+				}
+				node.r += child.r
+				node.g += child.g
+				node.b += child.b
+				node.a += child.a
+				node.count += child.count
+				node.children[k] = nil
+				merged++
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			if node.count > 0 {
+				a := node.a
+				pal = append(pal, color.NRGBA{
+					uint8((node.r + a/2) / a),
+					uint8((node.g + a/2) / a),
+					uint8((node.b + a/2) / a),
+					uint8((a + node.count/2) / node.count),
+				})
+			}
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+	return pal
+}
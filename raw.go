@@ -0,0 +1,235 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// RAWDecoder develops camera RAW files. Without one, RAW sources are
+// decoded from the largest JPEG preview the camera embedded in them, which
+// is fast and usually close to full size.
+type RAWDecoder interface {
+	// DecodeRAW develops data, a whole RAW file whose format name is
+	// format, such as "cr2" or "nef".
+	DecodeRAW(ctx context.Context, data []byte, format string) (image.Image, error)
+}
+
+var rawDecoder struct {
+	sync.RWMutex
+	d RAWDecoder
+}
+
+// SetRAWDecoder makes d develop RAW sources instead of using their embedded
+// previews. A nil d restores preview extraction.
+func SetRAWDecoder(d RAWDecoder) {
+	rawDecoder.Lock()
+	rawDecoder.d = d
+	rawDecoder.Unlock()
+}
+
+// RAWCommand is a RAWDecoder that runs an external program, given the RAW
+// file in a temporary file, and decodes the image it writes to standard
+// output. In Args, "{input}" is replaced by the file's path.
+type RAWCommand struct {
+	Name string
+	Args []string
+}
+
+// Dcraw develops RAW files with dcraw, writing TIFF.
+var Dcraw = RAWCommand{Name: "dcraw", Args: []string{"-c", "-w", "-T", "{input}"}}
+
+// DecodeRAW runs the command on data.
+func (c RAWCommand) DecodeRAW(ctx context.Context, data []byte, format string) (image.Image, error) {
+	f, err := os.CreateTemp("", "thumbnail-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = strings.ReplaceAll(a, "{input}", f.Name())
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("thumbnail: %s: %w: %s", c.Name, err, msg)
+		}
+		return nil, fmt.Errorf("thumbnail: %s: %w", c.Name, err)
+	}
+	img, _, err := image.Decode(&stdout)
+	return img, err
+}
+
+// rawMakes maps the Make of TIFF-structured RAW formats that have no
+// signature of their own to their format names.
+var rawMakes = []struct{ make, format string }{
+	{"NIKON", "nef"},
+	{"SONY", "arw"},
+	{"PENTAX", "pef"},
+	{"SAMSUNG", "srw"},
+}
+
+// rawFormat returns the format name of a TIFF-structured camera RAW file,
+// or "" if data is not one.
+func rawFormat(data []byte) string {
+	t, off, err := newTIFFReader(data)
+	if err != nil {
+		return ""
+	}
+	return tiffRAWFormat(t, off, data)
+}
+
+// tiffRAWFormat is rawFormat for the file read by t, whose first IFD is at
+// off and which starts with header.
+func tiffRAWFormat(t *tiffReader, off uint32, header []byte) string {
+	switch string(header[:4]) {
+	case "IIRO", "IIRS", "MMOR":
+		return "orf"
+	case "IIU\x00":
+		return "rw2"
+	}
+	if len(header) >= 10 && string(header[8:10]) == "CR" {
+		return "cr2"
+	}
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		switch e.tag {
+		case tagDNGVersion:
+			return "dng"
+		case tagMake:
+			for _, m := range rawMakes {
+				if bytes.HasPrefix(bytes.ToUpper(e.data), []byte(m.make)) {
+					return m.format
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// rawPreview returns the largest baseline JPEG embedded in a RAW file, and
+// the orientation recorded in its first IFD. Previews are found in the IFD
+// chain and its SubIFDs, either as JPEGInterchangeFormat fields or as
+// single JPEG-compressed strips.
+func rawPreview(data []byte) ([]byte, int) {
+	t, off, err := newTIFFReader(data)
+	if err != nil {
+		return nil, 1
+	}
+	orientation := 1
+	var best []byte
+	bestArea := 0
+	consider := func(start, size uint32) {
+		if size < 2 || uint64(start)+uint64(size) > uint64(len(data)) {
+			return
+		}
+		b := data[start : start+size]
+		if b[0] != 0xff || b[1] != 0xd8 {
+			return
+		}
+		// Lossless JPEG raw data fails here, leaving only viewable previews.
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		if a := cfg.Width * cfg.Height; a > bestArea {
+			best, bestArea = b, a
+		}
+	}
+
+	seen := make(map[uint32]bool)
+	queue := []uint32{off}
+	for len(queue) > 0 && len(seen) < 64 {
+		off := queue[0]
+		queue = queue[1:]
+		if off == 0 || seen[off] {
+			continue
+		}
+		seen[off] = true
+		entries, next, err := t.ifd(off)
+		if err != nil {
+			continue
+		}
+		if len(seen) == 1 {
+			if o, ok := t.field(entries, tagOrientation); ok && o >= 1 && o <= 8 {
+				orientation = int(o)
+			}
+		}
+		queue = append(queue, next)
+		var strips, stripSizes ifdEntry
+		for _, e := range entries {
+			switch e.tag {
+			case tagSubIFDs:
+				for i := 0; ; i++ {
+					v, ok := t.uint(e, i)
+					if !ok {
+						break
+					}
+					queue = append(queue, v)
+				}
+			case tagStripOffsets:
+				strips = e
+			case tagStripByteCounts:
+				stripSizes = e
+			}
+		}
+		if start, ok := t.field(entries, tagJPEGInterchange); ok {
+			if size, ok := t.field(entries, tagJPEGInterchangeSize); ok {
+				consider(start, size)
+			}
+		}
+		if c, _ := t.field(entries, tagCompression); (c == 6 || c == 7) && strips.count == 1 {
+			start, ok1 := t.uint(strips, 0)
+			size, ok2 := t.uint(stripSizes, 0)
+			if ok1 && ok2 {
+				consider(start, size)
+			}
+		}
+	}
+	return best, orientation
+}
+
+// decodeRAW decodes a RAW file with the RAWDecoder set by SetRAWDecoder
+// or, without one, from its embedded preview, which is turned upright
+// when upright is set.
+func decodeRAW(ctx context.Context, data []byte, format string, upright bool) (image.Image, error) {
+	rawDecoder.RLock()
+	d := rawDecoder.d
+	rawDecoder.RUnlock()
+	if d != nil {
+		return d.DecodeRAW(ctx, data, format)
+	}
+	preview, o := rawPreview(data)
+	if preview == nil {
+		return nil, errNoPreview(format)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil || !upright {
+		return img, err
+	}
+	return orient(img, o), nil
+}
+
+func errNoPreview(format string) error {
+	return fmt.Errorf("%w: no JPEG preview in %s file", image.ErrFormat, format)
+}
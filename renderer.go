@@ -0,0 +1,454 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"sync"
+)
+
+// Renderer rasterizes a resolution-independent source format, such as SVG,
+// that image.Decode cannot read. Sources it matches are rendered at the
+// size the thumbnail needs rather than decoded at a fixed resolution.
+type Renderer interface {
+	// Size reports the natural size of the document in pixels.
+	Size(data []byte) (width, height int, err error)
+
+	// Render rasterizes the whole document scaled to width×height.
+	Render(ctx context.Context, data []byte, width, height int) (image.Image, error)
+}
+
+// sniffLen is the number of leading bytes passed to renderer match
+// functions.
+const sniffLen = 512
+
+type registeredRenderer struct {
+	format string
+	match  func(header []byte) bool
+	r      Renderer
+}
+
+var renderers struct {
+	sync.RWMutex
+	list []registeredRenderer
+}
+
+// RegisterRenderer makes r available for sources whose first bytes (up to
+// 512 of them) satisfy match. The format name is reported as the source
+// format. Renderers are tried before the image decoders, the most recently
+// registered first, so a built-in renderer can be replaced.
+func RegisterRenderer(format string, match func(header []byte) bool, r Renderer) {
+	renderers.Lock()
+	renderers.list = append(renderers.list, registeredRenderer{format, match, r})
+	renderers.Unlock()
+}
+
+// lookupRenderer returns the renderer matching header, if any.
+func lookupRenderer(header []byte) (string, Renderer) {
+	renderers.RLock()
+	defer renderers.RUnlock()
+	for i := len(renderers.list) - 1; i >= 0; i-- {
+		if rr := renderers.list[i]; rr.match(header) {
+			return rr.format, rr.r
+		}
+	}
+	return "", nil
+}
+
+// decodeFor decodes an image from r like decode. Sources handled by a
+// Renderer are rasterized at the scale opts would reduce them by, or at
+// their natural size when opts is nil, and camera RAW files are decoded
+// with decodeRAW. Animated PNGs and WebPs are decoded to their first
+// frame, multi-page TIFFs to the page opts selects, and CMYK JPEGs as
+// described for decodeCMYK. With opts.EmbeddedThumbnail, JPEGs may be
+// decoded from their EXIF thumbnail instead, adjusting *opts as
+// decodeEmbedded does, and other JPEGs much larger than the thumbnail are
+// decoded reduced, as decodeDraft does. Tiled, pyramidal and BigTIFF
+// files are decoded as decodeTiled does.
+// JPEG, TIFF and RAW sources are turned upright according to their
+// orientation unless opts.NoAutoOrient is set, and sources with an RGB
+// ICC profile other than sRGB are converted to sRGB unless
+// opts.NoColorConvert is.
+func decodeFor(ctx context.Context, r io.Reader, opts *Options) (image.Image, string, error) {
+	img, format, icc, err := decodeSource(ctx, r, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if convertColors(opts) {
+		if p := rgbProfile(icc); p != nil {
+			if img, err = toSRGB(ctx, img, p); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	return img, format, nil
+}
+
+// decodeSource decodes an image from r as decodeFor does, without color
+// conversion. It also returns the ICC profile of the source, found in all
+// of its bytes for the formats it reads whole, and in those before the
+// image data of PNG and WebP streams, which it decodes as they are read.
+// r may be a wholeReader, whose bytes are then used in place, or a
+// seekable io.ReaderAt, such as an *os.File, through which TIFF files are
+// read as decodeTIFFAt reads them.
+func decodeSource(ctx context.Context, r io.Reader, opts *Options) (image.Image, string, []byte, error) {
+	whole, _ := r.(*wholeReader)
+	var section *io.SectionReader
+	var head headCapture
+	if whole == nil {
+		section = sectionOf(r)
+		r = io.TeeReader(r, &head)
+	}
+	profile := func(data []byte) []byte {
+		return readMetadata(data).ICC
+	}
+	br := bufio.NewReaderSize(ctxReader{ctx, r}, sniffLen)
+	readAll := func() ([]byte, error) {
+		if whole != nil {
+			return whole.data, nil
+		}
+		return io.ReadAll(br)
+	}
+	streamed := func(img image.Image, format string, err error) (image.Image, string, []byte, error) {
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if whole != nil {
+			return img, format, profile(whole.data), nil
+		}
+		return img, format, profile(head.buf), nil
+	}
+	header, _ := br.Peek(sniffLen)
+	format, rend := lookupRenderer(header)
+	if rend == nil && isAPNG(header) {
+		// image/png decodes the fallback image, which need not be the
+		// first frame of the animation.
+		data, err := readAll()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if err := checkSize(data, opts); err != nil {
+			return nil, "", nil, err
+		}
+		img, err := decodeAPNGFirst(data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return img, "png", profile(data), nil
+	}
+	if rend == nil && isAnimatedWebP(header) {
+		// golang.org/x/image/webp only reads still images.
+		data, err := readAll()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if err := checkSize(data, opts); err != nil {
+			return nil, "", nil, err
+		}
+		img, err := decodeAnimatedWebPFirst(data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return img, WEBP, profile(data), nil
+	}
+	if rend == nil && bytes.HasPrefix(header, []byte("\xff\xd8")) {
+		// CMYK streams need their markers, which precede the frame
+		// header, to be decoded correctly.
+		data, err := readAll()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if err := checkSize(data, opts); err != nil {
+			return nil, "", nil, err
+		}
+		o := 1
+		if autoOrient(opts) {
+			o = exifOrientation(jpegEXIF(data))
+			markOriented(opts)
+		}
+		if opts != nil && opts.EmbeddedThumbnail {
+			if img, ok := decodeEmbedded(data, o, opts); ok {
+				return img, JPEG, profile(data), nil
+			}
+		}
+		var img image.Image
+		if jpegComponents(data) == 4 {
+			img, err = decodeCMYK(ctx, data, opts)
+		} else if draft, ok := decodeDraft(ctx, data, o, opts); ok {
+			return draft, JPEG, profile(data), nil
+		} else {
+			img, _, err = decode(ctx, bytes.NewReader(data))
+		}
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return orient(img, o), JPEG, profile(data), nil
+	}
+	if rend == nil {
+		if _, _, err := newTIFFReader(header); err != nil {
+			if !limited(opts) {
+				return streamed(decode(ctx, br))
+			}
+			// Size the image from its header, then decode it from the
+			// start.
+			var cfgHead bytes.Buffer
+			if cfg, _, err := image.DecodeConfig(io.TeeReader(br, &cfgHead)); err == nil {
+				if err := checkLimits(cfg.Width, cfg.Height, opts); err != nil {
+					return nil, "", nil, err
+				}
+			}
+			return streamed(decode(ctx, io.MultiReader(&cfgHead, br)))
+		}
+		if section != nil {
+			img, icc, err := decodeTIFFAt(ctx, section, header, opts)
+			if err == nil {
+				return img, TIFF, icc, nil
+			}
+			if err != errTiledUnsupported {
+				return nil, "", nil, err
+			}
+		}
+		// Camera RAW files are TIFF-structured, and only their IFDs tell
+		// them apart from plain TIFF.
+		data, err := readAll()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if format := rawFormat(data); format != "" {
+			// As Probe does, size RAW files by their preview.
+			if preview, _ := rawPreview(data); preview != nil {
+				if err := checkSize(preview, opts); err != nil {
+					return nil, "", nil, err
+				}
+			}
+			if autoOrient(opts) {
+				markOriented(opts)
+			}
+			img, err := decodeRAW(ctx, data, format, autoOrient(opts))
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return nil, "", nil, ctxErr
+				}
+				return nil, "", nil, err
+			}
+			return img, format, profile(data), nil
+		}
+		if opts != nil && opts.Page > 1 {
+			if data, err = tiffPage(data, opts.Page); err != nil {
+				return nil, "", nil, err
+			}
+		}
+		if err := checkSize(data, opts); err != nil {
+			return nil, "", nil, err
+		}
+		o := 1
+		if autoOrient(opts) {
+			// The first IFD of a TIFF file holds its own Orientation field
+			// just as an EXIF payload does.
+			o = exifOrientation(data)
+			markOriented(opts)
+		}
+		t, off, _ := newTIFFReader(data)
+		img, err := decodeTiled(ctx, t, off, o, opts)
+		if err == errTiledUnsupported {
+			img, _, err = decode(ctx, bytes.NewReader(data))
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, "", nil, ctxErr
+			}
+			return nil, "", nil, err
+		}
+		return orient(img, o), TIFF, profile(data), nil
+	}
+
+	data, err := readAll()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	w, h, err := rend.Size(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if w <= 0 || h <= 0 {
+		return nil, "", nil, ErrEmptyImage
+	}
+	if err := checkLimits(w, h, opts); err != nil {
+		return nil, "", nil, err
+	}
+	rw, rh := w, h
+	if opts != nil {
+		l, resolved, err := prepare(w, h, *opts)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		sx := float64(l.dst.Dx()) / float64(l.src.Dx())
+		sy := float64(l.dst.Dy()) / float64(l.src.Dy())
+		rw = int(math.Max(1, math.Round(float64(w)*sx)))
+		rh = int(math.Max(1, math.Round(float64(h)*sy)))
+		// The rendering stands in for the source, which Results still
+		// report, as for a reduced JPEG.
+		rescaleOptions(opts, l, resolved, float64(rw)/float64(w), float64(rh)/float64(h))
+		reportFullSize(opts, w, h, l)
+	}
+	img, err := rend.Render(ctx, data, rw, rh)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", nil, ctxErr
+		}
+		return nil, "", nil, err
+	}
+	return img, format, profile(data), nil
+}
+
+// decodeTIFFAt decodes the TIFF file read through r, which starts with
+// header, as decodeSource does, when decodeTiled can, reading only the
+// directories and the tiles it needs. It also returns the ICC profile of
+// the page decoded. It returns errTiledUnsupported for camera RAW files
+// and the TIFF files decodeTiled leaves to image/tiff, which are read
+// whole.
+func decodeTIFFAt(ctx context.Context, r *io.SectionReader, header []byte, opts *Options) (image.Image, []byte, error) {
+	t, off, err := newTIFFReaderAt(r, r.Size())
+	if err != nil || tiffRAWFormat(t, off, header) != "" {
+		return nil, nil, errTiledUnsupported
+	}
+	if opts != nil && opts.Page > 1 {
+		offs := tiffChain(t, off)
+		if opts.Page > len(offs) {
+			return nil, nil, ErrPageNotFound
+		}
+		off = offs[opts.Page-1]
+	}
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return nil, nil, errTiledUnsupported
+	}
+	if limited(opts) {
+		w, ok1 := t.field(entries, tagImageWidth)
+		h, ok2 := t.field(entries, tagImageLength)
+		if !ok1 || !ok2 {
+			return nil, nil, errTiledUnsupported
+		}
+		if err := checkLimits(int(w), int(h), opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	o := 1
+	if autoOrient(opts) {
+		if v, ok := t.field(entries, tagOrientation); ok && v >= 1 && v <= 8 {
+			o = int(v)
+		}
+	}
+	img, err := decodeTiled(ctx, t, off, o, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if autoOrient(opts) {
+		markOriented(opts)
+	}
+	var icc []byte
+	for _, e := range entries {
+		if e.tag == tagICCProfile {
+			icc = e.data
+		}
+	}
+	return orient(img, o), icc, nil
+}
+
+// sectionOf returns the rest of r, from its offset on, if r is an
+// io.ReaderAt that seeks, as files do, and nil otherwise.
+func sectionOf(r io.Reader) *io.SectionReader {
+	s, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if _, serr := s.Seek(pos, io.SeekStart); err != nil || serr != nil || end < pos {
+		return nil
+	}
+	return io.NewSectionReader(s, pos, end-pos)
+}
+
+// wholeReader reads a source already held in memory, whose bytes
+// decodeSource uses in place instead of reading them into a copy.
+type wholeReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newWholeReader(data []byte) *wholeReader {
+	return &wholeReader{bytes.NewReader(data), data}
+}
+
+// headCapture keeps the bytes written to it up to the start of the image
+// data of a PNG or WebP stream, past the metadata chunks that precede
+// it, and none of the bytes of other streams.
+type headCapture struct {
+	buf  []byte
+	done bool
+}
+
+func (c *headCapture) Write(p []byte) (int, error) {
+	if c.done {
+		return len(p), nil
+	}
+	c.buf = append(c.buf, p...)
+	b := c.buf
+	switch {
+	case len(b) < 12:
+		return len(p), nil
+	case bytes.HasPrefix(b, pngSignature):
+		for b = b[len(pngSignature):]; len(b) >= 8; {
+			if string(b[4:8]) == "IDAT" {
+				c.done = true
+				break
+			}
+			n := uint64(binary.BigEndian.Uint32(b)) + 12
+			if n > uint64(len(b)) {
+				break
+			}
+			b = b[n:]
+		}
+	case string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		for b = b[12:]; len(b) >= 8; {
+			if id := string(b[:4]); id == "VP8 " || id == "VP8L" || id == "ALPH" || id == "ANIM" {
+				c.done = true
+				break
+			}
+			n := uint64(binary.LittleEndian.Uint32(b[4:]))
+			n += 8 + n&1
+			if n > uint64(len(b)) {
+				break
+			}
+			b = b[n:]
+		}
+	default:
+		c.buf, c.done = nil, true
+	}
+	return len(p), nil
+}
+
+// autoOrient reports whether decodeFor applies source orientation for
+// opts, which it does by default.
+func autoOrient(opts *Options) bool {
+	return opts == nil || !opts.NoAutoOrient
+}
+
+// markOriented records in opts, if set, that the orientation of the source
+// has been applied to its decoded pixels.
+func markOriented(opts *Options) {
+	if opts != nil {
+		opts.oriented = true
+	}
+}
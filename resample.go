@@ -0,0 +1,200 @@
+package thumbnail
+
+import (
+	"container/list"
+	"image"
+	"math"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	"github.com/imgutils-org/imgutils-thumbnail/internal/resample"
+)
+
+// kernelWeights are the taps of a kernel filter resampling n source pixels
+// to m along one axis: destination pixel i mixes taps source pixels from
+// start[i] on, weighted by weights[i*taps:(i+1)*taps]. Every destination
+// pixel has the same number of taps, padded with zero weights, so that the
+// loops applying them are regular.
+type kernelWeights struct {
+	taps    int
+	start   []int
+	weights []float32
+}
+
+// newKernelWeights returns the weights of k resampling n pixels to m. As
+// in golang.org/x/image/draw, the kernel is stretched by the reduction
+// factor when reducing, and the weights of each destination pixel are
+// normalized over the source pixels inside the image.
+func newKernelWeights(k *draw.Kernel, m, n int) *kernelWeights {
+	scale := float64(n) / float64(m)
+	stretch := math.Max(1, scale)
+	support := k.Support * stretch
+	taps := minInt(n, int(math.Ceil(2*support))+1)
+	kw := &kernelWeights{taps: taps, start: make([]int, m), weights: make([]float32, m*taps)}
+	for i := 0; i < m; i++ {
+		center := (float64(i) + 0.5) * scale
+		start := clampInt(int(math.Ceil(center-support-0.5)), 0, n-taps)
+		ws := kw.weights[i*taps : (i+1)*taps]
+		var sum float64
+		for j := range ws {
+			if d := math.Abs(float64(start+j)+0.5-center) / stretch; d < k.Support {
+				w := k.At(d)
+				ws[j] = float32(w)
+				sum += w
+			}
+		}
+		if sum == 0 {
+			// The kernel is too narrow to reach a source pixel center,
+			// so take the nearest.
+			for j := range ws {
+				ws[j] = 0
+			}
+			ws[clampInt(int(center)-start, 0, taps-1)] = 1
+		} else {
+			for j := range ws {
+				ws[j] = float32(float64(ws[j]) / sum)
+			}
+		}
+		kw.start[i] = start
+	}
+	return kw
+}
+
+// kernelCacheSize is the number of prepared kernel scalers kept for reuse,
+// which covers the preset sizes a server generates from its common source
+// sizes.
+const kernelCacheSize = 64
+
+// kernelKey identifies a prepared kernel scaler.
+type kernelKey struct {
+	k              *draw.Kernel
+	sw, sh, dw, dh int
+}
+
+// kernelScaler holds the weights of a kernel filter resampling an sw×sh
+// source to dw×dh, horizontally and vertically. It is not modified once
+// prepared, so scales may share it.
+type kernelScaler struct {
+	key  kernelKey
+	h, v *kernelWeights
+}
+
+// kernelCache is a least recently used cache of kernel scalers.
+var kernelCache struct {
+	sync.Mutex
+	order *list.List // of *kernelScaler, most recently used first
+	byKey map[kernelKey]*list.Element
+}
+
+// newKernelScaler returns the scaler of k from sw×sh to dw×dh, preparing
+// it unless it is cached.
+func newKernelScaler(k *draw.Kernel, sw, sh, dw, dh int) *kernelScaler {
+	key := kernelKey{k, sw, sh, dw, dh}
+	c := &kernelCache
+	c.Lock()
+	if e, ok := c.byKey[key]; ok {
+		c.order.MoveToFront(e)
+		c.Unlock()
+		return e.Value.(*kernelScaler)
+	}
+	c.Unlock()
+
+	// Prepare outside the lock; two scales racing to prepare the same
+	// scaler compute equal weights, and the second one is kept.
+	ks := &kernelScaler{key: key, h: newKernelWeights(k, dw, sw), v: newKernelWeights(k, dh, sh)}
+	c.Lock()
+	defer c.Unlock()
+	if c.order == nil {
+		c.order, c.byKey = list.New(), make(map[kernelKey]*list.Element)
+	}
+	if e, ok := c.byKey[key]; ok {
+		c.order.Remove(e)
+	}
+	c.byKey[key] = c.order.PushFront(ks)
+	if c.order.Len() > kernelCacheSize {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.byKey, e.Value.(*kernelScaler).key)
+	}
+	return ks
+}
+
+// resampleRows resamples rows y0 to y1 of the sr portion of src
+// horizontally with kw into the same rows of tmp, which is kw's
+// destination size wide. With linear, the rows are converted to linear
+// light first.
+func resampleRows(tmp *image.RGBA64, y0, y1 int, src image.Image, sr image.Rectangle, kw *kernelWeights, linear bool) {
+	sw, dw := sr.Dx(), tmp.Rect.Dx()
+	deep := linear || highBitDepth(src.ColorModel())
+	var row8 *image.RGBA
+	var row16 *image.RGBA64
+	if deep {
+		row16 = getTmp(sw, 1)
+		defer scratch.put(row16.Pix)
+	} else {
+		row8 = scratch.rgba(image.Rect(0, 0, sw, 1), false)
+		defer scratch.put(row8.Pix)
+	}
+	row := make([]float32, 4*sw)
+	acc := make([]float32, 4*dw)
+	for y := y0; y < y1; y++ {
+		sp := image.Pt(sr.Min.X, sr.Min.Y+y)
+		switch {
+		case !deep:
+			draw.Draw(row8, row8.Rect, src, sp, draw.Src)
+			resample.Widen8(row, row8.Pix)
+		default:
+			if linear {
+				linearize(row16, src, image.Rectangle{Min: sp, Max: sp.Add(image.Pt(sw, 1))})
+			} else {
+				draw.Draw(row16, row16.Rect, src, sp, draw.Src)
+			}
+			resample.Widen16(row, row16.Pix)
+		}
+		resample.ConvolveRow(acc, row, kw.start, kw.weights, kw.taps)
+		resample.Pack16(tmp.Pix[y*tmp.Stride:y*tmp.Stride+8*dw], acc)
+	}
+}
+
+// resampleColumns resamples tmp vertically with kw into rows y0 to y1 of
+// the dr portion of dst, converting from linear light back to sRGB with
+// linear. Colors are clamped to their alpha, which kernels with negative
+// lobes may overshoot.
+func resampleColumns(dst draw.Image, dr image.Rectangle, y0, y1 int, tmp *image.RGBA64, kw *kernelWeights, linear bool) {
+	dw := dr.Dx()
+	inside := dr.In(dst.Bounds())
+	rgba, _ := dst.(*image.RGBA)
+	rgba64, _ := dst.(*image.RGBA64)
+	var row *image.RGBA64
+	if !inside || linear && rgba64 == nil || rgba == nil && rgba64 == nil {
+		row = getTmp(dw, 1)
+		defer scratch.put(row.Pix)
+	}
+
+	acc := make([]float32, 4*dw)
+	t := kw.taps
+	for y := y0; y < y1; y++ {
+		resample.AccumulateRows(acc, tmp.Pix[kw.start[y]*tmp.Stride:], tmp.Stride, kw.weights[y*t:(y+1)*t])
+
+		dy := dr.Min.Y + y
+		switch {
+		case row == nil && rgba != nil:
+			off := rgba.PixOffset(dr.Min.X, dy)
+			resample.PackPremul8(rgba.Pix[off:off+4*dw], acc)
+		default:
+			m := row
+			if m == nil {
+				m = rgba64.SubImage(image.Rect(dr.Min.X, dy, dr.Max.X, dy+1)).(*image.RGBA64)
+			}
+			off := m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+			resample.PackPremul16(m.Pix[off:off+8*dw], acc)
+			if linear {
+				delinearize(m)
+			}
+			if m == row {
+				draw.Draw(dst, image.Rect(dr.Min.X, dy, dr.Max.X, dy+1), row, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
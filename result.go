@@ -0,0 +1,259 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Result describes a generated thumbnail and the source it was made from.
+type Result struct {
+	Image image.Image
+
+	// Format is the source format name reported by the decoder, such as
+	// "jpeg" or "png". It is empty when the source was already decoded.
+	Format string
+
+	// OutputFormat is the format Encode writes Image in for
+	// Options.Format, with Auto resolved to the format BestFormat picks.
+	// It is empty when Options.Format is.
+	OutputFormat string
+
+	SourceWidth  int
+	SourceHeight int
+	Width        int
+	Height       int
+
+	// Scale is the factor by which the source was resized; values below
+	// one mean the source was reduced. For Stretch it is the horizontal
+	// factor.
+	Scale float64
+
+	// DominantColor is the DominantColor of Image when Options.DominantColor
+	// asks for it, and nil otherwise.
+	DominantColor color.Color
+
+	// BlurHash is the BlurHash of Image when Options.BlurHash asks for it,
+	// and empty otherwise.
+	BlurHash string
+
+	// ThumbHash is the ThumbHash of Image when Options.ThumbHash asks for
+	// it, and nil otherwise.
+	ThumbHash []byte
+
+	// LQIP is the data URI of an LQIP of Image in the format
+	// Options.LQIP names, or empty if it names none.
+	LQIP string
+
+	// Hash is the perceptual hash of Image of the kind Options.Hash
+	// selects, or zero if it selects none.
+	Hash Hash
+
+	// Metadata is the source metadata selected by Options.KeepMetadata
+	// less what Options.StripMetadata removes, or nil if none was asked
+	// for or found.
+	Metadata *Metadata
+}
+
+// Process generates a thumbnail from src like GenerateContext, and reports
+// the output dimensions and scale alongside the image.
+func Process(ctx context.Context, src image.Image, opts Options) (*Result, error) {
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return process(ctx, src, opts)
+}
+
+// process is Process without waiting for a slot under SetMaxConcurrent.
+func process(ctx context.Context, src image.Image, opts Options) (*Result, error) {
+	if src == nil {
+		return nil, ErrNilImage
+	}
+	srcW := src.Bounds().Dx()
+	srcH := src.Bounds().Dy()
+	l, opts, err := prepare(srcW, srcH, opts)
+	if err != nil {
+		return nil, err
+	}
+	if l, err = autoCrop(ctx, l, src, opts, &cropCache{}); err != nil {
+		return nil, err
+	}
+	if passthrough(srcW, srcH, opts) {
+		r := &Result{
+			Image:         src,
+			SourceWidth:   srcW,
+			SourceHeight:  srcH,
+			Width:         srcW,
+			Height:        srcH,
+			Scale:         1,
+			OutputFormat:  outputFormatOf(src, opts),
+			DominantColor: dominantColorOf(src, opts),
+		}
+		r.fullSource(opts)
+		if err := r.placeholders(ctx, opts); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	dst := newThumb(l.size, src, opts)
+	if err := render(ctx, dst, image.Point{}, src, l, opts); err != nil {
+		return nil, err
+	}
+
+	img := matchColorModel(dst, src, opts)
+	if img != dst && opts.pool != nil {
+		opts.pool.release(dst)
+	}
+	r := &Result{
+		Image:         img,
+		SourceWidth:   srcW,
+		SourceHeight:  srcH,
+		Width:         l.size.X,
+		Height:        l.size.Y,
+		Scale:         float64(l.dst.Dx()) / float64(l.src.Dx()),
+		OutputFormat:  outputFormatOf(img, opts),
+		DominantColor: dominantColorOf(img, opts),
+	}
+	r.fullSource(opts)
+	if err := r.placeholders(ctx, opts); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fullSource sets the source size and scale of r to those opts holds for
+// a source decoded reduced, if any.
+func (r *Result) fullSource(opts Options) {
+	if opts.source != (image.Point{}) {
+		r.SourceWidth, r.SourceHeight, r.Scale = opts.source.X, opts.source.Y, opts.scale
+	}
+}
+
+// outputFormatOf returns Result.OutputFormat for a thumbnail img.
+func outputFormatOf(img image.Image, opts Options) string {
+	if opts.Format == "" {
+		return ""
+	}
+	return encodedFormat(img, opts.Format)
+}
+
+// dominantColorOf returns Result.DominantColor for a thumbnail img.
+func dominantColorOf(img image.Image, opts Options) color.Color {
+	if !opts.DominantColor {
+		return nil
+	}
+	return DominantColor(img)
+}
+
+// placeholders sets the BlurHash, ThumbHash, LQIP and Hash of r that opts
+// asks for.
+func (r *Result) placeholders(ctx context.Context, opts Options) error {
+	if opts.BlurHash {
+		x, y := 4, 3
+		if r.Height > r.Width {
+			x, y = 3, 4
+		}
+		r.BlurHash, _ = BlurHash(r.Image, x, y)
+	}
+	if opts.ThumbHash {
+		r.ThumbHash = ThumbHash(r.Image)
+	}
+	r.Hash = opts.Hash.hash(r.Image)
+	if opts.LQIP != "" {
+		var err error
+		if r.LQIP, err = LQIP(ctx, r.Image, opts.LQIP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// engine, when set, generates thumbnails from encoded sources in place of
+// the pure-Go pipeline. It returns a Result with Image, Format and the
+// sizes and scale set, or nil for sources and options it leaves to the
+// pipeline. Building with the libvips tag sets it.
+var engine func(ctx context.Context, data []byte, opts Options) (*Result, error)
+
+// ProcessReader decodes an image from r and generates a thumbnail like
+// GenerateFromReaderContext, recording the detected format in the Result.
+func ProcessReader(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var data []byte
+	var res *Result
+	if opts.KeepMetadata != 0 || engine != nil {
+		if data, err = io.ReadAll(ctxReader{ctx, r}); err != nil {
+			return nil, err
+		}
+		if engine != nil {
+			if res, err = engine(ctx, data, opts); err != nil {
+				return nil, err
+			}
+		}
+		r = newWholeReader(data)
+	}
+	if res == nil {
+		src, format, err := decodeFor(ctx, r, &opts)
+		if err != nil {
+			return nil, err
+		}
+		if res, err = process(ctx, src, opts); err != nil {
+			return nil, err
+		}
+		res.Format = format
+	} else {
+		// libvips turns every source it loads upright.
+		opts.oriented = !opts.NoAutoOrient
+		res.OutputFormat = outputFormatOf(res.Image, opts)
+		res.DominantColor = dominantColorOf(res.Image, opts)
+		if err := res.placeholders(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+	var md *Metadata
+	if opts.KeepMetadata != 0 {
+		md = readMetadata(data).selectFor(opts.KeepMetadata, opts.oriented).strip(opts.StripMetadata)
+	}
+	if md != nil && md.ICC != nil && convertedProfile(md.ICC, &opts) {
+		// The pixels are sRGB now.
+		md.ICC = nil
+		if md.EXIF == nil && md.XMP == nil {
+			md = nil
+		}
+	}
+	res.Metadata = md
+	return res, nil
+}
+
+// ProcessFile reads an image file and generates a thumbnail like
+// GenerateFromFileContext.
+func ProcessFile(ctx context.Context, path string, opts Options) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ProcessReader(ctx, f, opts)
+}
+
+// decodeFile decodes the image file at path.
+func decodeFile(ctx context.Context, path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, _, err := decodeFor(ctx, f, nil)
+	return src, err
+}
@@ -0,0 +1,183 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/image/draw"
+)
+
+// bandSize is the number of rows or columns scaled between checks for
+// cancellation.
+const bandSize = 64
+
+// scale resamples the sr portion of src into the dr portion of dst with
+// opts.scaler(), checking ctx between bands so that a long scale can be
+// abandoned, and scaling bands on up to opts.workers() goroutines.
+//
+// Kernel filters other than Box first shrink sources they reduce by at
+// least twice shrinkMargin with shrink, unless opts.NoPrefilter is set.
+// They then run as two separable passes through a 16-bit intermediate
+// from scratch, in bands of source rows and then of destination rows, with
+// weights that newKernelScaler caches for repeated sizes, and with the
+// AVX2 or NEON inner loops of internal/resample where the CPU has them.
+// Each pixel is computed on its own, so the result does not depend on how
+// the work is divided. NearestNeighbor and ApproxBiLinear compute each
+// pixel on their own too, and run in bands of destination rows; other
+// scalers are opaque, so they run in a single call.
+//
+// With opts.LinearLight, pixels are converted to linear light before
+// resampling and back after it. Kernel filters convert one row at a time.
+func scale(ctx context.Context, dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, opts Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dr.Size() == sr.Size() {
+		// Nothing to resample, e.g. an unscaled crop or NoUpscale.
+		draw.Draw(dst, dr, src, sr.Min, draw.Src)
+		return nil
+	}
+	scaler, linear, workers := opts.scaler(), opts.LinearLight, opts.workers()
+	if !concurrentWrites(dst) {
+		workers = 1
+	}
+	k, ok := scaler.(*draw.Kernel)
+	if !ok {
+		if !linear {
+			return scaleRows(ctx, dst, dr, src, sr, scaler, workers)
+		}
+		in := image.NewRGBA64(image.Rectangle{Max: sr.Size()})
+		linearize(in, src, sr)
+		out := image.NewRGBA64(image.Rectangle{Max: dr.Size()})
+		if err := scaleRows(ctx, out, out.Rect, in, in.Rect, scaler, opts.workers()); err != nil {
+			return err
+		}
+		delinearize(out)
+		draw.Draw(dst, dr, out, image.Point{}, draw.Src)
+		return nil
+	}
+
+	dw, dh := dr.Dx(), dr.Dy()
+	sw, sh := sr.Dx(), sr.Dy()
+	if fx, fy := shrinkFactors(sw, sh, dw, dh); (fx > 1 || fy > 1) && k != box && !opts.NoPrefilter {
+		mid, err := shrink(ctx, src, sr, fx, fy, linear, opts.workers())
+		if err != nil {
+			return err
+		}
+		defer scratch.release(mid)
+		opts.NoPrefilter = true
+		return scale(ctx, dst, dr, mid, mid.Bounds(), opts)
+	}
+	tmp := getTmp(dw, sh)
+	defer scratch.put(tmp.Pix)
+	ks := newKernelScaler(k, sw, sh, dw, dh)
+	err := parallel(ctx, ceilDiv(sh, bandSize), opts.workers(), func(i int) error {
+		resampleRows(tmp, i*bandSize, minInt((i+1)*bandSize, sh), src, sr, ks.h, linear)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return parallel(ctx, ceilDiv(dh, bandSize), workers, func(i int) error {
+		resampleColumns(dst, dr, i*bandSize, minInt((i+1)*bandSize, dh), tmp, ks.v, linear)
+		return nil
+	})
+}
+
+// scaleRows scales the sr portion of src into the dr portion of dst with
+// scaler, in bands of destination rows for the interpolators known to
+// compute each pixel on their own. Each band is scaled into a sub-image of
+// dst clipped to it, so that it maps the source as the whole of dr does.
+func scaleRows(ctx context.Context, dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, scaler draw.Scaler, workers int) error {
+	sub, ok := dst.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok || workers <= 1 || scaler != draw.NearestNeighbor && scaler != draw.ApproxBiLinear {
+		scaler.Scale(dst, dr, src, sr, draw.Src, nil)
+		return nil
+	}
+	return parallel(ctx, ceilDiv(dr.Dy(), bandSize), workers, func(i int) error {
+		y := dr.Min.Y + i*bandSize
+		band := image.Rect(dr.Min.X, y, dr.Max.X, minInt(y+bandSize, dr.Max.Y))
+		scaler.Scale(sub.SubImage(band).(draw.Image), dr, src, sr, draw.Src, nil)
+		return nil
+	})
+}
+
+// parallel runs band for each of the n bands of a scale, on up to workers
+// goroutines, checking ctx before each. It returns the first error.
+func parallel(ctx context.Context, n, workers int, band func(i int) error) error {
+	workers = minInt(workers, n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := band(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		next  int32 = -1
+		once  sync.Once
+		first error
+		wg    sync.WaitGroup
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= n {
+					return
+				}
+				err := ctx.Err()
+				if err == nil {
+					err = band(i)
+				}
+				if err != nil {
+					once.Do(func() { first = err })
+					// Leave the remaining bands undone.
+					atomic.StoreInt32(&next, int32(n))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
+
+// workers returns the number of goroutines o lets a scale use.
+func (o Options) workers() int {
+	if o.Parallelism > 0 {
+		return o.Parallelism
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// concurrentWrites reports whether separate goroutines may write disjoint
+// parts of img at once, which holds for the image package's types, but
+// not necessarily for others.
+func concurrentWrites(img draw.Image) bool {
+	switch img.(type) {
+	case *image.RGBA, *image.RGBA64, *image.NRGBA, *image.NRGBA64,
+		*image.Gray, *image.Gray16, *image.Alpha, *image.Alpha16,
+		*image.CMYK, *image.Paletted:
+		return true
+	}
+	return false
+}
+
+// getTmp returns a w×h intermediate image from scratch, with unspecified
+// contents.
+func getTmp(w, h int) *image.RGBA64 {
+	return scratch.rgba64(image.Rect(0, 0, w, h), false)
+}
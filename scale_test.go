@@ -0,0 +1,30 @@
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestParallelismKeepsOutput(t *testing.T) {
+	src := testImage(701, 523, true)
+	for _, filter := range []Filter{CatmullRom, Lanczos, BiLinear, ApproxBiLinear, NearestNeighbor, Mitchell, Box} {
+		for _, linear := range []bool{false, true} {
+			name := fmt.Sprintf("%v, linear light %v", filter, linear)
+			var want image.Image
+			for _, n := range []int{1, 2, 3, 7, 16} {
+				opts := Options{Width: 211, Height: 211, Filter: filter, LinearLight: linear, Parallelism: n}
+				img, err := Generate(src, opts)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if want == nil {
+					want = img
+				} else if !reflect.DeepEqual(img, want) {
+					t.Errorf("%s: Parallelism %d differs from 1", name, n)
+				}
+			}
+		}
+	}
+}
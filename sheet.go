@@ -0,0 +1,194 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font/opentype"
+)
+
+// Sheet describes the layout of a contact sheet made by ContactSheet: a
+// grid of thumbnails, in rows from the top left, each centered in a cell
+// and optionally labeled below it.
+type Sheet struct {
+	// Columns is the number of cells in each row. Zero picks the fewest
+	// that make the sheet no taller than it is wide in cells.
+	Columns int
+
+	// CellWidth and CellHeight are the size of each cell. Images are made
+	// into thumbnails that fit within it, as by Fit mode with Options.
+	// When both are zero the cells are the size of the largest image,
+	// which are drawn unchanged, as when they are thumbnails already.
+	CellWidth, CellHeight int
+
+	// Spacing is the gap in pixels between cells and around the grid.
+	Spacing int
+
+	// Background is the color of the sheet. Nil is white.
+	Background color.Color
+
+	// Labels, when set, holds a label for each image, drawn centered below
+	// its cell and shortened to fit it. ContactSheetFiles labels images
+	// with their file names.
+	Labels []string
+
+	// Font is the font of the labels; nil uses Go Regular. LabelSize is
+	// their size in pixels, 12 when zero, and LabelColor their color,
+	// black when nil.
+	Font       *opentype.Font
+	LabelSize  float64
+	LabelColor color.Color
+
+	// Options are the options thumbnails are made with when the cell size
+	// is set, apart from Width, Height and Mode.
+	Options Options
+}
+
+// ContactSheet lays out images, or thumbnails of them, as a grid on a
+// single image as s describes, for reviewing many pictures or video frames
+// at a glance.
+func ContactSheet(ctx context.Context, images []image.Image, s Sheet) (*image.RGBA, error) {
+	if len(images) == 0 || s.Columns < 0 || s.Spacing < 0 || s.CellWidth < 0 || s.CellHeight < 0 {
+		return nil, ErrInvalidSheet
+	}
+	thumbs := images
+	if s.CellWidth > 0 || s.CellHeight > 0 {
+		thumbs = make([]image.Image, len(images))
+		opts := s.Options
+		opts.Width, opts.Height, opts.Mode = s.CellWidth, s.CellHeight, Fit
+		for i, img := range images {
+			r, err := Process(ctx, img, opts)
+			if err != nil {
+				return nil, err
+			}
+			thumbs[i] = r.Image
+		}
+	}
+	return layoutSheet(ctx, thumbs, s)
+}
+
+// ContactSheetFiles is like ContactSheet for the images in the files at
+// paths, labeled with their file names unless s.Labels is set. Only the
+// thumbnails are kept in memory, so the cell size must be set.
+func ContactSheetFiles(ctx context.Context, paths []string, s Sheet) (*image.RGBA, error) {
+	if len(paths) == 0 || s.Columns < 0 || s.Spacing < 0 || s.CellWidth < 0 || s.CellHeight < 0 ||
+		s.CellWidth == 0 && s.CellHeight == 0 {
+		return nil, ErrInvalidSheet
+	}
+	opts := s.Options
+	opts.Width, opts.Height, opts.Mode = s.CellWidth, s.CellHeight, Fit
+	thumbs := make([]image.Image, len(paths))
+	for i, path := range paths {
+		r, err := ProcessFile(ctx, path, opts)
+		if err != nil {
+			return nil, err
+		}
+		thumbs[i] = r.Image
+	}
+	if s.Labels == nil {
+		s.Labels = make([]string, len(paths))
+		for i, path := range paths {
+			s.Labels[i] = filepath.Base(path)
+		}
+	}
+	return layoutSheet(ctx, thumbs, s)
+}
+
+// layoutSheet draws thumbs, which are no larger than the cells of s, onto
+// a new contact sheet.
+func layoutSheet(ctx context.Context, thumbs []image.Image, s Sheet) (*image.RGBA, error) {
+	cell := image.Pt(s.CellWidth, s.CellHeight)
+	if cell.X == 0 || cell.Y == 0 {
+		for _, t := range thumbs {
+			size := t.Bounds().Size()
+			cell.X, cell.Y = maxInt(cell.X, size.X), maxInt(cell.Y, size.Y)
+		}
+	}
+	labels, err := s.labels(len(thumbs), cell.X)
+	if err != nil {
+		return nil, err
+	}
+	labelHeight := 0
+	for _, l := range labels {
+		if l != nil {
+			labelHeight = maxInt(labelHeight, l.Bounds().Dy())
+		}
+	}
+	if labelHeight > 0 {
+		labelHeight += s.Spacing / 2
+	}
+
+	cols := s.Columns
+	if cols == 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(thumbs)))))
+	}
+	cols = minInt(cols, len(thumbs))
+	rows := ceilDiv(len(thumbs), cols)
+	pitch := image.Pt(cell.X+s.Spacing, cell.Y+labelHeight+s.Spacing)
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*pitch.X+s.Spacing, rows*pitch.Y+s.Spacing))
+	bg := s.Background
+	if bg == nil {
+		bg = color.White
+	}
+	draw.Draw(sheet, sheet.Rect, image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for i, t := range thumbs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		at := image.Pt(s.Spacing+i%cols*pitch.X, s.Spacing+i/cols*pitch.Y)
+		tb := t.Bounds()
+		off := at.Add(cell.Sub(tb.Size()).Div(2))
+		draw.Draw(sheet, image.Rectangle{off, off.Add(tb.Size())}, t, tb.Min, draw.Over)
+		if labels[i] != nil {
+			lb := labels[i].Bounds()
+			off := image.Pt(at.X+(cell.X-lb.Dx())/2, at.Y+cell.Y+s.Spacing/2)
+			draw.Draw(sheet, image.Rectangle{off, off.Add(lb.Size())}, labels[i], image.Point{}, draw.Over)
+		}
+	}
+	return sheet, nil
+}
+
+// labels returns the labels of s for n cells, rendered no wider than
+// width, with nil for cells without one.
+func (s Sheet) labels(n, width int) ([]*image.RGBA, error) {
+	labels := make([]*image.RGBA, n)
+	if len(s.Labels) == 0 {
+		return labels, nil
+	}
+	f, err := fontOrDefault(s.Font)
+	if err != nil {
+		return nil, err
+	}
+	size := s.LabelSize
+	if size <= 0 {
+		size = 12
+	}
+	col := s.LabelColor
+	if col == nil {
+		col = color.Black
+	}
+	for i := 0; i < n && i < len(s.Labels); i++ {
+		if s.Labels[i] == "" {
+			continue
+		}
+		c := Caption{Text: s.Labels[i], Color: col}
+		// Drop characters from the end until the label fits.
+		text := []rune(c.Text)
+		for {
+			if labels[i], err = c.render(f, size); err != nil {
+				return nil, err
+			}
+			if labels[i].Bounds().Dx() <= width || len(text) == 0 {
+				break
+			}
+			text = text[:len(text)-1]
+			c.Text = string(text) + "…"
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,116 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// shrinkMargin is how many times larger than the destination, along each
+// axis, box shrinking leaves the source for the kernel filter that
+// finishes the scale, as libvips does, so that the filter still has the
+// detail to work with.
+const shrinkMargin = 2
+
+// shrinkFactors returns the integer factors by which scale box-shrinks an
+// sw×sh source before filtering it to dw×dh: the largest that leave
+// shrinkMargin times the destination size, and so 1 along axes reduced by
+// less than twice that.
+func shrinkFactors(sw, sh, dw, dh int) (fx, fy int) {
+	return maxInt(1, sw/(shrinkMargin*dw)), maxInt(1, sh/(shrinkMargin*dh))
+}
+
+// shrink averages each fx×fy box of the sr portion of src into a pixel of
+// an *image.RGBA from scratch, or of an *image.RGBA64 for 16-bit sources
+// and with linear, in which case the boxes are averaged in linear light. Rows and
+// columns left over by the factors are dropped evenly from both sides.
+// Bands of rows are shrunk on up to workers goroutines.
+func shrink(ctx context.Context, src image.Image, sr image.Rectangle, fx, fy int, linear bool, workers int) (draw.Image, error) {
+	w, h := sr.Dx()/fx, sr.Dy()/fy
+	origin := sr.Min.Add(image.Pt(sr.Dx()%fx/2, sr.Dy()%fy/2))
+	deep := linear || highBitDepth(src.ColorModel())
+	var out8 *image.RGBA
+	var out16 *image.RGBA64
+	if deep {
+		out16 = scratch.rgba64(image.Rect(0, 0, w, h), false)
+	} else {
+		out8 = scratch.rgba(image.Rect(0, 0, w, h), false)
+	}
+
+	n := uint64(fx * fy)
+	err := parallel(ctx, ceilDiv(h, bandSize), workers, func(i int) error {
+		acc := make([]uint64, 4*w)
+		var row8 *image.RGBA
+		var row16 *image.RGBA64
+		if deep {
+			row16 = getTmp(w*fx, 1)
+			defer scratch.put(row16.Pix)
+		} else {
+			row8 = scratch.rgba(image.Rect(0, 0, w*fx, 1), false)
+			defer scratch.put(row8.Pix)
+		}
+		y0, y1 := i*bandSize, minInt((i+1)*bandSize, h)
+		for y := y0; y < y1; y++ {
+			for k := range acc {
+				acc[k] = 0
+			}
+			for j := 0; j < fy; j++ {
+				sp := origin.Add(image.Pt(0, y*fy+j))
+				if !deep {
+					draw.Draw(row8, row8.Rect, src, sp, draw.Src)
+					for x, p := 0, row8.Pix; x < w; x++ {
+						var r, g, b, a uint64
+						q := p[4*x*fx : 4*(x+1)*fx]
+						for k := 0; k < len(q); k += 4 {
+							r, g, b, a = r+uint64(q[k]), g+uint64(q[k+1]), b+uint64(q[k+2]), a+uint64(q[k+3])
+						}
+						acc[4*x] += r
+						acc[4*x+1] += g
+						acc[4*x+2] += b
+						acc[4*x+3] += a
+					}
+					continue
+				}
+				if linear {
+					linearize(row16, src, image.Rectangle{Min: sp, Max: sp.Add(image.Pt(w*fx, 1))})
+				} else {
+					draw.Draw(row16, row16.Rect, src, sp, draw.Src)
+				}
+				for x, p := 0, row16.Pix; x < w; x++ {
+					for k := x * fx; k < (x+1)*fx; k++ {
+						q := p[8*k : 8*k+8]
+						for c := 0; c < 4; c++ {
+							acc[4*x+c] += uint64(q[2*c])<<8 | uint64(q[2*c+1])
+						}
+					}
+				}
+			}
+			if !deep {
+				px := out8.Pix[y*out8.Stride : y*out8.Stride+4*w]
+				for k, v := range acc {
+					px[k] = uint8((v + n/2) / n)
+				}
+				continue
+			}
+			px := out16.Pix[y*out16.Stride : y*out16.Stride+8*w]
+			for k, v := range acc {
+				v = (v + n/2) / n
+				px[2*k], px[2*k+1] = uint8(v>>8), uint8(v)
+			}
+		}
+		if linear {
+			delinearize(out16.SubImage(image.Rect(0, y0, w, y1)).(*image.RGBA64))
+		}
+		return nil
+	})
+	var out draw.Image = out8
+	if deep {
+		out = out16
+	}
+	if err != nil {
+		scratch.release(out)
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,199 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// GenerateSizes generates one thumbnail per entry of sizes from a single
+// source, returning them in the same order.
+func GenerateSizes(src image.Image, sizes []Options) ([]image.Image, error) {
+	results, err := ProcessSizes(context.Background(), src, sizes)
+	if err != nil {
+		return nil, err
+	}
+	return resultImages(results), nil
+}
+
+// GenerateSizesFromFile decodes an image file once and generates one
+// thumbnail per entry of sizes from it.
+func GenerateSizesFromFile(path string, sizes []Options) ([]image.Image, error) {
+	ctx := context.Background()
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	src, err := decodeFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	results, err := processSizes(ctx, src, sizes)
+	if err != nil {
+		return nil, err
+	}
+	return resultImages(results), nil
+}
+
+// ProcessSizes generates one thumbnail per entry of sizes from a single
+// source like Process, returning the results in the same order.
+//
+// The largest thumbnails are produced first. Each smaller one is then
+// scaled from the smallest earlier full-frame result (Fit or Stretch) that
+// still has at least as many pixels as it needs, and was scaled with the
+// same HighBitDepth, LinearLight, Filter and Scaler, rather than from the
+// source, which avoids repeatedly resampling a large original. Likewise
+// Options.Detector is run once, that of the first size that has one, and
+// the regions it finds are used for every size.
+func ProcessSizes(ctx context.Context, src image.Image, sizes []Options) ([]*Result, error) {
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return processSizes(ctx, src, sizes)
+}
+
+// processSizes is ProcessSizes without waiting for a slot under
+// SetMaxConcurrent.
+func processSizes(ctx context.Context, src image.Image, sizes []Options) ([]*Result, error) {
+	if src == nil {
+		return nil, ErrNilImage
+	}
+	sb := src.Bounds()
+
+	type job struct {
+		index int
+		l     layout
+		opts  Options
+	}
+	jobs := make([]job, len(sizes))
+	var crops cropCache // shared by all sizes
+	for i, o := range sizes {
+		l, o, err := prepare(sb.Dx(), sb.Dy(), o)
+		if err == nil {
+			l, err = autoCrop(ctx, l, src, o, &crops)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("thumbnail: size %d: %w", i, err)
+		}
+		jobs[i] = job{i, l, o}
+	}
+	sort.SliceStable(jobs, func(a, b int) bool {
+		return area(jobs[a].l.dst) > area(jobs[b].l.dst)
+	})
+
+	full := image.Rect(0, 0, sb.Dx(), sb.Dy())
+	results := make([]*Result, len(sizes))
+	type frame struct {
+		img  draw.Image
+		opts Options
+	}
+	var frames []frame // full-frame results, largest first
+	for _, j := range jobs {
+		if passthrough(sb.Dx(), sb.Dy(), j.opts) {
+			results[j.index] = &Result{
+				Image:         src,
+				SourceWidth:   sb.Dx(),
+				SourceHeight:  sb.Dy(),
+				Width:         sb.Dx(),
+				Height:        sb.Dy(),
+				Scale:         1,
+				OutputFormat:  outputFormatOf(src, j.opts),
+				DominantColor: dominantColorOf(src, j.opts),
+			}
+			if err := results[j.index].placeholders(ctx, j.opts); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		from, l := src, j.l
+		for k := len(frames) - 1; k >= 0; k-- {
+			if !sameScaling(frames[k].opts, j.opts) {
+				continue
+			}
+			if r, ok := mapToFrame(j.l, full, frames[k].img.Bounds().Size()); ok {
+				from, l.src = frames[k].img, r
+				break
+			}
+		}
+
+		dst := newThumb(l.size, src, j.opts)
+		if err := render(ctx, dst, image.Point{}, from, l, j.opts); err != nil {
+			return nil, err
+		}
+		if j.l.src == full && j.l.dst.Size() == j.l.size && area(j.l.dst) < area(full) && !masked(j.opts) && j.opts.Obscure == ObscureNone {
+			frames = append(frames, frame{dst, j.opts})
+		}
+
+		img := matchColorModel(dst, src, j.opts)
+		results[j.index] = &Result{
+			Image:         img,
+			SourceWidth:   sb.Dx(),
+			SourceHeight:  sb.Dy(),
+			Width:         j.l.size.X,
+			Height:        j.l.size.Y,
+			Scale:         float64(j.l.dst.Dx()) / float64(j.l.src.Dx()),
+			OutputFormat:  outputFormatOf(img, j.opts),
+			DominantColor: dominantColorOf(img, j.opts),
+		}
+		if err := results[j.index].placeholders(ctx, j.opts); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// mapToFrame translates the source region of l from full-source
+// coordinates into a full-frame result of the given size. It reports false
+// if that region would have fewer pixels than l needs, so that scaling
+// from the frame would mean enlarging it.
+func mapToFrame(l layout, full image.Rectangle, frame image.Point) (image.Rectangle, bool) {
+	fx := float64(frame.X) / float64(full.Dx())
+	fy := float64(frame.Y) / float64(full.Dy())
+	r := image.Rect(
+		int(math.Round(float64(l.src.Min.X)*fx)),
+		int(math.Round(float64(l.src.Min.Y)*fy)),
+		int(math.Round(float64(l.src.Max.X)*fx)),
+		int(math.Round(float64(l.src.Max.Y)*fy)),
+	)
+	if r.Dx() < l.dst.Dx() || r.Dy() < l.dst.Dy() {
+		return image.Rectangle{}, false
+	}
+	return r, true
+}
+
+// sameScaling reports whether thumbnails for a and b are scaled alike, at
+// the same depth, in the same light and with the same filter, so that one
+// can be scaled from the other.
+func sameScaling(a, b Options) bool {
+	if a.HighBitDepth != b.HighBitDepth || a.LinearLight != b.LinearLight || a.Filter != b.Filter {
+		return false
+	}
+	if a.Scaler == nil || b.Scaler == nil {
+		return a.Scaler == b.Scaler
+	}
+	// Scalers of uncomparable types cannot be told apart.
+	return reflect.TypeOf(a.Scaler).Comparable() && a.Scaler == b.Scaler
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+func resultImages(results []*Result) []image.Image {
+	images := make([]image.Image, len(results))
+	for i, res := range results {
+		images[i] = res.Image
+	}
+	return images
+}
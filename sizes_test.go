@@ -0,0 +1,38 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestProcessSizesReusesOnlyLikeFrames(t *testing.T) {
+	src := testImage(800, 600, false)
+	small := Options{Width: 100, Height: 100}
+	for name, large := range map[string]Options{
+		"filter":         {Width: 400, Height: 400, Filter: NearestNeighbor},
+		"scaler":         {Width: 400, Height: 400, Scaler: draw.ApproxBiLinear},
+		"linear light":   {Width: 400, Height: 400, LinearLight: true},
+		"high bit depth": {Width: 400, Height: 400, HighBitDepth: true},
+	} {
+		results, err := ProcessSizes(context.Background(), src, []Options{large, small})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Generate(src, small)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(results[1].Image, want) {
+			t.Errorf("%s: thumbnail scaled from a frame scaled differently", name)
+		}
+	}
+}
+
+// sameImage reports whether a and b have the same bounds and pixels.
+func sameImage(a, b image.Image) bool {
+	return a.Bounds() == b.Bounds() && reflect.DeepEqual(toNRGBA(a).Pix, toNRGBA(b).Pix)
+}
@@ -0,0 +1,80 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// smartSize is the size of the longer side of the reduced copy of the
+// source that the Smart gravity measures detail in.
+const smartSize = 256
+
+// energyMap holds the detail, or edge energy, of a reduced copy of a source
+// as a summed-area table, so that the energy within any window is found
+// with four lookups.
+type energyMap struct {
+	w, h  int
+	scale float64   // size of the copy relative to the source
+	sum   []float64 // (w+1)×(h+1) table of the energy above and left
+}
+
+// newEnergyMap measures the detail of src.
+func newEnergyMap(src image.Image) *energyMap {
+	b := src.Bounds()
+	scale := math.Min(1, smartSize/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Rect, src, b, draw.Src, nil)
+
+	lum := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		px := small.Pix[y*small.Stride:]
+		for x := 0; x < w; x++ {
+			lum[y*w+x] = int(luma(px[4*x], px[4*x+1], px[4*x+2])) * int(px[4*x+3]) / 0xff
+		}
+	}
+	m := &energyMap{w: w, h: h, scale: float64(w) / float64(b.Dx()), sum: make([]float64, (w+1)*(h+1))}
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			// Central differences, with the edges repeated.
+			dx := lum[y*w+minInt(x+1, w-1)] - lum[y*w+maxInt(x-1, 0)]
+			dy := lum[minInt(y+1, h-1)*w+x] - lum[maxInt(y-1, 0)*w+x]
+			row += float64(absInt(dx) + absInt(dy))
+			m.sum[(y+1)*(w+1)+x+1] = m.sum[y*(w+1)+x+1] + row
+		}
+	}
+	return m
+}
+
+// energy returns the energy within r, in the coordinates of the copy.
+func (m *energyMap) energy(r image.Rectangle) float64 {
+	s := func(x, y int) float64 { return m.sum[y*(m.w+1)+x] }
+	return s(r.Max.X, r.Max.Y) - s(r.Min.X, r.Max.Y) - s(r.Max.X, r.Min.Y) + s(r.Min.X, r.Min.Y)
+}
+
+// window returns the position within full, the source's bounds relative
+// to its origin, of the crop of the given size with the most detail. Of
+// equally detailed positions it picks the one nearest the center.
+func (m *energyMap) window(size image.Point, full image.Rectangle) image.Rectangle {
+	ww := clampInt(int(math.Round(float64(size.X)*m.scale)), 1, m.w)
+	wh := clampInt(int(math.Round(float64(size.Y)*m.scale)), 1, m.h)
+	cx, cy := float64(m.w-ww)/2, float64(m.h-wh)/2
+	best, bx, by := -1.0, 0, 0
+	bestDist := math.Inf(1)
+	for y := 0; y <= m.h-wh; y++ {
+		for x := 0; x <= m.w-ww; x++ {
+			e := m.energy(image.Rect(x, y, x+ww, y+wh))
+			d := math.Hypot(float64(x)-cx, float64(y)-cy)
+			if e > best || e == best && d < bestDist {
+				best, bestDist, bx, by = e, d, x, y
+			}
+		}
+	}
+	x := clampInt(int(math.Round(float64(bx)/m.scale)), full.Min.X, full.Max.X-size.X)
+	y := clampInt(int(math.Round(float64(by)/m.scale)), full.Min.Y, full.Max.Y-size.Y)
+	return image.Rect(x, y, x+size.X, y+size.Y)
+}
@@ -0,0 +1,117 @@
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Sprite is the place of one image within a SpriteSheet.
+type Sprite struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// SpriteSheet is many images packed into one, so that clients fetch them
+// in a single request, as for video scrubbing previews and icon sets. It
+// marshals to JSON as its size and sprites, the coordinate map clients
+// look the images up in.
+type SpriteSheet struct {
+	Image   *image.RGBA `json:"-"`
+	Width   int         `json:"width"`
+	Height  int         `json:"height"`
+	Sprites []Sprite    `json:"sprites"`
+}
+
+// PackSprites packs images, usually thumbnails, into a sprite sheet with
+// padding pixels around each, returning their places in the order of
+// images. names, when not nil, holds a name for each image; otherwise they
+// are named by their index. Rows are filled left to right with images of
+// decreasing height, and equal heights keep their order, so frames of a
+// video form a grid in time order.
+func PackSprites(images []image.Image, names []string, padding int) (*SpriteSheet, error) {
+	if len(images) == 0 || padding < 0 || names != nil && len(names) != len(images) {
+		return nil, ErrInvalidSheet
+	}
+	order := make([]int, len(images))
+	var area, widest int
+	for i, img := range images {
+		order[i] = i
+		size := img.Bounds().Size().Add(image.Pt(padding, padding))
+		area += size.X * size.Y
+		widest = maxInt(widest, size.X)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return images[order[a]].Bounds().Dy() > images[order[b]].Bounds().Dy()
+	})
+
+	// Shelves as wide as a square of the same area keep the sheet about
+	// square.
+	limit := maxInt(widest, int(math.Ceil(math.Sqrt(float64(area)))))
+	sheet := &SpriteSheet{Sprites: make([]Sprite, len(images))}
+	x, y, shelf := padding, padding, 0
+	for _, i := range order {
+		size := images[i].Bounds().Size()
+		if x > padding && x+size.X > limit {
+			x, y, shelf = padding, y+shelf+padding, 0
+		}
+		name := strconv.Itoa(i)
+		if names != nil {
+			name = names[i]
+		}
+		sheet.Sprites[i] = Sprite{Name: name, X: x, Y: y, Width: size.X, Height: size.Y}
+		sheet.Width = maxInt(sheet.Width, x+size.X+padding)
+		sheet.Height = maxInt(sheet.Height, y+size.Y+padding)
+		x += size.X + padding
+		shelf = maxInt(shelf, size.Y)
+	}
+
+	sheet.Image = image.NewRGBA(image.Rect(0, 0, sheet.Width, sheet.Height))
+	for i, img := range images {
+		s := sheet.Sprites[i]
+		draw.Draw(sheet.Image, image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height), img, img.Bounds().Min, draw.Src)
+	}
+	return sheet, nil
+}
+
+// CSS returns a style sheet with a rule for each sprite, of the class
+// prefix followed by a hyphen and the sprite's name, that shows it from the
+// sheet at url. Characters of names that cannot appear in class names are
+// replaced by hyphens.
+func (s *SpriteSheet) CSS(prefix, url string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[class^=\"%s-\"], [class*=\" %s-\"] { background-image: url(%q); background-repeat: no-repeat; }\n", prefix, prefix, url)
+	for _, sp := range s.Sprites {
+		fmt.Fprintf(&b, ".%s-%s { background-position: %s %s; width: %dpx; height: %dpx; }\n",
+			prefix, cssIdent(sp.Name), cssOffset(sp.X), cssOffset(sp.Y), sp.Width, sp.Height)
+	}
+	return b.String()
+}
+
+// cssIdent returns name with the characters other than ASCII letters,
+// digits, hyphens and underscores replaced by hyphens.
+func cssIdent(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// cssOffset returns the background position that shows the sheet from v
+// pixels in.
+func cssOffset(v int) string {
+	if v == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("-%dpx", v)
+}
@@ -0,0 +1,240 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// rgbGrid is the number of points per channel of the lookup table that
+// RGB profile conversion interpolates in.
+const rgbGrid = 33
+
+// convertColors reports whether decodeFor converts sources with an RGB
+// profile to sRGB for opts, which it does by default.
+func convertColors(opts *Options) bool {
+	return opts == nil || !opts.NoColorConvert
+}
+
+// rgbProfile parses icc as the profile of an RGB source and returns it
+// when converting to sRGB changes the source's colors. It returns nil for
+// missing, unsupported and non-RGB profiles, and for sRGB itself.
+func rgbProfile(icc []byte) *ICCProfile {
+	if len(icc) == 0 {
+		return nil
+	}
+	p, err := ParseICCProfile(icc)
+	if err != nil || p.ColorSpace != "RGB " || p.isSRGB() {
+		return nil
+	}
+	return p
+}
+
+// convertedProfile reports whether decoding for opts converts the pixels
+// of a source with profile icc to sRGB, so that the profile no longer
+// describes them.
+func convertedProfile(icc []byte, opts *Options) bool {
+	if rgbProfile(icc) != nil {
+		return convertColors(opts)
+	}
+	p, err := ParseICCProfile(icc)
+	return err == nil && p.ColorSpace == "CMYK"
+}
+
+// isSRGB reports whether p leaves colors unchanged within rounding, as the
+// many variants of the sRGB profile do.
+func (p *ICCProfile) isSRGB() bool {
+	for _, c := range [][]float64{
+		{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 1},
+		{0.2, 0.2, 0.2}, {0.5, 0.5, 0.5}, {0.8, 0.4, 0.1}, {0.1, 0.6, 0.9},
+	} {
+		r, g, b := p.sRGB(c)
+		if math.Abs(r-c[0]) > 1.5/0xff || math.Abs(g-c[1]) > 1.5/0xff || math.Abs(b-c[2]) > 1.5/0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// rgbLUT is an RGB to sRGB transform sampled on an rgbGrid³ grid. Its
+// samples are not clipped to the sRGB gamut, so that colors between grid
+// points interpolate as they convert; lookup results need to be clamped.
+type rgbLUT [][3]float32
+
+// newRGBLUT samples the conversion from p to sRGB.
+func newRGBLUT(p *ICCProfile) rgbLUT {
+	lut := make(rgbLUT, rgbGrid*rgbGrid*rgbGrid)
+	// The tone curves of matrix/TRC profiles apply per channel, so only
+	// need evaluating at each grid value once.
+	var lin [3][rgbGrid]float64
+	if p.a2b == nil {
+		for c := range lin {
+			for k := range lin[c] {
+				lin[c][k] = p.trc[c].apply(float64(k) / (rgbGrid - 1))
+			}
+		}
+	}
+	in := make([]float64, 3)
+	for i := range lut {
+		ri, gi, bi := i/(rgbGrid*rgbGrid), i/rgbGrid%rgbGrid, i%rgbGrid
+		var r, g, b float64
+		if p.a2b == nil {
+			m, v := p.matrix, [3]float64{lin[0][ri], lin[1][gi], lin[2][bi]}
+			r, g, b = xyzToLinearSRGB(
+				m[0]*v[0]+m[1]*v[1]+m[2]*v[2],
+				m[3]*v[0]+m[4]*v[1]+m[5]*v[2],
+				m[6]*v[0]+m[7]*v[1]+m[8]*v[2],
+			)
+		} else {
+			in[0], in[1], in[2] = float64(ri)/(rgbGrid-1), float64(gi)/(rgbGrid-1), float64(bi)/(rgbGrid-1)
+			r, g, b = p.linearSRGB(in)
+		}
+		lut[i] = [3]float32{float32(sRGBExtend(r)), float32(sRGBExtend(g)), float32(sRGBExtend(b))}
+	}
+	return lut
+}
+
+// sRGBExtend applies the sRGB transfer function to a linear value without
+// clamping it, mirrored for negative values.
+func sRGBExtend(v float64) float64 {
+	switch {
+	case v < 0:
+		return -sRGBExtend(-v)
+	case v <= 0.0031308:
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// lookup converts a color with channels in 0-1 by trilinear interpolation.
+func (l rgbLUT) lookup(c [3]float32) [3]float32 {
+	var k [3]int
+	var f [3]float32
+	for i, v := range c {
+		v *= rgbGrid - 1
+		k[i] = int(v)
+		if k[i] >= rgbGrid-1 {
+			k[i] = rgbGrid - 2
+		}
+		f[i] = v - float32(k[i])
+	}
+	const sr, sg = rgbGrid * rgbGrid, rgbGrid
+	i := k[0]*sr + k[1]*sg + k[2]
+	var out [3]float32
+	for ch := range out {
+		// Interpolate along blue, then green, then red.
+		c00 := l[i][ch] + f[2]*(l[i+1][ch]-l[i][ch])
+		c01 := l[i+sg][ch] + f[2]*(l[i+sg+1][ch]-l[i+sg][ch])
+		c10 := l[i+sr][ch] + f[2]*(l[i+sr+1][ch]-l[i+sr][ch])
+		c11 := l[i+sr+sg][ch] + f[2]*(l[i+sr+sg+1][ch]-l[i+sr+sg][ch])
+		c0 := c00 + f[1]*(c01-c00)
+		c1 := c10 + f[1]*(c11-c10)
+		out[ch] = c0 + f[0]*(c1-c0)
+	}
+	return out
+}
+
+// toSRGB converts img, whose colors are in profile p, to sRGB. Paletted
+// images keep their pixels and have their palette converted. Others are
+// converted to *image.NRGBA64 at 16 bits per channel, and otherwise to
+// *image.RGBA unless they are *image.NRGBA.
+// Gray images are returned unchanged.
+func toSRGB(ctx context.Context, img image.Image, p *ICCProfile) (image.Image, error) {
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return img, nil
+	}
+	lut := newRGBLUT(p)
+
+	if m, ok := img.(*image.Paletted); ok {
+		pal := make(color.Palette, len(m.Palette))
+		for i, c := range m.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			v := lut.lookup([3]float32{float32(n.R) / 0xff, float32(n.G) / 0xff, float32(n.B) / 0xff})
+			pal[i] = color.NRGBA{to8(v[0]), to8(v[1]), to8(v[2]), n.A}
+		}
+		m.Palette = pal
+		return m, nil
+	}
+
+	b := img.Bounds()
+	if highBitDepth(img.ColorModel()) {
+		m, ok := img.(*image.NRGBA64)
+		if !ok {
+			m = image.NewNRGBA64(b)
+			draw.Draw(m, b, img, b.Min, draw.Src)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if (y-b.Min.Y)%bandSize == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			px := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := 0; i < 8*b.Dx(); i += 8 {
+				if px[i+6] == 0 && px[i+7] == 0 {
+					continue
+				}
+				var c [3]float32
+				for k := range c {
+					c[k] = float32(uint16(px[i+2*k])<<8|uint16(px[i+2*k+1])) / 0xffff
+				}
+				v := lut.lookup(c)
+				for k := range v {
+					u := uint16(math.Round(math.Max(0, math.Min(1, float64(v[k]))) * 0xffff))
+					px[i+2*k], px[i+2*k+1] = byte(u>>8), byte(u)
+				}
+			}
+		}
+		return m, nil
+	}
+
+	// Other sources are converted to RGBA, which the decoders' YCbCr
+	// output has fast paths for, and unpremultiplied pixel by pixel.
+	var pix []byte
+	var stride int
+	var premul bool
+	if m, ok := img.(*image.NRGBA); ok {
+		img, pix, stride = m, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride
+	} else {
+		m := image.NewRGBA(b)
+		draw.Draw(m, b, img, b.Min, draw.Src)
+		img, pix, stride, premul = m, m.Pix, m.Stride, true
+	}
+	for y := 0; y < b.Dy(); y++ {
+		if y%bandSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		px := pix[y*stride:]
+		for i := 0; i < 4*b.Dx(); i += 4 {
+			a := px[i+3]
+			if a == 0 {
+				continue
+			}
+			c := [3]float32{float32(px[i]) / 0xff, float32(px[i+1]) / 0xff, float32(px[i+2]) / 0xff}
+			if premul && a != 0xff {
+				s := float32(0xff) / float32(a)
+				c[0], c[1], c[2] = c[0]*s, c[1]*s, c[2]*s
+			}
+			v := lut.lookup(c)
+			if premul && a != 0xff {
+				s := float32(a) / 0xff
+				for k := range v {
+					v[k] = float32(math.Max(0, math.Min(1, float64(v[k])))) * s
+				}
+			}
+			px[i], px[i+1], px[i+2] = to8(v[0]), to8(v[1]), to8(v[2])
+		}
+	}
+	return img, nil
+}
+
+// to8 quantizes a channel value to 8 bits, clamping it to 0-1.
+func to8(v float32) uint8 {
+	return clamp255(int(v*0xff + 0.5))
+}
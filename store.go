@@ -0,0 +1,123 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidKey is returned by DirStore for keys that are not valid
+// slash-separated relative paths, such as keys containing "..".
+var ErrInvalidKey = errors.New("thumbnail: invalid store key")
+
+// Store persists encoded thumbnails under string keys. Implementations
+// might write to local disk, object storage or a cache.
+type Store interface {
+	// Put stores the contents of r under key, replacing any existing value.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns the value stored under key. It returns an error wrapping
+	// fs.ErrNotExist if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether a value is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// DirStore is a Store that keeps each value as a file below Dir. Keys are
+// slash-separated paths relative to Dir.
+type DirStore struct {
+	Dir string
+}
+
+// Put writes r to a temporary file and renames it into place, so readers
+// never observe a partially written thumbnail.
+func (s DirStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumbnail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := io.Copy(tmp, ctxReader{ctx, r}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the file stored under key.
+func (s DirStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Exists reports whether a file is stored under key.
+func (s DirStore) Exists(ctx context.Context, key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s DirStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("%w %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// SaveToStore encodes img and stores it in st under key. Like Save, the
+// format is opts.Format when set and is otherwise inferred from the key's
+// extension.
+func SaveToStore(ctx context.Context, st Store, key string, img image.Image, opts Options) error {
+	opts.Format = outputFormat(key, opts)
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		return err
+	}
+	return st.Put(ctx, key, &buf)
+}
+
+// GenerateAndStore is like GenerateAndSave but writes the thumbnail to st
+// under key instead of to a local file.
+func GenerateAndStore(ctx context.Context, st Store, inputPath, key string, opts Options) error {
+	if format := outputFormat(key, opts); !hasEncoder(format) {
+		return fmt.Errorf("%w %q for %s", ErrUnsupportedFormat, format, key)
+	}
+
+	res, err := ProcessFile(ctx, inputPath, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Metadata == nil {
+		opts.Metadata = res.Metadata
+	}
+	if res.OutputFormat != "" {
+		opts.Format = res.OutputFormat
+	}
+	return SaveToStore(ctx, st, key, res.Image, opts)
+}
@@ -0,0 +1,1129 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/vector"
+)
+
+// ErrInvalidSVG is returned when an SVG source cannot be parsed.
+var ErrInvalidSVG = errors.New("thumbnail: invalid SVG")
+
+// SVG is the source format name reported for SVG documents.
+const SVG = "svg"
+
+func init() {
+	RegisterRenderer(SVG, isSVG, svgRenderer{})
+}
+
+// isSVG reports whether header looks like the start of an SVG document.
+func isSVG(header []byte) bool {
+	header = bytes.TrimPrefix(header, []byte("\xef\xbb\xbf"))
+	header = bytes.TrimLeft(header, " \t\r\n")
+	return bytes.HasPrefix(header, []byte("<")) && bytes.Contains(header, []byte("<svg"))
+}
+
+// svgRenderer is the built-in SVG renderer. It draws shapes and paths with
+// solid fills and strokes; text, images, filters, masks and clipping are
+// not drawn, gradients are approximated by their first stop and the
+// even-odd fill rule is treated as non-zero.
+type svgRenderer struct{}
+
+func (svgRenderer) Size(data []byte) (int, int, error) {
+	doc, err := parseSVG(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	w, h := doc.size()
+	return int(math.Ceil(w)), int(math.Ceil(h)), nil
+}
+
+func (svgRenderer) Render(ctx context.Context, data []byte, width, height int) (image.Image, error) {
+	doc, err := parseSVG(data)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	c := &svgCanvas{ctx: ctx, doc: doc, dst: dst, z: vector.NewRasterizer(0, 0)}
+	c.vw, c.vh = doc.viewBox()
+	m := doc.viewport(float64(width), float64(height))
+	c.drawChildren(doc.root, m, c.inherit(doc.root.props(), defaultSVGStyle))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// svgNode is an element of a parsed SVG document.
+type svgNode struct {
+	name     string
+	attrs    map[string]string
+	children []*svgNode
+}
+
+type svgDoc struct {
+	root *svgNode
+	ids  map[string]*svgNode
+}
+
+func parseSVG(data []byte) (*svgDoc, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	doc := &svgDoc{ids: make(map[string]*svgNode)}
+	var stack []*svgNode
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &svgNode{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			if id := n.attrs["id"]; id != "" {
+				doc.ids[id] = n
+			}
+			if len(stack) > 0 {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			} else if doc.root == nil {
+				doc.root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if doc.root == nil || doc.root.name != "svg" {
+		return nil, ErrInvalidSVG
+	}
+	return doc, nil
+}
+
+// viewBox returns the size of the user coordinate system of the document.
+func (doc *svgDoc) viewBox() (float64, float64) {
+	if vb := parseNumbers(doc.root.attrs["viewBox"]); len(vb) == 4 && vb[2] > 0 && vb[3] > 0 {
+		return vb[2], vb[3]
+	}
+	return doc.size()
+}
+
+// size returns the natural size of the document: its width and height
+// attributes, whichever is missing derived from the viewBox, and 300×150
+// as browsers do when neither gives one.
+func (doc *svgDoc) size() (float64, float64) {
+	w, wok := parseAbsLength(doc.root.attrs["width"])
+	h, hok := parseAbsLength(doc.root.attrs["height"])
+	vb := parseNumbers(doc.root.attrs["viewBox"])
+	if len(vb) == 4 && vb[2] > 0 && vb[3] > 0 {
+		switch {
+		case !wok && !hok:
+			w, h = vb[2], vb[3]
+		case !wok:
+			w = h * vb[2] / vb[3]
+		case !hok:
+			h = w * vb[3] / vb[2]
+		}
+		return w, h
+	}
+	if !wok {
+		w = 300
+	}
+	if !hok {
+		h = 150
+	}
+	return w, h
+}
+
+// viewport returns the transform from user space to a width×height canvas.
+func (doc *svgDoc) viewport(width, height float64) svgMatrix {
+	vb := parseNumbers(doc.root.attrs["viewBox"])
+	if len(vb) != 4 || vb[2] <= 0 || vb[3] <= 0 {
+		w, h := doc.size()
+		return svgMatrix{width / w, 0, 0, height / h, 0, 0}
+	}
+	sx, sy := width/vb[2], height/vb[3]
+	if strings.TrimSpace(doc.root.attrs["preserveAspectRatio"]) != "none" {
+		// Center the viewBox, scaled to fit (xMidYMid meet).
+		s := math.Min(sx, sy)
+		return svgMatrix{s, 0, 0, s, (width-vb[2]*s)/2 - vb[0]*s, (height-vb[3]*s)/2 - vb[1]*s}
+	}
+	return svgMatrix{sx, 0, 0, sy, -vb[0] * sx, -vb[1] * sy}
+}
+
+// svgMatrix is an affine transform [a c e; b d f].
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+// mul returns the transform applying n and then m.
+func (m svgMatrix) mul(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m svgMatrix) apply(p svgPoint) svgPoint {
+	return svgPoint{m[0]*p.x + m[2]*p.y + m[4], m[1]*p.x + m[3]*p.y + m[5]}
+}
+
+// scale returns the mean factor by which m scales lengths.
+func (m svgMatrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// parseSVGTransform parses a transform attribute.
+func parseSVGTransform(s string) svgMatrix {
+	m := svgIdentity
+	for {
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open < 0 || end < open {
+			return m
+		}
+		name := strings.Trim(s[:open], " \t\r\n,")
+		v := parseNumbers(s[open+1 : end])
+		s = s[end+1:]
+		arg := func(i int, def float64) float64 {
+			if i < len(v) {
+				return v[i]
+			}
+			return def
+		}
+		var t svgMatrix
+		switch name {
+		case "matrix":
+			if len(v) != 6 {
+				continue
+			}
+			copy(t[:], v)
+		case "translate":
+			t = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			sx := arg(0, 1)
+			t = svgMatrix{sx, 0, 0, arg(1, sx), 0, 0}
+		case "rotate":
+			a := arg(0, 0) * math.Pi / 180
+			sin, cos := math.Sincos(a)
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = svgMatrix{1, 0, 0, 1, cx, cy}.
+				mul(svgMatrix{cos, sin, -sin, cos, 0, 0}).
+				mul(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			t = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			continue
+		}
+		m = m.mul(t)
+	}
+}
+
+type svgPaint struct {
+	none bool
+	c    color.NRGBA
+}
+
+// svgStyle holds the inherited presentation properties.
+type svgStyle struct {
+	fill, stroke                svgPaint
+	fillOpacity, strokeOpacity  float64
+	opacity                     float64 // product of ancestor opacities
+	strokeWidth, miterLimit     float64
+	lineCap, lineJoin, colorVal string
+}
+
+var defaultSVGStyle = svgStyle{
+	fill:          svgPaint{c: color.NRGBA{A: 0xff}},
+	stroke:        svgPaint{none: true},
+	fillOpacity:   1,
+	strokeOpacity: 1,
+	opacity:       1,
+	strokeWidth:   1,
+	miterLimit:    4,
+	lineCap:       "butt",
+	lineJoin:      "miter",
+	colorVal:      "black",
+}
+
+type svgCanvas struct {
+	ctx    context.Context
+	doc    *svgDoc
+	dst    *image.RGBA
+	z      *vector.Rasterizer
+	vw, vh float64 // viewBox size, for percentage lengths
+	depth  int     // <use> nesting, to stop reference cycles
+}
+
+// props returns the presentation properties of n, with its style attribute
+// taking precedence over presentation attributes.
+func (n *svgNode) props() map[string]string {
+	style, ok := n.attrs["style"]
+	if !ok {
+		return n.attrs
+	}
+	p := make(map[string]string, len(n.attrs))
+	for k, v := range n.attrs {
+		p[k] = v
+	}
+	for _, decl := range strings.Split(style, ";") {
+		if i := strings.IndexByte(decl, ':'); i >= 0 {
+			p[strings.TrimSpace(decl[:i])] = strings.TrimSpace(decl[i+1:])
+		}
+	}
+	return p
+}
+
+func (c *svgCanvas) inherit(p map[string]string, st svgStyle) svgStyle {
+	if v, ok := p["color"]; ok && v != "inherit" {
+		st.colorVal = v
+	}
+	if v, ok := p["fill"]; ok && v != "inherit" {
+		st.fill = c.paint(v, st)
+	}
+	if v, ok := p["stroke"]; ok && v != "inherit" {
+		st.stroke = c.paint(v, st)
+	}
+	num := func(key string, dst *float64) {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(p[key]), 64); err == nil {
+			*dst = v
+		}
+	}
+	num("fill-opacity", &st.fillOpacity)
+	num("stroke-opacity", &st.strokeOpacity)
+	num("stroke-miterlimit", &st.miterLimit)
+	if v, ok := p["stroke-width"]; ok {
+		if w, ok := c.length(v, 0); ok {
+			st.strokeWidth = w
+		}
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSpace(p["opacity"]), 64); err == nil {
+		st.opacity *= clamp01(v)
+	}
+	if v := p["stroke-linecap"]; v != "" {
+		st.lineCap = v
+	}
+	if v := p["stroke-linejoin"]; v != "" {
+		st.lineJoin = v
+	}
+	return st
+}
+
+// paint parses a fill or stroke value.
+func (c *svgCanvas) paint(v string, st svgStyle) svgPaint {
+	v = strings.TrimSpace(v)
+	switch {
+	case v == "none" || v == "transparent":
+		return svgPaint{none: true}
+	case v == "currentColor":
+		v = st.colorVal
+	case strings.HasPrefix(v, "url("):
+		// Use the first gradient stop, or the fallback color after the
+		// reference.
+		end := strings.IndexByte(v, ')')
+		if end < 0 {
+			return svgPaint{none: true}
+		}
+		id := strings.Trim(v[4:end], " '\"#")
+		if g, ok := c.doc.ids[id]; ok {
+			if stop, ok := c.firstStop(g, 0); ok {
+				return svgPaint{c: stop}
+			}
+		}
+		if fb := strings.TrimSpace(v[end+1:]); fb != "" {
+			return c.paint(fb, st)
+		}
+		return svgPaint{none: true}
+	}
+	if col, ok := parseSVGColor(v); ok {
+		return svgPaint{c: col}
+	}
+	return svgPaint{c: color.NRGBA{A: 0xff}}
+}
+
+// firstStop returns the color of the first stop of gradient g, following
+// href links to other gradients.
+func (c *svgCanvas) firstStop(g *svgNode, depth int) (color.NRGBA, bool) {
+	for _, s := range g.children {
+		if s.name != "stop" {
+			continue
+		}
+		p := s.props()
+		col, ok := parseSVGColor(p["stop-color"])
+		if !ok {
+			col = color.NRGBA{A: 0xff}
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(p["stop-opacity"]), 64); err == nil {
+			col.A = uint8(float64(col.A)*clamp01(v) + 0.5)
+		}
+		return col, true
+	}
+	if href := g.attrs["href"]; strings.HasPrefix(href, "#") && depth < 8 {
+		if h, ok := c.doc.ids[href[1:]]; ok {
+			return c.firstStop(h, depth+1)
+		}
+	}
+	return color.NRGBA{}, false
+}
+
+// length parses a length, resolving percentages against ref.
+func (c *svgCanvas) length(s string, ref float64) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		return v / 100 * ref, err == nil
+	}
+	return parseAbsLength(s)
+}
+
+// attrLength returns the length attribute key of n, or def when missing.
+func (c *svgCanvas) attrLength(n *svgNode, key string, ref, def float64) float64 {
+	if v, ok := c.length(n.attrs[key], ref); ok {
+		return v
+	}
+	return def
+}
+
+// parseAbsLength parses a length in absolute units, with CSS pixels for
+// unit-less values.
+func parseAbsLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{{"px", 1}, {"pt", 4.0 / 3}, {"pc", 16}, {"mm", 96 / 25.4}, {"cm", 96 / 2.54}, {"in", 96}, {"em", 16}, {"ex", 8}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = s[:len(s)-len(u.suffix)], u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+func (c *svgCanvas) drawChildren(n *svgNode, m svgMatrix, st svgStyle) {
+	for _, child := range n.children {
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.draw(child, m, st)
+	}
+}
+
+func (c *svgCanvas) draw(n *svgNode, m svgMatrix, st svgStyle) {
+	p := n.props()
+	if p["display"] == "none" {
+		return
+	}
+	m = m.mul(parseSVGTransform(n.attrs["transform"]))
+	st = c.inherit(p, st)
+	diag := math.Hypot(c.vw, c.vh) / math.Sqrt2
+
+	switch n.name {
+	case "g", "a", "switch", "svg":
+		if n.name == "svg" {
+			m = m.mul(svgMatrix{1, 0, 0, 1, c.attrLength(n, "x", c.vw, 0), c.attrLength(n, "y", c.vh, 0)})
+		}
+		c.drawChildren(n, m, st)
+	case "use":
+		href := n.attrs["href"]
+		ref, ok := c.doc.ids[strings.TrimPrefix(href, "#")]
+		if !ok || !strings.HasPrefix(href, "#") || c.depth > 8 {
+			return
+		}
+		m = m.mul(svgMatrix{1, 0, 0, 1, c.attrLength(n, "x", c.vw, 0), c.attrLength(n, "y", c.vh, 0)})
+		c.depth++
+		if ref.name == "symbol" {
+			c.drawChildren(ref, m, c.inherit(ref.props(), st))
+		} else {
+			c.draw(ref, m, st)
+		}
+		c.depth--
+	case "path":
+		pb := &svgPath{m: m}
+		pb.parse(n.attrs["d"])
+		c.paintPath(pb, st)
+	case "rect":
+		x, y := c.attrLength(n, "x", c.vw, 0), c.attrLength(n, "y", c.vh, 0)
+		w, h := c.attrLength(n, "width", c.vw, 0), c.attrLength(n, "height", c.vh, 0)
+		if w <= 0 || h <= 0 {
+			return
+		}
+		rx, rxok := c.length(n.attrs["rx"], c.vw)
+		ry, ryok := c.length(n.attrs["ry"], c.vh)
+		if !rxok {
+			rx = ry
+		}
+		if !ryok {
+			ry = rx
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		pb := &svgPath{m: m}
+		if rx == 0 || ry == 0 {
+			pb.moveTo(svgPoint{x, y})
+			pb.lineTo(svgPoint{x + w, y})
+			pb.lineTo(svgPoint{x + w, y + h})
+			pb.lineTo(svgPoint{x, y + h})
+		} else {
+			pb.moveTo(svgPoint{x + rx, y})
+			pb.lineTo(svgPoint{x + w - rx, y})
+			pb.arcTo(rx, ry, 0, false, true, svgPoint{x + w, y + ry})
+			pb.lineTo(svgPoint{x + w, y + h - ry})
+			pb.arcTo(rx, ry, 0, false, true, svgPoint{x + w - rx, y + h})
+			pb.lineTo(svgPoint{x + rx, y + h})
+			pb.arcTo(rx, ry, 0, false, true, svgPoint{x, y + h - ry})
+			pb.lineTo(svgPoint{x, y + ry})
+			pb.arcTo(rx, ry, 0, false, true, svgPoint{x + rx, y})
+		}
+		pb.close()
+		c.paintPath(pb, st)
+	case "circle", "ellipse":
+		cx, cy := c.attrLength(n, "cx", c.vw, 0), c.attrLength(n, "cy", c.vh, 0)
+		var rx, ry float64
+		if n.name == "circle" {
+			rx = c.attrLength(n, "r", diag, 0)
+			ry = rx
+		} else {
+			rx, ry = c.attrLength(n, "rx", c.vw, 0), c.attrLength(n, "ry", c.vh, 0)
+		}
+		if rx <= 0 || ry <= 0 {
+			return
+		}
+		pb := &svgPath{m: m}
+		pb.moveTo(svgPoint{cx + rx, cy})
+		pb.arcTo(rx, ry, 0, false, true, svgPoint{cx, cy + ry})
+		pb.arcTo(rx, ry, 0, false, true, svgPoint{cx - rx, cy})
+		pb.arcTo(rx, ry, 0, false, true, svgPoint{cx, cy - ry})
+		pb.arcTo(rx, ry, 0, false, true, svgPoint{cx + rx, cy})
+		pb.close()
+		c.paintPath(pb, st)
+	case "line":
+		pb := &svgPath{m: m}
+		pb.moveTo(svgPoint{c.attrLength(n, "x1", c.vw, 0), c.attrLength(n, "y1", c.vh, 0)})
+		pb.lineTo(svgPoint{c.attrLength(n, "x2", c.vw, 0), c.attrLength(n, "y2", c.vh, 0)})
+		st.fill = svgPaint{none: true}
+		c.paintPath(pb, st)
+	case "polyline", "polygon":
+		v := parseNumbers(n.attrs["points"])
+		if len(v) < 4 {
+			return
+		}
+		pb := &svgPath{m: m}
+		pb.moveTo(svgPoint{v[0], v[1]})
+		for i := 2; i+1 < len(v); i += 2 {
+			pb.lineTo(svgPoint{v[i], v[i+1]})
+		}
+		if n.name == "polygon" {
+			pb.close()
+		}
+		c.paintPath(pb, st)
+	}
+}
+
+// paintPath fills and then strokes pb.
+func (c *svgCanvas) paintPath(pb *svgPath, st svgStyle) {
+	pb.finish()
+	if !st.fill.none {
+		var polys [][]svgPoint
+		for _, sp := range pb.subpaths {
+			if len(sp.pts) > 2 {
+				polys = append(polys, sp.pts)
+			}
+		}
+		c.fill(polys, st.fill.c, st.fillOpacity*st.opacity)
+	}
+	if !st.stroke.none && st.strokeWidth > 0 {
+		c.fill(strokePolygons(pb.subpaths, st.strokeWidth*pb.m.scale(), st), st.stroke.c, st.strokeOpacity*st.opacity)
+	}
+}
+
+// fill rasterizes polys, in canvas coordinates, with the non-zero rule
+// and composites them over the canvas in col.
+func (c *svgCanvas) fill(polys [][]svgPoint, col color.NRGBA, opacity float64) {
+	if len(polys) == 0 || opacity <= 0 || col.A == 0 {
+		return
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, poly := range polys {
+		for _, p := range poly {
+			minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+			maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+		}
+	}
+	r := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY))).
+		Intersect(c.dst.Rect)
+	if r.Empty() {
+		return
+	}
+	// Rasterize only the bounding box of the shape.
+	c.z.Reset(r.Dx(), r.Dy())
+	c.z.DrawOp = draw.Over
+	ox, oy := float64(r.Min.X), float64(r.Min.Y)
+	for _, poly := range polys {
+		c.z.MoveTo(float32(poly[0].x-ox), float32(poly[0].y-oy))
+		for _, p := range poly[1:] {
+			c.z.LineTo(float32(p.x-ox), float32(p.y-oy))
+		}
+		c.z.ClosePath()
+	}
+	col.A = uint8(float64(col.A)*clamp01(opacity) + 0.5)
+	c.z.Draw(c.dst, r, image.NewUniform(col), image.Point{})
+}
+
+type svgPoint struct{ x, y float64 }
+
+func (p svgPoint) add(q svgPoint) svgPoint        { return svgPoint{p.x + q.x, p.y + q.y} }
+func (p svgPoint) sub(q svgPoint) svgPoint        { return svgPoint{p.x - q.x, p.y - q.y} }
+func (p svgPoint) mulf(f float64) svgPoint        { return svgPoint{p.x * f, p.y * f} }
+func (p svgPoint) dist(q svgPoint) float64        { return math.Hypot(p.x-q.x, p.y-q.y) }
+func (p svgPoint) cross(q svgPoint) float64       { return p.x*q.y - p.y*q.x }
+func (p svgPoint) dot(q svgPoint) float64         { return p.x*q.x + p.y*q.y }
+func lerpPoint(p, q svgPoint, t float64) svgPoint { return p.add(q.sub(p).mulf(t)) }
+
+// svgSubpath is a flattened subpath in canvas coordinates.
+type svgSubpath struct {
+	pts    []svgPoint
+	closed bool
+}
+
+// svgPath builds flattened subpaths from path commands given in user
+// space, transforming each point by m.
+type svgPath struct {
+	m        svgMatrix
+	subpaths []svgSubpath
+	cur      svgSubpath
+	start    svgPoint // user-space start of the current subpath
+	pen      svgPoint // user-space current point
+}
+
+func (pb *svgPath) moveTo(p svgPoint) {
+	pb.finish()
+	pb.start, pb.pen = p, p
+	pb.cur.pts = append(pb.cur.pts, pb.m.apply(p))
+}
+
+func (pb *svgPath) lineTo(p svgPoint) {
+	if len(pb.cur.pts) == 0 {
+		pb.moveTo(pb.pen)
+	}
+	pb.pen = p
+	pb.cur.pts = append(pb.cur.pts, pb.m.apply(p))
+}
+
+func (pb *svgPath) cubicTo(c1, c2, p svgPoint) {
+	if len(pb.cur.pts) == 0 {
+		pb.moveTo(pb.pen)
+	}
+	a := pb.cur.pts[len(pb.cur.pts)-1]
+	b, c, d := pb.m.apply(c1), pb.m.apply(c2), pb.m.apply(p)
+	n := flattenSteps(a.dist(b) + b.dist(c) + c.dist(d))
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		ab, bc, cd := lerpPoint(a, b, t), lerpPoint(b, c, t), lerpPoint(c, d, t)
+		pb.cur.pts = append(pb.cur.pts, lerpPoint(lerpPoint(ab, bc, t), lerpPoint(bc, cd, t), t))
+	}
+	pb.pen = p
+}
+
+func (pb *svgPath) quadTo(c1, p svgPoint) {
+	q := pb.pen
+	pb.cubicTo(lerpPoint(q, c1, 2.0/3), lerpPoint(p, c1, 2.0/3), p)
+}
+
+// arcTo adds an elliptical arc as SVG's A command describes it, as a
+// sequence of cubic Béziers.
+func (pb *svgPath) arcTo(rx, ry, phi float64, large, sweep bool, p svgPoint) {
+	p0 := pb.pen
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p {
+		pb.lineTo(p)
+		return
+	}
+	sin, cos := math.Sincos(phi * math.Pi / 180)
+	// Endpoint to center parameterization (SVG 1.1 appendix F.6.5).
+	dx, dy := (p0.x-p.x)/2, (p0.y-p.y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p0.x+p.x)/2
+	cy := sin*cx1 + cos*cy1 + (p0.y+p.y)/2
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	t1 := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	dt := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && dt > 0 {
+		dt -= 2 * math.Pi
+	} else if sweep && dt < 0 {
+		dt += 2 * math.Pi
+	}
+
+	segs := int(math.Ceil(math.Abs(dt) / (math.Pi / 2)))
+	step := dt / float64(segs)
+	kappa := 4.0 / 3 * math.Tan(step/4)
+	point := func(t float64) (svgPoint, svgPoint) {
+		st, ct := math.Sincos(t)
+		pos := svgPoint{cx + rx*ct*cos - ry*st*sin, cy + rx*ct*sin + ry*st*cos}
+		der := svgPoint{-rx*st*cos - ry*ct*sin, -rx*st*sin + ry*ct*cos}
+		return pos, der
+	}
+	a, da := point(t1)
+	for i := 1; i <= segs; i++ {
+		t := t1 + step*float64(i)
+		b, db := point(t)
+		if i == segs {
+			b = p
+		}
+		pb.cubicTo(a.add(da.mulf(kappa)), b.sub(db.mulf(kappa)), b)
+		a, da = b, db
+	}
+}
+
+func (pb *svgPath) close() {
+	if len(pb.cur.pts) > 0 {
+		pb.cur.closed = true
+		pb.pen = pb.start
+		pb.finish()
+	}
+}
+
+// finish ends the current subpath.
+func (pb *svgPath) finish() {
+	if len(pb.cur.pts) > 0 {
+		pb.subpaths = append(pb.subpaths, pb.cur)
+	}
+	pb.cur = svgSubpath{}
+}
+
+// flattenSteps returns the number of line segments used for a curve whose
+// control polygon is length pixels long.
+func flattenSteps(length float64) int {
+	n := int(length/3) + 1
+	if n > 256 {
+		n = 256
+	}
+	return n
+}
+
+// parse adds the commands of path data d.
+func (pb *svgPath) parse(d string) {
+	s := svgScanner{s: d}
+	var cmd byte
+	var ctrl svgPoint // last control point, for S and T
+	var prev byte
+	for {
+		s.skipSep()
+		if s.i >= len(s.s) {
+			return
+		}
+		if c := s.s[s.i]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			cmd = c
+			s.i++
+		} else if cmd == 0 {
+			return
+		}
+		rel := cmd >= 'a'
+		pt := func() (svgPoint, bool) {
+			x, ok1 := s.number()
+			y, ok2 := s.number()
+			p := svgPoint{x, y}
+			if rel {
+				p = p.add(pb.pen)
+			}
+			return p, ok1 && ok2
+		}
+		pen := pb.pen
+		switch cmd {
+		case 'M', 'm':
+			p, ok := pt()
+			if !ok {
+				return
+			}
+			pb.moveTo(p)
+			// Further coordinate pairs are implicit line commands.
+			if cmd == 'M' {
+				cmd = 'L'
+			} else {
+				cmd = 'l'
+			}
+		case 'L', 'l':
+			p, ok := pt()
+			if !ok {
+				return
+			}
+			pb.lineTo(p)
+		case 'H', 'h':
+			x, ok := s.number()
+			if !ok {
+				return
+			}
+			if rel {
+				x += pen.x
+			}
+			pb.lineTo(svgPoint{x, pen.y})
+		case 'V', 'v':
+			y, ok := s.number()
+			if !ok {
+				return
+			}
+			if rel {
+				y += pen.y
+			}
+			pb.lineTo(svgPoint{pen.x, y})
+		case 'C', 'c':
+			c1, ok1 := pt()
+			c2, ok2 := pt()
+			p, ok3 := pt()
+			if !ok1 || !ok2 || !ok3 {
+				return
+			}
+			pb.cubicTo(c1, c2, p)
+			ctrl = c2
+		case 'S', 's':
+			c1 := pen
+			if strings.IndexByte("CcSs", prev) >= 0 {
+				c1 = pen.add(pen.sub(ctrl))
+			}
+			c2, ok1 := pt()
+			p, ok2 := pt()
+			if !ok1 || !ok2 {
+				return
+			}
+			pb.cubicTo(c1, c2, p)
+			ctrl = c2
+		case 'Q', 'q':
+			c1, ok1 := pt()
+			p, ok2 := pt()
+			if !ok1 || !ok2 {
+				return
+			}
+			pb.quadTo(c1, p)
+			ctrl = c1
+		case 'T', 't':
+			c1 := pen
+			if strings.IndexByte("QqTt", prev) >= 0 {
+				c1 = pen.add(pen.sub(ctrl))
+			}
+			p, ok := pt()
+			if !ok {
+				return
+			}
+			pb.quadTo(c1, p)
+			ctrl = c1
+		case 'A', 'a':
+			rx, ok1 := s.number()
+			ry, ok2 := s.number()
+			phi, ok3 := s.number()
+			large, ok4 := s.flag()
+			sweep, ok5 := s.flag()
+			p, ok6 := pt()
+			if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+				return
+			}
+			pb.arcTo(rx, ry, phi, large, sweep, p)
+		case 'Z', 'z':
+			pb.close()
+		}
+		prev = cmd
+	}
+}
+
+// svgScanner reads numbers and flags from path data and attribute lists.
+type svgScanner struct {
+	s string
+	i int
+}
+
+func (s *svgScanner) skipSep() {
+	for s.i < len(s.s) && strings.IndexByte(" \t\r\n,", s.s[s.i]) >= 0 {
+		s.i++
+	}
+}
+
+func (s *svgScanner) number() (float64, bool) {
+	s.skipSep()
+	start, i := s.i, s.i
+	if i < len(s.s) && (s.s[i] == '+' || s.s[i] == '-') {
+		i++
+	}
+	digits, dot := false, false
+	for ; i < len(s.s); i++ {
+		if c := s.s[i]; c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+	}
+	if !digits {
+		return 0, false
+	}
+	if i < len(s.s) && (s.s[i] == 'e' || s.s[i] == 'E') {
+		j := i + 1
+		if j < len(s.s) && (s.s[j] == '+' || s.s[j] == '-') {
+			j++
+		}
+		if j < len(s.s) && s.s[j] >= '0' && s.s[j] <= '9' {
+			for j < len(s.s) && s.s[j] >= '0' && s.s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	v, err := strconv.ParseFloat(s.s[start:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	s.i = i
+	return v, true
+}
+
+// flag reads an arc flag, which need not be separated from what follows.
+func (s *svgScanner) flag() (bool, bool) {
+	s.skipSep()
+	if s.i < len(s.s) && (s.s[s.i] == '0' || s.s[s.i] == '1') {
+		s.i++
+		return s.s[s.i-1] == '1', true
+	}
+	return false, false
+}
+
+// parseNumbers parses a list of numbers separated by spaces or commas.
+func parseNumbers(str string) []float64 {
+	s := svgScanner{s: str}
+	var v []float64
+	for {
+		f, ok := s.number()
+		if !ok {
+			return v
+		}
+		v = append(v, f)
+	}
+}
+
+// strokePolygons returns polygons covering the outline of subpaths drawn
+// with a pen width wide. They are all wound the same way, so filling them
+// together with the non-zero rule gives their union.
+func strokePolygons(subpaths []svgSubpath, width float64, st svgStyle) [][]svgPoint {
+	h := width / 2
+	var polys [][]svgPoint
+	add := func(poly ...svgPoint) {
+		var area float64
+		for i, p := range poly {
+			area += p.cross(poly[(i+1)%len(poly)])
+		}
+		if area < 0 {
+			for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+				poly[i], poly[j] = poly[j], poly[i]
+			}
+		}
+		polys = append(polys, poly)
+	}
+	disc := func(c svgPoint) {
+		n := flattenSteps(2*math.Pi*h) + 8
+		poly := make([]svgPoint, n)
+		for i := range poly {
+			sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+			poly[i] = svgPoint{c.x + h*cos, c.y + h*sin}
+		}
+		add(poly...)
+	}
+
+	for _, sp := range subpaths {
+		// Drop repeated points, which have no direction.
+		pts := make([]svgPoint, 0, len(sp.pts))
+		for _, p := range sp.pts {
+			if len(pts) == 0 || p.dist(pts[len(pts)-1]) > 1e-9 {
+				pts = append(pts, p)
+			}
+		}
+		if sp.closed && len(pts) > 1 && pts[0].dist(pts[len(pts)-1]) > 1e-9 {
+			pts = append(pts, pts[0])
+		}
+		if len(pts) < 2 {
+			if len(pts) == 1 && st.lineCap == "round" {
+				disc(pts[0])
+			}
+			continue
+		}
+
+		normal := func(a, b svgPoint) (svgPoint, svgPoint) {
+			d := b.sub(a).mulf(1 / a.dist(b))
+			return d, svgPoint{-d.y, d.x}
+		}
+		for i := 0; i+1 < len(pts); i++ {
+			a, b := pts[i], pts[i+1]
+			d, n := normal(a, b)
+			if !sp.closed && st.lineCap == "square" {
+				if i == 0 {
+					a = a.sub(d.mulf(h))
+				}
+				if i+2 == len(pts) {
+					b = b.add(d.mulf(h))
+				}
+			}
+			add(a.add(n.mulf(h)), b.add(n.mulf(h)), b.sub(n.mulf(h)), a.sub(n.mulf(h)))
+		}
+
+		// Joins between consecutive segments, including the closing one.
+		last := len(pts) - 1
+		for i := 1; i <= last; i++ {
+			if i == last && !sp.closed {
+				break
+			}
+			prev, p, next := pts[i-1], pts[i], pts[1]
+			if i < last {
+				next = pts[i+1]
+			}
+			if st.lineJoin == "round" {
+				disc(p)
+				continue
+			}
+			d1, n1 := normal(prev, p)
+			d2, n2 := normal(p, next)
+			turn := d1.cross(d2)
+			if math.Abs(turn) < 1e-9 {
+				continue
+			}
+			// The join fills the gap on the outside of the turn.
+			if turn > 0 {
+				n1, n2 = n1.mulf(-1), n2.mulf(-1)
+			}
+			a, b := p.add(n1.mulf(h)), p.add(n2.mulf(h))
+			if cosine := n1.dot(n2); st.lineJoin != "bevel" && 1+cosine > 1e-9 &&
+				math.Sqrt(2/(1+cosine)) <= st.miterLimit {
+				add(p, a, p.add(n1.add(n2).mulf(h/(1+cosine))), b)
+			} else {
+				add(p, a, b)
+			}
+		}
+		if !sp.closed && st.lineCap == "round" {
+			disc(pts[0])
+			disc(pts[last])
+		}
+	}
+	return polys
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// parseSVGColor parses a CSS color: a hex value, rgb() or rgba(), or one
+// of the common color keywords.
+func parseSVGColor(s string) (color.NRGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return color.NRGBA{}, false
+	}
+	if s[0] == '#' {
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var b strings.Builder
+			for _, c := range hex {
+				b.WriteRune(c)
+				b.WriteRune(c)
+			}
+			hex = b.String()
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 8 {
+			return color.NRGBA{}, false
+		}
+		return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+	}
+	if strings.HasPrefix(s, "rgb") {
+		open, end := strings.IndexByte(s, '('), strings.IndexByte(s, ')')
+		if open < 0 || end < open {
+			return color.NRGBA{}, false
+		}
+		parts := strings.FieldsFunc(s[open+1:end], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) < 3 {
+			return color.NRGBA{}, false
+		}
+		var ch [4]float64
+		ch[3] = 1
+		for i := 0; i < len(parts) && i < 4; i++ {
+			p, scale := parts[i], 1.0/255
+			if i == 3 {
+				scale = 1
+			}
+			if strings.HasSuffix(p, "%") {
+				p, scale = p[:len(p)-1], 0.01
+			}
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			ch[i] = clamp01(v * scale)
+		}
+		return color.NRGBA{uint8(ch[0]*255 + 0.5), uint8(ch[1]*255 + 0.5), uint8(ch[2]*255 + 0.5), uint8(ch[3]*255 + 0.5)}, true
+	}
+	if v, ok := svgColorNames[s]; ok {
+		return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+	}
+	return color.NRGBA{}, false
+}
+
+// svgColorNames holds the CSS basic color keywords and the extended ones
+// most often found in icon sets.
+var svgColorNames = map[string]uint32{
+	"black": 0x000000, "silver": 0xc0c0c0, "gray": 0x808080, "grey": 0x808080,
+	"white": 0xffffff, "maroon": 0x800000, "red": 0xff0000, "purple": 0x800080,
+	"fuchsia": 0xff00ff, "magenta": 0xff00ff, "green": 0x008000, "lime": 0x00ff00,
+	"olive": 0x808000, "yellow": 0xffff00, "navy": 0x000080, "blue": 0x0000ff,
+	"teal": 0x008080, "aqua": 0x00ffff, "cyan": 0x00ffff, "orange": 0xffa500,
+	"darkgray": 0xa9a9a9, "darkgrey": 0xa9a9a9, "lightgray": 0xd3d3d3,
+	"lightgrey": 0xd3d3d3, "dimgray": 0x696969, "dimgrey": 0x696969,
+	"gainsboro": 0xdcdcdc, "whitesmoke": 0xf5f5f5, "brown": 0xa52a2a,
+	"pink": 0xffc0cb, "gold": 0xffd700, "indigo": 0x4b0082, "violet": 0xee82ee,
+	"crimson": 0xdc143c, "coral": 0xff7f50, "tomato": 0xff6347, "salmon": 0xfa8072,
+	"orangered": 0xff4500, "darkorange": 0xff8c00, "khaki": 0xf0e68c,
+	"beige": 0xf5f5dc, "tan": 0xd2b48c, "chocolate": 0xd2691e,
+	"darkred": 0x8b0000, "darkgreen": 0x006400, "darkblue": 0x00008b,
+	"skyblue": 0x87ceeb, "lightblue": 0xadd8e6, "steelblue": 0x4682b4,
+	"royalblue": 0x4169e1, "dodgerblue": 0x1e90ff, "deepskyblue": 0x00bfff,
+	"turquoise": 0x40e0d0, "seagreen": 0x2e8b57, "forestgreen": 0x228b22,
+	"limegreen": 0x32cd32, "lightgreen": 0x90ee90, "slategray": 0x708090,
+	"slategrey": 0x708090, "lavender": 0xe6e6fa, "ivory": 0xfffff0,
+}
@@ -0,0 +1,30 @@
+package thumbnail
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSVGResultSize(t *testing.T) {
+	const doc = `<svg xmlns="http://www.w3.org/2000/svg" width="2000" height="1000"><rect width="1000" height="1000" fill="red"/></svg>`
+	for _, c := range []struct {
+		name  string
+		opts  Options
+		w, h  int
+		scale float64
+	}{
+		{"fit", Options{Width: 100, Height: 100}, 100, 50, 0.05},
+		{"scale", Options{Scale: 0.25}, 500, 250, 0.25},
+		{"fill", Options{Width: 100, Height: 100, Mode: Fill}, 100, 100, 0.1},
+	} {
+		res, err := ProcessReader(context.Background(), strings.NewReader(doc), c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.SourceWidth != 2000 || res.SourceHeight != 1000 || res.Width != c.w || res.Height != c.h || res.Scale != c.scale {
+			t.Errorf("%s: got %dx%d from %dx%d at %v, want %dx%d from 2000x1000 at %v", c.name,
+				res.Width, res.Height, res.SourceWidth, res.SourceHeight, res.Scale, c.w, c.h, c.scale)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Caption describes text drawn onto an image by the Text step, such as a
+// "SOLD" badge or an episode number.
+type Caption struct {
+	// Text is the text to draw. Lines are separated by "\n" and aligned
+	// to the side Gravity places the text at.
+	Text string
+
+	// Font is a TrueType or OpenType font, as parsed by opentype.Parse.
+	// Nil uses Go Regular.
+	Font *opentype.Font
+
+	// Size is the font size in pixels. Zero sizes the text to a tenth of
+	// the image's height, unless Scale is set.
+	Size float64
+
+	// Color is the color of the text. Nil is white.
+	Color color.Color
+
+	// Outline, when set, draws an outline of OutlineWidth pixels (1 when
+	// zero) in this color around the glyphs.
+	Outline      color.Color
+	OutlineWidth int
+
+	// Shadow, when set, draws a drop shadow in this color, ShadowOffset
+	// pixels down and right of the text. A zero ShadowOffset is about a
+	// twelfth of the font size in both directions.
+	Shadow       color.Color
+	ShadowOffset image.Point
+
+	// Overlay places the text like a watermark. Its Scale, when set, sets
+	// the font size so that the text fits Scale times the image's width
+	// and height, in place of Size.
+	Overlay
+}
+
+var defaultFont struct {
+	once sync.Once
+	f    *opentype.Font
+	err  error
+}
+
+// Text returns a step that draws c onto the image. The result is an
+// *image.RGBA with the image's bounds; an empty Text leaves the image
+// unchanged.
+func Text(c Caption) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if c.Text == "" {
+			return img, nil
+		}
+		f, err := fontOrDefault(c.Font)
+		if err != nil {
+			return nil, err
+		}
+
+		b := img.Bounds()
+		size := c.Size
+		if size <= 0 {
+			size = math.Max(8, float64(b.Dy())/10)
+		}
+		if c.Scale > 0 {
+			// Measure at the nominal size, which text extents are
+			// nearly proportional to, and render once at the fitting one.
+			mark, err := c.render(f, size)
+			if err != nil {
+				return nil, err
+			}
+			mb := mark.Bounds()
+			size *= math.Min(c.Scale*float64(b.Dx())/float64(mb.Dx()), c.Scale*float64(b.Dy())/float64(mb.Dy()))
+			c.Scale = 0
+		}
+		mark, err := c.render(f, size)
+		if err != nil {
+			return nil, err
+		}
+		return drawOverlay(img, mark, c.Overlay), nil
+	})
+}
+
+// fontOrDefault returns f, or Go Regular if f is nil.
+func fontOrDefault(f *opentype.Font) (*opentype.Font, error) {
+	if f != nil {
+		return f, nil
+	}
+	defaultFont.once.Do(func() {
+		defaultFont.f, defaultFont.err = opentype.Parse(goregular.TTF)
+	})
+	return defaultFont.f, defaultFont.err
+}
+
+// render draws c's text at size onto a transparent image just large
+// enough for it, its outline and its shadow.
+func (c *Caption) render(f *opentype.Font, size float64) (*image.RGBA, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lines := strings.Split(c.Text, "\n")
+	widths := make([]int, len(lines))
+	width := 0
+	for i, line := range lines {
+		widths[i] = font.MeasureString(face, line).Ceil()
+		width = maxInt(width, widths[i])
+	}
+	m := face.Metrics()
+	lineHeight := m.Height.Ceil()
+	height := lineHeight*(len(lines)-1) + (m.Ascent + m.Descent).Ceil()
+
+	outline := 0
+	if c.Outline != nil {
+		outline = c.OutlineWidth
+		if outline <= 0 {
+			outline = 1
+		}
+	}
+	var shadow image.Point
+	if c.Shadow != nil {
+		shadow = c.ShadowOffset
+		if shadow == (image.Point{}) {
+			d := maxInt(1, int(math.Round(size/12)))
+			shadow = image.Pt(d, d)
+		}
+	}
+	// Leave room for the outline on all sides and the shadow on
+	// the side it falls to.
+	pad := image.Rect(outline-minInt(shadow.X, 0), outline-minInt(shadow.Y, 0),
+		outline+maxInt(shadow.X, 0), outline+maxInt(shadow.Y, 0))
+	dst := image.NewRGBA(image.Rect(0, 0, maxInt(1, width+pad.Min.X+pad.Max.X), maxInt(1, height+pad.Min.Y+pad.Max.Y)))
+
+	fx, _ := c.Gravity.anchor()
+	drawText := func(col color.Color, off image.Point) {
+		d := font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: face}
+		for i, line := range lines {
+			x := pad.Min.X + int(math.Round(fx*float64(width-widths[i]))) + off.X
+			y := pad.Min.Y + m.Ascent.Ceil() + i*lineHeight + off.Y
+			d.Dot = fixed.P(x, y)
+			d.DrawString(line)
+		}
+	}
+	if c.Shadow != nil {
+		drawText(c.Shadow, shadow)
+	}
+	for dy := -outline; dy <= outline; dy++ {
+		for dx := -outline; dx <= outline; dx++ {
+			if (dx != 0 || dy != 0) && dx*dx+dy*dy <= outline*outline+outline {
+				drawText(c.Outline, image.Pt(dx, dy))
+			}
+		}
+	}
+	col := c.Color
+	if col == nil {
+		col = color.White
+	}
+	drawText(col, image.Point{})
+	return dst, nil
+}
@@ -0,0 +1,146 @@
+package thumbnail
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbHashSize is the largest size of image ThumbHash encodes; larger
+// ones are reduced to fit, which changes nothing in the few terms kept.
+const thumbHashSize = 100
+
+// ThumbHash returns the ThumbHash (https://evanw.github.io/thumbhash/) of
+// img, a placeholder of about 25 bytes that, unlike BlurHash, keeps the
+// image's aspect ratio and transparency. Clients usually receive it base64
+// encoded.
+func ThumbHash(img image.Image) []byte {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	scale := math.Min(1, thumbHashSize/float64(maxInt(b.Dx(), b.Dy())))
+	w := maxInt(1, int(math.Round(float64(b.Dx())*scale)))
+	h := maxInt(1, int(math.Round(float64(b.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Rect, img, b, draw.Src, nil)
+	n := w * h
+
+	// The average color, weighted by alpha, stands in for transparent
+	// pixels.
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < n; i++ {
+		p := small.Pix[4*i : 4*i+4]
+		avgR += float64(p[0]) / 255
+		avgG += float64(p[1]) / 255
+		avgB += float64(p[2]) / 255
+		avgA += float64(p[3]) / 255
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+	alpha := avgA < float64(n)
+	lLimit := 7.0
+	if alpha {
+		// Luminance gets fewer terms to leave room for alpha.
+		lLimit = 5
+	}
+	long := float64(maxInt(w, h))
+	lx := maxInt(1, int(math.Round(lLimit*float64(w)/long)))
+	ly := maxInt(1, int(math.Round(lLimit*float64(h)/long)))
+
+	// Convert to luminance, yellow-blue, red-green and alpha channels,
+	// over the average color.
+	l := make([]float64, n)
+	p := make([]float64, n)
+	q := make([]float64, n)
+	a := make([]float64, n)
+	for i := 0; i < n; i++ {
+		px := small.Pix[4*i : 4*i+4]
+		t := 1 - float64(px[3])/255
+		r := avgR*t + float64(px[0])/255
+		g := avgG*t + float64(px[1])/255
+		bl := avgB*t + float64(px[2])/255
+		l[i] = (r + g + bl) / 3
+		p[i] = (r+g)/2 - bl
+		q[i] = r - g
+		a[i] = 1 - t
+	}
+
+	lDC, lAC, lScale := thumbHashChannel(l, w, h, maxInt(3, lx), maxInt(3, ly))
+	pDC, pAC, pScale := thumbHashChannel(p, w, h, 3, 3)
+	qDC, qAC, qScale := thumbHashChannel(q, w, h, 3, 3)
+	acs := [][]float64{lAC, pAC, qAC}
+
+	landscape := w > h
+	header24 := roundUint(63*lDC) | roundUint(31.5+31.5*pDC)<<6 | roundUint(31.5+31.5*qDC)<<12 | roundUint(31*lScale)<<18
+	header16 := roundUint(63*pScale)<<3 | roundUint(63*qScale)<<9
+	if landscape {
+		header16 |= uint(ly) | 1<<15
+	} else {
+		header16 |= uint(lx)
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if alpha {
+		hash[2] |= 1 << 7
+		aDC, aAC, aScale := thumbHashChannel(a, w, h, 5, 5)
+		hash = append(hash, byte(roundUint(15*aDC)|roundUint(15*aScale)<<4))
+		acs = append(acs, aAC)
+	}
+
+	// The AC terms follow as nibbles, low first.
+	start, k := len(hash), 0
+	for _, ac := range acs {
+		k += len(ac)
+	}
+	hash = append(hash, make([]byte, (k+1)/2)...)
+	k = 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			hash[start+k/2] |= byte(roundUint(15*f)) << (4 * uint(k&1))
+			k++
+		}
+	}
+	return hash
+}
+
+// thumbHashChannel returns the DCT terms of a w×h channel for ThumbHash,
+// those within a triangle of nx by ny: the constant term, the others scaled
+// to 0-1 and the scale.
+func thumbHashChannel(channel []float64, w, h, nx, ny int) (dc float64, ac []float64, scale float64) {
+	fx := make([]float64, w)
+	for cy := 0; cy < ny; cy++ {
+		for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+			for x := range fx {
+				fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+			}
+			var f float64
+			for y := 0; y < h; y++ {
+				fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+				row := channel[y*w : y*w+w]
+				for x, v := range row {
+					f += v * fx[x] * fy
+				}
+			}
+			f /= float64(w * h)
+			if cx == 0 && cy == 0 {
+				dc = f
+				continue
+			}
+			ac = append(ac, f)
+			scale = math.Max(scale, math.Abs(f))
+		}
+	}
+	if scale > 0 {
+		for i := range ac {
+			ac[i] = 0.5 + 0.5/scale*ac[i]
+		}
+	}
+	return dc, ac, scale
+}
+
+// roundUint rounds v, which must not be negative, to the nearest integer.
+func roundUint(v float64) uint {
+	return uint(math.Floor(v + 0.5))
+}
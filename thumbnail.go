@@ -2,86 +2,554 @@
 package thumbnail
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"image"
-	"image/gif"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"io"
-	"os"
+	"math"
+	"sync"
+	"time"
 
+	"golang.org/x/image/bmp"
 	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	// Register the WebP decoder so WebP sources can be read.
+	_ "golang.org/x/image/webp"
+)
+
+// MaxDimension is the largest thumbnail width or height Generate accepts.
+const MaxDimension = 16384
+
+// Errors returned when a thumbnail cannot be generated. Invalid options are
+// reported as an *OptionError wrapping one of the ErrInvalid* values, so
+// test for them with errors.Is.
+var (
+	// ErrNilImage is returned when the source image is nil.
+	ErrNilImage = errors.New("thumbnail: nil source image")
+	// ErrEmptyImage is returned when the source image has zero width or height.
+	ErrEmptyImage = errors.New("thumbnail: source image has zero area")
+	// ErrInvalidDimensions is returned when Width or Height is not positive
+	// or exceeds MaxDimension.
+	ErrInvalidDimensions = errors.New("thumbnail: invalid thumbnail dimensions")
+	// ErrInvalidScale is returned when Scale is negative or not finite.
+	ErrInvalidScale = errors.New("thumbnail: invalid scale factor")
+	// ErrInvalidAspectRatio is returned when AspectRatio is negative or
+	// not finite, or an aspect ratio string cannot be parsed.
+	ErrInvalidAspectRatio = errors.New("thumbnail: invalid aspect ratio")
+	// ErrInvalidQuality is returned when Quality is outside 0-100.
+	ErrInvalidQuality = errors.New("thumbnail: invalid JPEG quality")
+	// ErrInvalidFilter is returned when Filter is not a known filter.
+	ErrInvalidFilter = errors.New("thumbnail: unknown filter")
+	// ErrInvalidMode is returned when Mode is not a known mode.
+	ErrInvalidMode = errors.New("thumbnail: unknown resize mode")
+	// ErrInvalidGravity is returned when Gravity is not a known gravity.
+	ErrInvalidGravity = errors.New("thumbnail: unknown gravity")
+	// ErrInvalidFocus is returned when the Focus point lies outside 0-1.
+	ErrInvalidFocus = errors.New("thumbnail: focal point out of range")
+	// ErrInvalidSubsampling is returned when Subsampling is not a known
+	// subsampling.
+	ErrInvalidSubsampling = errors.New("thumbnail: unknown chroma subsampling")
+	// ErrInvalidCompression is returned when Compression is not a known
+	// compression level.
+	ErrInvalidCompression = errors.New("thumbnail: unknown compression level")
+	// ErrInvalidQuantizer is returned when Quantizer is not a known
+	// quantizer.
+	ErrInvalidQuantizer = errors.New("thumbnail: unknown quantizer")
+	// ErrInvalidDither is returned when Dither is not a known dithering
+	// method.
+	ErrInvalidDither = errors.New("thumbnail: unknown dithering method")
+	// ErrInvalidMetadataKind is returned when KeepMetadata holds an
+	// unknown metadata kind.
+	ErrInvalidMetadataKind = errors.New("thumbnail: unknown metadata kind")
+	// ErrInvalidMetadataStrip is returned when StripMetadata is not a
+	// known strip mode.
+	ErrInvalidMetadataStrip = errors.New("thumbnail: unknown metadata strip mode")
+	// ErrInvalidBorder is returned when Border is negative or leaves no
+	// room for the thumbnail within Width or Height.
+	ErrInvalidBorder = errors.New("thumbnail: invalid border width")
+	// ErrInvalidFrame is returned when Frame is not a known frame style.
+	ErrInvalidFrame = errors.New("thumbnail: unknown frame style")
+	// ErrInvalidCornerRadius is returned when CornerRadius is negative.
+	ErrInvalidCornerRadius = errors.New("thumbnail: invalid corner radius")
+	// ErrInvalidAutoBackground is returned when AutoBackground is not a
+	// known automatic background.
+	ErrInvalidAutoBackground = errors.New("thumbnail: unknown automatic background")
+	// ErrInvalidObscure is returned when Obscure is not a known
+	// obscuring method.
+	ErrInvalidObscure = errors.New("thumbnail: unknown obscuring method")
+	// ErrInvalidHashKind is returned when Hash is not a known hash kind.
+	ErrInvalidHashKind = errors.New("thumbnail: unknown hash kind")
+	// ErrInvalidParallelism is returned when Parallelism is negative.
+	ErrInvalidParallelism = errors.New("thumbnail: invalid parallelism")
+	// ErrInvalidMaxFrames is returned when MaxFrames is negative.
+	ErrInvalidMaxFrames = errors.New("thumbnail: invalid frame limit")
+	// ErrInvalidMaxDuration is returned when MaxDuration is negative.
+	ErrInvalidMaxDuration = errors.New("thumbnail: invalid duration limit")
+	// ErrInvalidPage is returned when Page is negative.
+	ErrInvalidPage = errors.New("thumbnail: invalid page number")
+	// ErrInvalidLimit is returned when MaxPixels, MaxWidth or MaxHeight
+	// is negative.
+	ErrInvalidLimit = errors.New("thumbnail: invalid source size limit")
+	// ErrSourceTooLarge is returned, wrapped in a *LimitError, for sources
+	// larger than MaxPixels, MaxWidth or MaxHeight allow.
+	ErrSourceTooLarge = errors.New("thumbnail: source image too large")
+	// ErrPageNotFound is returned when Page is past the last page of the
+	// source.
+	ErrPageNotFound = errors.New("thumbnail: page not found")
+	// ErrInvalidBlurHash is returned by BlurHash for component counts
+	// outside 1 to 9.
+	ErrInvalidBlurHash = errors.New("thumbnail: invalid BlurHash components")
+	// ErrSizeMismatch is returned by PSNR and SSIM when the images they
+	// compare differ in size.
+	ErrSizeMismatch = errors.New("thumbnail: images differ in size")
+	// ErrInvalidSheet is returned by ContactSheet for a Sheet with a
+	// negative size, spacing or column count, and by PackSprites for a
+	// negative padding or a name count that differs from the image
+	// count; both return it for no images.
+	ErrInvalidSheet = errors.New("thumbnail: invalid contact sheet")
+	// ErrInvalidCollage is returned by MakeCollage for a Collage of
+	// unknown layout, a size that is not positive or negative spacing, or
+	// for no images.
+	ErrInvalidCollage = errors.New("thumbnail: invalid collage")
+	// ErrInvalidRotation is returned by the Rotate step for angles that
+	// are not multiples of 90 degrees.
+	ErrInvalidRotation = errors.New("thumbnail: invalid rotation")
+	// ErrDestinationTooSmall is returned by GenerateInto when the thumbnail
+	// does not fit within the destination image.
+	ErrDestinationTooSmall = errors.New("thumbnail: destination image too small")
 )
 
 // Options configures thumbnail generation.
 type Options struct {
-	Width   int
-	Height  int
-	Quality int // JPEG quality (1-100), default 85
+	Width   int     // must be in 1..MaxDimension unless Scale is set
+	Height  int     // must be in 1..MaxDimension unless Scale is set
+	Quality int     // JPEG quality (1-100), 0 selects the default of 85
+	Filter  Filter  // interpolation filter, CatmullRom by default
+	Mode    Mode    // how the source is fitted to the box, Fit by default
+	Gravity Gravity // anchor for cropping and padding, Center by default
+	Focus   *Focus  // region to keep when cropping; overrides Gravity
+
+	// Detector, when set, finds regions of interest such as faces in the
+	// source, which Fill and Crop then keep in frame as they would a
+	// Focus Rect around all of them. When it finds none, Gravity applies.
+	// An explicit Focus takes precedence. Detector errors are returned.
+	Detector Detector
+
+	// Scaler, when set, is used for resampling instead of Filter. It may
+	// be any draw.Scaler, such as a custom *draw.Kernel or an
+	// experimental implementation. Kernels are applied in bands, like the
+	// built-in filters, and so honor cancellation; other scalers run in a
+	// single call.
+	Scaler draw.Scaler
+
+	// LinearLight resamples in linear light rather than on sRGB-encoded
+	// values, converting each band of pixels there and back with lookup
+	// tables. Fine detail then keeps its brightness and high-contrast
+	// edges do not grow dark halos, at some cost in speed.
+	LinearLight bool
+
+	// Parallelism limits the number of goroutines that scale a thumbnail,
+	// each taking bands of rows or columns in turn; the result is the same
+	// whatever their number. Zero means runtime.GOMAXPROCS(0), and one
+	// scales on the calling goroutine, which suits servers that already
+	// make many thumbnails at a time. Sources are read from several
+	// goroutines at once, as the image package's types allow.
+	Parallelism int
+
+	// NoPrefilter applies the filter to the whole source however much it
+	// is reduced. By default sources reduced by 4 or more are first
+	// shrunk by averaging boxes of pixels to about twice the thumbnail
+	// size, as libvips does, which is several times faster and leaves the
+	// filter little to alias.
+	NoPrefilter bool
+
+	// NoUpscale keeps sources smaller than the box at their native size
+	// instead of enlarging them. Fill, Crop and Stretch then produce a
+	// thumbnail smaller than the box; Pad still produces the full canvas.
+	NoUpscale bool
+
+	// Background fills the canvas around the scaled source in Pad mode.
+	// A nil Background is fully transparent, which PNG output preserves
+	// and JPEG output fills with Matte.
+	Background color.Color
+
+	// AutoBackground, when set, replaces Background with a color sampled
+	// from the source's edges, their average or their dominant color, so
+	// that the padding blends with the picture.
+	AutoBackground AutoBackground
+
+	// Border, when positive, frames the thumbnail with a border of that
+	// many pixels, drawn in BorderColor (black when nil) in the Frame
+	// style. The border is part of the thumbnail: the source is fitted to
+	// the box less the border, so that the result still fits Width×Height.
+	Border      int
+	BorderColor color.Color
+	Frame       Frame
+
+	// CornerRadius, when positive, rounds the corners of the thumbnail
+	// with that radius in pixels, and Circle cuts it to the circle, or
+	// for thumbnails that are not square the ellipse, that fills it, as
+	// for avatars. The pixels cut away are transparent, which PNG and
+	// WebP output keep and JPEG output fills with Matte. Any Border is
+	// rounded with the thumbnail.
+	CornerRadius int
+	Circle       bool
+
+	// Obscure, when set, makes the thumbnail deliberately unrecognizable
+	// at the requested size, by ObscurePixelate or ObscureBlur, as for
+	// content pending moderation. Any Border stays sharp.
+	Obscure Obscure
+
+	// Matte is the color that JPEG output composites transparent and
+	// translucent pixels onto, since the format cannot store them. A nil
+	// Matte is white, and a translucent one is itself put on white.
+	Matte color.Color
+
+	// Scale, when positive, sizes the box as a fraction of the source
+	// dimensions (0.25 for a quarter-size thumbnail) and Width and Height
+	// are ignored.
+	Scale float64
+
+	// Passthrough returns the source image itself, without copying or
+	// resampling it, when it already fits within the box and the mode
+	// would neither crop nor pad it. The result then has the source's
+	// type and bounds. Process reports a Scale of 1 in that case.
+	Passthrough bool
+
+	// PreserveColorModel keeps grayscale sources grayscale and paletted
+	// sources paletted (re-quantized to the source palette) instead of
+	// returning *image.RGBA, which reduces memory and encoded size.
+	PreserveColorModel bool
+
+	// NoAutoOrient leaves JPEG, TIFF and RAW sources as stored. By default
+	// they are first rotated or mirrored as their EXIF orientation says,
+	// so that the thumbnail, and the box it is fitted to, are upright.
+	NoAutoOrient bool
+
+	// NoColorConvert leaves the pixels of sources that embed an RGB ICC
+	// profile, such as Adobe RGB, ProPhoto or Display P3, as stored. By
+	// default they are converted to sRGB, which is what thumbnails are
+	// encoded in and viewers assume.
+	NoColorConvert bool
+
+	// HighBitDepth keeps sources with 16 bits per channel, such as 16-bit
+	// PNG and TIFF, at 16 bits through scaling instead of reducing them to
+	// 8, so that formats that can store it (PNG and TIFF) write it.
+	HighBitDepth bool
+
+	// CMYKProfile, when set, converts CMYK JPEG sources that do not embed
+	// an ICC profile of their own to sRGB through it, rather than with
+	// the naive formula that print-oriented images look wrong with.
+	CMYKProfile *ICCProfile
+
+	// EmbeddedThumbnail lets JPEG sources be decoded from the small
+	// preview many cameras store in their EXIF data, typically 160 pixels
+	// wide, whenever it is large enough for the requested thumbnail. This
+	// is much faster than decoding the full image, at some cost in
+	// quality since the preview is itself a lossy reduction. Results then
+	// report the preview's size as the source size.
+	EmbeddedThumbnail bool
+
+	// NoDraft decodes JPEG and TIFF sources at full size. By default JPEGs
+	// much larger than the thumbnail are reduced by 2, 4 or 8 as they are
+	// decoded, which is several times faster and keeps enough detail for
+	// the scaler, and TIFFs are read from the smallest pyramid level that
+	// keeps that detail, only where Fill or Crop keeps them, and reduced
+	// as they are read, so that huge scans are never decoded whole.
+	// Results still report the size of the full image, or of the full
+	// page, as the source size, and the scale against it.
+	NoDraft bool
+
+	// KeepMetadata selects the metadata of the source that ProcessReader
+	// and ProcessFile report in Result.Metadata, and that GenerateAndSave
+	// and GenerateAndStore write into the thumbnail.
+	KeepMetadata MetadataKind
+
+	// Metadata, when set, is written into JPEG, PNG and WebP output.
+	// Encoders write no other metadata: without it thumbnails carry none.
+	Metadata *Metadata
+
+	// StripMetadata removes metadata from output even where Metadata or
+	// KeepMetadata ask for it: StripGPS guarantees that no location data,
+	// and StripAll that no metadata at all, reaches a thumbnail.
+	StripMetadata MetadataStrip
+
+	// AspectRatio, when positive, is the width/height ratio of the
+	// thumbnail (see ParseAspectRatio). Only one of Width and Height needs
+	// to be given; the other is derived from the ratio. When both are
+	// given the box is the largest one with the ratio that fits within
+	// them. The default Fit mode behaves like Fill, cropping the source to
+	// the ratio; other modes apply to the derived box as usual.
+	AspectRatio float64
+
+	// DominantColor has Process and the functions built on it report the
+	// thumbnail's DominantColor in Result.DominantColor, which is computed
+	// from the thumbnail and so costs little.
+	DominantColor bool
+
+	// BlurHash has Process and the functions built on it report the
+	// thumbnail's BlurHash in Result.BlurHash, with 4×3 components, or 3×4
+	// for portrait thumbnails, for clients to show as a placeholder.
+	BlurHash bool
+
+	// ThumbHash has Process and the functions built on it report the
+	// thumbnail's ThumbHash in Result.ThumbHash.
+	ThumbHash bool
+
+	// LQIP, when set, names the format, such as "jpeg" or "webp", of an
+	// LQIP of the thumbnail for Process and the functions built on it to
+	// report in Result.LQIP.
+	LQIP string
+
+	// Hash selects a perceptual hash of the thumbnail, such as
+	// HashPerceptual, for Process and the functions built on it to report
+	// in Result.Hash, so that duplicate uploads can be found while they
+	// are thumbnailed.
+	Hash HashKind
+
+	// Format names the output format ("jpeg", "png", "gif", "webp", "tiff",
+	// "bmp", "ico" or any format added with RegisterEncoder) used by Encode
+	// and Save. Auto ("auto") lets BestFormat choose for each thumbnail;
+	// Result.OutputFormat reports the choice.
+	// Save infers it from the file extension when empty.
+	Format string
+
+	// Lossless asks formats that support both modes, such as WebP, for
+	// lossless output. Quality is then ignored.
+	Lossless bool
+
+	// Progressive writes JPEG output as a progressive JPEG, which
+	// browsers can show at low detail before it has fully loaded.
+	Progressive bool
+
+	// Subsampling sets the chroma resolution of JPEG output, 4:2:0 by
+	// default.
+	Subsampling Subsampling
+
+	// Compression sets how hard PNG output is compressed.
+	Compression Compression
+
+	// Palette writes PNG output with a palette of at most 256 colors,
+	// transparency included, which typically halves the size of
+	// screenshots and other flat graphics.
+	Palette bool
+
+	// Quantizer chooses the palette of GIF output, the Plan 9 palette by
+	// default.
+	Quantizer Quantizer
+
+	// Dither sets how GIF output approximates colors that are not in its
+	// palette, Floyd–Steinberg error diffusion by default.
+	Dither Dither
+
+	// MaxFrames, when positive, limits animated thumbnails such as those
+	// of GenerateGIF to that many frames. Frames are dropped evenly and
+	// the time they were shown for is given to the frames that remain.
+	MaxFrames int
+
+	// MaxDuration, when positive, limits animated thumbnails to the
+	// frames that start within that much of the animation's first run.
+	// It applies before MaxFrames.
+	MaxDuration time.Duration
+
+	// Page selects the page of a multi-page TIFF to thumbnail, numbered
+	// from 1; 0 selects the first. Sources with a single image ignore it.
+	// Probe reports the number of pages.
+	Page int
+
+	// MaxPixels, MaxWidth and MaxHeight, when positive, limit the area,
+	// width and height of the sources ProcessReader and ProcessFile
+	// decode. Sources are sized from their headers, as Probe sizes them,
+	// and those over a limit are rejected with a *LimitError before any
+	// pixels are decoded, so that crafted images that declare a huge size
+	// cannot exhaust memory. The size of a multi-page TIFF is that of the
+	// page decoded.
+	MaxPixels int
+	MaxWidth  int
+	MaxHeight int
+
+	// pool, when set, provides the images thumbnails are scaled into, as
+	// for the calls of a Thumbnailer.
+	pool *pixelPool
+
+	// source, when set, is the size Results report for a source decoded
+	// reduced, that of the full image, and scale the scale they report.
+	source image.Point
+	scale  float64
+
+	// oriented is set once the orientation of the source has been applied
+	// to the pixels decoded, so that kept EXIF data is marked upright.
+	oriented bool
 }
 
-// DefaultOptions returns sensible defaults for thumbnail generation.
+// builtinDefaults are the options DefaultOptions returns until LoadConfig
+// is called, and those each configuration it loads is applied to.
+var builtinDefaults = Options{
+	Width:   150,
+	Height:  150,
+	Quality: 85,
+}
+
+// defaults holds the options returned by DefaultOptions.
+var defaults = struct {
+	sync.RWMutex
+	opts Options
+}{opts: builtinDefaults}
+
+// DefaultOptions returns sensible defaults for thumbnail generation. They
+// can be changed at run time with LoadConfig.
 func DefaultOptions() Options {
-	return Options{
-		Width:   150,
-		Height:  150,
-		Quality: 85,
-	}
+	defaults.RLock()
+	defer defaults.RUnlock()
+	return defaults.opts
 }
 
 // Generate creates a thumbnail from the source image.
 // It maintains aspect ratio, fitting within the specified dimensions.
-func Generate(src image.Image, opts Options) image.Image {
-	if opts.Width <= 0 {
-		opts.Width = 150
-	}
-	if opts.Height <= 0 {
-		opts.Height = 150
+// It returns ErrNilImage or ErrEmptyImage for unusable sources and the
+// error from Options.Validate for invalid options.
+func Generate(src image.Image, opts Options) (image.Image, error) {
+	return GenerateContext(context.Background(), src, opts)
+}
+
+// GenerateInto scales src into dst instead of allocating a new image, so
+// that callers can reuse buffers across calls. The thumbnail is written at
+// dst.Bounds().Min with the size reported by OutputSize; pixels of dst
+// outside that rectangle are left untouched. It returns
+// ErrDestinationTooSmall if the thumbnail does not fit within dst.
+func GenerateInto(dst draw.Image, src image.Image, opts Options) error {
+	release, err := acquire(context.Background())
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	srcBounds := src.Bounds()
-	srcW := srcBounds.Dx()
-	srcH := srcBounds.Dy()
+	if src == nil {
+		return ErrNilImage
+	}
+	sb := src.Bounds()
+	l, opts, err := prepare(sb.Dx(), sb.Dy(), opts)
+	if err != nil {
+		return err
+	}
+	if l, err = autoCrop(context.Background(), l, src, opts, &cropCache{}); err != nil {
+		return err
+	}
 
-	// Calculate dimensions maintaining aspect ratio
-	ratio := float64(srcW) / float64(srcH)
-	var newW, newH int
+	db := dst.Bounds()
+	if db.Dx() < l.size.X || db.Dy() < l.size.Y {
+		return ErrDestinationTooSmall
+	}
+	return render(context.Background(), dst, db.Min, src, l, opts)
+}
 
-	if float64(opts.Width)/float64(opts.Height) > ratio {
-		newH = opts.Height
-		newW = int(float64(newH) * ratio)
-	} else {
-		newW = opts.Width
-		newH = int(float64(newW) / ratio)
+// OutputSize returns the dimensions of the thumbnail Generate would produce
+// for a source of the given size. For every mode other than Fit this is
+// the requested Width×Height.
+func OutputSize(srcWidth, srcHeight int, opts Options) (width, height int, err error) {
+	l, _, err := prepare(srcWidth, srcHeight, opts)
+	if err != nil {
+		return 0, 0, err
 	}
+	return l.size.X, l.size.Y, nil
+}
 
-	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
-	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcBounds, draw.Over, nil)
+// prepare validates opts for a srcW×srcH source and computes its layout.
+// It also returns opts with Width and Height resolved from Scale.
+func prepare(srcW, srcH int, opts Options) (layout, Options, error) {
+	if err := opts.Validate(); err != nil {
+		return layout{}, opts, err
+	}
+	if srcW <= 0 || srcH <= 0 {
+		return layout{}, opts, ErrEmptyImage
+	}
+	if opts.Scale > 0 {
+		opts.Width = int(math.Round(float64(srcW) * opts.Scale))
+		opts.Height = int(math.Round(float64(srcH) * opts.Scale))
+		if opts.Width > MaxDimension || opts.Height > MaxDimension {
+			return layout{}, opts, &OptionError{"Scale", opts.Scale, ErrInvalidDimensions}
+		}
+		// Tiny factors still leave at least one pixel per axis.
+		opts.Width = maxInt(opts.Width, 1)
+		opts.Height = maxInt(opts.Height, 1)
+	}
+	if opts.AspectRatio > 0 {
+		var err error
+		if opts, err = applyAspectRatio(opts); err != nil {
+			return layout{}, opts, err
+		}
+	}
+	return plan(srcW, srcH, opts), opts, nil
+}
 
-	return dst
+// GenerateContext is like Generate but stops scaling and returns ctx.Err()
+// once ctx is cancelled or its deadline passes.
+func GenerateContext(ctx context.Context, src image.Image, opts Options) (image.Image, error) {
+	res, err := Process(ctx, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	return res.Image, nil
 }
 
 // GenerateFromFile reads an image file and generates a thumbnail.
 func GenerateFromFile(path string, opts Options) (image.Image, error) {
-	f, err := os.Open(path)
+	return GenerateFromFileContext(context.Background(), path, opts)
+}
+
+// GenerateFromFileContext is like GenerateFromFile but abandons decoding
+// and scaling once ctx is done.
+func GenerateFromFileContext(ctx context.Context, path string, opts Options) (image.Image, error) {
+	res, err := ProcessFile(ctx, path, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	return res.Image, nil
+}
+
+// GenerateFromReader decodes an image from r and generates a thumbnail.
+// It also returns the detected source format name (e.g. "jpeg", "png",
+// "gif") as reported by image.Decode, so callers can pick a matching
+// output encoder.
+func GenerateFromReader(r io.Reader, opts Options) (image.Image, string, error) {
+	return GenerateFromReaderContext(context.Background(), r, opts)
+}
 
-	src, _, err := image.Decode(f)
+// GenerateFromReaderContext is like GenerateFromReader but abandons
+// decoding and scaling once ctx is done.
+func GenerateFromReaderContext(ctx context.Context, r io.Reader, opts Options) (image.Image, string, error) {
+	res, err := ProcessReader(ctx, r, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	return res.Image, res.Format, nil
+}
 
-	return Generate(src, opts), nil
+// decode decodes an image from r, abandoning the read once ctx is done.
+func decode(ctx context.Context, r io.Reader) (image.Image, string, error) {
+	src, format, err := image.Decode(ctxReader{ctx, r})
+	if err != nil {
+		// Decoders may wrap or replace read errors; report the
+		// cancellation itself when that is what stopped them.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		return nil, "", err
+	}
+	return src, format, nil
 }
 
-// SaveJPEG saves the thumbnail as a JPEG file.
+// SaveJPEG saves the thumbnail as a JPEG file. Transparent areas are
+// flattened onto white.
 func SaveJPEG(img image.Image, w io.Writer, quality int) error {
 	if quality <= 0 || quality > 100 {
 		quality = 85
 	}
-	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	return jpeg.Encode(w, flatten(img, nil), &jpeg.Options{Quality: quality})
 }
 
 // SavePNG saves the thumbnail as a PNG file.
@@ -89,29 +557,74 @@ func SavePNG(img image.Image, w io.Writer) error {
 	return png.Encode(w, img)
 }
 
-// SaveGIF saves the thumbnail as a GIF file.
+// SaveGIF saves the thumbnail as a GIF file. Paletted images are written
+// with their own palette. Other images are dithered to the Plan 9 palette,
+// with one entry given up for transparency when the image has
+// transparent pixels, so that they do not turn black. SaveGIFOptions
+// offers better palettes.
 func SaveGIF(img image.Image, w io.Writer) error {
-	return gif.Encode(w, img, nil)
+	return SaveGIFOptions(img, w, Options{})
+}
+
+// SaveBMP saves the thumbnail as a BMP file.
+func SaveBMP(img image.Image, w io.Writer) error {
+	return bmp.Encode(w, img)
+}
+
+// SaveTIFF saves the thumbnail as a Deflate-compressed TIFF file.
+func SaveTIFF(img image.Image, w io.Writer) error {
+	return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+}
+
+// hasTransparency reports whether any pixel of img is not fully opaque.
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // GenerateAndSave is a convenience function that generates a thumbnail
-// and saves it to a file.
+// and saves it to a file. The output format follows Save: opts.Format when
+// set, and otherwise the extension of outputPath. Source metadata selected
+// by opts.KeepMetadata is written unless opts.Metadata is set.
 func GenerateAndSave(inputPath, outputPath string, opts Options) error {
-	thumb, err := GenerateFromFile(inputPath, opts)
-	if err != nil {
-		return err
+	// Reject unknown formats before doing the expensive work.
+	if format := outputFormat(outputPath, opts); !hasEncoder(format) {
+		return fmt.Errorf("%w %q for %s", ErrUnsupportedFormat, format, outputPath)
 	}
 
-	f, err := os.Create(outputPath)
+	res, err := ProcessFile(context.Background(), inputPath, opts)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	quality := opts.Quality
-	if quality <= 0 {
-		quality = 85
+	if opts.Metadata == nil {
+		opts.Metadata = res.Metadata
+	}
+	if res.OutputFormat != "" {
+		opts.Format = res.OutputFormat
 	}
+	return Save(res.Image, outputPath, opts)
+}
 
-	return SaveJPEG(thumb, f, quality)
+// ctxReader fails reads once its context is done, which lets decoders
+// abandon large inputs part way through.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
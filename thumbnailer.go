@@ -0,0 +1,93 @@
+package thumbnail
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"os"
+	"sync"
+)
+
+// readBufferSize is the size of the pooled buffered readers used for
+// decoding.
+const readBufferSize = 32 << 10
+
+// Thumbnailer generates thumbnails with a configuration fixed at
+// construction. It is safe for concurrent use by multiple goroutines and
+// reuses its decoding buffers between calls, so long-running servers should
+// share one Thumbnailer per configuration. Thumbnails handed back to
+// Release are reused too, by size class, so that batch jobs that encode
+// each thumbnail and drop it do not allocate a new one every time.
+type Thumbnailer struct {
+	opts    Options
+	readers sync.Pool // of *bufio.Reader
+	pixels  pixelPool
+}
+
+// NewThumbnailer returns a Thumbnailer configured with opts applied on top
+// of DefaultOptions. It returns an error if the resulting options are
+// invalid.
+func NewThumbnailer(opts ...Option) (*Thumbnailer, error) {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	t := &Thumbnailer{opts: o}
+	t.opts.pool = &t.pixels
+	return t, nil
+}
+
+// Options returns the options t was configured with.
+func (t *Thumbnailer) Options() Options {
+	o := t.opts
+	o.pool = nil
+	return o
+}
+
+// FromImage generates a thumbnail from an already decoded image.
+func (t *Thumbnailer) FromImage(src image.Image) (image.Image, error) {
+	return Generate(src, t.opts)
+}
+
+// FromReader decodes an image from r and generates a thumbnail. It also
+// returns the detected source format name.
+func (t *Thumbnailer) FromReader(r io.Reader) (image.Image, string, error) {
+	br := t.getReader(r)
+	defer t.putReader(br)
+
+	return GenerateFromReader(br, t.opts)
+}
+
+// FromFile reads an image file and generates a thumbnail.
+func (t *Thumbnailer) FromFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	thumb, _, err := t.FromReader(f)
+	return thumb, err
+}
+
+// Release hands a thumbnail generated by t back for a later call to reuse
+// its pixels. Neither img nor anything sharing its pixels, such as a
+// sub-image or, for thumbnails that Passthrough returned as they were, the
+// source, may be used afterwards.
+func (t *Thumbnailer) Release(img image.Image) {
+	t.pixels.release(img)
+}
+
+func (t *Thumbnailer) getReader(r io.Reader) *bufio.Reader {
+	if br, ok := t.readers.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, readBufferSize)
+}
+
+func (t *Thumbnailer) putReader(br *bufio.Reader) {
+	// Drop the reference to the source so it can be collected.
+	br.Reset(nil)
+	t.readers.Put(br)
+}
@@ -0,0 +1,62 @@
+package thumbnail
+
+// maxTIFFPages bounds the IFD chain walked in a TIFF file.
+const maxTIFFPages = 1 << 16
+
+// tiffIFDs returns the offsets of the chain of IFDs of a TIFF file, one
+// per page, ending early at a malformed or repeated IFD.
+func tiffIFDs(data []byte) []uint32 {
+	t, off, err := newTIFFReader(data)
+	if err != nil {
+		return nil
+	}
+	return tiffChain(t, off)
+}
+
+// tiffChain is tiffIFDs for the file read by t, whose first IFD is at off.
+func tiffChain(t *tiffReader, off uint32) []uint32 {
+	var offs []uint32
+	seen := make(map[uint32]bool)
+	for off != 0 && !seen[off] && len(offs) < maxTIFFPages {
+		seen[off] = true
+		_, next, err := t.ifd(off)
+		if err != nil {
+			break
+		}
+		offs = append(offs, off)
+		off = next
+	}
+	return offs
+}
+
+// tiffSize returns the size recorded in the first IFD of a TIFF file.
+func tiffSize(data []byte) (w, h int, ok bool) {
+	t, off, err := newTIFFReader(data)
+	if err != nil {
+		return 0, 0, false
+	}
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return 0, 0, false
+	}
+	tw, ok1 := t.field(entries, tagImageWidth)
+	th, ok2 := t.field(entries, tagImageLength)
+	return int(tw), int(th), ok1 && ok2
+}
+
+// tiffPage returns a copy of a TIFF file whose header points at the IFD of
+// page, numbered from 1, so that decoders read that page as the first.
+func tiffPage(data []byte, page int) ([]byte, error) {
+	offs := tiffIFDs(data)
+	if page > len(offs) {
+		return nil, ErrPageNotFound
+	}
+	t, _, _ := newTIFFReader(data)
+	b := append([]byte(nil), data...)
+	if t.big {
+		t.order.PutUint64(b[8:], uint64(offs[page-1]))
+	} else {
+		t.order.PutUint32(b[4:], offs[page-1])
+	}
+	return b, nil
+}
@@ -0,0 +1,568 @@
+package thumbnail
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// TIFF tags of the image data that decodeTiled reads.
+const (
+	tagNewSubfileType  = 0x00fe
+	tagImageWidth      = 0x0100
+	tagImageLength     = 0x0101
+	tagBitsPerSample   = 0x0102
+	tagPhotometric     = 0x0106
+	tagSamplesPerPixel = 0x0115
+	tagRowsPerStrip    = 0x0116
+	tagPlanarConfig    = 0x011c
+	tagPredictor       = 0x013d
+	tagColorMap        = 0x0140
+	tagTileWidth       = 0x0142
+	tagTileLength      = 0x0143
+	tagTileOffsets     = 0x0144
+	tagTileByteCounts  = 0x0145
+	tagExtraSamples    = 0x0152
+	tagSampleFormat    = 0x0153
+	tagJPEGTables      = 0x015b
+)
+
+// maxTiledSide bounds the sides of the images decodeTiled reads.
+const maxTiledSide = 1 << 24
+
+// errTiledUnsupported reports a TIFF file that decodeTiled leaves to
+// golang.org/x/image/tiff.
+var errTiledUnsupported = errors.New("thumbnail: unsupported TIFF for tiled decoding")
+
+// tiffRaster is the image data of a TIFF directory, stored in tiles or in
+// strips, which it reads as tiles as wide as the image. It reads 8-bit
+// chunky gray, RGB and palette data, with or without alpha, compressed
+// with LZW, Deflate, PackBits or JPEG, or not at all.
+type tiffRaster struct {
+	t               *tiffReader // of the file
+	w, h            int
+	tw, th          int      // tile size
+	offsets, counts []uint32 // of the tiles, by rows of tiles
+	compression     uint32
+	photometric     uint32
+	predictor       uint32
+	spp             int          // samples per pixel
+	alpha           uint32       // extra sample kind: 1 associated, 2 unassociated, else none
+	palette         []color.RGBA // for palette data
+	tables          []byte       // the JPEG tables the tiles share
+}
+
+// newTIFFRaster returns the raster of the directory entries read by t, or
+// errTiledUnsupported if it does not read its kind of data.
+func newTIFFRaster(t *tiffReader, entries []ifdEntry) (*tiffRaster, error) {
+	get := func(tag uint16, def uint32) uint32 {
+		if v, ok := t.field(entries, tag); ok {
+			return v
+		}
+		return def
+	}
+	r := &tiffRaster{
+		t:           t,
+		w:           int(get(tagImageWidth, 0)),
+		h:           int(get(tagImageLength, 0)),
+		compression: get(tagCompression, 1),
+		photometric: get(tagPhotometric, math.MaxUint32),
+		predictor:   get(tagPredictor, 1),
+		spp:         int(get(tagSamplesPerPixel, 1)),
+	}
+	if r.w <= 0 || r.h <= 0 || r.w > maxTiledSide || r.h > maxTiledSide {
+		return nil, errTiledUnsupported
+	}
+	if get(tagPlanarConfig, 1) != 1 || get(tagSampleFormat, 1) != 1 {
+		return nil, errTiledUnsupported
+	}
+	bps := tiffValues(t, entries, tagBitsPerSample)
+	if bps == nil {
+		bps = []uint32{1}
+	}
+	for _, b := range bps {
+		if b != 8 {
+			return nil, errTiledUnsupported
+		}
+	}
+	if r.spp == 2 || r.spp == 4 {
+		r.alpha = get(tagExtraSamples, 0)
+	}
+	switch r.photometric {
+	case 0, 1: // WhiteIsZero, BlackIsZero
+		if r.spp > 2 {
+			return nil, errTiledUnsupported
+		}
+	case 2: // RGB
+		if r.spp < 3 || r.spp > 4 || r.compression == 7 {
+			return nil, errTiledUnsupported
+		}
+	case 3: // palette
+		cm := tiffValues(t, entries, tagColorMap)
+		if r.spp != 1 || len(cm) != 3*256 {
+			return nil, errTiledUnsupported
+		}
+		r.palette = make([]color.RGBA, 256)
+		for i := range r.palette {
+			r.palette[i] = color.RGBA{uint8(cm[i] >> 8), uint8(cm[256+i] >> 8), uint8(cm[512+i] >> 8), 0xff}
+		}
+	case 6: // YCbCr, which only JPEG data is read as
+		if r.spp != 3 || r.compression != 7 {
+			return nil, errTiledUnsupported
+		}
+	default:
+		return nil, errTiledUnsupported
+	}
+	switch r.compression {
+	case 1, 5, 8, 32946, 32773: // none, LZW, Deflate, old Deflate, PackBits
+	case 7:
+		if r.predictor != 1 || r.photometric == 3 {
+			return nil, errTiledUnsupported
+		}
+		for _, e := range entries {
+			if e.tag == tagJPEGTables {
+				r.tables = e.data
+			}
+		}
+	default:
+		return nil, errTiledUnsupported
+	}
+	if r.predictor != 1 && r.predictor != 2 {
+		return nil, errTiledUnsupported
+	}
+
+	n := 0
+	if tw, ok := t.field(entries, tagTileWidth); ok {
+		r.tw, r.th = int(tw), int(get(tagTileLength, 0))
+		if r.tw <= 0 || r.th <= 0 || r.tw > maxTiledSide || r.th > maxTiledSide {
+			return nil, errTiledUnsupported
+		}
+		n = ceilDiv(r.w, r.tw) * ceilDiv(r.h, r.th)
+		r.offsets, r.counts = tiffValues(t, entries, tagTileOffsets), tiffValues(t, entries, tagTileByteCounts)
+	} else {
+		r.tw, r.th = r.w, clampInt(int(get(tagRowsPerStrip, uint32(r.h))), 1, r.h)
+		n = ceilDiv(r.h, r.th)
+		r.offsets, r.counts = tiffValues(t, entries, tagStripOffsets), tiffValues(t, entries, tagStripByteCounts)
+	}
+	if len(r.offsets) != n || len(r.counts) != n {
+		return nil, errTiledUnsupported
+	}
+	for i, off := range r.offsets {
+		if uint64(off)+uint64(r.counts[i]) > t.size {
+			return nil, errTiledUnsupported
+		}
+	}
+	return r, nil
+}
+
+// tiffValues returns all values of the BYTE, SHORT, LONG or IFD field with
+// the given tag, or nil if there is none or it has other values.
+func tiffValues(t *tiffReader, entries []ifdEntry, tag uint16) []uint32 {
+	for _, e := range entries {
+		if e.tag != tag {
+			continue
+		}
+		vs := make([]uint32, e.count)
+		for i := range vs {
+			v, ok := t.uint(e, i)
+			if !ok {
+				return nil
+			}
+			vs[i] = v
+		}
+		return vs
+	}
+	return nil
+}
+
+// channels returns the bytes per pixel of the rows decode produces: 1
+// for gray data without alpha, and 4, premultiplied RGBA, for the rest.
+func (r *tiffRaster) channels() int {
+	if r.photometric <= 1 && !r.hasAlpha() {
+		return 1
+	}
+	return 4
+}
+
+// hasAlpha reports whether r has an alpha sample.
+func (r *tiffRaster) hasAlpha() bool {
+	return r.alpha == 1 || r.alpha == 2
+}
+
+// tiffLevels returns base, the raster of the directory holding entries,
+// followed by the reduced-resolution copies of it the file stores, largest
+// first: those in its SubIFDs and in the directories after it, from next
+// on, that are marked as reduced-resolution, as pyramidal TIFFs store
+// them. Copies of another aspect ratio or of a kind tiffRaster does not
+// read are skipped.
+func tiffLevels(t *tiffReader, entries []ifdEntry, next uint32, base *tiffRaster) []*tiffRaster {
+	levels := []*tiffRaster{base}
+	add := func(entries []ifdEntry) {
+		r, err := newTIFFRaster(t, entries)
+		if err != nil || r.w >= base.w || r.h >= base.h {
+			return
+		}
+		// Levels round their sides, which may each be off by a pixel.
+		tol := int64(base.w + base.h)
+		if d := int64(r.w)*int64(base.h) - int64(r.h)*int64(base.w); d <= -tol || d >= tol {
+			return
+		}
+		levels = append(levels, r)
+	}
+	seen := make(map[uint32]bool)
+	for _, off := range tiffValues(t, entries, tagSubIFDs) {
+		if sub, _, err := t.ifd(off); err == nil && !seen[off] {
+			seen[off] = true
+			add(sub)
+		}
+	}
+	for off := next; off != 0 && !seen[off] && len(seen) < maxTIFFPages; {
+		seen[off] = true
+		e, n, err := t.ifd(off)
+		if err != nil {
+			break
+		}
+		if v, _ := t.field(e, tagNewSubfileType); v&1 == 0 {
+			break
+		}
+		add(e)
+		off = n
+	}
+	sort.SliceStable(levels, func(i, j int) bool { return levels[i].w > levels[j].w })
+	return levels
+}
+
+// decodeTiled decodes the page of the TIFF file read by t whose directory
+// is at off, when it can read less of it than image/tiff would, and
+// BigTIFF files, which image/tiff does not read, in any case. With opts,
+// it picks the smallest level of a pyramidal file that leaves shrinkMargin
+// times the thumbnail's size, reads only the tiles or strips under the
+// part of it a Fill or Crop thumbnail keeps, and box-reduces them as they
+// are read to the same margin, so that the full raster of a huge scan is
+// never held at once, nor the file itself when t reads it through an
+// io.ReaderAt. *opts is adjusted for the smaller source: as for
+// decodeDraft, and to Stretch the region decoded into the thumbnail's
+// size, with Results reporting the size and scale of the full page either
+// way. o is the orientation that will be applied to the result.
+//
+// It returns errTiledUnsupported for files left to image/tiff.
+func decodeTiled(ctx context.Context, t *tiffReader, off uint32, o int, opts *Options) (image.Image, error) {
+	entries, next, err := t.ifd(off)
+	if err != nil {
+		return nil, errTiledUnsupported
+	}
+	base, err := newTIFFRaster(t, entries)
+	if err != nil {
+		return nil, err
+	}
+	full := image.Rect(0, 0, base.w, base.h)
+	if opts == nil || opts.NoDraft {
+		if !t.big {
+			return nil, errTiledUnsupported
+		}
+		return base.decode(ctx, full, 1, 1)
+	}
+
+	w, h := base.w, base.h
+	if o >= 5 {
+		w, h = h, w
+	}
+	l, resolved, err := prepare(w, h, *opts)
+	if err != nil {
+		return nil, errTiledUnsupported
+	}
+	// Only Fill and Crop keep part of the source, and only a window
+	// that autoCrop will not move can be read on its own.
+	crop := o == 1 && l.src != full && (resolved.Mode == Fill || resolved.Mode == Crop) &&
+		resolved.Gravity != Smart && resolved.Detector == nil
+	region := full
+	if crop {
+		region = l.src
+	}
+	need, kept := l.dst.Size(), l.src.Size()
+	if o >= 5 {
+		need, kept = image.Pt(need.Y, need.X), image.Pt(kept.Y, kept.X)
+	}
+
+	level := base
+	for _, lv := range tiffLevels(t, entries, next, base) {
+		sx, sy := float64(lv.w)/float64(base.w), float64(lv.h)/float64(base.h)
+		if float64(kept.X)*sx >= float64(shrinkMargin*need.X) && float64(kept.Y)*sy >= float64(shrinkMargin*need.Y) {
+			level = lv
+		}
+	}
+	sx, sy := float64(level.w)/float64(base.w), float64(level.h)/float64(base.h)
+	lr := image.Rect(
+		int(math.Floor(float64(region.Min.X)*sx)), int(math.Floor(float64(region.Min.Y)*sy)),
+		int(math.Ceil(float64(region.Max.X)*sx)), int(math.Ceil(float64(region.Max.Y)*sy)),
+	).Intersect(image.Rect(0, 0, level.w, level.h))
+	if lr.Empty() {
+		return nil, errTiledUnsupported
+	}
+	fx, fy := shrinkFactors(lr.Dx(), lr.Dy(), need.X, need.Y)
+	if level == base && fx == 1 && fy == 1 && !crop && !t.big {
+		return nil, errTiledUnsupported
+	}
+
+	img, err := level.decode(ctx, lr, fx, fy)
+	if err != nil {
+		return nil, err
+	}
+	if crop {
+		opts.Mode, opts.Width, opts.Height = Stretch, l.size.X, l.size.Y
+		opts.Scale, opts.AspectRatio, opts.NoUpscale, opts.Focus = 0, 0, false, nil
+		reportFullSize(opts, w, h, l)
+		return img, nil
+	}
+	b := img.Bounds()
+	dw, dh := b.Dx(), b.Dy()
+	if o >= 5 {
+		dw, dh = dh, dw
+	}
+	rescaleOptions(opts, l, resolved, float64(dw)/float64(w), float64(dh)/float64(h))
+	reportFullSize(opts, w, h, l)
+	return img, nil
+}
+
+// decode returns the region part of r, reduced as it is read by averaging
+// each fx×fy box into a pixel. Rows and columns left over by the factors
+// are dropped evenly from both sides, as shrink drops them.
+// Only the tiles under region are read. The result is an *image.Gray or
+// an *image.RGBA, as channels says.
+func (r *tiffRaster) decode(ctx context.Context, region image.Rectangle, fx, fy int) (image.Image, error) {
+	ow, oh := region.Dx()/fx, region.Dy()/fy
+	region.Min = region.Min.Add(image.Pt(region.Dx()%fx/2, region.Dy()%fy/2))
+	region.Max = region.Min.Add(image.Pt(ow*fx, oh*fy))
+	ch := r.channels()
+	var out image.Image
+	var pix []byte
+	if ch == 1 {
+		m := image.NewGray(image.Rect(0, 0, ow, oh))
+		out, pix = m, m.Pix
+	} else {
+		m := image.NewRGBA(image.Rect(0, 0, ow, oh))
+		out, pix = m, m.Pix
+	}
+	var sums []uint32
+	if fx*fy > 1 {
+		sums = make([]uint32, len(pix))
+	}
+
+	across := ceilDiv(r.w, r.tw)
+	for ty := region.Min.Y / r.th; ty*r.th < region.Max.Y; ty++ {
+		for tx := region.Min.X / r.tw; tx*r.tw < region.Max.X; tx++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			rows := minInt(r.th, r.h-ty*r.th)
+			px, err := r.tile(ty*across+tx, rows)
+			if err != nil {
+				return nil, err
+			}
+			origin := image.Pt(tx*r.tw, ty*r.th)
+			tr := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(r.tw, rows))}.Intersect(region)
+			for y := tr.Min.Y; y < tr.Max.Y; y++ {
+				i := ((y-origin.Y)*r.tw + tr.Min.X - origin.X) * ch
+				line := px[i : i+tr.Dx()*ch]
+				ox, oy := tr.Min.X-region.Min.X, (y-region.Min.Y)/fy
+				if sums == nil {
+					copy(pix[(oy*ow+ox)*ch:], line)
+					continue
+				}
+				acc := sums[oy*ow*ch:]
+				for x := 0; x < tr.Dx(); x++ {
+					p := (ox + x) / fx * ch
+					for c := 0; c < ch; c++ {
+						acc[p+c] += uint32(line[x*ch+c])
+					}
+				}
+			}
+			scratch.put(px)
+		}
+	}
+	if sums != nil {
+		n := uint32(fx * fy)
+		for i, s := range sums {
+			pix[i] = uint8((s + n/2) / n)
+		}
+	}
+	return out, nil
+}
+
+// tile returns the pixels of tile i, which holds rows rows, r.tw to a row
+// with channels bytes each, in a buffer from scratch.
+func (r *tiffRaster) tile(i, rows int) ([]byte, error) {
+	raw, err := r.t.at(uint64(r.offsets[i]), uint64(r.counts[i]))
+	if err != nil {
+		return nil, err
+	}
+	if r.t.ra != nil {
+		defer scratch.put(raw)
+	}
+	ch := r.channels()
+	out := scratch.get(r.tw * rows * ch)
+	if r.compression == 7 {
+		if err := r.jpegTile(out, raw, rows); err != nil {
+			scratch.put(out)
+			return nil, err
+		}
+		return out, nil
+	}
+
+	// Only BlackIsZero gray and RGB with associated alpha store samples
+	// as the pixels are returned.
+	direct := r.photometric == 1 && r.spp == 1 || r.photometric == 2 && r.spp == 4 && r.alpha == 1
+	samples := out
+	if !direct {
+		samples = scratch.get(r.tw * rows * r.spp)
+		defer scratch.put(samples)
+	}
+	switch r.compression {
+	case 1:
+		if len(raw) < len(samples) {
+			err = errBadTIFF
+		} else {
+			copy(samples, raw)
+		}
+	case 5:
+		zr := lzw.NewReader(bytes.NewReader(raw), lzw.MSB, 8)
+		_, err = io.ReadFull(zr, samples)
+		zr.Close()
+	case 8, 32946:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			_, err = io.ReadFull(zr, samples)
+			zr.Close()
+		}
+	case 32773:
+		err = unpackBits(samples, raw)
+	}
+	if err != nil {
+		scratch.put(out)
+		return nil, err
+	}
+	if r.predictor == 2 {
+		// Horizontal differencing of each sample with the pixel before.
+		for y := 0; y < rows; y++ {
+			row := samples[y*r.tw*r.spp : (y+1)*r.tw*r.spp]
+			for k := r.spp; k < len(row); k++ {
+				row[k] += row[k-r.spp]
+			}
+		}
+	}
+	if !direct {
+		r.convert(out, samples)
+	}
+	return out, nil
+}
+
+// convert converts the samples of src to pixels of channels bytes in dst.
+func (r *tiffRaster) convert(dst, src []byte) {
+	ch, spp := r.channels(), r.spp
+	for i, j := 0, 0; j+spp <= len(src); i, j = i+ch, j+spp {
+		s := src[j : j+spp]
+		switch {
+		case r.photometric == 3:
+			c := r.palette[s[0]]
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+			continue
+		case r.photometric <= 1:
+			v := s[0]
+			if r.photometric == 0 {
+				v = 0xff - v
+			}
+			if ch == 1 {
+				dst[i] = v
+				continue
+			}
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = v, v, v, 0xff
+		default:
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = s[0], s[1], s[2], 0xff
+		}
+		if !r.hasAlpha() {
+			continue
+		}
+		a := s[spp-1]
+		if r.photometric == 0 && r.alpha == 1 {
+			// Inverted gray premultiplied by alpha is alpha less the
+			// premultiplied gray.
+			v := uint8(0)
+			if s[0] < a {
+				v = a - s[0]
+			}
+			dst[i], dst[i+1], dst[i+2] = v, v, v
+		}
+		dst[i+3] = a
+		if r.alpha == 2 {
+			for c := 0; c < 3; c++ {
+				dst[i+c] = uint8((uint32(dst[i+c])*uint32(a) + 0x7f) / 0xff)
+			}
+		}
+	}
+}
+
+// jpegTile decodes the JPEG-compressed tile raw, prefixed with the tables
+// the tiles share, into the rows rows of pixels of dst.
+func (r *tiffRaster) jpegTile(dst, raw []byte, rows int) error {
+	stream := raw
+	if len(r.tables) >= 4 && len(raw) >= 2 {
+		// Splice the tables, less their EOI, before the tile's markers,
+		// less its SOI.
+		stream = make([]byte, 0, len(r.tables)+len(raw))
+		stream = append(append(stream, r.tables[:len(r.tables)-2]...), raw[2:]...)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stream))
+	if err != nil {
+		return err
+	}
+	rect := image.Rect(0, 0, r.tw, rows)
+	var m draw.Image
+	if r.channels() == 1 {
+		m = &image.Gray{Pix: dst, Stride: r.tw, Rect: rect}
+	} else {
+		m = &image.RGBA{Pix: dst, Stride: 4 * r.tw, Rect: rect}
+	}
+	clearBytes(dst)
+	draw.Draw(m, rect, img, img.Bounds().Min, draw.Src)
+	return nil
+}
+
+// unpackBits decodes PackBits data from src into all of dst.
+func unpackBits(dst, src []byte) error {
+	n := 0
+	for len(src) > 0 && n < len(dst) {
+		c := int(int8(src[0]))
+		src = src[1:]
+		switch {
+		case c >= 0:
+			if c+1 > len(src) {
+				return errBadTIFF
+			}
+			n += copy(dst[n:], src[:c+1])
+			src = src[c+1:]
+		case c != -128:
+			if len(src) == 0 {
+				return errBadTIFF
+			}
+			for k := 1 - c; k > 0 && n < len(dst); k-- {
+				dst[n] = src[0]
+				n++
+			}
+			src = src[1:]
+		}
+	}
+	if n < len(dst) {
+		return errBadTIFF
+	}
+	return nil
+}
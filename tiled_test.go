@@ -0,0 +1,153 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// tiledTIFF encodes img as an uncompressed RGB TIFF of size×size tiles.
+func tiledTIFF(img *image.NRGBA, size int) []byte {
+	le := binary.LittleEndian
+	var data []byte
+	u16 := func(v uint16) { data = append(data, byte(v), byte(v>>8)) }
+	u32 := func(v uint32) { data = append(data, byte(v), byte(v>>8), byte(v>>16), byte(v>>24)) }
+	b := img.Bounds()
+	across, down := ceilDiv(b.Dx(), size), ceilDiv(b.Dy(), size)
+	n := across * down
+	data = append(data, "II*\x00\x00\x00\x00\x00"...)
+	var offsets, counts []uint32
+	for ty := 0; ty < down; ty++ {
+		for tx := 0; tx < across; tx++ {
+			offsets = append(offsets, uint32(len(data)))
+			for y := ty * size; y < (ty+1)*size; y++ {
+				for x := tx * size; x < (tx+1)*size; x++ {
+					c := img.NRGBAAt(x, y)
+					data = append(data, c.R, c.G, c.B)
+				}
+			}
+			counts = append(counts, uint32(3*size*size))
+		}
+	}
+	bps := len(data)
+	data = append(data, 8, 0, 8, 0, 8, 0)
+	offs := len(data)
+	for _, o := range offsets {
+		u32(o)
+	}
+	cnts := len(data)
+	for _, c := range counts {
+		u32(c)
+	}
+	le.PutUint32(data[4:], uint32(len(data)))
+	type field struct {
+		tag, typ     uint16
+		count, value uint32
+	}
+	fields := []field{
+		{tagImageWidth, 4, 1, uint32(b.Dx())},
+		{tagImageLength, 4, 1, uint32(b.Dy())},
+		{tagBitsPerSample, 3, 3, uint32(bps)},
+		{tagCompression, 3, 1, 1},
+		{tagPhotometric, 3, 1, 2},
+		{tagSamplesPerPixel, 3, 1, 3},
+		{tagPlanarConfig, 3, 1, 1},
+		{tagTileWidth, 4, 1, uint32(size)},
+		{tagTileLength, 4, 1, uint32(size)},
+		{tagTileOffsets, 4, uint32(n), uint32(offs)},
+		{tagTileByteCounts, 4, uint32(n), uint32(cnts)},
+	}
+	u16(uint16(len(fields)))
+	for _, f := range fields {
+		u16(f.tag)
+		u16(f.typ)
+		u32(f.count)
+		u32(f.value)
+	}
+	u32(0)
+	return data
+}
+
+// countingReaderAt counts the bytes read through ReadAt.
+type countingReaderAt struct {
+	*bytes.Reader
+	n int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestTiledReaderAt(t *testing.T) {
+	data := tiledTIFF(testImage(2048, 512, false), 64)
+	path := filepath.Join(t.TempDir(), "scan.tif")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Width: 100, Height: 100, Mode: Fill}
+	ctx := context.Background()
+	// A plain io.Reader is read whole.
+	want, err := ProcessReader(ctx, struct{ io.Reader }{bytes.NewReader(data)}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReaderAt{Reader: bytes.NewReader(data)}
+	got, err := ProcessReader(ctx, r, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got.Image, want.Image) || got.SourceWidth != 2048 || got.Scale != want.Scale {
+		t.Errorf("ReaderAt: got %dx%d at %v, want the %dx%d of a plain reader at %v",
+			got.SourceWidth, got.SourceHeight, got.Scale, want.SourceWidth, want.SourceHeight, want.Scale)
+	}
+	// The crop keeps a quarter of the tiles.
+	if r.n > int64(len(data))/3 {
+		t.Errorf("read %d of %d bytes through ReadAt", r.n, len(data))
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); pos > sniffLen {
+		t.Errorf("read %d bytes through Read", pos)
+	}
+
+	got, err = ProcessFile(ctx, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got.Image, want.Image) {
+		t.Error("ProcessFile differs from a plain reader")
+	}
+}
+
+func TestTiledResultSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, testImage(1600, 1200, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		mode  Mode
+		w, h  int
+		scale float64
+	}{
+		{Fit, 100, 75, 100.0 / 1600},
+		{Fill, 100, 100, 100.0 / 1200},
+	} {
+		opts := Options{Width: 100, Height: 100, Mode: c.mode}
+		res, err := ProcessReader(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.SourceWidth != 1600 || res.SourceHeight != 1200 || res.Width != c.w || res.Height != c.h || res.Scale != c.scale {
+			t.Errorf("%v: got %dx%d from %dx%d at %v, want %dx%d from 1600x1200 at %v", c.mode,
+				res.Width, res.Height, res.SourceWidth, res.SourceHeight, res.Scale, c.w, c.h, c.scale)
+		}
+	}
+}
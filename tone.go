@@ -0,0 +1,198 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Grayscale returns a step that removes the color from the image, as for
+// archived or inactive items. Opaque images become an *image.Gray, which
+// encodes smaller; others an *image.RGBA with their alpha kept.
+func Grayscale() Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !hasTransparency(img) {
+			b := img.Bounds()
+			g := image.NewGray(b)
+			draw.Draw(g, b, img, b.Min, draw.Src)
+			return g, nil
+		}
+		return recolor(ctx, img, func(r, g, b uint8) (uint8, uint8, uint8) {
+			y := luma(r, g, b)
+			return y, y, y
+		})
+	})
+}
+
+// Sepia returns a step that gives the image the brown tones of an old
+// photograph. The result is an *image.RGBA with the image's alpha.
+func Sepia() Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return recolor(ctx, img, func(r, g, b uint8) (uint8, uint8, uint8) {
+			// The weights, in 1/1000ths, are the customary sepia matrix.
+			ri, gi, bi := int(r), int(g), int(b)
+			return clamp255((393*ri + 769*gi + 189*bi + 500) / 1000),
+				clamp255((349*ri + 686*gi + 168*bi + 500) / 1000),
+				clamp255((272*ri + 534*gi + 131*bi + 500) / 1000)
+		})
+	})
+}
+
+// Duotone returns a step that maps the brightness of the image onto a
+// gradient from shadow, for black, to highlight, for white, as for
+// branded or themed thumbnails. The colors' own alpha is ignored; nil ones
+// are black and white, which makes Duotone(nil, nil) a grayscale filter
+// that keeps an RGBA image. The result is an *image.RGBA with the image's
+// alpha.
+func Duotone(shadow, highlight color.Color) Step {
+	if shadow == nil {
+		shadow = color.Black
+	}
+	if highlight == nil {
+		highlight = color.White
+	}
+	lo := color.NRGBAModel.Convert(shadow).(color.NRGBA)
+	hi := color.NRGBAModel.Convert(highlight).(color.NRGBA)
+	var ramp [256][3]uint8
+	for i := range ramp {
+		mix := func(a, b uint8) uint8 { return uint8((int(a)*(255-i) + int(b)*i + 127) / 255) }
+		ramp[i] = [3]uint8{mix(lo.R, hi.R), mix(lo.G, hi.G), mix(lo.B, hi.B)}
+	}
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return recolor(ctx, img, func(r, g, b uint8) (uint8, uint8, uint8) {
+			c := ramp[luma(r, g, b)]
+			return c[0], c[1], c[2]
+		})
+	})
+}
+
+// Brightness returns a step that lightens the image by amount, from -1,
+// which makes it black, to 1, which makes it white, as for lifting dark
+// phone photos slightly; 0.1 is a gentle lift. The result is an
+// *image.RGBA with the image's alpha.
+func Brightness(amount float64) Step {
+	return levels(func(v float64) float64 { return v + amount*255 })
+}
+
+// Contrast returns a step that spreads the image's tones away from mid
+// gray by amount, from -1, which makes it flat gray, through 0, which
+// leaves it unchanged, to 1, which doubles the spread. The result is an
+// *image.RGBA with the image's alpha.
+func Contrast(amount float64) Step {
+	return levels(func(v float64) float64 { return 128 + (v-128)*(1+amount) })
+}
+
+// Saturation returns a step that intensifies the image's colors by amount,
+// from -1, which removes them, through 0, which leaves them unchanged, to
+// 1, which doubles their distance from gray. The result is an *image.RGBA
+// with the image's alpha.
+func Saturation(amount float64) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return recolor(ctx, img, func(r, g, b uint8) (uint8, uint8, uint8) {
+			y := float64(luma(r, g, b))
+			s := func(v uint8) uint8 { return clamp255(int(math.Round(y + (float64(v)-y)*(1+amount)))) }
+			return s(r), s(g), s(b)
+		})
+	})
+}
+
+// Vignette returns a step that darkens the image towards its edges, as for
+// stylized gallery thumbnails. Darkening begins at radius, the share of
+// the distance from the center to the corners, and deepens smoothly to
+// strength, from 0 to 1, at the corners. Like the other effects it is
+// cheapest after Resize. The result is an *image.RGBA with the image's
+// alpha.
+func Vignette(strength, radius float64) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		b := img.Bounds()
+		dst := image.NewRGBA(b)
+		draw.Draw(dst, b, img, b.Min, draw.Src)
+		if strength <= 0 || radius >= 1 || b.Empty() {
+			return dst, nil
+		}
+		cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+		for y := 0; y < b.Dy(); y++ {
+			if y%bandSize == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			dy := (float64(y) + 0.5 - cy) / cy
+			px := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				dx := (float64(x) + 0.5 - cx) / cx
+				// Scaling premultiplied channels alike darkens the
+				// color and keeps the alpha.
+				t := clamp01((math.Sqrt((dx*dx+dy*dy)/2) - radius) / (1 - radius))
+				k := 1 - strength*t*t*(3-2*t)
+				for c := 0; c < 3; c++ {
+					px[4*x+c] = uint8(float64(px[4*x+c])*k + 0.5)
+				}
+			}
+		}
+		return dst, nil
+	})
+}
+
+// levels returns a step that maps each color channel through f, which is
+// tabulated for the channel values 0 to 255 and clamped.
+func levels(f func(v float64) float64) Step {
+	var table [256]uint8
+	for i := range table {
+		table[i] = clamp255(int(math.Round(f(float64(i)))))
+	}
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return recolor(ctx, img, func(r, g, b uint8) (uint8, uint8, uint8) {
+			return table[r], table[g], table[b]
+		})
+	})
+}
+
+// luma returns the brightness of an sRGB color with the weights of
+// color.GrayModel.
+func luma(r, g, b uint8) uint8 {
+	return uint8((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+}
+
+// recolor returns a copy of img with the color of each pixel mapped
+// through f, which works on colors that are not premultiplied.
+func recolor(ctx context.Context, img image.Image, f func(r, g, b uint8) (uint8, uint8, uint8)) (image.Image, error) {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	w := b.Dx()
+	for y := 0; y < b.Dy(); y++ {
+		if y%bandSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		px := dst.Pix[y*dst.Stride : y*dst.Stride+4*w]
+		for i := 0; i < len(px); i += 4 {
+			a := uint32(px[i+3])
+			switch a {
+			case 0:
+			case 0xff:
+				px[i], px[i+1], px[i+2] = f(px[i], px[i+1], px[i+2])
+			default:
+				cr, cg, cb := f(unpremul(px[i], a), unpremul(px[i+1], a), unpremul(px[i+2], a))
+				px[i] = uint8((uint32(cr)*a + 127) / 0xff)
+				px[i+1] = uint8((uint32(cg)*a + 127) / 0xff)
+				px[i+2] = uint8((uint32(cb)*a + 127) / 0xff)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// unpremul returns the 8-bit channel value v premultiplied by alpha a as
+// it was before, for 0 < a < 0xff.
+func unpremul(v uint8, a uint32) uint8 {
+	return uint8(minUint32((uint32(v)*0xff+a/2)/a, 0xff))
+}
@@ -0,0 +1,143 @@
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidTransform is returned by ParseTransform for malformed
+// transformation strings.
+var ErrInvalidTransform = errors.New("thumbnail: invalid transformation")
+
+// ParseTransform parses a compact, URL-friendly transformation string into
+// Options. The string is a comma-separated list of key_value parameters:
+//
+//	w_300        Width
+//	h_200        Height
+//	c_fill       Mode: fit, fill, crop, stretch, pad, blurpad or carve; also
+//	             the CDN names scale (stretch), limit (fit without
+//	             upscaling) and lpad (pad without upscaling)
+//	ar_16:9      AspectRatio, as accepted by ParseAspectRatio
+//	g_north      Gravity, by position (top_left) or compass (north_west) name,
+//	             or g_auto for Smart
+//	q_80         Quality
+//	f_webp       Format
+//	b_ff8800     Background as a hex RGB or RGBA color, or "transparent";
+//	             b_auto for AutoBackground (b_auto:dominant for its
+//	             dominant variant)
+//	r_20         CornerRadius, or r_max for Circle
+//	e_pixelate   Obscure: pixelate or blur
+//	pg_2         Page of a multi-page source
+//
+// Parameters that are not given keep their DefaultOptions values, so
+// "w_300,h_300,c_fill,g_north,q_80,f_webp" fills a 300×300 box anchored at
+// the top. ParseTransform checks syntax only; call Options.Validate to
+// check the result.
+func ParseTransform(s string) (Options, error) {
+	return parseTransform(DefaultOptions(), s)
+}
+
+// parseTransform applies the parameters in s on top of opts.
+func parseTransform(opts Options, s string) (Options, error) {
+	if s == "" {
+		return opts, nil
+	}
+	for _, param := range strings.Split(s, ",") {
+		if err := opts.applyParam(param); err != nil {
+			return Options{}, fmt.Errorf("%w %q: %v", ErrInvalidTransform, param, err)
+		}
+	}
+	return opts, nil
+}
+
+func (o *Options) applyParam(param string) error {
+	i := strings.IndexByte(param, '_')
+	if i <= 0 || i == len(param)-1 {
+		return errors.New("want key_value")
+	}
+	key, value := param[:i], param[i+1:]
+
+	var err error
+	switch key {
+	case "w":
+		o.Width, err = strconv.Atoi(value)
+	case "h":
+		o.Height, err = strconv.Atoi(value)
+	case "q":
+		o.Quality, err = strconv.Atoi(value)
+	case "f":
+		o.Format = normalizeFormat(value)
+	case "ar":
+		o.AspectRatio, err = ParseAspectRatio(value)
+	case "g":
+		o.Gravity, err = ParseGravity(value)
+	case "b":
+		err = o.setBackground(value)
+	case "e":
+		o.Obscure, err = ParseObscure(value)
+	case "r":
+		if strings.EqualFold(value, "max") {
+			o.Circle = true
+		} else {
+			o.CornerRadius, err = strconv.Atoi(value)
+		}
+	case "pg":
+		o.Page, err = strconv.Atoi(value)
+	case "c":
+		switch strings.ToLower(value) {
+		case "scale":
+			o.Mode = Stretch
+		case "limit":
+			o.Mode, o.NoUpscale = Fit, true
+		case "lpad":
+			o.Mode, o.NoUpscale = Pad, true
+		default:
+			o.Mode, err = ParseMode(value)
+		}
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return err
+}
+
+// setBackground sets the Background of o to the color s, as parseColor
+// accepts, or its AutoBackground for "auto", or "auto:" followed by the
+// name of one, as in "auto:dominant".
+func (o *Options) setBackground(s string) error {
+	if strings.EqualFold(s, "auto") {
+		o.AutoBackground = AutoBackgroundAverage
+		return nil
+	}
+	if len(s) > 5 && strings.EqualFold(s[:5], "auto:") {
+		var err error
+		o.AutoBackground, err = ParseAutoBackground(s[5:])
+		return err
+	}
+	var err error
+	o.Background, err = parseColor(s)
+	return err
+}
+
+// parseColor parses "transparent" or a hex color of the form RRGGBB or
+// RRGGBBAA, with an optional leading '#'.
+func parseColor(s string) (color.Color, error) {
+	if strings.EqualFold(s, "transparent") {
+		return color.Transparent, nil
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return nil, fmt.Errorf("bad color %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad color %q", s)
+	}
+	if len(s) == 6 {
+		v = v<<8 | 0xff
+	}
+	c := color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return c, nil
+}
@@ -0,0 +1,108 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Trim returns a step that crops away borders of a single color, such as
+// the white margins of product shots or the edges of scans, so that the
+// subject fills the thumbnail; it typically precedes Resize. The border
+// color is that of the top left pixel, and pixels whose channels differ
+// from it by at most tolerance, out of 255, count as border. Images that
+// are all border are left unchanged. As with CropRect the result shares
+// pixels with the image where it can.
+func Trim(tolerance int) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return trim(ctx, img, func(ref, p []uint8) bool {
+			for c := 0; c < 4; c++ {
+				if d := int(p[c]) - int(ref[c]); d > tolerance || -d > tolerance {
+					return false
+				}
+			}
+			return true
+		})
+	})
+}
+
+// TrimTransparent returns a step that crops the image to the bounding box
+// of its pixels that are not fully transparent, for sources such as
+// exported icons and cut-out products with wide empty margins. Like Trim
+// it typically precedes Resize, leaves images with no such pixels
+// unchanged and shares pixels with the image where it can.
+func TrimTransparent() Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if !hasTransparency(img) {
+			return img, nil
+		}
+		return trim(ctx, img, func(_, p []uint8) bool { return p[3] == 0 })
+	})
+}
+
+// trim crops img to the part within its edges, where border reports, for
+// each pixel, whether it belongs to them. Its arguments are the top left
+// pixel and the pixel, both as premultiplied RGBA.
+func trim(ctx context.Context, img image.Image, border func(ref, p []uint8) bool) (image.Image, error) {
+	r, err := trimRect(ctx, img, border)
+	if err != nil {
+		return nil, err
+	}
+	if r.Empty() || r == img.Bounds() {
+		return img, nil
+	}
+	return cropImage(img, r)
+}
+
+// trimRect returns the part of img within its edges for trim, or the
+// empty rectangle if img is edge throughout.
+func trimRect(ctx context.Context, img image.Image, border func(ref, p []uint8) bool) (image.Rectangle, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return image.Rectangle{}, nil
+	}
+	// Lines are converted to RGBA one at a time, which is fast for the
+	// common source types and keeps memory flat for large ones.
+	row := image.NewRGBA(image.Rect(0, 0, b.Dx(), 1))
+	col := image.NewRGBA(image.Rect(0, 0, 1, b.Dy()))
+	draw.Draw(row, row.Rect, img, b.Min, draw.Src)
+	ref := append([]uint8(nil), row.Pix[:4]...)
+	// uniform reports whether the pixels of img within r, a single row or
+	// column, are all border, copying them into m to check.
+	var err error
+	uniform := func(m *image.RGBA, r image.Rectangle) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		draw.Draw(m, image.Rectangle{Max: r.Size()}, img, r.Min, draw.Src)
+		for i := 0; i < 4*r.Dx()*r.Dy(); i += 4 {
+			if !border(ref, m.Pix[i:i+4]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top, bottom := b.Min.Y, b.Max.Y
+	for top < bottom && uniform(row, image.Rect(b.Min.X, top, b.Max.X, top+1)) {
+		top++
+	}
+	if top == bottom {
+		return image.Rectangle{}, nil
+	}
+	for bottom > top && uniform(row, image.Rect(b.Min.X, bottom-1, b.Max.X, bottom)) {
+		bottom--
+	}
+	left, right := b.Min.X, b.Max.X
+	for left < right && uniform(col, image.Rect(left, top, left+1, bottom)) {
+		left++
+	}
+	for right > left && uniform(col, image.Rect(right-1, top, right, bottom)) {
+		right--
+	}
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return image.Rect(left, top, right, bottom), nil
+}
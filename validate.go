@@ -0,0 +1,132 @@
+package thumbnail
+
+import (
+	"fmt"
+	"math"
+)
+
+// OptionError reports an invalid Options field.
+type OptionError struct {
+	Field string      // name of the Options field
+	Value interface{} // the offending value
+	Err   error       // ErrUnsupportedFormat or one of the ErrInvalid* values
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("%v: %s = %v", e.Err, e.Field, e.Value)
+}
+
+// Unwrap returns the underlying sentinel error.
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// Validate reports whether o describes a thumbnail that can be generated
+// and encoded, so that bad user input can be rejected before any image is
+// decoded. It returns nil or an *OptionError.
+func (o Options) Validate() error {
+	if !(o.Scale >= 0) || math.IsInf(o.Scale, 0) {
+		return &OptionError{"Scale", o.Scale, ErrInvalidScale}
+	}
+	if !(o.AspectRatio >= 0) || math.IsInf(o.AspectRatio, 0) {
+		return &OptionError{"AspectRatio", o.AspectRatio, ErrInvalidAspectRatio}
+	}
+	if o.Scale == 0 {
+		// With an aspect ratio one dimension may be left to be derived.
+		lo := 1
+		if o.AspectRatio > 0 {
+			lo = 0
+		}
+		if o.Width < lo || o.Width > MaxDimension {
+			return &OptionError{"Width", o.Width, ErrInvalidDimensions}
+		}
+		if o.Height < lo || o.Height > MaxDimension {
+			return &OptionError{"Height", o.Height, ErrInvalidDimensions}
+		}
+		if o.Width == 0 && o.Height == 0 {
+			return &OptionError{"Width", o.Width, ErrInvalidDimensions}
+		}
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return &OptionError{"Quality", o.Quality, ErrInvalidQuality}
+	}
+	if !o.Filter.valid() {
+		return &OptionError{"Filter", o.Filter, ErrInvalidFilter}
+	}
+	if !o.Mode.valid() {
+		return &OptionError{"Mode", o.Mode, ErrInvalidMode}
+	}
+	if !o.Gravity.valid() {
+		return &OptionError{"Gravity", o.Gravity, ErrInvalidGravity}
+	}
+	if o.Focus != nil && !o.Focus.valid() {
+		return &OptionError{"Focus", *o.Focus, ErrInvalidFocus}
+	}
+	if !o.Subsampling.valid() {
+		return &OptionError{"Subsampling", o.Subsampling, ErrInvalidSubsampling}
+	}
+	if !o.Compression.valid() {
+		return &OptionError{"Compression", o.Compression, ErrInvalidCompression}
+	}
+	if !o.Quantizer.valid() {
+		return &OptionError{"Quantizer", o.Quantizer, ErrInvalidQuantizer}
+	}
+	if !o.Dither.valid() {
+		return &OptionError{"Dither", o.Dither, ErrInvalidDither}
+	}
+	if o.CMYKProfile != nil && o.CMYKProfile.ColorSpace != "CMYK" {
+		return &OptionError{"CMYKProfile", o.CMYKProfile.ColorSpace, ErrInvalidProfile}
+	}
+	if !o.KeepMetadata.valid() {
+		return &OptionError{"KeepMetadata", o.KeepMetadata, ErrInvalidMetadataKind}
+	}
+	if !o.StripMetadata.valid() {
+		return &OptionError{"StripMetadata", o.StripMetadata, ErrInvalidMetadataStrip}
+	}
+	if o.Border < 0 || o.Scale == 0 && (o.Width > 0 && 2*o.Border >= o.Width || o.Height > 0 && 2*o.Border >= o.Height) {
+		return &OptionError{"Border", o.Border, ErrInvalidBorder}
+	}
+	if !o.Frame.valid() {
+		return &OptionError{"Frame", o.Frame, ErrInvalidFrame}
+	}
+	if o.CornerRadius < 0 {
+		return &OptionError{"CornerRadius", o.CornerRadius, ErrInvalidCornerRadius}
+	}
+	if !o.AutoBackground.valid() {
+		return &OptionError{"AutoBackground", o.AutoBackground, ErrInvalidAutoBackground}
+	}
+	if !o.Obscure.valid() {
+		return &OptionError{"Obscure", o.Obscure, ErrInvalidObscure}
+	}
+	if !o.Hash.valid() {
+		return &OptionError{"Hash", o.Hash, ErrInvalidHashKind}
+	}
+	if o.Parallelism < 0 {
+		return &OptionError{"Parallelism", o.Parallelism, ErrInvalidParallelism}
+	}
+	if o.MaxFrames < 0 {
+		return &OptionError{"MaxFrames", o.MaxFrames, ErrInvalidMaxFrames}
+	}
+	if o.MaxDuration < 0 {
+		return &OptionError{"MaxDuration", o.MaxDuration, ErrInvalidMaxDuration}
+	}
+	if o.Page < 0 {
+		return &OptionError{"Page", o.Page, ErrInvalidPage}
+	}
+	if o.MaxPixels < 0 {
+		return &OptionError{"MaxPixels", o.MaxPixels, ErrInvalidLimit}
+	}
+	if o.MaxWidth < 0 {
+		return &OptionError{"MaxWidth", o.MaxWidth, ErrInvalidLimit}
+	}
+	if o.MaxHeight < 0 {
+		return &OptionError{"MaxHeight", o.MaxHeight, ErrInvalidLimit}
+	}
+	if o.Format != "" && !hasEncoder(o.Format) {
+		return &OptionError{"Format", o.Format, ErrUnsupportedFormat}
+	}
+	if o.LQIP != "" && !hasEncoder(o.LQIP) {
+		return &OptionError{"LQIP", o.LQIP, ErrUnsupportedFormat}
+	}
+	return nil
+}
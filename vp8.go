@@ -0,0 +1,1119 @@
+package thumbnail
+
+import "math"
+
+// This file implements a VP8 (lossy WebP) key frame encoder, following
+// RFC 6386. Every macroblock uses whole-block 16×16 luma and 8×8 chroma
+// prediction, chosen by squared error, and the token probabilities are
+// re-estimated from the image before the coefficients are written.
+
+const (
+	vp8PredDC = iota
+	vp8PredTM
+	vp8PredVE
+	vp8PredHE
+)
+
+const (
+	vp8PlaneY1WithY2 = iota
+	vp8PlaneY2
+	vp8PlaneUV
+	vp8PlaneY1SansY2
+)
+
+type vp8Probs [4][8][3][11]uint8
+
+// vp8Counts records how often each token probability coded a 0 and a 1.
+type vp8Counts [4][8][3][11][2]uint32
+
+// vp8Macroblock holds the chosen modes and quantized coefficients of a
+// macroblock: 16 luma blocks, 4 Cb, 4 Cr and the luma DC (Y2) block, all in
+// raster coefficient order.
+type vp8Macroblock struct {
+	ymode, uvmode uint8
+	coeffs        [25][16]int16
+}
+
+func (mb *vp8Macroblock) empty() bool {
+	for i := range mb.coeffs {
+		for _, c := range mb.coeffs[i] {
+			if c != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type vp8Encoder struct {
+	w, h     int
+	mbw, mbh int
+	qIndex   int
+	q        struct{ y1, y2, uv [2]int32 }
+
+	// Source and reconstructed planes, padded to whole macroblocks.
+	y, u, v    []byte
+	ry, ru, rv []byte
+	ys, cs     int
+
+	mbs []vp8Macroblock
+}
+
+// encodeVP8 returns the VP8 bitstream of a w×h image given as BT.601
+// studio-range planes padded to a multiple of 16 (luma) and 8 (chroma)
+// pixels, with strides ys and cs.
+func encodeVP8(y, u, v []byte, ys, cs, w, h, quality int) []byte {
+	e := &vp8Encoder{
+		w: w, h: h,
+		mbw: (w + 15) / 16, mbh: (h + 15) / 16,
+		y: y, u: u, v: v, ys: ys, cs: cs,
+	}
+	e.setQuality(quality)
+	e.ry = make([]byte, len(y))
+	e.ru = make([]byte, len(u))
+	e.rv = make([]byte, len(v))
+	e.mbs = make([]vp8Macroblock, e.mbw*e.mbh)
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.macroblock(mbx, mby)
+		}
+	}
+	return e.write()
+}
+
+// setQuality maps a 1-100 quality to a quantizer index and the derived
+// quantizer step sizes.
+func (e *vp8Encoder) setQuality(quality int) {
+	// The curve is steeper at high qualities, where small index changes
+	// are most visible, roughly matching libwebp's scale.
+	f := float64(100-quality) / 100
+	e.qIndex = clampInt(int(127*f*(0.6+0.4*f)+0.5), 0, 127)
+	q := e.qIndex
+	e.q.y1 = [2]int32{int32(vp8DCTable[q]), int32(vp8ACTable[q])}
+	e.q.y2 = [2]int32{2 * int32(vp8DCTable[q]), int32(vp8ACTable[q]) * 155 / 100}
+	if e.q.y2[1] < 8 {
+		e.q.y2[1] = 8
+	}
+	e.q.uv = [2]int32{int32(vp8DCTable[minInt(q, 117)]), int32(vp8ACTable[q])}
+}
+
+// edges returns the top row, left column and top-left sample a w×w block
+// at (x, y) of a reconstructed plane is predicted from, using the values
+// the decoder substitutes outside the image.
+func edges(plane []byte, stride, x, y, w int, top, left []int32) (tl int32) {
+	for i := 0; i < w; i++ {
+		if y == 0 {
+			top[i] = 0x7f
+		} else {
+			top[i] = int32(plane[(y-1)*stride+x+i])
+		}
+		if x == 0 {
+			left[i] = 0x81
+		} else {
+			left[i] = int32(plane[(y+i)*stride+x-1])
+		}
+	}
+	switch {
+	case y == 0:
+		return 0x7f
+	case x == 0:
+		return 0x81
+	}
+	return int32(plane[(y-1)*stride+x-1])
+}
+
+// predictBlock fills pred with the w×w prediction for mode. DC prediction
+// falls back to whichever edges are inside the image.
+func predictBlock(pred []int32, mode uint8, w int, top, left []int32, tl int32, hasTop, hasLeft bool) {
+	switch mode {
+	case vp8PredDC:
+		var sum, n int32
+		if hasTop {
+			for _, t := range top[:w] {
+				sum += t
+			}
+			n += int32(w)
+		}
+		if hasLeft {
+			for _, l := range left[:w] {
+				sum += l
+			}
+			n += int32(w)
+		}
+		dc := int32(0x80)
+		if n > 0 {
+			dc = (sum + n/2) / n
+		}
+		for i := range pred[:w*w] {
+			pred[i] = dc
+		}
+	case vp8PredTM:
+		for j := 0; j < w; j++ {
+			for i := 0; i < w; i++ {
+				pred[j*w+i] = int32(clamp255(int(left[j] + top[i] - tl)))
+			}
+		}
+	case vp8PredVE:
+		for j := 0; j < w; j++ {
+			copy(pred[j*w:j*w+w], top[:w])
+		}
+	case vp8PredHE:
+		for j := 0; j < w; j++ {
+			for i := 0; i < w; i++ {
+				pred[j*w+i] = left[j]
+			}
+		}
+	}
+}
+
+// bestMode returns the prediction mode with the least squared error over
+// the w×w blocks at (x, y) of the given source planes.
+func bestMode(planes [][]byte, recon [][]byte, stride, x, y, w int, hasTop, hasLeft bool) uint8 {
+	var top, left [16]int32
+	pred := make([]int32, w*w)
+	best, bestErr := uint8(0), int64(-1)
+	for mode := uint8(vp8PredDC); mode <= vp8PredHE; mode++ {
+		var sse int64
+		for p := range planes {
+			tl := edges(recon[p], stride, x, y, w, top[:], left[:])
+			predictBlock(pred, mode, w, top[:], left[:], tl, hasTop, hasLeft)
+			for j := 0; j < w; j++ {
+				for i := 0; i < w; i++ {
+					d := int64(planes[p][(y+j)*stride+x+i]) - int64(pred[j*w+i])
+					sse += d * d
+				}
+			}
+		}
+		if bestErr < 0 || sse < bestErr {
+			best, bestErr = mode, sse
+		}
+	}
+	return best
+}
+
+// macroblock chooses the modes of a macroblock, quantizes its residuals
+// and reconstructs it the way the decoder will.
+func (e *vp8Encoder) macroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+	hasTop, hasLeft := mby > 0, mbx > 0
+	var top, left [16]int32
+
+	// Luma, with the DC of each 4×4 block coded through the Y2 block.
+	x, y := 16*mbx, 16*mby
+	mb.ymode = bestMode([][]byte{e.y}, [][]byte{e.ry}, e.ys, x, y, 16, hasTop, hasLeft)
+	var pred [256]int32
+	tl := edges(e.ry, e.ys, x, y, 16, top[:], left[:])
+	predictBlock(pred[:], mb.ymode, 16, top[:], left[:], tl, hasTop, hasLeft)
+	var dct [16][16]int32
+	var dc [16]int32
+	for n := 0; n < 16; n++ {
+		bx, by := 4*(n%4), 4*(n/4)
+		fdct(&dct[n], e.y[(y+by)*e.ys+x+bx:], e.ys, pred[by*16+bx:], 16)
+		dc[n] = dct[n][0]
+	}
+	var wht [16]int32
+	fwht(&wht, &dc)
+	for k := range wht {
+		mb.coeffs[24][k] = quantize(wht[k], e.q.y2[btoi(k > 0)], k == 0)
+		wht[k] = int32(mb.coeffs[24][k]) * e.q.y2[btoi(k > 0)]
+	}
+	iwht(&dc, &wht)
+	for n := 0; n < 16; n++ {
+		var coeffs [16]int32
+		coeffs[0] = dc[n]
+		for k := 1; k < 16; k++ {
+			mb.coeffs[n][k] = quantize(dct[n][k], e.q.y1[1], false)
+			coeffs[k] = int32(mb.coeffs[n][k]) * e.q.y1[1]
+		}
+		bx, by := 4*(n%4), 4*(n/4)
+		idct(e.ry[(y+by)*e.ys+x+bx:], e.ys, pred[by*16+bx:], 16, &coeffs)
+	}
+
+	// Chroma, with one mode shared by both planes.
+	x, y = 8*mbx, 8*mby
+	mb.uvmode = bestMode([][]byte{e.u, e.v}, [][]byte{e.ru, e.rv}, e.cs, x, y, 8, hasTop, hasLeft)
+	for p, planes := range [2][2][]byte{{e.u, e.ru}, {e.v, e.rv}} {
+		src, rec := planes[0], planes[1]
+		tl := edges(rec, e.cs, x, y, 8, top[:], left[:])
+		predictBlock(pred[:], mb.uvmode, 8, top[:], left[:], tl, hasTop, hasLeft)
+		for n := 0; n < 4; n++ {
+			bx, by := 4*(n%2), 4*(n/2)
+			var c, coeffs [16]int32
+			fdct(&c, src[(y+by)*e.cs+x+bx:], e.cs, pred[by*8+bx:], 8)
+			out := &mb.coeffs[16+4*p+n]
+			for k := range c {
+				out[k] = quantize(c[k], e.q.uv[btoi(k > 0)], k == 0)
+				coeffs[k] = int32(out[k]) * e.q.uv[btoi(k > 0)]
+			}
+			idct(rec[(y+by)*e.cs+x+bx:], e.cs, pred[by*8+bx:], 8, &coeffs)
+		}
+	}
+}
+
+// quantize returns the quantizer level of coefficient c for step q. AC
+// coefficients are rounded towards zero a little, which saves more bits
+// than it costs in quality.
+func quantize(c, q int32, dc bool) int16 {
+	bias := q * 3 / 8
+	if dc {
+		bias = q / 2
+	}
+	neg := c < 0
+	if neg {
+		c = -c
+	}
+	l := (c + bias) / q
+	if l > 2048 {
+		l = 2048
+	}
+	if neg {
+		l = -l
+	}
+	return int16(l)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fdct computes the forward transform of the 4×4 difference between src
+// and pred, as libwebp does, scaled to match the decoder's inverse.
+func fdct(out *[16]int32, src []byte, stride int, pred []int32, pstride int) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		d0 := int32(src[i*stride+0]) - pred[i*pstride+0]
+		d1 := int32(src[i*stride+1]) - pred[i*pstride+1]
+		d2 := int32(src[i*stride+2]) - pred[i*pstride+2]
+		d3 := int32(src[i*stride+3]) - pred[i*pstride+3]
+		a0, a1, a2, a3 := d0+d3, d1+d2, d1-d2, d0-d3
+		tmp[0+i*4] = (a0 + a1) * 8
+		tmp[1+i*4] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[2+i*4] = (a0 - a1) * 8
+		tmp[3+i*4] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[12+i]
+		a1 := tmp[4+i] + tmp[8+i]
+		a2 := tmp[4+i] - tmp[8+i]
+		a3 := tmp[0+i] - tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// idct adds the inverse transform of coeffs to pred and stores the clipped
+// result in dst, exactly as the decoder reconstructs a block.
+func idct(dst []byte, stride int, pred []int32, pstride int, coeffs *[16]int32) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := coeffs[i] + coeffs[8+i]
+		b := coeffs[i] - coeffs[8+i]
+		c := (coeffs[4+i]*c2)>>16 - (coeffs[12+i]*c1)>>16
+		d := (coeffs[4+i]*c1)>>16 + (coeffs[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := [4]int32{(a + d) >> 3, (b + c) >> 3, (b - c) >> 3, (a - d) >> 3}
+		for i, r := range row {
+			dst[j*stride+i] = clamp255(int(pred[j*pstride+i] + r))
+		}
+	}
+}
+
+// fwht computes the forward Walsh-Hadamard transform of the luma DC
+// coefficients of a macroblock.
+func fwht(out, in *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i*4+0] + in[i*4+2]
+		a1 := in[i*4+1] + in[i*4+3]
+		a2 := in[i*4+1] - in[i*4+3]
+		a3 := in[i*4+0] - in[i*4+2]
+		tmp[0+i*4] = a0 + a1
+		tmp[1+i*4] = a3 + a2
+		tmp[2+i*4] = a3 - a2
+		tmp[3+i*4] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[8+i]
+		a1 := tmp[4+i] + tmp[12+i]
+		a2 := tmp[4+i] - tmp[12+i]
+		a3 := tmp[0+i] - tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// iwht inverts fwht the way the decoder does.
+func iwht(out, in *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[0+i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[0+i] - in[12+i]
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0 := dc + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := dc - m[3+i*4]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+// boolEncoder is the boolean entropy encoder of RFC 6386 section 7.
+type boolEncoder struct {
+	buf    []byte
+	rng    uint32
+	bottom uint32
+	count  int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, count: 24}
+}
+
+func (e *boolEncoder) putBit(prob uint8, bit bool) {
+	split := 1 + (e.rng-1)*uint32(prob)>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			// Propagate the carry into the bytes already written.
+			for i := len(e.buf) - 1; i >= 0; i-- {
+				e.buf[i]++
+				if e.buf[i] != 0 {
+					break
+				}
+			}
+		}
+		e.bottom <<= 1
+		e.count--
+		if e.count == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.count = 8
+		}
+	}
+}
+
+// putUint writes the n low bits of v, most significant first, at even odds.
+func (e *boolEncoder) putUint(v uint32, n uint) {
+	for n > 0 {
+		n--
+		e.putBit(128, v>>n&1 != 0)
+	}
+}
+
+// bytes flushes the encoder and returns the coded data.
+func (e *boolEncoder) bytes() []byte {
+	for i := 0; i < 32; i++ {
+		e.putBit(128, false)
+	}
+	return e.buf
+}
+
+var (
+	vp8Bands   = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	vp8Zigzag  = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Cat3456 = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// tokenWriter writes coefficient tokens, or only counts how often each
+// probability sees a 0 and a 1 when counts is set.
+type tokenWriter struct {
+	e      *boolEncoder
+	probs  *vp8Probs
+	counts *vp8Counts
+}
+
+func (t *tokenWriter) put(plane, band, ctx, i int, bit bool) {
+	if t.counts != nil {
+		t.counts[plane][band][ctx][i][btoi(bit)]++
+		return
+	}
+	t.e.putBit(t.probs[plane][band][ctx][i], bit)
+}
+
+func (t *tokenWriter) putFixed(prob uint8, bit bool) {
+	if t.counts == nil {
+		t.e.putBit(prob, bit)
+	}
+}
+
+// residuals writes the tokens of one block from coefficient first on and
+// reports whether it had any non-zero coefficient.
+func (t *tokenWriter) residuals(c *[16]int16, plane, ctx, first int) int {
+	last := -1
+	for n := first; n < 16; n++ {
+		if c[vp8Zigzag[n]] != 0 {
+			last = n
+		}
+	}
+	band := int(vp8Bands[first])
+	if last < 0 {
+		t.put(plane, band, ctx, 0, false)
+		return 0
+	}
+	t.put(plane, band, ctx, 0, true)
+	for n := first; n < 16; {
+		v := int(c[vp8Zigzag[n]])
+		n++
+		if v == 0 {
+			t.put(plane, band, ctx, 1, false)
+			band, ctx = int(vp8Bands[n]), 0
+			continue
+		}
+		t.put(plane, band, ctx, 1, true)
+		a := absInt(v)
+		if a == 1 {
+			t.put(plane, band, ctx, 2, false)
+			ctx = 1
+		} else {
+			t.put(plane, band, ctx, 2, true)
+			switch {
+			case a <= 4:
+				t.put(plane, band, ctx, 3, false)
+				if a == 2 {
+					t.put(plane, band, ctx, 4, false)
+				} else {
+					t.put(plane, band, ctx, 4, true)
+					t.put(plane, band, ctx, 5, a == 4)
+				}
+			case a <= 10:
+				t.put(plane, band, ctx, 3, true)
+				t.put(plane, band, ctx, 6, false)
+				if a <= 6 {
+					t.put(plane, band, ctx, 7, false)
+					t.putFixed(159, a == 6)
+				} else {
+					t.put(plane, band, ctx, 7, true)
+					t.putFixed(165, (a-7)>>1 != 0)
+					t.putFixed(145, (a-7)&1 != 0)
+				}
+			default:
+				t.put(plane, band, ctx, 3, true)
+				t.put(plane, band, ctx, 6, true)
+				cat := 3
+				for cat > 0 && a < 3+8<<uint(cat) {
+					cat--
+				}
+				t.put(plane, band, ctx, 8, cat>>1 != 0)
+				t.put(plane, band, ctx, 9+cat>>1, cat&1 != 0)
+				extra := a - (3 + 8<<uint(cat))
+				tab := vp8Cat3456[cat]
+				for i, p := range tab {
+					t.putFixed(p, extra>>uint(len(tab)-1-i)&1 != 0)
+				}
+			}
+			ctx = 2
+		}
+		t.putFixed(128, v < 0)
+		band = int(vp8Bands[n])
+		if n == 16 {
+			return 1
+		}
+		t.put(plane, band, ctx, 0, n <= last)
+		if n > last {
+			return 1
+		}
+	}
+	return 1
+}
+
+// tokens writes the coefficients of every macroblock. Skipped macroblocks
+// are those with no coefficients at all, when skip is set.
+func (e *vp8Encoder) tokens(t *tokenWriter, skip bool) {
+	type nz struct {
+		y, u, v [4]int // per 4×4 column or row
+		y2      int
+	}
+	up := make([]nz, e.mbw)
+	for mby := 0; mby < e.mbh; mby++ {
+		var left nz
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			above := &up[mbx]
+			if skip && mb.empty() {
+				left.y, left.u, left.v, left.y2 = [4]int{}, [4]int{}, [4]int{}, 0
+				*above = nz{}
+				continue
+			}
+			n := t.residuals(&mb.coeffs[24], vp8PlaneY2, left.y2+above.y2, 0)
+			left.y2, above.y2 = n, n
+			for by := 0; by < 4; by++ {
+				for bx := 0; bx < 4; bx++ {
+					n := t.residuals(&mb.coeffs[4*by+bx], vp8PlaneY1WithY2, left.y[by]+above.y[bx], 1)
+					left.y[by], above.y[bx] = n, n
+				}
+			}
+			for p, ctx := range [2]*struct{ l, a *[4]int }{{&left.u, &above.u}, {&left.v, &above.v}} {
+				for by := 0; by < 2; by++ {
+					for bx := 0; bx < 2; bx++ {
+						n := t.residuals(&mb.coeffs[16+4*p+2*by+bx], vp8PlaneUV, ctx.l[by]+ctx.a[bx], 0)
+						ctx.l[by], ctx.a[bx] = n, n
+					}
+				}
+			}
+		}
+	}
+}
+
+// write assembles the frame: the uncompressed header, the first partition
+// with the frame parameters and modes, and a single token partition.
+func (e *vp8Encoder) write() []byte {
+	// Estimate token probabilities from the actual coefficients and keep
+	// the updates that pay for themselves.
+	var counts vp8Counts
+	skip, skipped := false, 0
+	for i := range e.mbs {
+		if e.mbs[i].empty() {
+			skipped++
+		}
+	}
+	skip = skipped > 0
+	e.tokens(&tokenWriter{counts: &counts}, skip)
+	probs := vp8DefaultProbs
+	var update [4][8][3][11]bool
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l := range probs[i][j][k] {
+					c := counts[i][j][k][l]
+					if c[0]+c[1] == 0 {
+						continue
+					}
+					p := uint8(clampInt(int((uint64(c[0])*256+uint64(c[0]+c[1])/2)/uint64(c[0]+c[1])), 1, 255))
+					saved := bitCost(probs[i][j][k][l], c) - bitCost(p, c)
+					upd := vp8UpdateProbs[i][j][k][l]
+					overhead := 8*256 + bitCost(upd, [2]uint32{0, 1}) - bitCost(upd, [2]uint32{1, 0})
+					if saved > overhead {
+						probs[i][j][k][l] = p
+						update[i][j][k][l] = true
+					}
+				}
+			}
+		}
+	}
+
+	fp := newBoolEncoder()
+	fp.putBit(128, false) // color space
+	fp.putBit(128, false) // clamping type
+	fp.putBit(128, false) // no segmentation
+	fp.putBit(128, false) // normal loop filter
+	fp.putUint(uint32(e.filterLevel()), 6)
+	fp.putUint(0, 3)      // sharpness
+	fp.putBit(128, false) // no loop filter deltas
+	fp.putUint(0, 2)      // one token partition
+	fp.putUint(uint32(e.qIndex), 7)
+	for i := 0; i < 5; i++ {
+		fp.putBit(128, false) // no quantizer deltas
+	}
+	fp.putBit(128, false) // refresh entropy probs
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l := range probs[i][j][k] {
+					fp.putBit(vp8UpdateProbs[i][j][k][l], update[i][j][k][l])
+					if update[i][j][k][l] {
+						fp.putUint(uint32(probs[i][j][k][l]), 8)
+					}
+				}
+			}
+		}
+	}
+	skipProb := uint8(0)
+	if skip {
+		skipProb = uint8(clampInt(256*(len(e.mbs)-skipped)/len(e.mbs), 1, 255))
+		fp.putBit(128, true)
+		fp.putUint(uint32(skipProb), 8)
+	} else {
+		fp.putBit(128, false)
+	}
+	for i := range e.mbs {
+		mb := &e.mbs[i]
+		if skip {
+			fp.putBit(skipProb, mb.empty())
+		}
+		fp.putBit(145, true) // 16×16 luma prediction
+		switch mb.ymode {
+		case vp8PredDC:
+			fp.putBit(156, false)
+			fp.putBit(163, false)
+		case vp8PredVE:
+			fp.putBit(156, false)
+			fp.putBit(163, true)
+		case vp8PredHE:
+			fp.putBit(156, true)
+			fp.putBit(128, false)
+		case vp8PredTM:
+			fp.putBit(156, true)
+			fp.putBit(128, true)
+		}
+		fp.putBit(142, mb.uvmode != vp8PredDC)
+		if mb.uvmode != vp8PredDC {
+			fp.putBit(114, mb.uvmode != vp8PredVE)
+			if mb.uvmode != vp8PredVE {
+				fp.putBit(183, mb.uvmode == vp8PredTM)
+			}
+		}
+	}
+	first := fp.bytes()
+
+	tp := newBoolEncoder()
+	e.tokens(&tokenWriter{e: tp, probs: &probs}, skip)
+	tokens := tp.bytes()
+
+	out := make([]byte, 10, 10+len(first)+len(tokens))
+	size := uint32(len(first))
+	tag := 1<<4 | size<<5 // key frame, version 0, shown
+	out[0], out[1], out[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	out[3], out[4], out[5] = 0x9d, 0x01, 0x2a
+	out[6], out[7] = byte(e.w), byte(e.w>>8)
+	out[8], out[9] = byte(e.h), byte(e.h>>8)
+	out = append(out, first...)
+	return append(out, tokens...)
+}
+
+// filterLevel returns the loop filter strength for the quantizer, stronger
+// as coarser quantization makes block edges more visible.
+func (e *vp8Encoder) filterLevel() int {
+	return clampInt(e.qIndex*3/8, 0, 63)
+}
+
+// bitCost returns the approximate number of bits, in 1/256ths, needed to
+// code c[0] zeros and c[1] ones with probability p of a zero.
+func bitCost(p uint8, c [2]uint32) int64 {
+	return int64(c[0])*vp8BitCost(int(p)) + int64(c[1])*vp8BitCost(256-int(p))
+}
+
+// vp8BitCost returns the cost in 1/256ths of a bit of coding an event of
+// probability p/256.
+func vp8BitCost(p int) int64 {
+	return int64(-math.Log2(float64(p)/256)*256 + 0.5)
+}
+
+// The quantizer step tables are specified in section 14.1 of RFC 6386.
+var (
+	vp8DCTable = [128]uint16{
+		4, 5, 6, 7, 8, 9, 10, 10,
+		11, 12, 13, 14, 15, 16, 17, 17,
+		18, 19, 20, 20, 21, 21, 22, 22,
+		23, 23, 24, 25, 25, 26, 27, 28,
+		29, 30, 31, 32, 33, 34, 35, 36,
+		37, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 46, 47, 48, 49, 50,
+		51, 52, 53, 54, 55, 56, 57, 58,
+		59, 60, 61, 62, 63, 64, 65, 66,
+		67, 68, 69, 70, 71, 72, 73, 74,
+		75, 76, 76, 77, 78, 79, 80, 81,
+		82, 83, 84, 85, 86, 87, 88, 89,
+		91, 93, 95, 96, 98, 100, 101, 102,
+		104, 106, 108, 110, 112, 114, 116, 118,
+		122, 124, 126, 128, 130, 132, 134, 136,
+		138, 140, 143, 145, 148, 151, 154, 157,
+	}
+	vp8ACTable = [128]uint16{
+		4, 5, 6, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16, 17, 18, 19,
+		20, 21, 22, 23, 24, 25, 26, 27,
+		28, 29, 30, 31, 32, 33, 34, 35,
+		36, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 47, 48, 49, 50, 51,
+		52, 53, 54, 55, 56, 57, 58, 60,
+		62, 64, 66, 68, 70, 72, 74, 76,
+		78, 80, 82, 84, 86, 88, 90, 92,
+		94, 96, 98, 100, 102, 104, 106, 108,
+		110, 112, 114, 116, 119, 122, 125, 128,
+		131, 134, 137, 140, 143, 146, 149, 152,
+		155, 158, 161, 164, 167, 170, 173, 177,
+		181, 185, 189, 193, 197, 201, 205, 209,
+		213, 217, 221, 225, 229, 234, 239, 245,
+		249, 254, 259, 264, 269, 274, 279, 284,
+	}
+)
+
+// Token probability update probabilities are specified in section 13.4.
+var vp8UpdateProbs = vp8Probs{
+	{
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{176, 246, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 241, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 244, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 246, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{239, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 254, 255, 255, 255, 255, 255, 255},
+			{250, 255, 254, 255, 254, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{217, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{225, 252, 241, 253, 255, 255, 254, 255, 255, 255, 255},
+			{234, 250, 241, 250, 253, 255, 253, 254, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{238, 253, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{247, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{186, 251, 250, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 251, 244, 254, 255, 255, 255, 255, 255, 255, 255},
+			{251, 251, 243, 253, 254, 255, 254, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{236, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 253, 253, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{248, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 254, 252, 254, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 249, 253, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{246, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 254, 251, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{245, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 252, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+}
+
+// The default token probabilities are specified in section 13.5.
+var vp8DefaultProbs = vp8Probs{
+	{
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{253, 136, 254, 255, 228, 219, 128, 128, 128, 128, 128},
+			{189, 129, 242, 255, 227, 213, 255, 219, 128, 128, 128},
+			{106, 126, 227, 252, 214, 209, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 98, 248, 255, 236, 226, 255, 255, 128, 128, 128},
+			{181, 133, 238, 254, 221, 234, 255, 154, 128, 128, 128},
+			{78, 134, 202, 247, 198, 180, 255, 219, 128, 128, 128},
+		},
+		{
+			{1, 185, 249, 255, 243, 255, 128, 128, 128, 128, 128},
+			{184, 150, 247, 255, 236, 224, 128, 128, 128, 128, 128},
+			{77, 110, 216, 255, 236, 230, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 101, 251, 255, 241, 255, 128, 128, 128, 128, 128},
+			{170, 139, 241, 252, 236, 209, 255, 255, 128, 128, 128},
+			{37, 116, 196, 243, 228, 255, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 204, 254, 255, 245, 255, 128, 128, 128, 128, 128},
+			{207, 160, 250, 255, 238, 128, 128, 128, 128, 128, 128},
+			{102, 103, 231, 255, 211, 171, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 152, 252, 255, 240, 255, 128, 128, 128, 128, 128},
+			{177, 135, 243, 255, 234, 225, 128, 128, 128, 128, 128},
+			{80, 129, 211, 255, 194, 224, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{246, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{255, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{198, 35, 237, 223, 193, 187, 162, 160, 145, 155, 62},
+			{131, 45, 198, 221, 172, 176, 220, 157, 252, 221, 1},
+			{68, 47, 146, 208, 149, 167, 221, 162, 255, 223, 128},
+		},
+		{
+			{1, 149, 241, 255, 221, 224, 255, 255, 128, 128, 128},
+			{184, 141, 234, 253, 222, 220, 255, 199, 128, 128, 128},
+			{81, 99, 181, 242, 176, 190, 249, 202, 255, 255, 128},
+		},
+		{
+			{1, 129, 232, 253, 214, 197, 242, 196, 255, 255, 128},
+			{99, 121, 210, 250, 201, 198, 255, 202, 128, 128, 128},
+			{23, 91, 163, 242, 170, 187, 247, 210, 255, 255, 128},
+		},
+		{
+			{1, 200, 246, 255, 234, 255, 128, 128, 128, 128, 128},
+			{109, 178, 241, 255, 231, 245, 255, 255, 128, 128, 128},
+			{44, 130, 201, 253, 205, 192, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 132, 239, 251, 219, 209, 255, 165, 128, 128, 128},
+			{94, 136, 225, 251, 218, 190, 255, 255, 128, 128, 128},
+			{22, 100, 174, 245, 186, 161, 255, 199, 128, 128, 128},
+		},
+		{
+			{1, 182, 249, 255, 232, 235, 128, 128, 128, 128, 128},
+			{124, 143, 241, 255, 227, 234, 128, 128, 128, 128, 128},
+			{35, 77, 181, 251, 193, 211, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 157, 247, 255, 236, 231, 255, 255, 128, 128, 128},
+			{121, 141, 235, 255, 225, 227, 255, 255, 128, 128, 128},
+			{45, 99, 188, 251, 195, 217, 255, 224, 128, 128, 128},
+		},
+		{
+			{1, 1, 251, 255, 213, 255, 128, 128, 128, 128, 128},
+			{203, 1, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{137, 1, 177, 255, 224, 255, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{253, 9, 248, 251, 207, 208, 255, 192, 128, 128, 128},
+			{175, 13, 224, 243, 193, 185, 249, 198, 255, 255, 128},
+			{73, 17, 171, 221, 161, 179, 236, 167, 255, 234, 128},
+		},
+		{
+			{1, 95, 247, 253, 212, 183, 255, 255, 128, 128, 128},
+			{239, 90, 244, 250, 211, 209, 255, 255, 128, 128, 128},
+			{155, 77, 195, 248, 188, 195, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 24, 239, 251, 218, 219, 255, 205, 128, 128, 128},
+			{201, 51, 219, 255, 196, 186, 128, 128, 128, 128, 128},
+			{69, 46, 190, 239, 201, 218, 255, 228, 128, 128, 128},
+		},
+		{
+			{1, 191, 251, 255, 255, 128, 128, 128, 128, 128, 128},
+			{223, 165, 249, 255, 213, 255, 128, 128, 128, 128, 128},
+			{141, 124, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 16, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{190, 36, 230, 255, 236, 255, 128, 128, 128, 128, 128},
+			{149, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 226, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{247, 192, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{240, 128, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 134, 252, 255, 255, 128, 128, 128, 128, 128, 128},
+			{213, 62, 250, 255, 255, 128, 128, 128, 128, 128, 128},
+			{55, 93, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{202, 24, 213, 235, 186, 191, 220, 160, 240, 175, 255},
+			{126, 38, 182, 232, 169, 184, 228, 174, 255, 187, 128},
+			{61, 46, 138, 219, 151, 178, 240, 170, 255, 216, 128},
+		},
+		{
+			{1, 112, 230, 250, 199, 191, 247, 159, 255, 255, 128},
+			{166, 109, 228, 252, 211, 215, 255, 174, 128, 128, 128},
+			{39, 77, 162, 232, 172, 180, 245, 178, 255, 255, 128},
+		},
+		{
+			{1, 52, 220, 246, 198, 199, 249, 220, 255, 255, 128},
+			{124, 74, 191, 243, 183, 193, 250, 221, 255, 255, 128},
+			{24, 71, 130, 219, 154, 170, 243, 182, 255, 255, 128},
+		},
+		{
+			{1, 182, 225, 249, 219, 240, 255, 224, 128, 128, 128},
+			{149, 150, 226, 252, 216, 205, 255, 171, 128, 128, 128},
+			{28, 108, 170, 242, 183, 194, 254, 223, 255, 255, 128},
+		},
+		{
+			{1, 81, 230, 252, 204, 203, 255, 192, 128, 128, 128},
+			{123, 102, 209, 247, 188, 196, 255, 233, 128, 128, 128},
+			{20, 95, 153, 243, 164, 173, 255, 203, 128, 128, 128},
+		},
+		{
+			{1, 222, 248, 255, 216, 213, 128, 128, 128, 128, 128},
+			{168, 175, 246, 252, 235, 205, 255, 255, 128, 128, 128},
+			{47, 116, 215, 255, 211, 212, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 121, 236, 253, 212, 214, 255, 255, 128, 128, 128},
+			{141, 84, 213, 252, 201, 202, 255, 219, 128, 128, 128},
+			{42, 80, 160, 240, 162, 185, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{244, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{238, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+}
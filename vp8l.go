@@ -0,0 +1,635 @@
+package thumbnail
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// This file implements a VP8L (lossless WebP) encoder. It uses the
+// subtract-green and predictor transforms followed by LZ77 and Huffman
+// coding, which is a small subset of what the format allows but keeps the
+// output within reach of libwebp's faster settings.
+
+const (
+	vp8lPredictorBits = 4 // log2 of the predictor tile size
+	vp8lMaxLength     = 4096
+	vp8lMinLength     = 3
+	vp8lMaxChain      = 16
+	vp8lWindow        = 1<<20 - 120
+	vp8lHashBits      = 16
+
+	vp8lNumLiterals = 256
+	vp8lNumLengths  = 24
+	vp8lNumDistance = 40
+)
+
+// bitWriter writes the least significant bits first, as VP8L expects.
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns the written data.
+func (b *bitWriter) bytes() []byte {
+	if b.n > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.n = 0, 0
+	}
+	return b.buf
+}
+
+// encodeVP8L returns the VP8L bitstream for an NRGBA pixel buffer of w×h
+// pixels with no padding between rows.
+func encodeVP8L(pix []byte, w, h int, alpha bool) []byte {
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	writeVP8LStream(&bw, pix, w, h)
+	return bw.bytes()
+}
+
+// writeVP8LStream writes the transforms and entropy-coded image for pix,
+// which it modifies. It is the part of a VP8L bitstream that follows the
+// header, which is also how alpha planes are stored.
+func writeVP8LStream(bw *bitWriter, pix []byte, w, h int) {
+	// The decoder undoes the transforms in reverse order, so subtracting
+	// green first leaves the predictor to work on decorrelated channels.
+	bw.write(1, 1)
+	bw.write(2, 2)
+	for p := 0; p < len(pix); p += 4 {
+		pix[p+0] -= pix[p+1]
+		pix[p+2] -= pix[p+1]
+	}
+
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	modes, tw, _ := choosePredictors(pix, w, h)
+	writeVP8LPixels(bw, modes, tw, false)
+	pix = predict(pix, w, h, modes, tw)
+
+	bw.write(0, 1)
+	writeVP8LPixels(bw, pix, w, true)
+}
+
+// nTiles returns the number of predictor tiles needed to cover n pixels.
+func nTiles(n int) int {
+	return (n + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+// choosePredictors picks a predictor mode for each tile of pix, minimising
+// the magnitude of the residuals. The modes are returned as a tile image
+// with the mode in the green channel.
+func choosePredictors(pix []byte, w, h int) ([]byte, int, int) {
+	tw, th := nTiles(w), nTiles(h)
+	modes := make([]byte, 4*tw*th)
+	const size = 1 << vp8lPredictorBits
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := maxInt(ty*size, 1); y < minInt((ty+1)*size, h); y++ {
+					for x := maxInt(tx*size, 1); x < minInt((tx+1)*size, w); x++ {
+						p := 4 * (y*w + x)
+						pred := predictPixel(pix, p, 4*w, mode)
+						for c := 0; c < 4; c++ {
+							d := int(int8(pix[p+c] - pred[c]))
+							if d < 0 {
+								d = -d
+							}
+							cost += d
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[4*(ty*tw+tx)+1] = byte(best)
+		}
+	}
+	return modes, tw, th
+}
+
+// predict returns the residuals of pix under the predictor modes.
+func predict(pix []byte, w, h int, modes []byte, tw int) []byte {
+	res := make([]byte, len(pix))
+	stride := 4 * w
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := 4 * (y*w + x)
+			var pred [4]byte
+			switch {
+			case x == 0 && y == 0:
+				pred = [4]byte{0, 0, 0, 0xff}
+			case y == 0:
+				copy(pred[:], pix[p-4:p])
+			case x == 0:
+				copy(pred[:], pix[p-stride:p-stride+4])
+			default:
+				m := modes[4*((y>>vp8lPredictorBits)*tw+x>>vp8lPredictorBits)+1]
+				pred = predictPixel(pix, p, stride, int(m))
+			}
+			for c := 0; c < 4; c++ {
+				res[p+c] = pix[p+c] - pred[c]
+			}
+		}
+	}
+	return res
+}
+
+// predictPixel returns the prediction for the pixel at offset p, which
+// must not be in the first row or column, using the given mode. The
+// top-right neighbour of the last pixel in a row is the first pixel of the
+// row, which falls out of the flat layout.
+func predictPixel(pix []byte, p, stride, mode int) [4]byte {
+	var out [4]byte
+	l, t := p-4, p-stride
+	tl, tr := t-4, t+4
+	if mode == 11 {
+		var pl, pt int
+		for c := 0; c < 4; c++ {
+			pl += absInt(int(pix[tl+c]) - int(pix[t+c]))
+			pt += absInt(int(pix[tl+c]) - int(pix[l+c]))
+		}
+		src := t
+		if pl < pt {
+			src = l
+		}
+		copy(out[:], pix[src:src+4])
+		return out
+	}
+	for c := 0; c < 4; c++ {
+		L, T, TL, TR := pix[l+c], pix[t+c], pix[tl+c], pix[tr+c]
+		var v byte
+		switch mode {
+		case 0:
+			if c == 3 {
+				v = 0xff
+			}
+		case 1:
+			v = L
+		case 2:
+			v = T
+		case 3:
+			v = TR
+		case 4:
+			v = TL
+		case 5:
+			v = avg2(avg2(L, TR), T)
+		case 6:
+			v = avg2(L, TL)
+		case 7:
+			v = avg2(L, T)
+		case 8:
+			v = avg2(TL, T)
+		case 9:
+			v = avg2(T, TR)
+		case 10:
+			v = avg2(avg2(L, TL), avg2(T, TR))
+		case 12:
+			v = clamp255(int(L) + int(T) - int(TL))
+		case 13:
+			a := int(avg2(L, T))
+			v = clamp255(a + (a-int(TL))/2)
+		}
+		out[c] = v
+	}
+	return out
+}
+
+func avg2(a, b byte) byte {
+	return byte((int(a) + int(b)) / 2)
+}
+
+func clamp255(v int) byte {
+	return byte(clampInt(v, 0, 255))
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// vp8lSymbol is a literal pixel or, when length is non-zero, a backward
+// reference of length pixels using the distance code dist.
+type vp8lSymbol struct {
+	argb   uint32
+	length int
+	dist   int
+}
+
+// writeVP8LPixels entropy-codes pix, a w-pixel-wide RGBA image, without a
+// color cache or meta Huffman image.
+func writeVP8LPixels(bw *bitWriter, pix []byte, w int, topLevel bool) {
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta Huffman image
+	}
+
+	syms := lz77(pix, w)
+	var (
+		green = make([]uint32, vp8lNumLiterals+vp8lNumLengths)
+		red   = make([]uint32, 256)
+		blue  = make([]uint32, 256)
+		alpha = make([]uint32, 256)
+		dist  = make([]uint32, vp8lNumDistance)
+	)
+	for _, s := range syms {
+		if s.length > 0 {
+			c, _, _ := prefixEncode(s.length)
+			green[vp8lNumLiterals+c]++
+			c, _, _ = prefixEncode(s.dist)
+			dist[c]++
+			continue
+		}
+		alpha[s.argb>>24]++
+		red[s.argb>>16&0xff]++
+		green[s.argb>>8&0xff]++
+		blue[s.argb&0xff]++
+	}
+	codes := [5]huffmanCode{
+		writeHuffmanCode(bw, green),
+		writeHuffmanCode(bw, red),
+		writeHuffmanCode(bw, blue),
+		writeHuffmanCode(bw, alpha),
+		writeHuffmanCode(bw, dist),
+	}
+	for _, s := range syms {
+		if s.length > 0 {
+			c, n, extra := prefixEncode(s.length)
+			codes[0].write(bw, vp8lNumLiterals+c)
+			bw.write(uint32(extra), uint(n))
+			c, n, extra = prefixEncode(s.dist)
+			codes[4].write(bw, c)
+			bw.write(uint32(extra), uint(n))
+			continue
+		}
+		codes[0].write(bw, int(s.argb>>8&0xff))
+		codes[1].write(bw, int(s.argb>>16&0xff))
+		codes[2].write(bw, int(s.argb&0xff))
+		codes[3].write(bw, int(s.argb>>24))
+	}
+}
+
+// prefixEncode returns the prefix code, extra bit count and extra bits of
+// the LZ77 length or distance v, which must be at least 1.
+func prefixEncode(v int) (code, n, extra int) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	hi := bits.Len(uint(d)) - 1
+	second := d >> uint(hi-1) & 1
+	return 2*hi + second, hi - 1, d & (1<<uint(hi-1) - 1)
+}
+
+// distanceCodes maps the pixel distances reachable through the short
+// two-dimensional distance codes of a w-pixel-wide image to those codes.
+func distanceCodes(w int) map[int]int {
+	m := make(map[int]int, len(distanceMapTable))
+	for i := len(distanceMapTable) - 1; i >= 0; i-- {
+		yOff := int(distanceMapTable[i] >> 4)
+		xOff := 8 - int(distanceMapTable[i]&0xf)
+		if d := yOff*w + xOff; d >= 1 {
+			m[d] = i + 1
+		}
+	}
+	return m
+}
+
+// distanceMapTable lists the (dy, 8-dx) pixel offsets of distance codes
+// 1 to 120, as specified in section 4.2.2 of the VP8L specification.
+var distanceMapTable = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// lz77 splits pix into literals and backward references using a greedy
+// hash-chain search.
+func lz77(pix []byte, w int) []vp8lSymbol {
+	n := len(pix) / 4
+	argb := make([]uint32, n)
+	for i := range argb {
+		v := binary.LittleEndian.Uint32(pix[4*i:])
+		// RGBA in memory to ARGB.
+		argb[i] = v&0xff00ff00 | v<<16&0xff0000 | v>>16&0xff
+	}
+	short := distanceCodes(w)
+
+	const hashSize = 1 << vp8lHashBits
+	head := make([]int32, hashSize)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	syms := make([]vp8lSymbol, 0, n/2)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		if i+vp8lMinLength <= n {
+			maxLen := minInt(vp8lMaxLength, n-i)
+			try := func(j int) {
+				if j < 0 || i-j > vp8lWindow {
+					return
+				}
+				k := 0
+				for k < maxLen && argb[j+k] == argb[i+k] {
+					k++
+				}
+				if k > bestLen {
+					bestLen, bestDist = k, i-j
+				}
+			}
+			// The pixel to the left and the one above are cheap to code
+			// and catch flat areas that hashing may miss.
+			try(i - 1)
+			try(i - w)
+			j := head[hash(i)]
+			for c := 0; j >= 0 && c < vp8lMaxChain && bestLen < maxLen; c++ {
+				try(int(j))
+				j = chain[j]
+			}
+		}
+		if bestLen < vp8lMinLength {
+			syms = append(syms, vp8lSymbol{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		code, ok := short[bestDist]
+		if !ok {
+			code = bestDist + len(distanceMapTable)
+		}
+		syms = append(syms, vp8lSymbol{length: bestLen, dist: code})
+		for k := 0; k < bestLen; k++ {
+			insert(i + k)
+		}
+		i += bestLen
+	}
+	return syms
+}
+
+// huffmanCode holds the canonical codes of an alphabet, bit-reversed for
+// writing least significant bit first.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (h huffmanCode) write(bw *bitWriter, sym int) {
+	bw.write(uint32(h.codes[sym]), uint(h.lengths[sym]))
+}
+
+// newHuffmanCode returns the canonical code for the given code lengths. A
+// code with a single symbol takes no bits at all.
+func newHuffmanCode(lengths []uint8) huffmanCode {
+	h := huffmanCode{lengths: make([]uint8, len(lengths)), codes: make([]uint16, len(lengths))}
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used <= 1 {
+		return h
+	}
+	copy(h.lengths, lengths)
+	var count [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for sym, l := range lengths {
+		if l > 0 {
+			c := next[l]
+			next[l]++
+			h.codes[sym] = uint16(bits.Reverse16(uint16(c)) >> (16 - l))
+		}
+	}
+	return h
+}
+
+// codeLengthOrder is the order in which the code length code lengths are
+// written.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writeHuffmanCode builds a length-limited Huffman code for the symbol
+// frequencies, writes its description and returns it.
+func writeHuffmanCode(bw *bitWriter, freq []uint32) huffmanCode {
+	var syms []int
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	if len(syms) <= 2 && (len(syms) == 0 || syms[len(syms)-1] < 256) {
+		// Simple code of one or two symbols.
+		bw.write(1, 1)
+		if len(syms) == 0 {
+			syms = []int{0}
+		}
+		bw.write(uint32(len(syms)-1), 1)
+		if syms[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(syms[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(syms[0]), 8)
+		}
+		lengths := make([]uint8, len(freq))
+		lengths[syms[0]] = 1
+		if len(syms) == 2 {
+			bw.write(uint32(syms[1]), 8)
+			lengths[syms[1]] = 1
+		}
+		return newHuffmanCode(lengths)
+	}
+
+	lengths := huffmanLengths(freq, 15)
+	bw.write(0, 1)
+
+	// Run-length code the lengths with the repeat codes 16, 17 and 18.
+	type token struct{ code, extra int }
+	var tokens []token
+	prev := 8
+	for i := 0; i < len(lengths); {
+		v := int(lengths[i])
+		run := 1
+		for i+run < len(lengths) && int(lengths[i+run]) == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 11 {
+				k := minInt(run, 138)
+				tokens = append(tokens, token{18, k - 11})
+				run -= k
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, run - 3})
+				run = 0
+			}
+		} else {
+			if v != prev {
+				tokens = append(tokens, token{v, 0})
+				run--
+				prev = v
+			}
+			for run >= 3 {
+				k := minInt(run, 6)
+				tokens = append(tokens, token{16, k - 3})
+				run -= k
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{v, 0})
+		}
+	}
+
+	clFreq := make([]uint32, 19)
+	for _, t := range tokens {
+		clFreq[t.code]++
+	}
+	clLengths := huffmanLengths(clFreq, 7)
+	n := 19
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, c := range codeLengthOrder[:n] {
+		bw.write(uint32(clLengths[c]), 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	cl := newHuffmanCode(clLengths)
+	for _, t := range tokens {
+		cl.write(bw, t.code)
+		switch t.code {
+		case 16:
+			bw.write(uint32(t.extra), 2)
+		case 17:
+			bw.write(uint32(t.extra), 3)
+		case 18:
+			bw.write(uint32(t.extra), 7)
+		}
+	}
+	return newHuffmanCode(lengths)
+}
+
+// huffmanLengths returns Huffman code lengths for freq of at most maxLen
+// bits. Symbols with zero frequency get no code; a lone symbol gets a
+// length of 1.
+func huffmanLengths(freq []uint32, maxLen int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	f := make([]uint32, len(freq))
+	copy(f, freq)
+	for {
+		type node struct {
+			weight      uint64
+			left, right int // children, or -1 for leaves
+			sym         int
+		}
+		var nodes []node
+		for s, v := range f {
+			if v > 0 {
+				nodes = append(nodes, node{uint64(v), -1, -1, s})
+			}
+		}
+		switch len(nodes) {
+		case 0:
+			return lengths
+		case 1:
+			lengths[nodes[0].sym] = 1
+			return lengths
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+
+		// Two-queue construction: leaves are sorted and internal nodes
+		// are created in non-decreasing weight order.
+		leaves := len(nodes)
+		li, qi := 0, leaves
+		pick := func() int {
+			if li < leaves && (qi >= len(nodes) || nodes[li].weight <= nodes[qi].weight) {
+				li++
+				return li - 1
+			}
+			qi++
+			return qi - 1
+		}
+		for k := 0; k < leaves-1; k++ {
+			a, b := pick(), pick()
+			nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, a, b, -1})
+		}
+		depth := make([]int, len(nodes))
+		maxDepth := 0
+		for i := len(nodes) - 1; i >= leaves; i-- {
+			depth[nodes[i].left] = depth[i] + 1
+			depth[nodes[i].right] = depth[i] + 1
+		}
+		for i := 0; i < leaves; i++ {
+			if depth[i] > maxDepth {
+				maxDepth = depth[i]
+			}
+		}
+		if maxDepth <= maxLen {
+			for i := 0; i < leaves; i++ {
+				lengths[nodes[i].sym] = uint8(depth[i])
+			}
+			return lengths
+		}
+		// Flatten the distribution and try again.
+		for s, v := range f {
+			if v > 0 {
+				f[s] = v/2 + 1
+			}
+		}
+	}
+}
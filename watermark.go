@@ -0,0 +1,114 @@
+package thumbnail
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Overlay describes where and how an overlay such as a watermark is drawn
+// onto an image.
+type Overlay struct {
+	// Gravity places the overlay on the image: at an edge, a corner or
+	// the center (the default).
+	Gravity Gravity
+
+	// OffsetX and OffsetY move the overlay away from the edges Gravity
+	// puts it against, in pixels; for the center, and the middle of an
+	// edge, they move it right and down.
+	OffsetX, OffsetY int
+
+	// Opacity scales the overlay's alpha, from 0 to 1. Zero is taken as 1,
+	// fully opaque.
+	Opacity float64
+
+	// Scale, when positive, sizes the overlay to fit Scale times the
+	// image's width and height, keeping its aspect ratio, so that it
+	// covers the same share of thumbnails of any size. Zero keeps its
+	// own size.
+	Scale float64
+
+	// Tile repeats the overlay over the whole image in a grid that
+	// includes the position Gravity and the offsets give, with Spacing
+	// pixels between copies.
+	Tile    bool
+	Spacing int
+}
+
+// Watermark returns a step that composites mark onto the image as o
+// describes. The result is an *image.RGBA with the image's bounds.
+func Watermark(mark image.Image, o Overlay) Step {
+	return StepFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if mark == nil {
+			return nil, ErrNilImage
+		}
+		return drawOverlay(img, mark, o), nil
+	})
+}
+
+// drawOverlay returns a copy of img with mark composited onto it as o
+// describes.
+func drawOverlay(img, mark image.Image, o Overlay) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	mb := mark.Bounds()
+	size := mb.Size()
+	if o.Scale > 0 && !mb.Empty() {
+		f := math.Min(o.Scale*float64(b.Dx())/float64(size.X), o.Scale*float64(b.Dy())/float64(size.Y))
+		size = image.Pt(maxInt(1, int(math.Round(float64(size.X)*f))), maxInt(1, int(math.Round(float64(size.Y)*f))))
+		scaled := image.NewRGBA(image.Rectangle{Max: size})
+		draw.CatmullRom.Scale(scaled, scaled.Rect, mark, mb, draw.Src, nil)
+		mark, mb = scaled, scaled.Rect
+	}
+	if mb.Empty() || b.Empty() {
+		return dst
+	}
+
+	r := o.Gravity.place(size, b)
+	fx, fy := o.Gravity.anchor()
+	dx, dy := o.OffsetX, o.OffsetY
+	if fx > 0.5 {
+		dx = -dx
+	}
+	if fy > 0.5 {
+		dy = -dy
+	}
+	r = r.Add(image.Pt(dx, dy))
+
+	var mask image.Image
+	if o.Opacity > 0 && o.Opacity < 1 {
+		mask = image.NewUniform(color.Alpha16{uint16(math.Round(o.Opacity * 0xffff))})
+	}
+	if !o.Tile {
+		draw.DrawMask(dst, r, mark, mb.Min, mask, image.Point{}, draw.Over)
+		return dst
+	}
+	// Start the grid at the copy above and left of the image that lines
+	// up with r.
+	stepX, stepY := size.X+maxInt(0, o.Spacing), size.Y+maxInt(0, o.Spacing)
+	x0 := r.Min.X - ceilDiv(r.Min.X-b.Min.X, stepX)*stepX
+	y0 := r.Min.Y - ceilDiv(r.Min.Y-b.Min.Y, stepY)*stepY
+	for y := y0; y < b.Max.Y; y += stepY {
+		for x := x0; x < b.Max.X; x += stepX {
+			t := image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+size.X, y+size.Y)}
+			draw.DrawMask(dst, t, mark, mb.Min, mask, image.Point{}, draw.Over)
+		}
+	}
+	return dst
+}
+
+// ceilDiv returns a/b rounded towards positive infinity, for b > 0.
+func ceilDiv(a, b int) int {
+	if a >= 0 {
+		return (a + b - 1) / b
+	}
+	return -(-a / b)
+}
@@ -0,0 +1,289 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// ErrImageTooLarge is returned when an image exceeds the dimensions an
+// output format can represent.
+var ErrImageTooLarge = errors.New("thumbnail: image too large for format")
+
+// maxWebPDimension is the largest width or height of a WebP image.
+const maxWebPDimension = 16383
+
+// SaveWebP writes img to w as WebP. With opts.Lossless the image is stored
+// exactly (VP8L); otherwise it is compressed lossily (VP8) at opts.Quality,
+// which defaults to 85 like SaveJPEG. Transparency is kept in both modes,
+// and opts.Metadata is written in the chunks the format has for it.
+func SaveWebP(img image.Image, w io.Writer, opts Options) error {
+	b := img.Bounds()
+	if b.Dx() > maxWebPDimension || b.Dy() > maxWebPDimension {
+		return ErrImageTooLarge
+	}
+	if b.Empty() {
+		return ErrEmptyImage
+	}
+	chunks, alpha := webpImage(toNRGBA(img), opts)
+	md := opts.Metadata.strip(opts.StripMetadata)
+	if md == nil {
+		md = new(Metadata)
+	}
+	if len(chunks) == 1 && md.EXIF == nil && md.XMP == nil && md.ICC == nil {
+		return writeRIFF(w, chunks...)
+	}
+
+	// Lossy images carry alpha in a separate chunk and metadata has
+	// chunks of its own; both need the extended format header.
+	const (
+		iccFlag   = 1 << 5
+		alphaFlag = 1 << 4
+		exifFlag  = 1 << 3
+		xmpFlag   = 1 << 2
+	)
+	vp8x := make([]byte, 10)
+	if alpha {
+		vp8x[0] |= alphaFlag
+	}
+	putUint24(vp8x[4:], uint32(b.Dx()-1))
+	putUint24(vp8x[7:], uint32(b.Dy()-1))
+	all := []riffChunk{{"VP8X", vp8x}}
+	if md.ICC != nil {
+		vp8x[0] |= iccFlag
+		all = append(all, riffChunk{"ICCP", md.ICC})
+	}
+	all = append(all, chunks...)
+	if md.EXIF != nil {
+		vp8x[0] |= exifFlag
+		all = append(all, riffChunk{"EXIF", md.EXIF})
+	}
+	if md.XMP != nil {
+		vp8x[0] |= xmpFlag
+		all = append(all, riffChunk{"XMP ", md.XMP})
+	}
+	return writeRIFF(w, all...)
+}
+
+// webpImage encodes nrgba as SaveWebP does, returning the chunks that hold
+// the image (a VP8L chunk, or a VP8 chunk preceded by an ALPH chunk when
+// the image is transparent) and whether it is transparent.
+func webpImage(nrgba *image.NRGBA, opts Options) ([]riffChunk, bool) {
+	b := nrgba.Rect
+	alpha := hasTransparency(nrgba)
+
+	if opts.Lossless {
+		// The encoder transforms the pixels in place.
+		pix := append([]byte(nil), nrgba.Pix...)
+		return []riffChunk{{"VP8L", encodeVP8L(pix, b.Dx(), b.Dy(), alpha)}}, alpha
+	}
+
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 85
+	}
+	y, u, v, ys, cs := toYUV420(nrgba)
+	frame := encodeVP8(y, u, v, ys, cs, b.Dx(), b.Dy(), quality)
+	if !alpha {
+		return []riffChunk{{"VP8 ", frame}}, false
+	}
+
+	// The alpha plane is compressed losslessly.
+	a := make([]byte, 4*b.Dx()*b.Dy())
+	for i := 0; i < len(a); i += 4 {
+		// The alpha plane is stored in the green channel; repeating it in
+		// red and blue lets subtract-green reduce those to zero.
+		v := nrgba.Pix[i+3]
+		a[i], a[i+1], a[i+2], a[i+3] = v, v, v, 0xff
+	}
+	var bw bitWriter
+	writeVP8LStream(&bw, a, b.Dx(), b.Dy())
+	alph := append([]byte{1}, bw.bytes()...) // lossless compression, no filter
+	return []riffChunk{{"ALPH", alph}, {"VP8 ", frame}}, true
+}
+
+// toNRGBA returns img as a non-premultiplied image with its origin at
+// (0, 0) and no padding between rows.
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if m, ok := img.(*image.NRGBA); ok && m.Rect.Min == (image.Point{}) && m.Stride == 4*b.Dx() {
+		return m
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+	return m
+}
+
+// toYUV420 converts m to BT.601 studio-range planes with 2×2 subsampled
+// chroma, as VP8 decoders expect, padding them to whole macroblocks by
+// repeating the last row and column.
+func toYUV420(m *image.NRGBA) (y, u, v []byte, ys, cs int) {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	pw, ph := (w+15)&^15, (h+15)&^15
+	ys, cs = pw, pw/2
+	y = make([]byte, pw*ph)
+	u = make([]byte, cs*ph/2)
+	v = make([]byte, cs*ph/2)
+	px := func(x, yy int) (int32, int32, int32) {
+		p := m.Pix[minInt(yy, h-1)*m.Stride+4*minInt(x, w-1):]
+		return int32(p[0]), int32(p[1]), int32(p[2])
+	}
+	for j := 0; j < ph; j++ {
+		for i := 0; i < pw; i++ {
+			r, g, b := px(i, j)
+			y[j*ys+i] = byte((16839*r + 33059*g + 6420*b + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for j := 0; j < ph/2; j++ {
+		for i := 0; i < cs; i++ {
+			var r, g, b int32
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := px(2*i+d[0], 2*j+d[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			u[j*cs+i] = clipUV(-9719*r - 19081*g + 28800*b)
+			v[j*cs+i] = clipUV(28800*r - 24116*g - 4684*b)
+		}
+	}
+	return y, u, v, ys, cs
+}
+
+// clipUV scales a chroma sum over four pixels back to a byte.
+func clipUV(c int32) byte {
+	return clamp255(int((c + 128<<18 + 1<<17) >> 18))
+}
+
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+// writeRIFF writes a RIFF WEBP container holding chunks.
+func writeRIFF(w io.Writer, chunks ...riffChunk) error {
+	size := 4
+	for _, c := range chunks {
+		size += 8 + len(c.data) + len(c.data)&1
+	}
+	buf := make([]byte, 0, 8+size)
+	buf = append(buf, "RIFF"...)
+	buf = appendUint32(buf, uint32(size))
+	buf = append(buf, "WEBP"...)
+	buf = appendChunks(buf, chunks...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendChunks appends chunks to b, padding each to an even length.
+func appendChunks(b []byte, chunks ...riffChunk) []byte {
+	for _, c := range chunks {
+		b = append(b, c.id...)
+		b = appendUint32(b, uint32(len(c.data)))
+		b = append(b, c.data...)
+		if len(c.data)&1 != 0 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// webpAnimationFlag marks animated files in the flags of a VP8X chunk.
+const webpAnimationFlag = 1 << 1
+
+// isAnimatedWebP reports whether header starts a WebP file whose VP8X
+// chunk marks it animated.
+func isAnimatedWebP(header []byte) bool {
+	return len(header) >= 21 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP" &&
+		string(header[12:16]) == "VP8X" && header[20]&webpAnimationFlag != 0
+}
+
+// readRIFFChunks splits b, the body of a RIFF container or of an ANMF
+// chunk, into its chunks.
+func readRIFFChunks(b []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := uint64(binary.LittleEndian.Uint32(b[4:]))
+		if n+8 > uint64(len(b)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		chunks = append(chunks, riffChunk{string(b[:4]), b[8 : 8+n]})
+		b = b[minInt(int(8+n+n&1), len(b)):]
+	}
+	return chunks, nil
+}
+
+// decodeAnimatedWebPFirst decodes the first frame of the animated WebP
+// file data, which golang.org/x/image/webp does not read, placed on the
+// transparent canvas of the animation.
+func decodeAnimatedWebPFirst(data []byte) (image.Image, error) {
+	if len(data) < 12 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	chunks, err := readRIFFChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	var canvas image.Rectangle
+	for _, c := range chunks {
+		switch {
+		case c.id == "VP8X" && len(c.data) >= 10:
+			canvas = image.Rect(0, 0, int(uint24(c.data[4:]))+1, int(uint24(c.data[7:]))+1)
+		case c.id == "ANMF" && len(c.data) >= 16:
+			frame, err := readRIFFChunks(c.data[16:])
+			if err != nil {
+				return nil, err
+			}
+			// A lone frame is a still WebP file, which needs a VP8X
+			// chunk of its own to carry an ALPH chunk.
+			r := image.Rect(0, 0, int(uint24(c.data[6:]))+1, int(uint24(c.data[9:]))+1)
+			for _, f := range frame {
+				if f.id == "ALPH" {
+					vp8x := make([]byte, 10)
+					vp8x[0] = 1 << 4 // alpha
+					copy(vp8x[4:], c.data[6:12])
+					frame = append([]riffChunk{{"VP8X", vp8x}}, frame...)
+					break
+				}
+			}
+			var buf bytes.Buffer
+			if err := writeRIFF(&buf, frame...); err != nil {
+				return nil, err
+			}
+			m, err := webp.Decode(&buf)
+			if err != nil {
+				return nil, err
+			}
+			// Frame offsets are stored halved.
+			r = r.Add(image.Pt(2*int(uint24(c.data[0:])), 2*int(uint24(c.data[3:]))))
+			if r == canvas {
+				return m, nil
+			}
+			dst := image.NewNRGBA(canvas)
+			draw.Draw(dst, r.Intersect(canvas), m, m.Bounds().Min, draw.Src)
+			return dst, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: animated WebP without frames", image.ErrFormat)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
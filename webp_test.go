@@ -0,0 +1,92 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestSaveWebPLossless(t *testing.T) {
+	for _, alpha := range []bool{false, true} {
+		src := testImage(97, 61, alpha)
+		var buf bytes.Buffer
+		if err := SaveWebP(src, &buf, Options{Lossless: true}); err != nil {
+			t.Fatal(err)
+		}
+		img, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("alpha %v: decoding: %v", alpha, err)
+		}
+		got := toNRGBA(img)
+		if got.Bounds() != src.Bounds() {
+			t.Fatalf("alpha %v: decoded bounds %v, want %v", alpha, got.Bounds(), src.Bounds())
+		}
+		for i := range src.Pix {
+			// Fully transparent pixels may have any color.
+			if src.Pix[i|3] != 0 && got.Pix[i] != src.Pix[i] {
+				t.Fatalf("alpha %v: pixel %d differs: %v, want %v", alpha, i/4, got.Pix[i&^3:i&^3+4], src.Pix[i&^3:i&^3+4])
+			}
+		}
+	}
+}
+
+func TestSaveWebPLossy(t *testing.T) {
+	for _, alpha := range []bool{false, true} {
+		src := testImage(150, 100, alpha)
+		var buf bytes.Buffer
+		if err := SaveWebP(src, &buf, Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("alpha %v: decoding: %v", alpha, err)
+		}
+		img := studioRGB(t, m)
+		if img.Bounds() != src.Bounds() {
+			t.Fatalf("alpha %v: decoded bounds %v, want %v", alpha, img.Bounds(), src.Bounds())
+		}
+		if p := psnr(t, img, src); p < 30 {
+			t.Errorf("alpha %v: PSNR %.1f dB, want at least 30", alpha, p)
+		}
+	}
+}
+
+// studioRGB converts a decoded lossy WebP to RGB. VP8 stores studio-range
+// BT.601, which golang.org/x/image/webp returns as an *image.YCbCr or
+// *image.NYCbCrA that the image package would convert as full range.
+func studioRGB(t *testing.T, img image.Image) *image.NRGBA {
+	t.Helper()
+	var m *image.YCbCr
+	var a *image.NYCbCrA
+	switch img := img.(type) {
+	case *image.YCbCr:
+		m = img
+	case *image.NYCbCrA:
+		m, a = &img.YCbCr, img
+	default:
+		t.Fatalf("decoded lossy WebP as %T", img)
+	}
+	b := m.Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			yy := 1.164 * (float64(m.Y[m.YOffset(x, y)]) - 16)
+			cb := float64(m.Cb[m.COffset(x, y)]) - 128
+			cr := float64(m.Cr[m.COffset(x, y)]) - 128
+			c := color.NRGBA{
+				clamp255(int(yy + 1.596*cr + 0.5)),
+				clamp255(int(yy - 0.813*cr - 0.391*cb + 0.5)),
+				clamp255(int(yy + 2.018*cb + 0.5)),
+				255,
+			}
+			if a != nil {
+				c.A = a.A[a.AOffset(x, y)]
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}